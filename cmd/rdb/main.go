@@ -0,0 +1,251 @@
+// Command rdb moves datasets between Redis and this server through RDB
+// files. It checks a file, dumps its keys as the commands recreating
+// them, or rewrites it as another version.
+//
+//	rdb check <file.rdb>
+//	rdb dump <file.rdb>
+//	rdb convert [-version N] [-compress] <in.rdb> <out.rdb>
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"go-redis/pkg/rdb"
+	"go-redis/pkg/utils"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s check <file.rdb>\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s dump <file.rdb>\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s convert [-version N] [-compress] <in.rdb> <out.rdb>\n", os.Args[0])
+	os.Exit(1)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch args := os.Args[2:]; os.Args[1] {
+	case "check":
+		if len(args) != 1 {
+			usage()
+		}
+		err = check(args[0], os.Stdout)
+	case "dump":
+		if len(args) != 1 {
+			usage()
+		}
+		w := bufio.NewWriter(os.Stdout)
+		if err = dump(args[0], w); err == nil {
+			err = w.Flush()
+		}
+	case "convert":
+		fs := flag.NewFlagSet("convert", flag.ExitOnError)
+		version := fs.Int("version", 9, "RDB version to write, 9 to 11")
+		compress := fs.Bool("compress", false, "LZF compress long strings")
+		fs.Parse(args)
+		if fs.NArg() != 2 {
+			usage()
+		}
+		err = convert(fs.Arg(0), fs.Arg(1), *version, *compress)
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// readFile calls fn with every key of the RDB file at path.
+func readFile(path string, fn func(r *rdb.Reader, e *rdb.Entry) error) (*rdb.Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := rdb.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			return r, nil
+		}
+		if err != nil {
+			return r, err
+		}
+		if err := fn(r, e); err != nil {
+			return r, err
+		}
+	}
+}
+
+// check reads the whole file and prints what it holds, like
+// redis-check-rdb.
+func check(path string, out io.Writer) error {
+	type dbStats struct {
+		keys, expires int
+		types         map[string]int
+	}
+	stats := make(map[int]*dbStats)
+	r, err := readFile(path, func(_ *rdb.Reader, e *rdb.Entry) error {
+		st := stats[e.DB]
+		if st == nil {
+			st = &dbStats{types: make(map[string]int)}
+			stats[e.DB] = st
+		}
+		st.keys++
+		if !e.Expire.IsZero() {
+			st.expires++
+		}
+		st.types[e.Value.Type()]++
+		return nil
+	})
+	if r == nil {
+		return err
+	}
+	fmt.Fprintf(out, "RDB version %d\n", r.Version)
+	for _, key := range sortedKeys(r.Aux) {
+		fmt.Fprintf(out, "AUX %s = %s\n", key, utils.QuoteArg(r.Aux[key]))
+	}
+	dbs := make([]int, 0, len(stats))
+	for db := range stats {
+		dbs = append(dbs, db)
+	}
+	sort.Ints(dbs)
+	for _, db := range dbs {
+		st := stats[db]
+		fmt.Fprintf(out, "db%d: keys=%d expires=%d", db, st.keys, st.expires)
+		for _, typ := range sortedKeys(st.types) {
+			fmt.Fprintf(out, " %s=%d", typ, st.types[typ])
+		}
+		fmt.Fprintln(out)
+	}
+	if len(r.Functions) > 0 {
+		fmt.Fprintf(out, "functions: %d libraries\n", len(r.Functions))
+	}
+	if err != nil {
+		return fmt.Errorf("RDB check failed: %w", err)
+	}
+	fmt.Fprintln(out, "RDB looks OK!")
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// dump writes the keys as commands in the RESP format, to be replayed as
+// an AOF or piped to redis-cli --pipe.
+func dump(path string, out io.Writer) error {
+	db := 0
+	_, err := readFile(path, func(_ *rdb.Reader, e *rdb.Entry) error {
+		var buf []byte
+		if e.DB != db {
+			db = e.DB
+			buf = utils.AppendCommand(buf, "SELECT", strconv.Itoa(db))
+		}
+		var args []string
+		switch v := e.Value.(type) {
+		case rdb.String:
+			args = []string{"SET", e.Key, string(v)}
+		case rdb.List:
+			args = append([]string{"RPUSH", e.Key}, v...)
+		case rdb.Set:
+			args = append([]string{"SADD", e.Key}, v...)
+		case rdb.Zset:
+			args = []string{"ZADD", e.Key}
+			for _, m := range v {
+				args = append(args, strconv.FormatFloat(m.Score, 'g', -1, 64), m.Member)
+			}
+		case rdb.Hash:
+			args = []string{"HSET", e.Key}
+			for _, f := range v {
+				args = append(args, f.Field, f.Value)
+			}
+		}
+		if len(args) <= 2 {
+			// an empty collection can not be created
+			return nil
+		}
+		buf = utils.AppendCommand(buf, args...)
+		if !e.Expire.IsZero() {
+			buf = utils.AppendCommand(buf, "PEXPIREAT", e.Key, strconv.FormatInt(e.Expire.UnixMilli(), 10))
+		}
+		_, err := out.Write(buf)
+		return err
+	})
+	return err
+}
+
+// convert rewrites the RDB file in as the given version, values in the
+// plain encodings. The keys are held in memory as every database starts
+// with its size.
+func convert(in, out string, version int, compress bool) error {
+	type database struct {
+		entries []*rdb.Entry
+		expires int
+	}
+	dbs := make(map[int]*database)
+	r, err := readFile(in, func(_ *rdb.Reader, e *rdb.Entry) error {
+		db := dbs[e.DB]
+		if db == nil {
+			db = &database{}
+			dbs[e.DB] = db
+		}
+		db.entries = append(db.entries, e)
+		if !e.Expire.IsZero() {
+			db.expires++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(r.Functions) > 0 {
+		return fmt.Errorf("functions can not be converted")
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	w, err := rdb.NewWriter(f, version)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.Compress = compress
+	for _, key := range sortedKeys(r.Aux) {
+		w.Aux(key, r.Aux[key])
+	}
+	ids := make([]int, 0, len(dbs))
+	for id := range dbs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		db := dbs[id]
+		w.SelectDB(id, len(db.entries), db.expires)
+		for _, e := range db.entries {
+			w.Write(e)
+		}
+	}
+	err = w.Close()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"go-redis/pkg/rdb"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeRDB(t *testing.T, path string, entries ...*rdb.Entry) {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := rdb.NewWriter(&buf, 9)
+	require.NoError(t, err)
+	require.NoError(t, w.Aux("redis-ver", "7.2.4"))
+	require.NoError(t, w.SelectDB(0, len(entries), 1))
+	for _, e := range entries {
+		require.NoError(t, w.Write(e))
+	}
+	require.NoError(t, w.Close())
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+}
+
+func TestTool(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.rdb")
+	writeRDB(t, in,
+		&rdb.Entry{Key: "s", Value: rdb.String(strings.Repeat("ab", 20))},
+		&rdb.Entry{Key: "l", Value: rdb.List{"a", "b"}, Expire: time.UnixMilli(1700000000123)},
+		&rdb.Entry{Key: "empty", Value: rdb.Set{}},
+	)
+
+	var out bytes.Buffer
+	require.NoError(t, check(in, &out))
+	require.Equal(t, "RDB version 9\nAUX redis-ver = 7.2.4\ndb0: keys=3 expires=1 list=1 set=1 string=1\nRDB looks OK!\n", out.String())
+
+	// empty collections have no command to create them
+	out.Reset()
+	require.NoError(t, dump(in, &out))
+	require.Equal(t, "*3\r\n$3\r\nSET\r\n$1\r\ns\r\n$40\r\n"+strings.Repeat("ab", 20)+"\r\n"+
+		"*4\r\n$5\r\nRPUSH\r\n$1\r\nl\r\n$1\r\na\r\n$1\r\nb\r\n"+
+		"*3\r\n$9\r\nPEXPIREAT\r\n$1\r\nl\r\n$13\r\n1700000000123\r\n", out.String())
+
+	// the converted file holds the same keys, the long string compressed
+	converted := filepath.Join(dir, "out.rdb")
+	require.NoError(t, convert(in, converted, 11, true))
+	data, err := os.ReadFile(converted)
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(data, []byte("REDIS0011")))
+	require.NotContains(t, string(data), strings.Repeat("ab", 20))
+	out.Reset()
+	require.NoError(t, check(converted, &out))
+	require.Contains(t, out.String(), "RDB version 11\n")
+	require.Contains(t, out.String(), "db0: keys=3 expires=1 list=1 set=1 string=1\n")
+	require.Error(t, convert(in, filepath.Join(dir, "old.rdb"), 8, false))
+
+	// a file cut short is reported with what could be read
+	data, err = os.ReadFile(in)
+	require.NoError(t, err)
+	truncated := filepath.Join(dir, "truncated.rdb")
+	require.NoError(t, os.WriteFile(truncated, data[:len(data)-12], 0o644))
+	out.Reset()
+	require.ErrorContains(t, check(truncated, &out), "RDB check failed")
+	require.Contains(t, out.String(), "db0: keys=2 expires=1")
+	require.NotContains(t, out.String(), "RDB looks OK!")
+}
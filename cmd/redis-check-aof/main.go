@@ -0,0 +1,27 @@
+// Command redis-check-aof verifies an append only file, given by its
+// manifest or as a single file, and with --fix truncates its last file to
+// the last whole command so the server can load it.
+package main
+
+import (
+	"fmt"
+	"go-redis/server"
+	"os"
+)
+
+func main() {
+	args := os.Args[1:]
+	fix := false
+	if len(args) == 2 && args[0] == "--fix" {
+		fix = true
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s [--fix] <file.manifest|file.aof>\n", os.Args[0])
+		os.Exit(1)
+	}
+	if err := server.CheckAOF(args[0], fix, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
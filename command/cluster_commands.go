@@ -0,0 +1,314 @@
+package command
+
+import (
+	"fmt"
+	"go-redis/pkg/utils"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/resp"
+)
+
+const (
+	commandCluster   = "cluster"
+	commandAsking    = "asking"
+	commandReadonly  = "readonly"
+	commandReadwrite = "readwrite"
+)
+
+var errClusterDisabled = fmt.Errorf("This instance has cluster support disabled")
+
+// clusterSubArity is the number of arguments of each CLUSTER subcommand,
+// the subcommand included, negative for a minimum.
+var clusterSubArity = map[string]int{
+	"INFO":                  1,
+	"NODES":                 1,
+	"SLOTS":                 1,
+	"SHARDS":                1,
+	"MYID":                  1,
+	"SAVECONFIG":            1,
+	"KEYSLOT":               2,
+	"COUNTKEYSINSLOT":       2,
+	"GETKEYSINSLOT":         3,
+	"MEET":                  -3,
+	"FORGET":                2,
+	"REPLICATE":             2,
+	"COUNT-FAILURE-REPORTS": 2,
+	"ADDSLOTS":              -2,
+	"DELSLOTS":              -2,
+	"ADDSLOTSRANGE":         -3,
+	"DELSLOTSRANGE":         -3,
+	"SETSLOT":               -3,
+}
+
+// ClusterCommand is the CLUSTER family. Slots holds the slots named by
+// ADDSLOTS, DELSLOTS and their range variants, and the slot of
+// COUNTKEYSINSLOT, GETKEYSINSLOT and SETSLOT.
+type ClusterCommand struct {
+	Sub   string
+	Args  []string
+	Slots []int
+	// Count is the number of keys of GETKEYSINSLOT, Port and BusPort the
+	// address of MEET.
+	Count   int
+	Port    int
+	BusPort int
+}
+
+func ClusterCommandHandler(set []resp.Value) (Command, error) {
+	cmd := ClusterCommand{Sub: strings.ToUpper(set[1].String()), Args: stringArgs(set[2:])}
+	arity, ok := clusterSubArity[cmd.Sub]
+	if !ok {
+		return nil, fmt.Errorf("unknown subcommand '%s'. Try CLUSTER HELP.", set[1].String())
+	}
+	argc := len(set) - 1
+	if (arity > 0 && argc != arity) || (arity < 0 && argc < -arity) {
+		return nil, fmt.Errorf("wrong number of arguments for 'cluster|%s' command", strings.ToLower(cmd.Sub))
+	}
+	switch cmd.Sub {
+	case "COUNTKEYSINSLOT", "GETKEYSINSLOT", "ADDSLOTS", "DELSLOTS":
+		args := cmd.Args
+		if cmd.Sub == "GETKEYSINSLOT" {
+			count, err := strconv.Atoi(args[1])
+			if err != nil || count < 0 {
+				return nil, fmt.Errorf("Invalid number of keys")
+			}
+			cmd.Count, args = count, args[:1]
+		}
+		for _, arg := range args {
+			slot, err := parseSlot(arg)
+			if err != nil {
+				return nil, err
+			}
+			cmd.Slots = append(cmd.Slots, slot)
+		}
+	case "ADDSLOTSRANGE", "DELSLOTSRANGE":
+		if len(cmd.Args)%2 != 0 {
+			return nil, fmt.Errorf("wrong number of arguments for 'cluster|%s' command", strings.ToLower(cmd.Sub))
+		}
+		for i := 0; i < len(cmd.Args); i += 2 {
+			start, err := parseSlot(cmd.Args[i])
+			if err != nil {
+				return nil, err
+			}
+			end, err := parseSlot(cmd.Args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			if start > end {
+				return nil, fmt.Errorf("start slot number %d is greater than end slot number %d", start, end)
+			}
+			for slot := start; slot <= end; slot++ {
+				cmd.Slots = append(cmd.Slots, slot)
+			}
+		}
+	case "SETSLOT":
+		slot, err := parseSlot(cmd.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		cmd.Slots = []int{slot}
+		state := strings.ToUpper(cmd.Args[1])
+		switch {
+		case state == "STABLE" && len(cmd.Args) == 2:
+		case (state == "MIGRATING" || state == "IMPORTING" || state == "NODE") && len(cmd.Args) == 3:
+		case state == "STABLE" || state == "MIGRATING" || state == "IMPORTING" || state == "NODE":
+			return nil, errSyntax
+		default:
+			return nil, fmt.Errorf("Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP")
+		}
+		cmd.Args[1] = state
+	case "MEET":
+		if len(cmd.Args) > 3 {
+			return nil, fmt.Errorf("wrong number of arguments for 'cluster|meet' command")
+		}
+		port, err := strconv.Atoi(cmd.Args[1])
+		if err != nil || port < 0 || port > 65535 {
+			return nil, fmt.Errorf("Invalid base port specified: %s", cmd.Args[1])
+		}
+		cmd.Port, cmd.BusPort = port, port+10000
+		if len(cmd.Args) == 3 {
+			busPort, err := strconv.Atoi(cmd.Args[2])
+			if err != nil || busPort < 0 || busPort > 65535 {
+				return nil, fmt.Errorf("Invalid bus port specified: %s", cmd.Args[2])
+			}
+			cmd.BusPort = busPort
+		}
+	}
+	return cmd, nil
+}
+
+// parseSlot parses a hash slot number.
+func parseSlot(arg string) (int, error) {
+	slot, err := strconv.Atoi(arg)
+	if err != nil || slot < 0 || slot >= utils.ClusterSlots {
+		return 0, fmt.Errorf("Invalid or out of range slot")
+	}
+	return slot, nil
+}
+
+func (c ClusterCommand) Name() string   { return commandCluster }
+func (c ClusterCommand) Keys() []string { return nil }
+
+func (c ClusterCommand) Execute(ctx *Context) error {
+	srv := ctx.Server
+	if !srv.ClusterEnabled() {
+		return errClusterDisabled
+	}
+	var err error
+	switch c.Sub {
+	case "INFO":
+		ctx.Reply.WriteVerbatim("txt", srv.ClusterInfo())
+		return nil
+	case "NODES":
+		ctx.Reply.WriteVerbatim("txt", srv.ClusterNodes())
+		return nil
+	case "MYID":
+		ctx.Reply.WriteBulkString(srv.ClusterMyID())
+		return nil
+	case "SLOTS":
+		writeClusterSlots(ctx.Reply, srv.ClusterSlots())
+		return nil
+	case "SHARDS":
+		writeClusterShards(ctx.Reply, srv.ClusterShards())
+		return nil
+	case "KEYSLOT":
+		ctx.Reply.WriteInteger(int64(utils.KeySlot(c.Args[0])))
+		return nil
+	case "COUNTKEYSINSLOT":
+		ctx.Reply.WriteInteger(int64(ctx.DB.CountKeysInSlot(c.Slots[0])))
+		return nil
+	case "GETKEYSINSLOT":
+		ctx.Reply.WriteBulks(ctx.DB.GetKeysInSlot(c.Slots[0], c.Count))
+		return nil
+	case "COUNT-FAILURE-REPORTS":
+		n, err := srv.ClusterCountFailureReports(c.Args[0])
+		if err != nil {
+			return err
+		}
+		ctx.Reply.WriteInteger(int64(n))
+		return nil
+	case "MEET":
+		err = srv.ClusterMeet(c.Args[0], c.Port, c.BusPort)
+	case "FORGET":
+		err = srv.ClusterForget(c.Args[0])
+	case "REPLICATE":
+		err = srv.ClusterReplicate(c.Args[0])
+	case "SAVECONFIG":
+		err = srv.ClusterSaveConfig()
+	case "ADDSLOTS", "ADDSLOTSRANGE":
+		err = srv.ClusterAddSlots(c.Slots)
+	case "DELSLOTS", "DELSLOTSRANGE":
+		err = srv.ClusterDelSlots(c.Slots)
+	case "SETSLOT":
+		id := ""
+		if len(c.Args) == 3 {
+			id = c.Args[2]
+		}
+		err = srv.ClusterSetSlot(c.Slots[0], c.Args[1], id)
+	}
+	if err != nil {
+		return err
+	}
+	ctx.Reply.WriteOK()
+	return nil
+}
+
+// writeClusterSlots writes the CLUSTER SLOTS reply: every range with the
+// address and ID of its master then of its replicas.
+func writeClusterSlots(w ReplyWriter, ranges []ClusterSlotRange) {
+	w.WriteArray(len(ranges))
+	for _, r := range ranges {
+		w.WriteArray(2 + len(r.Nodes))
+		w.WriteInteger(int64(r.Start))
+		w.WriteInteger(int64(r.End))
+		for _, n := range r.Nodes {
+			w.WriteArray(4)
+			w.WriteBulkString(n.IP)
+			w.WriteInteger(int64(n.Port))
+			w.WriteBulkString(n.ID)
+			// no hostname is announced
+			w.WriteMap(0)
+		}
+	}
+}
+
+func writeClusterShards(w ReplyWriter, shards []ClusterShard) {
+	w.WriteArray(len(shards))
+	for _, shard := range shards {
+		w.WriteMap(2)
+		w.WriteBulkString("slots")
+		w.WriteArray(2 * len(shard.Slots))
+		for _, r := range shard.Slots {
+			w.WriteInteger(int64(r[0]))
+			w.WriteInteger(int64(r[1]))
+		}
+		w.WriteBulkString("nodes")
+		w.WriteArray(len(shard.Nodes))
+		for _, n := range shard.Nodes {
+			w.WriteMap(7)
+			w.WriteBulkString("id")
+			w.WriteBulkString(n.ID)
+			w.WriteBulkString("port")
+			w.WriteInteger(int64(n.Port))
+			w.WriteBulkString("ip")
+			w.WriteBulkString(n.IP)
+			w.WriteBulkString("endpoint")
+			w.WriteBulkString(n.IP)
+			w.WriteBulkString("role")
+			w.WriteBulkString(n.Role)
+			w.WriteBulkString("replication-offset")
+			w.WriteInteger(n.ReplOffset)
+			w.WriteBulkString("health")
+			w.WriteBulkString(n.Health)
+		}
+	}
+}
+
+// AskingCommand lets the next command use a slot the node is importing,
+// sent by clients following an ASK redirection.
+type AskingCommand struct{}
+
+func AskingCommandHandler(set []resp.Value) (Command, error) {
+	return AskingCommand{}, nil
+}
+
+func (c AskingCommand) Name() string   { return commandAsking }
+func (c AskingCommand) Keys() []string { return nil }
+
+func (c AskingCommand) Execute(ctx *Context) error {
+	if !ctx.Server.ClusterEnabled() {
+		return errClusterDisabled
+	}
+	ctx.Server.Asking(ctx.Client)
+	ctx.Reply.WriteOK()
+	return nil
+}
+
+// ReadonlyCommand is READONLY, which lets a replica serve the reads of
+// the slots of its master, or READWRITE which turns that off.
+type ReadonlyCommand struct {
+	On bool
+}
+
+func ReadonlyCommandHandler(set []resp.Value) (Command, error) {
+	return ReadonlyCommand{On: strings.EqualFold(set[0].String(), commandReadonly)}, nil
+}
+
+func (c ReadonlyCommand) Name() string {
+	if c.On {
+		return commandReadonly
+	}
+	return commandReadwrite
+}
+
+func (c ReadonlyCommand) Keys() []string { return nil }
+
+func (c ReadonlyCommand) Execute(ctx *Context) error {
+	if !ctx.Server.ClusterEnabled() {
+		return errClusterDisabled
+	}
+	ctx.Server.ReadOnly(ctx.Client, c.On)
+	ctx.Reply.WriteOK()
+	return nil
+}
@@ -1,58 +1,508 @@
 package command
 
 import (
-	"bytes"
 	"fmt"
-	"io"
-	"log/slog"
 
 	"github.com/tidwall/resp"
 )
 
-var commandsHandlers = map[string]func([]resp.Value) (Command, error){
-	commandSet:    SetCommandHandler,
-	commandGet:    GetCommandHandler,
-	commandDel:    DelCommandHandler,
-	commandExist:  ExistCommandHandler,
-	commandIncr:   IncrCommandHandler,
-	commandDecr:   DecrCommandHandler,
-	commandLpush:  PushCommandHandler,
-	commandRpush:  PushCommandHandler,
-	commandLRange: LrangeCommandHandler,
-	commandZadd:   ZaddCommandHandler,
-	commandZscore: ZscoreCommandHandler,
-	commandZrank:  ZrankCommandHandler,
-}
+// commandTable describes every command the server understands, it drives
+// both parsing and dispatch.
+var commandTable = NewTable(
+	Spec{
+		Name: commandSet, Arity: -3, Flags: FlagWrite | FlagDenyOOM, FirstKey: 1, LastKey: 1, Step: 1,
+		Handler: SetCommandHandler,
+		Doc: Doc{
+			Summary: "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist.",
+			Since:   "1.0.0", Group: "string", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandGet, Arity: 2, Flags: FlagReadonly | FlagFast, FirstKey: 1, LastKey: 1, Step: 1,
+		Handler: GetCommandHandler,
+		Doc: Doc{
+			Summary: "Returns the string value of a key.",
+			Since:   "1.0.0", Group: "string", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandDel, Arity: -2, Flags: FlagWrite, FirstKey: 1, LastKey: -1, Step: 1,
+		Handler: DelCommandHandler,
+		Doc: Doc{
+			Summary: "Deletes one or more keys.",
+			Since:   "1.0.0", Group: "generic", Complexity: "O(N) where N is the number of keys that will be removed.",
+		},
+	},
+	Spec{
+		Name: commandUnlink, Arity: -2, Flags: FlagWrite | FlagFast, FirstKey: 1, LastKey: -1, Step: 1,
+		Handler: DelCommandHandler,
+		Doc: Doc{
+			Summary: "Asynchronously deletes one or more keys.",
+			Since:   "4.0.0", Group: "generic", Complexity: "O(1) for each key removed regardless of its size.",
+		},
+	},
+	Spec{
+		Name: commandExists, Arity: -2, Flags: FlagReadonly | FlagFast, FirstKey: 1, LastKey: -1, Step: 1,
+		Handler: ExistsCommandHandler,
+		Doc: Doc{
+			Summary: "Determines whether one or more keys exist.",
+			Since:   "1.0.0", Group: "generic", Complexity: "O(N) where N is the number of keys to check.",
+		},
+	},
+	// EXIST is the name EXISTS had before the generic commands, it is kept
+	// for one release.
+	Spec{
+		Name: commandExist, Arity: -2, Flags: FlagReadonly | FlagFast, FirstKey: 1, LastKey: -1, Step: 1,
+		Handler: ExistsCommandHandler,
+		Doc: Doc{
+			Summary: "Deprecated alias of EXISTS, to be removed in the next release.",
+			Since:   "1.0.0", Group: "generic", Complexity: "O(N) where N is the number of keys to check.",
+		},
+	},
+	Spec{
+		Name: commandTouch, Arity: -2, Flags: FlagReadonly | FlagFast, FirstKey: 1, LastKey: -1, Step: 1,
+		Handler: ExistsCommandHandler,
+		Doc: Doc{
+			Summary: "Returns the number of existing keys out of those specified after updating the time they were last accessed.",
+			Since:   "3.2.1", Group: "generic", Complexity: "O(N) where N is the number of keys that will be touched.",
+		},
+	},
+	Spec{
+		Name: commandType, Arity: 2, Flags: FlagReadonly | FlagFast, FirstKey: 1, LastKey: 1, Step: 1,
+		Handler: TypeCommandHandler,
+		Doc: Doc{
+			Summary: "Determines the type of value stored at a key.",
+			Since:   "1.0.0", Group: "generic", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandKeys, Arity: 2, Flags: FlagReadonly,
+		Handler: KeysCommandHandler,
+		Doc: Doc{
+			Summary: "Returns all key names that match a pattern.",
+			Since:   "1.0.0", Group: "generic", Complexity: "O(N) with N being the number of keys in the database, under the assumption that the key names in the database and the given pattern have limited length.",
+		},
+	},
+	Spec{
+		Name: commandRename, Arity: 3, Flags: FlagWrite, FirstKey: 1, LastKey: 2, Step: 1,
+		Handler: RenameCommandHandler,
+		Doc: Doc{
+			Summary: "Renames a key and overwrites the destination.",
+			Since:   "1.0.0", Group: "generic", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandRenamenx, Arity: 3, Flags: FlagWrite | FlagFast, FirstKey: 1, LastKey: 2, Step: 1,
+		Handler: RenameCommandHandler,
+		Doc: Doc{
+			Summary: "Renames a key only when the target key name doesn't exist.",
+			Since:   "1.0.0", Group: "generic", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandCopy, Arity: -3, Flags: FlagWrite | FlagDenyOOM, FirstKey: 1, LastKey: 2, Step: 1,
+		Handler: CopyCommandHandler,
+		Doc: Doc{
+			Summary: "Copies the value of a key to a new key.",
+			Since:   "6.2.0", Group: "generic", Complexity: "O(N) worst case for collections, where N is the number of nested items. O(1) for string values.",
+		},
+	},
+	Spec{
+		Name: commandRandomkey, Arity: 1, Flags: FlagReadonly,
+		Handler: RandomkeyCommandHandler,
+		Doc: Doc{
+			Summary: "Returns a random key name from the database.",
+			Since:   "1.0.0", Group: "generic", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandExpire, Arity: -3, Flags: FlagWrite | FlagFast, FirstKey: 1, LastKey: 1, Step: 1,
+		Handler: ExpireCommandHandler,
+		Doc: Doc{
+			Summary: "Sets the expiration time of a key in seconds.",
+			Since:   "1.0.0", Group: "generic", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandPexpire, Arity: -3, Flags: FlagWrite | FlagFast, FirstKey: 1, LastKey: 1, Step: 1,
+		Handler: ExpireCommandHandler,
+		Doc: Doc{
+			Summary: "Sets the expiration time of a key in milliseconds.",
+			Since:   "2.6.0", Group: "generic", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandExpireat, Arity: -3, Flags: FlagWrite | FlagFast, FirstKey: 1, LastKey: 1, Step: 1,
+		Handler: ExpireCommandHandler,
+		Doc: Doc{
+			Summary: "Sets the expiration time of a key to a Unix timestamp.",
+			Since:   "1.2.0", Group: "generic", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandPexpireat, Arity: -3, Flags: FlagWrite | FlagFast, FirstKey: 1, LastKey: 1, Step: 1,
+		Handler: ExpireCommandHandler,
+		Doc: Doc{
+			Summary: "Sets the expiration time of a key to a Unix milliseconds timestamp.",
+			Since:   "2.6.0", Group: "generic", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandTTL, Arity: 2, Flags: FlagReadonly | FlagFast, FirstKey: 1, LastKey: 1, Step: 1,
+		Handler: TTLCommandHandler,
+		Doc: Doc{
+			Summary: "Returns the expiration time in seconds of a key.",
+			Since:   "1.0.0", Group: "generic", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandPTTL, Arity: 2, Flags: FlagReadonly | FlagFast, FirstKey: 1, LastKey: 1, Step: 1,
+		Handler: TTLCommandHandler,
+		Doc: Doc{
+			Summary: "Returns the expiration time in milliseconds of a key.",
+			Since:   "2.6.0", Group: "generic", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandExpiretime, Arity: 2, Flags: FlagReadonly | FlagFast, FirstKey: 1, LastKey: 1, Step: 1,
+		Handler: TTLCommandHandler,
+		Doc: Doc{
+			Summary: "Returns the expiration time of a key as a Unix timestamp.",
+			Since:   "7.0.0", Group: "generic", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandPexpiretime, Arity: 2, Flags: FlagReadonly | FlagFast, FirstKey: 1, LastKey: 1, Step: 1,
+		Handler: TTLCommandHandler,
+		Doc: Doc{
+			Summary: "Returns the expiration time of a key as a Unix milliseconds timestamp.",
+			Since:   "7.0.0", Group: "generic", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandPersist, Arity: 2, Flags: FlagWrite | FlagFast, FirstKey: 1, LastKey: 1, Step: 1,
+		Handler: PersistCommandHandler,
+		Doc: Doc{
+			Summary: "Removes the expiration time of a key.",
+			Since:   "2.2.0", Group: "generic", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandScan, Arity: -2, Flags: FlagReadonly,
+		Handler: ScanCommandHandler,
+		Doc: Doc{
+			Summary: "Iterates over the key names in the database.",
+			Since:   "2.8.0", Group: "generic", Complexity: "O(1) for every call. O(N) for a complete iteration, including enough command calls for the cursor to return back to 0. N is the number of elements inside the collection.",
+		},
+	},
+	Spec{
+		Name: commandDbsize, Arity: 1, Flags: FlagReadonly | FlagFast,
+		Handler: DbsizeCommandHandler,
+		Doc: Doc{
+			Summary: "Returns the number of keys in the database.",
+			Since:   "1.0.0", Group: "server", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandIncr, Arity: -2, Flags: FlagWrite | FlagFast | FlagDenyOOM, FirstKey: 1, LastKey: 1, Step: 1,
+		Handler: IncrCommandHandler,
+		Doc: Doc{
+			Summary: "Increments the integer value of a key by one, or by the given amount. Uses 0 as initial value if the key doesn't exist.",
+			Since:   "1.0.0", Group: "string", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandDecr, Arity: -2, Flags: FlagWrite | FlagFast | FlagDenyOOM, FirstKey: 1, LastKey: 1, Step: 1,
+		Handler: DecrCommandHandler,
+		Doc: Doc{
+			Summary: "Decrements the integer value of a key by one, or by the given amount. Uses 0 as initial value if the key doesn't exist.",
+			Since:   "1.0.0", Group: "string", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandLpush, Arity: 3, Flags: FlagWrite | FlagFast | FlagDenyOOM, FirstKey: 1, LastKey: 1, Step: 1,
+		Handler: PushCommandHandler,
+		Doc: Doc{
+			Summary: "Prepends an element to a list. Creates the key if it doesn't exist.",
+			Since:   "1.0.0", Group: "list", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandRpush, Arity: 3, Flags: FlagWrite | FlagFast | FlagDenyOOM, FirstKey: 1, LastKey: 1, Step: 1,
+		Handler: PushCommandHandler,
+		Doc: Doc{
+			Summary: "Appends an element to a list. Creates the key if it doesn't exist.",
+			Since:   "1.0.0", Group: "list", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandLRange, Arity: 4, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Step: 1,
+		Handler: LrangeCommandHandler,
+		Doc: Doc{
+			Summary: "Returns a range of elements from a list.",
+			Since:   "1.0.0", Group: "list", Complexity: "O(S+N) where S is the distance of start offset from HEAD and N is the number of elements in the specified range.",
+		},
+	},
+	Spec{
+		Name: commandZadd, Arity: 4, Flags: FlagWrite | FlagFast | FlagDenyOOM, FirstKey: 1, LastKey: 1, Step: 1,
+		Handler: ZaddCommandHandler,
+		Doc: Doc{
+			Summary: "Adds a member to a sorted set, or updates its score if it already exists.",
+			Since:   "1.2.0", Group: "sorted-set", Complexity: "O(log(N)) where N is the number of elements in the sorted set.",
+		},
+	},
+	Spec{
+		Name: commandZscore, Arity: 3, Flags: FlagReadonly | FlagFast, FirstKey: 1, LastKey: 1, Step: 1,
+		Handler: ZscoreCommandHandler,
+		Doc: Doc{
+			Summary: "Returns the score of a member in a sorted set.",
+			Since:   "1.2.0", Group: "sorted-set", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandZrank, Arity: 3, Flags: FlagReadonly | FlagFast, FirstKey: 1, LastKey: 1, Step: 1,
+		Handler: ZrankCommandHandler,
+		Doc: Doc{
+			Summary: "Returns the index of a member in a sorted set ordered by ascending scores.",
+			Since:   "2.0.0", Group: "sorted-set", Complexity: "O(log(N))",
+		},
+	},
+	Spec{
+		Name: commandZscan, Arity: -3, Flags: FlagReadonly, FirstKey: 1, LastKey: 1, Step: 1,
+		Handler: ZscanCommandHandler,
+		Doc: Doc{
+			Summary: "Iterates over members and scores of a sorted set.",
+			Since:   "2.8.0", Group: "sorted-set", Complexity: "O(1) for every call. O(N) for a complete iteration, including enough command calls for the cursor to return back to 0. N is the number of elements inside the collection.",
+		},
+	},
+	Spec{
+		Name: commandHello, Arity: -1, Flags: FlagFast,
+		Handler: HelloCommandHandler,
+		Doc: Doc{
+			Summary: "Handshakes with the Redis server.",
+			Since:   "6.0.0", Group: "connection", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandShutdown, Arity: -1, Flags: FlagAdmin,
+		Handler: ShutdownCommandHandler,
+		Doc: Doc{
+			Summary: "Synchronously saves the database(s) to disk and shuts down the Redis server.",
+			Since:   "1.0.0", Group: "server", Complexity: "O(N) when saving, where N is the total number of keys in all databases when saving data, otherwise O(1)",
+		},
+	},
+	Spec{
+		Name: commandConfig, Arity: -2, Flags: FlagAdmin,
+		Handler: ConfigCommandHandler,
+		Doc: Doc{
+			Summary: "A container for server configuration commands.",
+			Since:   "2.0.0", Group: "server", Complexity: "Depends on subcommand.",
+		},
+	},
+	Spec{
+		Name: commandSlowlog, Arity: -2, Flags: FlagAdmin,
+		Handler: SlowlogCommandHandler,
+		Doc: Doc{
+			Summary: "A container for slow log commands.",
+			Since:   "2.2.12", Group: "server", Complexity: "Depends on subcommand.",
+		},
+	},
+	Spec{
+		Name: commandInfo, Arity: -1,
+		Handler: InfoCommandHandler,
+		Doc: Doc{
+			Summary: "Returns information and statistics about the server.",
+			Since:   "1.0.0", Group: "server", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandSave, Arity: 1, Flags: FlagAdmin,
+		Handler: SaveCommandHandler,
+		Doc: Doc{
+			Summary: "Synchronously saves the database(s) to disk.",
+			Since:   "1.0.0", Group: "server", Complexity: "O(N) where N is the total number of keys in all databases",
+		},
+	},
+	Spec{
+		Name: commandBgsave, Arity: -1, Flags: FlagAdmin,
+		Handler: BgsaveCommandHandler,
+		Doc: Doc{
+			Summary: "Asynchronously saves the database(s) to disk.",
+			Since:   "1.0.0", Group: "server", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandLastsave, Arity: 1, Flags: FlagFast,
+		Handler: LastsaveCommandHandler,
+		Doc: Doc{
+			Summary: "Returns the Unix timestamp of the last successful save to disk.",
+			Since:   "1.0.0", Group: "server", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandBgrewriteaof, Arity: 1, Flags: FlagAdmin,
+		Handler: BgrewriteaofCommandHandler,
+		Doc: Doc{
+			Summary: "Asynchronously rewrites the append-only file to disk.",
+			Since:   "1.0.0", Group: "server", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandPing, Arity: -1, Flags: FlagFast,
+		Handler: PingCommandHandler,
+		Doc: Doc{
+			Summary: "Returns the server's liveliness response.",
+			Since:   "1.0.0", Group: "connection", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandSelect, Arity: 2, Flags: FlagFast,
+		Handler: SelectCommandHandler,
+		Doc: Doc{
+			Summary: "Changes the selected database.",
+			Since:   "1.0.0", Group: "connection", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandReplicaof, Arity: 3, Flags: FlagAdmin,
+		Handler: ReplicaofCommandHandler,
+		Doc: Doc{
+			Summary: "Configures a server as replica of another, or promotes it to a master.",
+			Since:   "5.0.0", Group: "server", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandSlaveof, Arity: 3, Flags: FlagAdmin,
+		Handler: ReplicaofCommandHandler,
+		Doc: Doc{
+			Summary: "Sets a Redis server as a replica of another, or promotes it to being a master.",
+			Since:   "1.0.0", Group: "server", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandReplconf, Arity: -1, Flags: FlagAdmin,
+		Handler: ReplconfCommandHandler,
+		Doc: Doc{
+			Summary: "An internal command for configuring the replication stream.",
+			Since:   "3.0.0", Group: "server", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandPsync, Arity: -3, Flags: FlagAdmin,
+		Handler: PsyncCommandHandler,
+		Doc: Doc{
+			Summary: "An internal command used in replication.",
+			Since:   "2.8.0", Group: "server",
+		},
+	},
+	Spec{
+		Name: commandSync, Arity: 1, Flags: FlagAdmin,
+		Handler: SyncCommandHandler,
+		Doc: Doc{
+			Summary: "An internal command used in replication.",
+			Since:   "1.0.0", Group: "server",
+		},
+	},
+	Spec{
+		Name: commandWait, Arity: 3, Flags: FlagBlocking,
+		Handler: WaitCommandHandler,
+		Doc: Doc{
+			Summary: "Blocks until the asynchronous replication of all preceding write commands sent by the connection is completed.",
+			Since:   "3.0.0", Group: "generic",
+		},
+	},
+	Spec{
+		Name: commandWaitaof, Arity: 4, Flags: FlagBlocking,
+		Handler: WaitaofCommandHandler,
+		Doc: Doc{
+			Summary: "Blocks until all of the preceding write commands sent by the connection are written to the append-only file of the master and/or replicas.",
+			Since:   "7.2.0", Group: "generic",
+		},
+	},
+	Spec{
+		Name: commandCluster, Arity: -2,
+		Handler: ClusterCommandHandler,
+		Doc: Doc{
+			Summary: "A container for Redis Cluster commands.",
+			Since:   "3.0.0", Group: "cluster", Complexity: "Depends on subcommand.",
+		},
+	},
+	Spec{
+		Name: commandAsking, Arity: 1, Flags: FlagFast,
+		Handler: AskingCommandHandler,
+		Doc: Doc{
+			Summary: "Signals that a cluster client is following an -ASK redirect.",
+			Since:   "3.0.0", Group: "cluster", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandReadonly, Arity: 1, Flags: FlagFast,
+		Handler: ReadonlyCommandHandler,
+		Doc: Doc{
+			Summary: "Enables read-only queries for a connection to a Redis Cluster replica node.",
+			Since:   "3.0.0", Group: "cluster", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandReadwrite, Arity: 1, Flags: FlagFast,
+		Handler: ReadonlyCommandHandler,
+		Doc: Doc{
+			Summary: "Enables read-write queries for a connection to a Redis Cluster replica node.",
+			Since:   "3.0.0", Group: "cluster", Complexity: "O(1)",
+		},
+	},
+	Spec{
+		Name: commandCommand, Arity: -1,
+		Handler: CommandCommandHandler,
+		Doc: Doc{
+			Summary: "Returns detailed information about all commands.",
+			Since:   "2.8.13", Group: "server", Complexity: "O(N) where N is the total number of Redis commands",
+		},
+	},
+)
 
+// Command is a parsed command, ready to be executed.
 type Command interface {
-	// todo
+	// Name returns the lower case command name, as in the command table.
+	Name() string
+	// Keys returns the keys the command accesses.
+	Keys() []string
+	// Execute runs the command and writes its reply to ctx.Reply. When an
+	// error is returned nothing has been written and the error is sent
+	// to the client instead.
+	Execute(ctx *Context) error
 }
 
-func ParseRawMsg(msg string) (Command, error) {
-	rd := resp.NewReader(bytes.NewBufferString(msg))
+// ParseRawMsg parses every command in msg, in order. Pipelining clients
+// send many commands in one write, so none may be dropped after the first.
+func ParseRawMsg(msg string) ([]Command, error) {
+	p := NewParser()
+	p.Feed([]byte(msg))
+	var cmds []Command
 	for {
-		msg, _, err := rd.ReadValue()
-		if err == io.EOF {
+		val, ok, err := p.Next()
+		if err != nil {
+			return cmds, err
+		}
+		if !ok {
 			break
 		}
+		cmd, err := ParseValue(val)
 		if err != nil {
-			slog.Error("parse command err: %d", "err", err)
-		}
-		if msg.Type() == resp.Array {
-			cmd, err := parseCommandType(msg)
-			if err != nil {
-				return nil, err
-			}
-			return cmd, nil
+			return cmds, err
 		}
+		cmds = append(cmds, cmd)
+	}
+	if len(cmds) == 0 {
+		return nil, fmt.Errorf("unknown command")
 	}
-	return nil, fmt.Errorf("unknown command")
+	return cmds, nil
 }
 
-func parseCommandType(val resp.Value) (Command, error) {
-	commandType := val.Array()[0].String()
-	if handler, ok := commandsHandlers[commandType]; ok {
-		return handler(val.Array())
+// ParseValue turns a single frame decoded by the Parser into a Command.
+func ParseValue(val resp.Value) (Command, error) {
+	if val.Type() != resp.Array || len(val.Array()) == 0 {
+		return nil, fmt.Errorf("unknown command")
 	}
-	return nil, fmt.Errorf("unknown command")
+	return commandTable.Parse(val.Array())
 }
@@ -2,8 +2,13 @@ package command
 
 import (
 	"testing"
-)
 
-func Testr(t *testing.T) {
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
 
+func TestParseRawMsgPipeline(t *testing.T) {
+	cmds, err := ParseRawMsg("*2\r\n$3\r\nGET\r\n$1\r\na\r\n*2\r\n$3\r\nGET\r\n$1\r\nb\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, []Command{GetCommand{Key: "a"}, GetCommand{Key: "b"}}, cmds)
 }
@@ -0,0 +1,152 @@
+package command
+
+import (
+	"fmt"
+	"go-redis/pkg/utils"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/resp"
+)
+
+const (
+	commandHello  = "hello"
+	commandPing   = "ping"
+	commandSelect = "select"
+
+	// ServerVersion is the Redis version this server reports to clients.
+	ServerVersion = "7.2.0"
+)
+
+// HelloCommand switches the connection protocol. Protover is 0 when the
+// client only asks for the server information.
+type HelloCommand struct {
+	Protover int
+	Username string
+	Password string
+	SetName  string
+}
+
+func HelloCommandHandler(set []resp.Value) (Command, error) {
+	cmd := HelloCommand{}
+	if len(set) == 1 {
+		return cmd, nil
+	}
+	ver, err := strconv.Atoi(set[1].String())
+	if err != nil {
+		return nil, fmt.Errorf("Protocol version is not an integer or out of range")
+	}
+	cmd.Protover = ver
+	for i := 2; i < len(set); i++ {
+		switch opt := strings.ToUpper(set[i].String()); {
+		case opt == "AUTH" && i+2 < len(set):
+			cmd.Username = set[i+1].String()
+			cmd.Password = set[i+2].String()
+			i += 2
+		case opt == "SETNAME" && i+1 < len(set):
+			cmd.SetName = set[i+1].String()
+			i++
+		default:
+			return nil, fmt.Errorf("syntax error in HELLO option '%s'", set[i].String())
+		}
+	}
+	return cmd, nil
+}
+
+func (c HelloCommand) Name() string   { return commandHello }
+func (c HelloCommand) Keys() []string { return nil }
+
+func (c HelloCommand) Execute(ctx *Context) error {
+	if c.Protover != 0 && c.Protover != 2 && c.Protover != 3 {
+		return utils.CodedErrorf("NOPROTO", "unsupported protocol version")
+	}
+	if strings.ContainsAny(c.SetName, " \n") {
+		return fmt.Errorf("Client names cannot contain spaces, newlines or special characters.")
+	}
+	if c.Protover != 0 {
+		ctx.Client.SetProtocol(c.Protover)
+	}
+	if c.SetName != "" {
+		ctx.Client.SetName(c.SetName)
+	}
+	w := ctx.Reply
+	w.WriteMap(7)
+	w.WriteBulkString("server")
+	w.WriteBulkString("redis")
+	w.WriteBulkString("version")
+	w.WriteBulkString(ServerVersion)
+	w.WriteBulkString("proto")
+	w.WriteInteger(int64(w.Protocol()))
+	w.WriteBulkString("id")
+	w.WriteInteger(ctx.Client.ID())
+	w.WriteBulkString("mode")
+	if ctx.Server.ClusterEnabled() {
+		w.WriteBulkString("cluster")
+	} else {
+		w.WriteBulkString("standalone")
+	}
+	w.WriteBulkString("role")
+	if ctx.Server.IsReplica() {
+		w.WriteBulkString("replica")
+	} else {
+		w.WriteBulkString("master")
+	}
+	w.WriteBulkString("modules")
+	w.WriteArray(0)
+	return nil
+}
+
+// PingCommand replies PONG, or echoes Message when one is given.
+type PingCommand struct {
+	Message *string
+}
+
+func PingCommandHandler(set []resp.Value) (Command, error) {
+	cmd := PingCommand{}
+	if len(set) > 2 {
+		return nil, fmt.Errorf("wrong number of arguments for 'ping' command")
+	}
+	if len(set) == 2 {
+		msg := set[1].String()
+		cmd.Message = &msg
+	}
+	return cmd, nil
+}
+
+func (c PingCommand) Name() string   { return commandPing }
+func (c PingCommand) Keys() []string { return nil }
+
+func (c PingCommand) Execute(ctx *Context) error {
+	if c.Message != nil {
+		ctx.Reply.WriteBulkString(*c.Message)
+		return nil
+	}
+	ctx.Reply.WriteSimpleString("PONG")
+	return nil
+}
+
+// SelectCommand switches the database of the connection. There is a
+// single database, so only 0 is accepted, it is what a master streams to
+// its replicas before the first write.
+type SelectCommand struct {
+	Index int
+}
+
+func SelectCommandHandler(set []resp.Value) (Command, error) {
+	index, err := strconv.Atoi(set[1].String())
+	if err != nil {
+		return nil, fmt.Errorf("value is not an integer or out of range")
+	}
+	return SelectCommand{Index: index}, nil
+}
+
+func (c SelectCommand) Name() string   { return commandSelect }
+func (c SelectCommand) Keys() []string { return nil }
+
+func (c SelectCommand) Execute(ctx *Context) error {
+	if c.Index != 0 {
+		return fmt.Errorf("DB index is out of range")
+	}
+	ctx.Reply.WriteOK()
+	return nil
+}
@@ -0,0 +1,189 @@
+package command
+
+import (
+	"go-redis/repo"
+	"time"
+)
+
+// ReplyWriter encodes the reply of a command for the client, it is
+// implemented by the server connection. RESP3 types fall back to their
+// RESP2 equivalent for clients that did not negotiate protocol 3.
+type ReplyWriter interface {
+	Protocol() int
+	WriteOK()
+	WriteSimpleString(s string)
+	WriteError(err error)
+	WriteInteger(n int64)
+	WriteBulk(b []byte)
+	WriteBulkString(s string)
+	WriteBulks(vals []string)
+	WriteFloat(f float64)
+	WriteNull()
+	WriteNullArray()
+	WriteArray(n int)
+	WriteMap(n int)
+	WriteSet(n int)
+	WritePush(n int)
+	WriteAttribute(pairs ...string)
+	WriteDouble(f float64)
+	WriteBool(b bool)
+	WriteBigNumber(n string)
+	WriteVerbatim(format, s string)
+}
+
+// Client is the connection a command is executed for.
+type Client interface {
+	ID() int64
+	Name() string
+	SetName(name string)
+	SetProtocol(proto int)
+}
+
+// ShutdownOptions are the modifiers of SHUTDOWN.
+type ShutdownOptions struct {
+	Save   bool
+	NoSave bool
+	Now    bool
+	Force  bool
+	Abort  bool
+}
+
+// Server is the server a command runs in, used by the commands acting on
+// the server itself rather than on data.
+type Server interface {
+	// RequestShutdown makes the server shut down once the current command
+	// is handled.
+	RequestShutdown(opts ShutdownOptions) error
+	// ConfigGet returns the parameters whose name matches one of the glob
+	// patterns, as name and value pairs.
+	ConfigGet(patterns []string) []string
+	// ConfigSet applies name and value pairs, either all of them or none.
+	ConfigSet(pairs []string) error
+	// ConfigRewrite writes the running configuration to the config file.
+	ConfigRewrite() error
+	// ResetStats zeroes the server statistics.
+	ResetStats()
+	// SlowlogGet returns up to count slow log entries, newest first, or all
+	// of them when count is negative.
+	SlowlogGet(count int) []SlowlogEntry
+	SlowlogLen() int
+	SlowlogReset()
+	// Info renders the named INFO sections, the default ones when none is
+	// named.
+	Info(sections []string) string
+	// Save writes the dataset to disk before returning, BGSave in the
+	// background.
+	Save() error
+	BGSave() error
+	// LastSave returns when the dataset was last saved.
+	LastSave() time.Time
+	// BGRewriteAOF compacts the AOF in the background.
+	BGRewriteAOF() error
+	// ReplicaOf makes the server a replica of host:port, or a master when
+	// host is empty, and returns the status to reply.
+	ReplicaOf(host string, port int) (string, error)
+	// IsReplica reports whether the server replicates a master.
+	IsReplica() bool
+	// ReplConf applies the REPLCONF options sent by client.
+	ReplConf(client Client, args []string) error
+	// Psync makes client a replica, streaming from offset when possible,
+	// and Sync with a full sync. Both write their replies themselves.
+	Psync(client Client, replid string, offset int64) error
+	Sync(client Client) error
+	// Wait returns how many replicas acknowledged the last write of client,
+	// or blocks it and sends the reply itself once numReplicas did or
+	// timeout expired. WaitAOF does the same for the fsynced AOFs, locally
+	// and on the replicas.
+	Wait(client Client, numReplicas int, timeout time.Duration) (replicas int, blocked bool, err error)
+	WaitAOF(client Client, numLocal, numReplicas int, timeout time.Duration) (local, replicas int, blocked bool, err error)
+
+	// ClusterEnabled reports whether the server is a cluster node, the
+	// Cluster methods are only called when it is.
+	ClusterEnabled() bool
+	ClusterMyID() string
+	ClusterInfo() string
+	// ClusterNodes lists the nodes the way CLUSTER NODES replies.
+	ClusterNodes() string
+	ClusterSlots() []ClusterSlotRange
+	ClusterShards() []ClusterShard
+	// ClusterMeet adds the node at ip:port, whose bus listens on busPort,
+	// to the cluster.
+	ClusterMeet(ip string, port, busPort int) error
+	ClusterForget(id string) error
+	ClusterAddSlots(slots []int) error
+	ClusterDelSlots(slots []int) error
+	// ClusterSetSlot sets slot MIGRATING to, IMPORTING from or served by
+	// (NODE) the node id, or STABLE.
+	ClusterSetSlot(slot int, state, id string) error
+	ClusterReplicate(id string) error
+	ClusterSaveConfig() error
+	ClusterCountFailureReports(id string) (int, error)
+	// Asking lets the next command of client use a slot being imported,
+	// ReadOnly lets client read from a replica.
+	Asking(client Client)
+	ReadOnly(client Client, on bool)
+}
+
+// ClusterNode is a node as listed by CLUSTER SLOTS and CLUSTER SHARDS.
+type ClusterNode struct {
+	ID         string
+	IP         string
+	Port       int
+	Role       string
+	ReplOffset int64
+	Health     string
+}
+
+// ClusterSlotRange is a range of slots with the nodes serving it, the
+// master first.
+type ClusterSlotRange struct {
+	Start, End int
+	Nodes      []ClusterNode
+}
+
+// ClusterShard is a master with its replicas, and the ranges of slots they
+// serve.
+type ClusterShard struct {
+	Slots [][2]int
+	Nodes []ClusterNode
+}
+
+// SlowlogEntry is a command that ran for longer than the slow log
+// threshold.
+type SlowlogEntry struct {
+	ID       int64
+	Time     time.Time
+	Duration time.Duration
+	// Args is the command line, possibly shortened.
+	Args []string
+	Addr string
+	Name string
+}
+
+// Context is everything a command is executed against. A command writes
+// its reply to Reply, or returns an error which is sent to the client
+// instead.
+type Context struct {
+	Reply  ReplyWriter
+	Client Client
+	Server Server
+	// DB is the keyspace the command reads and writes.
+	DB *repo.DB
+
+	// propagated replaces the command in the AOF, see Propagate.
+	propagated [][]string
+}
+
+// Propagate logs args in the AOF instead of the command being executed,
+// called once per command to log. Commands whose effect depends on when
+// they run use it, so replaying them later gives the same dataset: a
+// relative time to live is logged as an absolute one.
+func (ctx *Context) Propagate(args ...string) {
+	ctx.propagated = append(ctx.propagated, args)
+}
+
+// Propagated returns the commands given to Propagate, nil when the command
+// is logged as received.
+func (ctx *Context) Propagated() [][]string {
+	return ctx.propagated
+}
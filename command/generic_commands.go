@@ -0,0 +1,496 @@
+package command
+
+import (
+	"fmt"
+	"go-redis/pkg/utils"
+	"go-redis/repo"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+const (
+	commandDel         = "del"
+	commandUnlink      = "unlink"
+	commandExists      = "exists"
+	commandExist       = "exist"
+	commandTouch       = "touch"
+	commandType        = "type"
+	commandKeys        = "keys"
+	commandRename      = "rename"
+	commandRenamenx    = "renamenx"
+	commandCopy        = "copy"
+	commandRandomkey   = "randomkey"
+	commandDbsize      = "dbsize"
+	commandScan        = "scan"
+	commandExpire      = "expire"
+	commandPexpire     = "pexpire"
+	commandExpireat    = "expireat"
+	commandPexpireat   = "pexpireat"
+	commandTTL         = "ttl"
+	commandPTTL        = "pttl"
+	commandExpiretime  = "expiretime"
+	commandPexpiretime = "pexpiretime"
+	commandPersist     = "persist"
+)
+
+// DelCommand is DEL or UNLINK, T is the command name in upper case. Memory
+// is reclaimed by the garbage collector, so both delete the same way.
+type DelCommand struct {
+	T       string
+	KeyArgs []string
+}
+
+// ExistsCommand is EXISTS, its alias EXIST or TOUCH, all count the keys
+// that exist.
+type ExistsCommand struct {
+	T       string
+	KeyArgs []string
+}
+
+type TypeCommand struct {
+	Key string
+}
+
+type KeysCommand struct {
+	Pattern string
+}
+
+// RenameCommand is RENAME, or RENAMENX when NX is set.
+type RenameCommand struct {
+	Src string
+	Dst string
+	NX  bool
+}
+
+type CopyCommand struct {
+	Src     string
+	Dst     string
+	Replace bool
+}
+
+type RandomkeyCommand struct{}
+
+type DbsizeCommand struct{}
+
+type ScanCommand struct {
+	ScanArgs
+	Type string
+}
+
+// ExpireCommand is EXPIRE, PEXPIRE, EXPIREAT or PEXPIREAT, T is the
+// command name in upper case. Amount counts seconds or milliseconds, from
+// now or, for the AT variants, from the Unix epoch.
+type ExpireCommand struct {
+	T      string
+	Key    string
+	Amount int64
+	Cond   repo.ExpireCond
+}
+
+// TTLCommand is TTL, PTTL, EXPIRETIME or PEXPIRETIME, T is the command
+// name in upper case.
+type TTLCommand struct {
+	T   string
+	Key string
+}
+
+type PersistCommand struct {
+	Key string
+}
+
+// ScanArgs are the arguments shared by SCAN and ZSCAN.
+type ScanArgs struct {
+	Cursor uint64
+	Match  string
+	Count  int
+}
+
+// parseScanArgs parses cursor [MATCH pattern] [COUNT count], followed by
+// [TYPE type] when typ is not nil.
+func parseScanArgs(set []resp.Value, typ *string) (ScanArgs, error) {
+	args := ScanArgs{Count: 10}
+	cursor, err := strconv.ParseUint(set[0].String(), 10, 64)
+	if err != nil {
+		return args, fmt.Errorf("invalid cursor")
+	}
+	args.Cursor = cursor
+	for i := 1; i < len(set); i += 2 {
+		if i+1 == len(set) {
+			return args, errSyntax
+		}
+		val := set[i+1].String()
+		switch opt := strings.ToUpper(set[i].String()); {
+		case opt == "MATCH":
+			if val != "*" {
+				args.Match = val
+			}
+		case opt == "COUNT":
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return args, fmt.Errorf("value is not an integer or out of range")
+			}
+			if n < 1 {
+				return args, errSyntax
+			}
+			args.Count = n
+		case opt == "TYPE" && typ != nil:
+			switch t := strings.ToLower(val); t {
+			case "string", "list", "zset":
+				*typ = t
+			default:
+				return args, fmt.Errorf("unknown type name '%s'", val)
+			}
+		default:
+			return args, errSyntax
+		}
+	}
+	return args, nil
+}
+
+func stringArgs(vals []resp.Value) []string {
+	args := make([]string, len(vals))
+	for i, v := range vals {
+		args[i] = v.String()
+	}
+	return args
+}
+
+func DelCommandHandler(set []resp.Value) (Command, error) {
+	cmd := DelCommand{
+		T:       strings.ToUpper(set[0].String()),
+		KeyArgs: stringArgs(set[1:]),
+	}
+	return cmd, nil
+}
+
+func ExistsCommandHandler(set []resp.Value) (Command, error) {
+	cmd := ExistsCommand{
+		T:       strings.ToUpper(set[0].String()),
+		KeyArgs: stringArgs(set[1:]),
+	}
+	return cmd, nil
+}
+
+func TypeCommandHandler(set []resp.Value) (Command, error) {
+	return TypeCommand{Key: set[1].String()}, nil
+}
+
+func KeysCommandHandler(set []resp.Value) (Command, error) {
+	return KeysCommand{Pattern: set[1].String()}, nil
+}
+
+func RenameCommandHandler(set []resp.Value) (Command, error) {
+	cmd := RenameCommand{
+		Src: set[1].String(),
+		Dst: set[2].String(),
+		NX:  strings.EqualFold(set[0].String(), commandRenamenx),
+	}
+	return cmd, nil
+}
+
+// CopyCommandHandler parses COPY source destination [DB destination-db]
+// [REPLACE], there is a single database so only DB 0 is accepted.
+func CopyCommandHandler(set []resp.Value) (Command, error) {
+	cmd := CopyCommand{
+		Src: set[1].String(),
+		Dst: set[2].String(),
+	}
+	for i := 3; i < len(set); i++ {
+		switch strings.ToUpper(set[i].String()) {
+		case "REPLACE":
+			cmd.Replace = true
+		case "DB":
+			if i+1 == len(set) {
+				return nil, errSyntax
+			}
+			i++
+			db, err := strconv.Atoi(set[i].String())
+			if err != nil {
+				return nil, fmt.Errorf("value is not an integer or out of range")
+			}
+			if db != 0 {
+				return nil, fmt.Errorf("DB index is out of range")
+			}
+		default:
+			return nil, errSyntax
+		}
+	}
+	return cmd, nil
+}
+
+func RandomkeyCommandHandler(set []resp.Value) (Command, error) {
+	return RandomkeyCommand{}, nil
+}
+
+func DbsizeCommandHandler(set []resp.Value) (Command, error) {
+	return DbsizeCommand{}, nil
+}
+
+// ScanCommandHandler parses SCAN cursor [MATCH pattern] [COUNT count]
+// [TYPE type].
+func ScanCommandHandler(set []resp.Value) (Command, error) {
+	cmd := ScanCommand{}
+	args, err := parseScanArgs(set[1:], &cmd.Type)
+	if err != nil {
+		return nil, err
+	}
+	cmd.ScanArgs = args
+	return cmd, nil
+}
+
+// ExpireCommandHandler parses key amount [NX|XX|GT|LT].
+func ExpireCommandHandler(set []resp.Value) (Command, error) {
+	cmd := ExpireCommand{
+		T:   strings.ToUpper(set[0].String()),
+		Key: set[1].String(),
+	}
+	n, err := strconv.ParseInt(set[2].String(), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("value is not an integer or out of range")
+	}
+	cmd.Amount = n
+	var nx, xx, gt, lt bool
+	for _, arg := range set[3:] {
+		switch strings.ToUpper(arg.String()) {
+		case "NX":
+			nx = true
+			cmd.Cond = repo.ExpireNX
+		case "XX":
+			xx = true
+			cmd.Cond = repo.ExpireXX
+		case "GT":
+			gt = true
+			cmd.Cond = repo.ExpireGT
+		case "LT":
+			lt = true
+			cmd.Cond = repo.ExpireLT
+		default:
+			return nil, fmt.Errorf("Unsupported option %s", arg.String())
+		}
+	}
+	if nx && (xx || gt || lt) {
+		return nil, fmt.Errorf("NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if gt && lt {
+		return nil, fmt.Errorf("GT and LT options at the same time are not compatible")
+	}
+	return cmd, nil
+}
+
+func TTLCommandHandler(set []resp.Value) (Command, error) {
+	cmd := TTLCommand{
+		T:   strings.ToUpper(set[0].String()),
+		Key: set[1].String(),
+	}
+	return cmd, nil
+}
+
+func PersistCommandHandler(set []resp.Value) (Command, error) {
+	return PersistCommand{Key: set[1].String()}, nil
+}
+
+func (c DelCommand) Name() string   { return strings.ToLower(c.T) }
+func (c DelCommand) Keys() []string { return c.KeyArgs }
+
+func (c DelCommand) Execute(ctx *Context) error {
+	var n int64
+	for _, key := range c.KeyArgs {
+		ok, err := ctx.DB.Delete(key)
+		if err != nil {
+			return err
+		}
+		if ok {
+			n++
+		}
+	}
+	ctx.Reply.WriteInteger(n)
+	return nil
+}
+
+func (c ExistsCommand) Name() string   { return strings.ToLower(c.T) }
+func (c ExistsCommand) Keys() []string { return c.KeyArgs }
+
+// Execute counts a key given several times as many times.
+func (c ExistsCommand) Execute(ctx *Context) error {
+	var n int64
+	for _, key := range c.KeyArgs {
+		ok, err := ctx.DB.Exist(key)
+		if err != nil {
+			return err
+		}
+		if ok {
+			n++
+		}
+	}
+	ctx.Reply.WriteInteger(n)
+	return nil
+}
+
+func (c TypeCommand) Name() string   { return commandType }
+func (c TypeCommand) Keys() []string { return []string{c.Key} }
+
+func (c TypeCommand) Execute(ctx *Context) error {
+	t, ok := ctx.DB.Type(c.Key)
+	if !ok {
+		ctx.Reply.WriteSimpleString("none")
+		return nil
+	}
+	ctx.Reply.WriteSimpleString(t.String())
+	return nil
+}
+
+func (c KeysCommand) Name() string   { return commandKeys }
+func (c KeysCommand) Keys() []string { return nil }
+
+func (c KeysCommand) Execute(ctx *Context) error {
+	ctx.Reply.WriteBulks(ctx.DB.Keys(c.Pattern))
+	return nil
+}
+
+func (c RenameCommand) Name() string {
+	if c.NX {
+		return commandRenamenx
+	}
+	return commandRename
+}
+
+func (c RenameCommand) Keys() []string { return []string{c.Src, c.Dst} }
+
+func (c RenameCommand) Execute(ctx *Context) error {
+	ok, err := ctx.DB.Rename(c.Src, c.Dst, c.NX)
+	if err != nil {
+		return err
+	}
+	if c.NX {
+		ctx.Reply.WriteInteger(int64(utils.Btoi(ok)))
+		return nil
+	}
+	ctx.Reply.WriteOK()
+	return nil
+}
+
+func (c CopyCommand) Name() string   { return commandCopy }
+func (c CopyCommand) Keys() []string { return []string{c.Src, c.Dst} }
+
+func (c CopyCommand) Execute(ctx *Context) error {
+	if c.Src == c.Dst {
+		return fmt.Errorf("source and destination objects are the same")
+	}
+	ok, err := ctx.DB.Copy(c.Src, c.Dst, c.Replace)
+	if err != nil {
+		return err
+	}
+	ctx.Reply.WriteInteger(int64(utils.Btoi(ok)))
+	return nil
+}
+
+func (c RandomkeyCommand) Name() string   { return commandRandomkey }
+func (c RandomkeyCommand) Keys() []string { return nil }
+
+func (c RandomkeyCommand) Execute(ctx *Context) error {
+	key, ok := ctx.DB.RandomKey()
+	if !ok {
+		ctx.Reply.WriteNull()
+		return nil
+	}
+	ctx.Reply.WriteBulkString(key)
+	return nil
+}
+
+func (c DbsizeCommand) Name() string   { return commandDbsize }
+func (c DbsizeCommand) Keys() []string { return nil }
+
+func (c DbsizeCommand) Execute(ctx *Context) error {
+	ctx.Reply.WriteInteger(int64(ctx.DB.Len()))
+	return nil
+}
+
+func (c ScanCommand) Name() string   { return commandScan }
+func (c ScanCommand) Keys() []string { return nil }
+
+func (c ScanCommand) Execute(ctx *Context) error {
+	cursor, keys := ctx.DB.Scan(c.Cursor, c.Count, c.Match, c.Type)
+	ctx.Reply.WriteArray(2)
+	ctx.Reply.WriteBulkString(strconv.FormatUint(cursor, 10))
+	ctx.Reply.WriteBulks(keys)
+	return nil
+}
+
+func (c ExpireCommand) Name() string   { return strings.ToLower(c.T) }
+func (c ExpireCommand) Keys() []string { return []string{c.Key} }
+
+func (c ExpireCommand) Execute(ctx *Context) error {
+	unit := time.Second
+	if c.T == "PEXPIRE" || c.T == "PEXPIREAT" {
+		unit = time.Millisecond
+	}
+	// keep the deadline within what time.Time and time.Duration handle
+	ms := c.Amount
+	if unit == time.Second {
+		if ms > math.MaxInt64/1000 || ms < math.MinInt64/1000 {
+			return fmt.Errorf("invalid expire time in '%s' command", c.Name())
+		}
+		ms *= 1000
+	}
+	var at time.Time
+	if c.T == "EXPIREAT" || c.T == "PEXPIREAT" {
+		at = time.UnixMilli(ms)
+	} else {
+		now := time.Now().UnixMilli()
+		if ms > 0 && now > math.MaxInt64-ms {
+			return fmt.Errorf("invalid expire time in '%s' command", c.Name())
+		}
+		at = time.UnixMilli(now + ms)
+	}
+	ok, err := ctx.DB.Expire(c.Key, at, c.Cond)
+	if err != nil {
+		return err
+	}
+	if ok {
+		// logged with an absolute time, or as a DEL when it was in the past
+		if _, exists := ctx.DB.ExpireTime(c.Key); exists {
+			ctx.Propagate("PEXPIREAT", c.Key, strconv.FormatInt(at.UnixMilli(), 10))
+		} else {
+			ctx.Propagate("DEL", c.Key)
+		}
+	}
+	ctx.Reply.WriteInteger(int64(utils.Btoi(ok)))
+	return nil
+}
+
+func (c TTLCommand) Name() string   { return strings.ToLower(c.T) }
+func (c TTLCommand) Keys() []string { return []string{c.Key} }
+
+// Execute replies -2 for a missing key and -1 for a key without a time to
+// live.
+func (c TTLCommand) Execute(ctx *Context) error {
+	at, ok := ctx.DB.ExpireTime(c.Key)
+	switch {
+	case !ok:
+		ctx.Reply.WriteInteger(-2)
+	case at.IsZero():
+		ctx.Reply.WriteInteger(-1)
+	case c.T == "TTL":
+		// rounded like Redis does
+		ctx.Reply.WriteInteger((time.Until(at).Milliseconds() + 500) / 1000)
+	case c.T == "PTTL":
+		ctx.Reply.WriteInteger(max(time.Until(at).Milliseconds(), 0))
+	case c.T == "EXPIRETIME":
+		ctx.Reply.WriteInteger(at.Unix())
+	default:
+		ctx.Reply.WriteInteger(at.UnixMilli())
+	}
+	return nil
+}
+
+func (c PersistCommand) Name() string   { return commandPersist }
+func (c PersistCommand) Keys() []string { return []string{c.Key} }
+
+func (c PersistCommand) Execute(ctx *Context) error {
+	ctx.Reply.WriteInteger(int64(utils.Btoi(ctx.DB.Persist(c.Key))))
+	return nil
+}
@@ -0,0 +1,59 @@
+package command
+
+import (
+	"go-redis/repo"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExistsAlias(t *testing.T) {
+	cmd, err := commandTable.Parse(args("EXIST", "a", "b"))
+	require.NoError(t, err)
+	assert.Equal(t, ExistsCommand{T: "EXIST", KeyArgs: []string{"a", "b"}}, cmd)
+	assert.Equal(t, "exist", cmd.Name())
+}
+
+func TestExpireCommandHandler(t *testing.T) {
+	cmd, err := commandTable.Parse(args("pexpire", "k", "100", "gt"))
+	require.NoError(t, err)
+	assert.Equal(t, ExpireCommand{T: "PEXPIRE", Key: "k", Amount: 100, Cond: repo.ExpireGT}, cmd)
+
+	for _, tt := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"EXPIRE", "k", "ten"}, "value is not an integer or out of range"},
+		{[]string{"EXPIRE", "k", "10", "NX", "XX"}, "NX and XX, GT or LT options at the same time are not compatible"},
+		{[]string{"EXPIRE", "k", "10", "GT", "LT"}, "GT and LT options at the same time are not compatible"},
+		{[]string{"EXPIRE", "k", "10", "KEEP"}, "Unsupported option KEEP"},
+	} {
+		_, err := commandTable.Parse(args(tt.args...))
+		assert.EqualError(t, err, tt.err, tt.args)
+	}
+}
+
+func TestScanCommandHandler(t *testing.T) {
+	cmd, err := commandTable.Parse(args("SCAN", "17", "MATCH", "key:*", "COUNT", "50", "TYPE", "String"))
+	require.NoError(t, err)
+	assert.Equal(t, ScanCommand{ScanArgs: ScanArgs{Cursor: 17, Match: "key:*", Count: 50}, Type: "string"}, cmd)
+	// MATCH * matches everything, like no pattern at all
+	cmd, err = commandTable.Parse(args("SCAN", "0", "MATCH", "*"))
+	require.NoError(t, err)
+	assert.Equal(t, ScanCommand{ScanArgs: ScanArgs{Count: 10}}, cmd)
+
+	for _, tt := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"SCAN", "x"}, "invalid cursor"},
+		{[]string{"SCAN", "0", "TYPE", "hash"}, "unknown type name 'hash'"},
+		{[]string{"SCAN", "0", "COUNT", "0"}, "syntax error"},
+		{[]string{"SCAN", "0", "COUNT"}, "syntax error"},
+		{[]string{"ZSCAN", "z", "0", "TYPE", "zset"}, "syntax error"},
+	} {
+		_, err := commandTable.Parse(args(tt.args...))
+		assert.EqualError(t, err, tt.err, tt.args)
+	}
+}
@@ -1,16 +1,15 @@
 package command
 
 import (
-	"fmt"
-	"log/slog"
+	"strings"
 
 	"github.com/tidwall/resp"
 )
 
 const (
-	commandLpush  = "LPUSH"
-	commandRpush  = "RPUSH"
-	commandLRange = "LRANGE"
+	commandLpush  = "lpush"
+	commandRpush  = "rpush"
+	commandLRange = "lrange"
 )
 
 type PushCommand struct {
@@ -26,27 +25,51 @@ type LrangeCommand struct {
 }
 
 func PushCommandHandler(set []resp.Value) (Command, error) {
-	if len(set) == 3 {
-		cmd := PushCommand{
-			T:     set[0].String(),
-			Key:   set[1].String(),
-			Value: set[2].String(),
-		}
-		return cmd, nil
+	cmd := PushCommand{
+		T:     strings.ToUpper(set[0].String()),
+		Key:   set[1].String(),
+		Value: set[2].String(),
 	}
-	slog.Error("invalid PUSH command")
-	return nil, fmt.Errorf("invalid PUSH command")
+	return cmd, nil
 }
 
 func LrangeCommandHandler(set []resp.Value) (Command, error) {
-	if len(set) == 4 {
-		cmd := LrangeCommand{
-			Key:   set[1].String(),
-			Start: set[2].String(),
-			End:   set[3].String(),
-		}
-		return cmd, nil
+	cmd := LrangeCommand{
+		Key:   set[1].String(),
+		Start: set[2].String(),
+		End:   set[3].String(),
 	}
-	slog.Error("invalid LRANGE command")
-	return nil, fmt.Errorf("invalid LRANGE command")
+	return cmd, nil
+}
+
+func (c PushCommand) Name() string   { return strings.ToLower(c.T) }
+func (c PushCommand) Keys() []string { return []string{c.Key} }
+
+func (c PushCommand) Execute(ctx *Context) error {
+	var (
+		length int
+		err    error
+	)
+	if c.T == "LPUSH" {
+		length, err = ctx.DB.Lpush(c.Key, c.Value)
+	} else {
+		length, err = ctx.DB.Rpush(c.Key, c.Value)
+	}
+	if err != nil {
+		return err
+	}
+	ctx.Reply.WriteInteger(int64(length))
+	return nil
+}
+
+func (c LrangeCommand) Name() string   { return commandLRange }
+func (c LrangeCommand) Keys() []string { return []string{c.Key} }
+
+func (c LrangeCommand) Execute(ctx *Context) error {
+	res, err := ctx.DB.Lrange(c.Key, c.Start, c.End)
+	if err != nil {
+		return err
+	}
+	ctx.Reply.WriteBulks(res)
+	return nil
 }
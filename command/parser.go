@@ -0,0 +1,236 @@
+package command
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go-redis/pkg/utils"
+	"strconv"
+
+	"github.com/tidwall/resp"
+)
+
+const (
+	// maxBulkLen mirrors Redis's default proto-max-bulk-len.
+	maxBulkLen = 512 * 1024 * 1024
+	// maxMultiBulkLen is the largest array a client may send.
+	maxMultiBulkLen = 1024 * 1024
+	// maxInlineLen bounds a frame header line, so a client that never sends
+	// "\r\n" cannot grow the buffer forever.
+	maxInlineLen = 64 * 1024
+)
+
+// errIncomplete is returned internally when the buffer ends in the middle
+// of a frame.
+var errIncomplete = errors.New("incomplete frame")
+
+// ProtocolError is returned by the Parser when the stream is not valid RESP.
+// The connection cannot be resynchronised after it, so it should be closed.
+type ProtocolError struct {
+	msg string
+}
+
+func (e *ProtocolError) Error() string {
+	return "Protocol error: " + e.msg
+}
+
+// Parser is an incremental RESP decoder bound to a single connection.
+// Bytes are appended with Feed as they are read from the socket and whole
+// frames are taken out with Next, so a command split across TCP segments or
+// a bulk string larger than one read is only handed out once complete.
+//
+// Like Redis, a frame that does not start with '*' is an inline command: a
+// single line of space separated, optionally quoted arguments, as typed by
+// a user over telnet or netcat. It is decoded to the same array of bulk
+// strings a RESP client would send.
+type Parser struct {
+	buf []byte
+	// need is the buffer length required before the pending frame can
+	// possibly be complete, it saves re-parsing a large bulk string on every
+	// read while its payload is still arriving.
+	need int
+	// noInline rejects inline commands, see NewRESPParser.
+	noInline bool
+}
+
+func NewParser() *Parser {
+	return &Parser{}
+}
+
+// NewRESPParser returns a Parser for streams written by programs rather
+// than typed, like the AOF, where anything but a RESP array is an error.
+func NewRESPParser() *Parser {
+	return &Parser{noInline: true}
+}
+
+// Feed appends data read from the connection to the parser buffer.
+func (p *Parser) Feed(data []byte) {
+	p.buf = append(p.buf, data...)
+}
+
+// Buffered returns the number of bytes received but not yet decoded.
+func (p *Parser) Buffered() int {
+	return len(p.buf)
+}
+
+// Next decodes the next complete frame from the buffer. ok is false when
+// more data is needed. A non-nil error is always a *ProtocolError.
+func (p *Parser) Next() (val resp.Value, ok bool, err error) {
+	if len(p.buf) == 0 || len(p.buf) < p.need {
+		return resp.Value{}, false, nil
+	}
+	var n int
+	if p.buf[0] == '*' {
+		val, n, err = p.parse(0)
+	} else if p.noInline {
+		return resp.Value{}, false, &ProtocolError{fmt.Sprintf("expected '*', got '%c'", p.buf[0])}
+	} else {
+		val, n, err = p.parseInline()
+	}
+	if err == errIncomplete {
+		if len(p.buf) > maxInlineLen && bytes.IndexByte(p.buf, '\n') < 0 {
+			return resp.Value{}, false, &ProtocolError{"too big request"}
+		}
+		return resp.Value{}, false, nil
+	}
+	if err != nil {
+		return resp.Value{}, false, err
+	}
+	p.need = 0
+	p.consume(n)
+	if val.Type() == resp.Array && len(val.Array()) == 0 {
+		// blank inline lines and empty arrays are skipped, not answered
+		return p.Next()
+	}
+	return val, true, nil
+}
+
+// NextRaw is Next also returning a copy of the bytes the frame was decoded
+// from, blank frames skipped before it included. A replica relays the
+// stream of its master to its own replicas as it was received.
+func (p *Parser) NextRaw() (val resp.Value, raw []byte, ok bool, err error) {
+	// consume only re-slices the buffer, the bytes stay in place
+	before := p.buf
+	val, ok, err = p.Next()
+	if !ok {
+		return val, nil, ok, err
+	}
+	raw = append([]byte(nil), before[:len(before)-len(p.buf)]...)
+	return val, raw, true, nil
+}
+
+// consume drops the first n bytes of the buffer. The backing array is
+// released once fully drained so a single large value does not pin memory.
+func (p *Parser) consume(n int) {
+	if n == len(p.buf) {
+		if cap(p.buf) > maxInlineLen {
+			p.buf = nil
+		} else {
+			p.buf = p.buf[:0]
+		}
+		return
+	}
+	p.buf = p.buf[n:]
+}
+
+// parse decodes the value starting at pos and returns it with the offset
+// just past it.
+func (p *Parser) parse(pos int) (resp.Value, int, error) {
+	line, next, err := p.line(pos)
+	if err != nil {
+		return resp.Value{}, 0, err
+	}
+	if len(line) == 0 {
+		return resp.Value{}, 0, &ProtocolError{"empty frame header"}
+	}
+	switch line[0] {
+	case '+':
+		return resp.SimpleStringValue(string(line[1:])), next, nil
+	case '-':
+		return resp.ErrorValue(errors.New(string(line[1:]))), next, nil
+	case ':':
+		n, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil {
+			return resp.Value{}, 0, &ProtocolError{"invalid integer"}
+		}
+		return resp.IntegerValue(int(n)), next, nil
+	case '$':
+		return p.parseBulk(line[1:], next)
+	case '*':
+		return p.parseArray(line[1:], next)
+	}
+	return resp.Value{}, 0, &ProtocolError{fmt.Sprintf("expected '$' or '*', got '%c'", line[0])}
+}
+
+func (p *Parser) parseBulk(header []byte, pos int) (resp.Value, int, error) {
+	size, err := strconv.Atoi(string(header))
+	if err != nil || size > maxBulkLen {
+		return resp.Value{}, 0, &ProtocolError{"invalid bulk length"}
+	}
+	if size < 0 {
+		return resp.NullValue(), pos, nil
+	}
+	end := pos + size + 2
+	if end > len(p.buf) {
+		p.need = end
+		return resp.Value{}, 0, errIncomplete
+	}
+	if p.buf[end-2] != '\r' || p.buf[end-1] != '\n' {
+		return resp.Value{}, 0, &ProtocolError{"invalid bulk line ending"}
+	}
+	data := make([]byte, size)
+	copy(data, p.buf[pos:end-2])
+	return resp.BytesValue(data), end, nil
+}
+
+func (p *Parser) parseArray(header []byte, pos int) (resp.Value, int, error) {
+	size, err := strconv.Atoi(string(header))
+	if err != nil || size > maxMultiBulkLen {
+		return resp.Value{}, 0, &ProtocolError{"invalid multibulk length"}
+	}
+	if size < 0 {
+		return resp.NullValue(), pos, nil
+	}
+	vals := make([]resp.Value, size)
+	for i := range vals {
+		vals[i], pos, err = p.parse(pos)
+		if err != nil {
+			return resp.Value{}, 0, err
+		}
+	}
+	return resp.ArrayValue(vals), pos, nil
+}
+
+// parseInline decodes an inline command, the line may end with a bare LF.
+func (p *Parser) parseInline() (resp.Value, int, error) {
+	i := bytes.IndexByte(p.buf, '\n')
+	if i < 0 {
+		if len(p.buf) > maxInlineLen {
+			return resp.Value{}, 0, &ProtocolError{"too big inline request"}
+		}
+		return resp.Value{}, 0, errIncomplete
+	}
+	line := bytes.TrimSuffix(p.buf[:i], []byte{'\r'})
+	args, err := utils.SplitArgs(string(line))
+	if err != nil {
+		return resp.Value{}, 0, &ProtocolError{"unbalanced quotes in request"}
+	}
+	vals := make([]resp.Value, len(args))
+	for j, arg := range args {
+		vals[j] = resp.StringValue(arg)
+	}
+	return resp.ArrayValue(vals), i + 1, nil
+}
+
+// line returns the CRLF terminated line starting at pos, without the CRLF.
+func (p *Parser) line(pos int) ([]byte, int, error) {
+	i := bytes.IndexByte(p.buf[pos:], '\n')
+	if i < 0 {
+		return nil, 0, errIncomplete
+	}
+	end := pos + i
+	if end == pos || p.buf[end-1] != '\r' {
+		return nil, 0, &ProtocolError{"invalid line ending"}
+	}
+	return p.buf[pos : end-1], end + 1, nil
+}
@@ -0,0 +1,85 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParserSplitFrames(t *testing.T) {
+	raw := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n"
+	p := NewParser()
+	// feed one byte at a time, nothing must come out before the last one
+	for i := 0; i < len(raw)-1; i++ {
+		p.Feed([]byte{raw[i]})
+		_, ok, err := p.Next()
+		require.NoError(t, err)
+		require.False(t, ok)
+	}
+	p.Feed([]byte{raw[len(raw)-1]})
+	val, ok, err := p.Next()
+	require.NoError(t, err)
+	require.True(t, ok)
+	cmd, err := ParseValue(val)
+	require.NoError(t, err)
+	assert.Equal(t, SetCommand{Key: "key", Val: "value"}, cmd)
+	assert.Equal(t, 0, p.Buffered())
+}
+
+func TestParserLargeBulk(t *testing.T) {
+	value := strings.Repeat("x", 1<<20)
+	raw := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$" + "1048576\r\n" + value + "\r\n"
+	p := NewParser()
+	for i := 0; i < len(raw); i += 1024 {
+		end := min(i+1024, len(raw))
+		p.Feed([]byte(raw[i:end]))
+		_, ok, err := p.Next()
+		require.NoError(t, err)
+		if end < len(raw) {
+			require.False(t, ok)
+			continue
+		}
+		require.True(t, ok)
+	}
+}
+
+func TestParserProtocolError(t *testing.T) {
+	p := NewParser()
+	p.Feed([]byte("*1\r\n$abc\r\n"))
+	_, _, err := p.Next()
+	var perr *ProtocolError
+	require.ErrorAs(t, err, &perr)
+}
+
+func TestParserInline(t *testing.T) {
+	p := NewParser()
+	p.Feed([]byte("\r\nSET foo \"hello \\x41\\n world\"\r\nGET 'it\\'s'\nLRANGE l 0"))
+	var got [][]string
+	for {
+		val, ok, err := p.Next()
+		require.NoError(t, err)
+		if !ok {
+			break
+		}
+		var args []string
+		for _, v := range val.Array() {
+			args = append(args, v.String())
+		}
+		got = append(got, args)
+	}
+	assert.Equal(t, [][]string{{"SET", "foo", "hello A\n world"}, {"GET", "it's"}}, got)
+	p.Feed([]byte(" -1\r\n"))
+	val, ok, err := p.Next()
+	require.NoError(t, err)
+	require.True(t, ok)
+	cmd, err := ParseValue(val)
+	require.NoError(t, err)
+	assert.Equal(t, LrangeCommand{Key: "l", Start: "0", End: "-1"}, cmd)
+
+	p.Feed([]byte("SET foo \"bar\r\n"))
+	_, _, err = p.Next()
+	var perr *ProtocolError
+	require.ErrorAs(t, err, &perr)
+}
@@ -0,0 +1,211 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+const (
+	commandReplicaof = "replicaof"
+	commandSlaveof   = "slaveof"
+	commandReplconf  = "replconf"
+	commandPsync     = "psync"
+	commandSync      = "sync"
+	commandWait      = "wait"
+	commandWaitaof   = "waitaof"
+)
+
+// ReplicaofCommand is REPLICAOF host port, or REPLICAOF NO ONE which
+// leaves Host empty and turns a replica back into a master. SLAVEOF is the
+// same command under its old name.
+type ReplicaofCommand struct {
+	Host string
+	Port int
+	name string
+}
+
+func ReplicaofCommandHandler(set []resp.Value) (Command, error) {
+	cmd := ReplicaofCommand{name: commandReplicaof}
+	if strings.EqualFold(set[0].String(), commandSlaveof) {
+		cmd.name = commandSlaveof
+	}
+	if strings.EqualFold(set[1].String(), "no") && strings.EqualFold(set[2].String(), "one") {
+		return cmd, nil
+	}
+	port, err := strconv.Atoi(set[2].String())
+	if err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("Invalid master port")
+	}
+	cmd.Host, cmd.Port = set[1].String(), port
+	return cmd, nil
+}
+
+func (c ReplicaofCommand) Name() string   { return c.name }
+func (c ReplicaofCommand) Keys() []string { return nil }
+
+func (c ReplicaofCommand) Execute(ctx *Context) error {
+	status, err := ctx.Server.ReplicaOf(c.Host, c.Port)
+	if err != nil {
+		return err
+	}
+	ctx.Reply.WriteSimpleString(status)
+	return nil
+}
+
+// ReplconfCommand is REPLCONF option value [option value ...], sent by a
+// replica to its master during the handshake and then to acknowledge the
+// stream it processed. Acknowledgements get no reply.
+type ReplconfCommand struct {
+	Args []string
+}
+
+func ReplconfCommandHandler(set []resp.Value) (Command, error) {
+	if len(set)%2 == 0 {
+		return nil, errSyntax
+	}
+	cmd := ReplconfCommand{}
+	for _, v := range set[1:] {
+		cmd.Args = append(cmd.Args, v.String())
+	}
+	return cmd, nil
+}
+
+func (c ReplconfCommand) Name() string   { return commandReplconf }
+func (c ReplconfCommand) Keys() []string { return nil }
+
+func (c ReplconfCommand) Execute(ctx *Context) error {
+	if err := ctx.Server.ReplConf(ctx.Client, c.Args); err != nil {
+		return err
+	}
+	if len(c.Args) > 0 {
+		if opt := strings.ToLower(c.Args[0]); opt == "ack" || opt == "getack" {
+			return nil
+		}
+	}
+	ctx.Reply.WriteOK()
+	return nil
+}
+
+// PsyncCommand is PSYNC replid offset, a replica asking for the stream
+// from offset on. The master continues the stream when it still has it,
+// or starts over with a full sync.
+type PsyncCommand struct {
+	ReplID string
+	Offset int64
+}
+
+func PsyncCommandHandler(set []resp.Value) (Command, error) {
+	offset, err := strconv.ParseInt(set[2].String(), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("value is not an integer or out of range")
+	}
+	return PsyncCommand{ReplID: set[1].String(), Offset: offset}, nil
+}
+
+func (c PsyncCommand) Name() string   { return commandPsync }
+func (c PsyncCommand) Keys() []string { return nil }
+
+func (c PsyncCommand) Execute(ctx *Context) error {
+	return ctx.Server.Psync(ctx.Client, c.ReplID, c.Offset)
+}
+
+// SyncCommand is the full sync of replicas older than PSYNC.
+type SyncCommand struct{}
+
+func SyncCommandHandler(set []resp.Value) (Command, error) {
+	return SyncCommand{}, nil
+}
+
+func (c SyncCommand) Name() string   { return commandSync }
+func (c SyncCommand) Keys() []string { return nil }
+
+func (c SyncCommand) Execute(ctx *Context) error {
+	return ctx.Server.Sync(ctx.Client)
+}
+
+// WaitCommand is WAIT numreplicas timeout, which blocks the client until
+// its last write was acknowledged by numreplicas replicas or timeout, in
+// milliseconds, expired. A zero timeout waits forever.
+type WaitCommand struct {
+	NumReplicas int
+	Timeout     time.Duration
+}
+
+func WaitCommandHandler(set []resp.Value) (Command, error) {
+	numReplicas, err := strconv.Atoi(set[1].String())
+	if err != nil {
+		return nil, fmt.Errorf("value is not an integer or out of range")
+	}
+	timeout, err := parseWaitTimeout(set[2])
+	if err != nil {
+		return nil, err
+	}
+	return WaitCommand{NumReplicas: numReplicas, Timeout: timeout}, nil
+}
+
+func (c WaitCommand) Name() string   { return commandWait }
+func (c WaitCommand) Keys() []string { return nil }
+
+func (c WaitCommand) Execute(ctx *Context) error {
+	replicas, blocked, err := ctx.Server.Wait(ctx.Client, c.NumReplicas, c.Timeout)
+	if err != nil || blocked {
+		return err
+	}
+	ctx.Reply.WriteInteger(int64(replicas))
+	return nil
+}
+
+// WaitaofCommand is WAITAOF numlocal numreplicas timeout, which blocks the
+// client until its last write was fsynced to the local AOF, when numlocal
+// is 1, and to the AOF of numreplicas replicas. The reply is both counts.
+type WaitaofCommand struct {
+	NumLocal    int
+	NumReplicas int
+	Timeout     time.Duration
+}
+
+func WaitaofCommandHandler(set []resp.Value) (Command, error) {
+	numLocal, err := strconv.Atoi(set[1].String())
+	if err != nil {
+		return nil, fmt.Errorf("value is not an integer or out of range")
+	}
+	numReplicas, err := strconv.Atoi(set[2].String())
+	if err != nil {
+		return nil, fmt.Errorf("value is not an integer or out of range")
+	}
+	timeout, err := parseWaitTimeout(set[3])
+	if err != nil {
+		return nil, err
+	}
+	return WaitaofCommand{NumLocal: numLocal, NumReplicas: numReplicas, Timeout: timeout}, nil
+}
+
+func (c WaitaofCommand) Name() string   { return commandWaitaof }
+func (c WaitaofCommand) Keys() []string { return nil }
+
+func (c WaitaofCommand) Execute(ctx *Context) error {
+	local, replicas, blocked, err := ctx.Server.WaitAOF(ctx.Client, c.NumLocal, c.NumReplicas, c.Timeout)
+	if err != nil || blocked {
+		return err
+	}
+	ctx.Reply.WriteArray(2)
+	ctx.Reply.WriteInteger(int64(local))
+	ctx.Reply.WriteInteger(int64(replicas))
+	return nil
+}
+
+// parseWaitTimeout parses a timeout in milliseconds.
+func parseWaitTimeout(v resp.Value) (time.Duration, error) {
+	ms, err := strconv.ParseInt(v.String(), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("timeout is not an integer or out of range")
+	}
+	if ms < 0 {
+		return 0, fmt.Errorf("timeout is negative")
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
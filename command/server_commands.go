@@ -0,0 +1,450 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/resp"
+)
+
+const (
+	commandCommand      = "command"
+	commandShutdown     = "shutdown"
+	commandConfig       = "config"
+	commandSlowlog      = "slowlog"
+	commandInfo         = "info"
+	commandSave         = "save"
+	commandBgsave       = "bgsave"
+	commandLastsave     = "lastsave"
+	commandBgrewriteaof = "bgrewriteaof"
+)
+
+// CommandCommand is the COMMAND introspection family, Sub is empty for
+// the bare COMMAND.
+type CommandCommand struct {
+	Sub  string
+	Args []resp.Value
+}
+
+func CommandCommandHandler(set []resp.Value) (Command, error) {
+	cmd := CommandCommand{}
+	if len(set) == 1 {
+		return cmd, nil
+	}
+	cmd.Sub = strings.ToUpper(set[1].String())
+	cmd.Args = set[2:]
+	switch cmd.Sub {
+	case "COUNT":
+		if len(cmd.Args) != 0 {
+			return nil, fmt.Errorf("wrong number of arguments for 'command|count' command")
+		}
+	case "GETKEYS":
+		if len(cmd.Args) == 0 {
+			return nil, fmt.Errorf("wrong number of arguments for 'command|getkeys' command")
+		}
+	case "INFO", "DOCS":
+	default:
+		return nil, fmt.Errorf("unknown subcommand '%s'. Try COMMAND HELP.", set[1].String())
+	}
+	return cmd, nil
+}
+
+func (c CommandCommand) Name() string   { return commandCommand }
+func (c CommandCommand) Keys() []string { return nil }
+
+func (c CommandCommand) Execute(ctx *Context) error {
+	switch c.Sub {
+	case "":
+		specs := commandTable.Specs()
+		ctx.Reply.WriteArray(len(specs))
+		for _, spec := range specs {
+			writeCommandInfo(ctx.Reply, spec)
+		}
+	case "COUNT":
+		ctx.Reply.WriteInteger(int64(len(commandTable.Specs())))
+	case "INFO":
+		specs := c.lookupSpecs()
+		ctx.Reply.WriteArray(len(specs))
+		for _, spec := range specs {
+			if spec == nil {
+				ctx.Reply.WriteNullArray()
+				continue
+			}
+			writeCommandInfo(ctx.Reply, spec)
+		}
+	case "DOCS":
+		var specs []*Spec
+		for _, spec := range c.lookupSpecs() {
+			if spec != nil {
+				specs = append(specs, spec)
+			}
+		}
+		ctx.Reply.WriteMap(len(specs))
+		for _, spec := range specs {
+			ctx.Reply.WriteBulkString(spec.Name)
+			writeCommandDocs(ctx.Reply, spec)
+		}
+	case "GETKEYS":
+		spec, ok := commandTable.Lookup(c.Args[0].String())
+		if !ok {
+			return fmt.Errorf("Invalid command specified")
+		}
+		if !spec.checkArity(len(c.Args)) {
+			return fmt.Errorf("Invalid number of arguments specified for command")
+		}
+		cmd, err := spec.Handler(c.Args)
+		if err != nil {
+			return err
+		}
+		keys := cmd.Keys()
+		if len(keys) == 0 {
+			return fmt.Errorf("The command has no key arguments")
+		}
+		ctx.Reply.WriteBulks(keys)
+	}
+	return nil
+}
+
+// lookupSpecs returns the specs named in the arguments, with nil for
+// unknown names, or every spec when no name is given.
+func (c CommandCommand) lookupSpecs() []*Spec {
+	if len(c.Args) == 0 {
+		return commandTable.Specs()
+	}
+	specs := make([]*Spec, len(c.Args))
+	for i, arg := range c.Args {
+		specs[i], _ = commandTable.Lookup(arg.String())
+	}
+	return specs
+}
+
+// writeCommandInfo writes the COMMAND INFO reply of a single command.
+func writeCommandInfo(w ReplyWriter, spec *Spec) {
+	w.WriteArray(10)
+	w.WriteBulkString(spec.Name)
+	w.WriteInteger(int64(spec.Arity))
+	flags := spec.Flags.Names()
+	w.WriteSet(len(flags))
+	for _, f := range flags {
+		w.WriteSimpleString(f)
+	}
+	w.WriteInteger(int64(spec.FirstKey))
+	w.WriteInteger(int64(spec.LastKey))
+	w.WriteInteger(int64(spec.Step))
+	cats := spec.aclCategories()
+	w.WriteSet(len(cats))
+	for _, cat := range cats {
+		w.WriteSimpleString(cat)
+	}
+	// tips
+	w.WriteArray(0)
+	writeKeySpecs(w, spec)
+	// subcommands
+	w.WriteArray(0)
+}
+
+// writeKeySpecs describes the key positions of spec with a single index
+// and range key specification.
+func writeKeySpecs(w ReplyWriter, spec *Spec) {
+	if spec.FirstKey == 0 {
+		w.WriteArray(0)
+		return
+	}
+	w.WriteArray(1)
+	w.WriteMap(3)
+	w.WriteBulkString("flags")
+	w.WriteSet(1)
+	if spec.Has(FlagWrite) {
+		w.WriteSimpleString("RW")
+	} else {
+		w.WriteSimpleString("RO")
+	}
+	w.WriteBulkString("begin_search")
+	w.WriteMap(2)
+	w.WriteBulkString("type")
+	w.WriteBulkString("index")
+	w.WriteBulkString("spec")
+	w.WriteMap(1)
+	w.WriteBulkString("index")
+	w.WriteInteger(int64(spec.FirstKey))
+	w.WriteBulkString("find_keys")
+	w.WriteMap(2)
+	w.WriteBulkString("type")
+	w.WriteBulkString("range")
+	w.WriteBulkString("spec")
+	w.WriteMap(3)
+	w.WriteBulkString("lastkey")
+	lastKey := spec.LastKey
+	if lastKey > 0 {
+		// relative to the first key
+		lastKey -= spec.FirstKey
+	}
+	w.WriteInteger(int64(lastKey))
+	w.WriteBulkString("keystep")
+	w.WriteInteger(int64(spec.Step))
+	w.WriteBulkString("limit")
+	w.WriteInteger(0)
+}
+
+// writeCommandDocs writes the COMMAND DOCS entry of a single command.
+func writeCommandDocs(w ReplyWriter, spec *Spec) {
+	w.WriteMap(4)
+	w.WriteBulkString("summary")
+	w.WriteBulkString(spec.Doc.Summary)
+	w.WriteBulkString("since")
+	w.WriteBulkString(spec.Doc.Since)
+	w.WriteBulkString("group")
+	w.WriteBulkString(spec.Doc.Group)
+	w.WriteBulkString("complexity")
+	w.WriteBulkString(spec.Doc.Complexity)
+}
+
+// ShutdownCommand is SHUTDOWN [NOSAVE|SAVE] [NOW] [FORCE] [ABORT].
+type ShutdownCommand struct {
+	Opts ShutdownOptions
+}
+
+func ShutdownCommandHandler(set []resp.Value) (Command, error) {
+	cmd := ShutdownCommand{}
+	for _, arg := range set[1:] {
+		switch strings.ToUpper(arg.String()) {
+		case "SAVE":
+			cmd.Opts.Save = true
+		case "NOSAVE":
+			cmd.Opts.NoSave = true
+		case "NOW":
+			cmd.Opts.Now = true
+		case "FORCE":
+			cmd.Opts.Force = true
+		case "ABORT":
+			cmd.Opts.Abort = true
+		default:
+			return nil, errSyntax
+		}
+	}
+	if cmd.Opts.Save && cmd.Opts.NoSave {
+		return nil, errSyntax
+	}
+	if cmd.Opts.Abort && (cmd.Opts.Save || cmd.Opts.NoSave || cmd.Opts.Now || cmd.Opts.Force) {
+		return nil, errSyntax
+	}
+	return cmd, nil
+}
+
+func (c ShutdownCommand) Name() string   { return commandShutdown }
+func (c ShutdownCommand) Keys() []string { return nil }
+
+// Execute asks the server to shut down. Nothing is replied on success, the
+// connection is closed with the others.
+func (c ShutdownCommand) Execute(ctx *Context) error {
+	return ctx.Server.RequestShutdown(c.Opts)
+}
+
+// ConfigCommand is CONFIG GET|SET|REWRITE|RESETSTAT.
+type ConfigCommand struct {
+	Sub  string
+	Args []string
+}
+
+func ConfigCommandHandler(set []resp.Value) (Command, error) {
+	cmd := ConfigCommand{Sub: strings.ToUpper(set[1].String())}
+	for _, arg := range set[2:] {
+		cmd.Args = append(cmd.Args, arg.String())
+	}
+	var ok bool
+	switch cmd.Sub {
+	case "GET":
+		ok = len(cmd.Args) >= 1
+	case "SET":
+		ok = len(cmd.Args) >= 2 && len(cmd.Args)%2 == 0
+	case "REWRITE", "RESETSTAT":
+		ok = len(cmd.Args) == 0
+	default:
+		return nil, fmt.Errorf("unknown subcommand '%s'. Try CONFIG HELP.", set[1].String())
+	}
+	if !ok {
+		return nil, fmt.Errorf("wrong number of arguments for 'config|%s' command", strings.ToLower(cmd.Sub))
+	}
+	return cmd, nil
+}
+
+func (c ConfigCommand) Name() string   { return commandConfig }
+func (c ConfigCommand) Keys() []string { return nil }
+
+func (c ConfigCommand) Execute(ctx *Context) error {
+	switch c.Sub {
+	case "GET":
+		pairs := ctx.Server.ConfigGet(c.Args)
+		ctx.Reply.WriteMap(len(pairs) / 2)
+		for _, s := range pairs {
+			ctx.Reply.WriteBulkString(s)
+		}
+		return nil
+	case "SET":
+		if err := ctx.Server.ConfigSet(c.Args); err != nil {
+			return err
+		}
+	case "REWRITE":
+		if err := ctx.Server.ConfigRewrite(); err != nil {
+			return err
+		}
+	case "RESETSTAT":
+		ctx.Server.ResetStats()
+	}
+	ctx.Reply.WriteOK()
+	return nil
+}
+
+// SlowlogCommand is SLOWLOG GET [count]|LEN|RESET.
+type SlowlogCommand struct {
+	Sub   string
+	Count int
+}
+
+func SlowlogCommandHandler(set []resp.Value) (Command, error) {
+	cmd := SlowlogCommand{Sub: strings.ToUpper(set[1].String()), Count: 10}
+	argc := len(set) - 2
+	switch cmd.Sub {
+	case "GET":
+		if argc > 1 {
+			return nil, fmt.Errorf("wrong number of arguments for 'slowlog|get' command")
+		}
+		if argc == 1 {
+			n, err := strconv.Atoi(set[2].String())
+			if err != nil || n < -1 {
+				return nil, fmt.Errorf("count should be greater than or equal to -1")
+			}
+			cmd.Count = n
+		}
+	case "LEN", "RESET":
+		if argc != 0 {
+			return nil, fmt.Errorf("wrong number of arguments for 'slowlog|%s' command", strings.ToLower(cmd.Sub))
+		}
+	default:
+		return nil, fmt.Errorf("unknown subcommand '%s'. Try SLOWLOG HELP.", set[1].String())
+	}
+	return cmd, nil
+}
+
+func (c SlowlogCommand) Name() string   { return commandSlowlog }
+func (c SlowlogCommand) Keys() []string { return nil }
+
+func (c SlowlogCommand) Execute(ctx *Context) error {
+	switch c.Sub {
+	case "GET":
+		entries := ctx.Server.SlowlogGet(c.Count)
+		ctx.Reply.WriteArray(len(entries))
+		for _, e := range entries {
+			ctx.Reply.WriteArray(6)
+			ctx.Reply.WriteInteger(e.ID)
+			ctx.Reply.WriteInteger(e.Time.Unix())
+			ctx.Reply.WriteInteger(e.Duration.Microseconds())
+			ctx.Reply.WriteBulks(e.Args)
+			ctx.Reply.WriteBulkString(e.Addr)
+			ctx.Reply.WriteBulkString(e.Name)
+		}
+	case "LEN":
+		ctx.Reply.WriteInteger(int64(ctx.Server.SlowlogLen()))
+	case "RESET":
+		ctx.Server.SlowlogReset()
+		ctx.Reply.WriteOK()
+	}
+	return nil
+}
+
+// InfoCommand is INFO [section ...].
+type InfoCommand struct {
+	Sections []string
+}
+
+func InfoCommandHandler(set []resp.Value) (Command, error) {
+	return InfoCommand{Sections: stringArgs(set[1:])}, nil
+}
+
+func (c InfoCommand) Name() string   { return commandInfo }
+func (c InfoCommand) Keys() []string { return nil }
+
+func (c InfoCommand) Execute(ctx *Context) error {
+	ctx.Reply.WriteVerbatim("txt", ctx.Server.Info(c.Sections))
+	return nil
+}
+
+type SaveCommand struct{}
+
+func SaveCommandHandler(set []resp.Value) (Command, error) {
+	return SaveCommand{}, nil
+}
+
+func (c SaveCommand) Name() string   { return commandSave }
+func (c SaveCommand) Keys() []string { return nil }
+
+func (c SaveCommand) Execute(ctx *Context) error {
+	if err := ctx.Server.Save(); err != nil {
+		return err
+	}
+	ctx.Reply.WriteOK()
+	return nil
+}
+
+// BgsaveCommand is BGSAVE [SCHEDULE], a save can always start right away
+// so SCHEDULE makes no difference.
+type BgsaveCommand struct {
+	Schedule bool
+}
+
+func BgsaveCommandHandler(set []resp.Value) (Command, error) {
+	cmd := BgsaveCommand{}
+	if len(set) > 2 {
+		return nil, errSyntax
+	}
+	if len(set) == 2 {
+		if !strings.EqualFold(set[1].String(), "SCHEDULE") {
+			return nil, errSyntax
+		}
+		cmd.Schedule = true
+	}
+	return cmd, nil
+}
+
+func (c BgsaveCommand) Name() string   { return commandBgsave }
+func (c BgsaveCommand) Keys() []string { return nil }
+
+func (c BgsaveCommand) Execute(ctx *Context) error {
+	if err := ctx.Server.BGSave(); err != nil {
+		return err
+	}
+	ctx.Reply.WriteSimpleString("Background saving started")
+	return nil
+}
+
+type LastsaveCommand struct{}
+
+func LastsaveCommandHandler(set []resp.Value) (Command, error) {
+	return LastsaveCommand{}, nil
+}
+
+func (c LastsaveCommand) Name() string   { return commandLastsave }
+func (c LastsaveCommand) Keys() []string { return nil }
+
+func (c LastsaveCommand) Execute(ctx *Context) error {
+	ctx.Reply.WriteInteger(ctx.Server.LastSave().Unix())
+	return nil
+}
+
+type BgrewriteaofCommand struct{}
+
+func BgrewriteaofCommandHandler(set []resp.Value) (Command, error) {
+	return BgrewriteaofCommand{}, nil
+}
+
+func (c BgrewriteaofCommand) Name() string   { return commandBgrewriteaof }
+func (c BgrewriteaofCommand) Keys() []string { return nil }
+
+func (c BgrewriteaofCommand) Execute(ctx *Context) error {
+	if err := ctx.Server.BGRewriteAOF(); err != nil {
+		return err
+	}
+	ctx.Reply.WriteSimpleString("Background append only file rewriting started")
+	return nil
+}
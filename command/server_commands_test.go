@@ -0,0 +1,26 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandCommandHandler(t *testing.T) {
+	cmd, err := commandTable.Parse(args("command", "info", "get"))
+	require.NoError(t, err)
+	assert.Equal(t, CommandCommand{Sub: "INFO", Args: args("get")}, cmd)
+
+	for _, tt := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"COMMAND", "COUNT", "x"}, "wrong number of arguments for 'command|count' command"},
+		{[]string{"COMMAND", "GETKEYS"}, "wrong number of arguments for 'command|getkeys' command"},
+		{[]string{"COMMAND", "nope"}, "unknown subcommand 'nope'. Try COMMAND HELP."},
+	} {
+		_, err := commandTable.Parse(args(tt.args...))
+		assert.EqualError(t, err, tt.err, tt.args)
+	}
+}
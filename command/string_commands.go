@@ -3,20 +3,22 @@ package command
 import (
 	"fmt"
 	"go-redis/pkg/utils"
-	"log/slog"
+	"go-redis/repo"
+	"strconv"
+	"strings"
 
 	"github.com/tidwall/resp"
 )
 
 const (
-	commandSet   = "SET"
-	commandGet   = "GET"
-	commandDel   = "DEL"
-	commandExist = "EXIST"
-	commandIncr  = "INCR"
-	commandDecr  = "DECR"
+	commandSet  = "set"
+	commandGet  = "get"
+	commandIncr = "incr"
+	commandDecr = "decr"
 )
 
+var errSyntax = fmt.Errorf("syntax error")
+
 type SetCommand struct {
 	Key string
 	Val string
@@ -27,14 +29,6 @@ type GetCommand struct {
 	Key string
 }
 
-type DelCommand struct {
-	Key string
-}
-
-type ExistCommand struct {
-	Key string
-}
-
 type IncrCommand struct {
 	Key    string
 	Amount string
@@ -45,14 +39,10 @@ type DecrCommand struct {
 }
 
 func GetCommandHandler(set []resp.Value) (Command, error) {
-	if len(set) == 2 {
-		cmd := GetCommand{
-			Key: set[1].String(),
-		}
-		return cmd, nil
+	cmd := GetCommand{
+		Key: set[1].String(),
 	}
-	slog.Error("invalid GET command")
-	return nil, fmt.Errorf("invalid GET command")
+	return cmd, nil
 }
 
 func IncrCommandHandler(set []resp.Value) (Command, error) {
@@ -68,8 +58,7 @@ func IncrCommandHandler(set []resp.Value) (Command, error) {
 		}
 		return cmd, nil
 	}
-	slog.Error("invalid Incr command")
-	return nil, fmt.Errorf("invalid Incr command")
+	return nil, errSyntax
 }
 
 func DecrCommandHandler(set []resp.Value) (Command, error) {
@@ -85,30 +74,7 @@ func DecrCommandHandler(set []resp.Value) (Command, error) {
 		}
 		return cmd, nil
 	}
-	slog.Error("invalid Decr command")
-	return nil, fmt.Errorf("invalid Decr command")
-}
-
-func ExistCommandHandler(set []resp.Value) (Command, error) {
-	if len(set) == 2 {
-		cmd := ExistCommand{
-			Key: set[1].String(),
-		}
-		return cmd, nil
-	}
-	slog.Error("invalid Exist command")
-	return nil, fmt.Errorf("invalid Exist command")
-}
-
-func DelCommandHandler(set []resp.Value) (Command, error) {
-	if len(set) == 2 {
-		cmd := DelCommand{
-			Key: set[1].String(),
-		}
-		return cmd, nil
-	}
-	slog.Error("invalid DEL command")
-	return nil, fmt.Errorf("invalid DEL command")
+	return nil, errSyntax
 }
 
 func SetCommandHandler(set []resp.Value) (Command, error) {
@@ -120,9 +86,10 @@ func SetCommandHandler(set []resp.Value) (Command, error) {
 		}
 		return cmd, nil
 	} else if len(set) == 5 {
-		if set[3].String() == "EX" || set[3].String() == "PX" {
+		unit := strings.ToUpper(set[3].String())
+		if unit == "EX" || unit == "PX" {
 			// verify is valid or not
-			ex, err := utils.MsOrS(set[3].String(), set[4].String())
+			ex, err := utils.MsOrS(unit, set[4].String())
 			if err != nil {
 				return nil, err
 			}
@@ -134,7 +101,65 @@ func SetCommandHandler(set []resp.Value) (Command, error) {
 			return cmd, nil
 		}
 	}
+	return nil, errSyntax
+}
+
+func (c SetCommand) Name() string   { return commandSet }
+func (c SetCommand) Keys() []string { return []string{c.Key} }
+
+func (c SetCommand) Execute(ctx *Context) error {
+	if err := ctx.DB.Set(c.Key, c.Val, c.EX); err != nil {
+		return err
+	}
+	if c.EX != "" {
+		// the time to live is relative, the AOF gets when the key expires
+		if at, ok := ctx.DB.ExpireTime(c.Key); ok {
+			ctx.Propagate("SET", c.Key, c.Val)
+			ctx.Propagate("PEXPIREAT", c.Key, strconv.FormatInt(at.UnixMilli(), 10))
+		} else {
+			ctx.Propagate("DEL", c.Key)
+		}
+	}
+	ctx.Reply.WriteOK()
+	return nil
+}
+
+func (c GetCommand) Name() string   { return commandGet }
+func (c GetCommand) Keys() []string { return []string{c.Key} }
 
-	slog.Error("invalid SET command")
-	return nil, fmt.Errorf("invalid SET command")
+func (c GetCommand) Execute(ctx *Context) error {
+	bytes, err := ctx.DB.Get(c.Key)
+	if err == repo.ErrNotExist {
+		ctx.Reply.WriteNull()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	ctx.Reply.WriteBulk(bytes)
+	return nil
+}
+
+func (c IncrCommand) Name() string   { return commandIncr }
+func (c IncrCommand) Keys() []string { return []string{c.Key} }
+
+func (c IncrCommand) Execute(ctx *Context) error {
+	n, err := ctx.DB.Incr(c.Key, c.Amount)
+	if err != nil {
+		return err
+	}
+	ctx.Reply.WriteInteger(n)
+	return nil
+}
+
+func (c DecrCommand) Name() string   { return commandDecr }
+func (c DecrCommand) Keys() []string { return []string{c.Key} }
+
+func (c DecrCommand) Execute(ctx *Context) error {
+	n, err := ctx.DB.Decr(c.Key, c.Amount)
+	if err != nil {
+		return err
+	}
+	ctx.Reply.WriteInteger(n)
+	return nil
 }
@@ -0,0 +1,207 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/resp"
+)
+
+// Flag is a property of a command, commands usually have several.
+type Flag uint32
+
+const (
+	// FlagWrite commands may modify the keyspace.
+	FlagWrite Flag = 1 << iota
+	// FlagReadonly commands only read data.
+	FlagReadonly
+	// FlagFast commands run in O(1) or O(log N).
+	FlagFast
+	// FlagBlocking commands may block the client.
+	FlagBlocking
+	// FlagAdmin commands are server administration commands.
+	FlagAdmin
+	// FlagPubsub commands are related to pub/sub.
+	FlagPubsub
+	// FlagDenyOOM commands may use more memory, they are refused once
+	// maxmemory is reached.
+	FlagDenyOOM
+)
+
+var flagNames = []struct {
+	flag Flag
+	name string
+}{
+	{FlagWrite, "write"},
+	{FlagReadonly, "readonly"},
+	{FlagFast, "fast"},
+	{FlagBlocking, "blocking"},
+	{FlagAdmin, "admin"},
+	{FlagPubsub, "pubsub"},
+	{FlagDenyOOM, "denyoom"},
+}
+
+// Names returns the Redis names of the flags set in f.
+func (f Flag) Names() []string {
+	names := []string{}
+	for _, fn := range flagNames {
+		if f&fn.flag != 0 {
+			names = append(names, fn.name)
+		}
+	}
+	return names
+}
+
+// Spec describes a command in the command table.
+type Spec struct {
+	// Name is the lower case command name, lookups are case-insensitive.
+	Name string
+	// Arity counts the command name itself. A negative arity -N means at
+	// least N arguments.
+	Arity int
+	Flags Flag
+	// FirstKey, LastKey and Step give the positions of key arguments, as
+	// reported by COMMAND. A negative LastKey counts from the end, 0 means
+	// the command takes no keys.
+	FirstKey int
+	LastKey  int
+	Step     int
+	// Handler builds the command from its arguments, which already passed
+	// the arity check.
+	Handler func([]resp.Value) (Command, error)
+	Doc     Doc
+}
+
+// Doc is the documentation of a command, as returned by COMMAND DOCS.
+type Doc struct {
+	Summary    string
+	Since      string
+	Group      string
+	Complexity string
+}
+
+// aclCategories derives the ACL categories of a command from its flags and
+// group.
+func (s *Spec) aclCategories() []string {
+	var cats []string
+	for _, fc := range []struct {
+		flag Flag
+		cat  string
+	}{
+		{FlagWrite, "@write"},
+		{FlagReadonly, "@read"},
+		{FlagFast, "@fast"},
+		{FlagBlocking, "@blocking"},
+		{FlagAdmin, "@admin"},
+		{FlagPubsub, "@pubsub"},
+	} {
+		if s.Has(fc.flag) {
+			cats = append(cats, fc.cat)
+		}
+	}
+	if !s.Has(FlagFast) {
+		cats = append(cats, "@slow")
+	}
+	switch s.Doc.Group {
+	case "string":
+		cats = append(cats, "@string")
+	case "list":
+		cats = append(cats, "@list")
+	case "sorted-set":
+		cats = append(cats, "@sortedset")
+	case "connection":
+		cats = append(cats, "@connection")
+	case "generic":
+		cats = append(cats, "@keyspace")
+	}
+	if s.Has(FlagAdmin) {
+		cats = append(cats, "@dangerous")
+	}
+	return cats
+}
+
+func (s *Spec) Has(flag Flag) bool {
+	return s.Flags&flag != 0
+}
+
+// checkArity reports whether argc arguments, the name included, fit the
+// command arity.
+func (s *Spec) checkArity(argc int) bool {
+	if s.Arity >= 0 {
+		return argc == s.Arity
+	}
+	return argc >= -s.Arity
+}
+
+// Table indexes command specs by lower case name.
+type Table struct {
+	specs map[string]*Spec
+}
+
+func NewTable(specs ...Spec) *Table {
+	t := &Table{specs: make(map[string]*Spec, len(specs))}
+	for _, spec := range specs {
+		t.Register(spec)
+	}
+	return t
+}
+
+// Register adds spec to the table, replacing any command of the same name.
+func (t *Table) Register(spec Spec) {
+	spec.Name = strings.ToLower(spec.Name)
+	t.specs[spec.Name] = &spec
+}
+
+// Lookup finds a command by name, ignoring case.
+func (t *Table) Lookup(name string) (*Spec, bool) {
+	spec, ok := t.specs[strings.ToLower(name)]
+	return spec, ok
+}
+
+// Specs returns every registered command sorted by name.
+func (t *Table) Specs() []*Spec {
+	specs := make([]*Spec, 0, len(t.specs))
+	for _, spec := range t.specs {
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	return specs
+}
+
+// Parse checks args against the table and builds the command they describe.
+func (t *Table) Parse(args []resp.Value) (Command, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("unknown command")
+	}
+	name := args[0].String()
+	spec, ok := t.Lookup(name)
+	if !ok {
+		return nil, unknownCommandError(name, args[1:])
+	}
+	if !spec.checkArity(len(args)) {
+		return nil, fmt.Errorf("wrong number of arguments for '%s' command", spec.Name)
+	}
+	return spec.Handler(args)
+}
+
+// Lookup finds a command of the default table by name, ignoring case.
+func Lookup(name string) (*Spec, bool) {
+	return commandTable.Lookup(name)
+}
+
+// Specs returns every command of the default table sorted by name.
+func Specs() []*Spec {
+	return commandTable.Specs()
+}
+
+func unknownCommandError(name string, args []resp.Value) error {
+	var sb strings.Builder
+	for _, arg := range args {
+		if sb.Len() >= 128 {
+			break
+		}
+		fmt.Fprintf(&sb, "'%s' ", arg.String())
+	}
+	return fmt.Errorf("unknown command '%s', with args beginning with: %s", name, sb.String())
+}
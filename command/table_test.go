@@ -0,0 +1,49 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/resp"
+)
+
+func TestTableArity(t *testing.T) {
+	_, err := ParseRawMsg("*1\r\n$3\r\nget\r\n")
+	assert.EqualError(t, err, "wrong number of arguments for 'get' command")
+	_, err = ParseRawMsg("SET k v EX 10 NX\r\n")
+	assert.EqualError(t, err, "syntax error")
+	spec, ok := Lookup("ZaDd")
+	require.True(t, ok)
+	assert.Equal(t, "zadd", spec.Name)
+	assert.Equal(t, []string{"write", "fast", "denyoom"}, spec.Flags.Names())
+	assert.Equal(t, []string{"@write", "@fast", "@sortedset"}, spec.aclCategories())
+}
+
+// args makes the arguments of a command, its name included.
+func args(vals ...string) []resp.Value {
+	set := make([]resp.Value, len(vals))
+	for i, v := range vals {
+		set[i] = resp.StringValue(v)
+	}
+	return set
+}
+
+func TestTableParse(t *testing.T) {
+	cmd, err := commandTable.Parse(args("gEt", "foo"))
+	require.NoError(t, err)
+	assert.Equal(t, GetCommand{Key: "foo"}, cmd)
+	_, err = commandTable.Parse(args("GET"))
+	assert.EqualError(t, err, "wrong number of arguments for 'get' command")
+	_, err = commandTable.Parse(args("nope", "a"))
+	assert.EqualError(t, err, "unknown command 'nope', with args beginning with: 'a' ")
+
+	// a table only knows the commands registered in it
+	table := NewTable()
+	_, err = table.Parse(args("GET", "foo"))
+	assert.EqualError(t, err, "unknown command 'GET', with args beginning with: 'foo' ")
+	table.Register(Spec{Name: "GET", Arity: 2, Handler: GetCommandHandler})
+	cmd, err = table.Parse(args("get", "foo"))
+	require.NoError(t, err)
+	assert.Equal(t, GetCommand{Key: "foo"}, cmd)
+}
@@ -2,15 +2,19 @@ package command
 
 import (
 	"fmt"
-	"log/slog"
+	"go-redis/pkg/utils"
+	"go-redis/repo"
+	"math"
+	"strconv"
 
 	"github.com/tidwall/resp"
 )
 
 const (
-	commandZadd   = "ZADD"
-	commandZscore = "ZSCORE"
-	commandZrank   = "ZRANK"
+	commandZadd   = "zadd"
+	commandZscore = "zscore"
+	commandZrank  = "zrank"
+	commandZscan  = "zscan"
 )
 
 type ZaddCommand struct {
@@ -29,39 +33,109 @@ type ZrankCommand struct {
 	Member string
 }
 
+type ZscanCommand struct {
+	Key string
+	ScanArgs
+}
+
+// ZaddCommandHandler parses ZADD key score member.
 func ZaddCommandHandler(set []resp.Value) (Command, error) {
-	if len(set) == 4 {
-		cmd := ZaddCommand{
-			Key:    set[1].String(),
-			Member: set[2].String(),
-			Score:  set[3].String(),
-		}
-		return cmd, nil
+	cmd := ZaddCommand{
+		Key:    set[1].String(),
+		Score:  set[2].String(),
+		Member: set[3].String(),
 	}
-	slog.Error("invalid Zadd command")
-	return nil, fmt.Errorf("invalid Zadd command")
+	return cmd, nil
 }
 
 func ZscoreCommandHandler(set []resp.Value) (Command, error) {
-	if len(set) == 3 {
-		cmd := ZscoreCommand{
-			Key:    set[1].String(),
-			Member: set[2].String(),
-		}
-		return cmd, nil
+	cmd := ZscoreCommand{
+		Key:    set[1].String(),
+		Member: set[2].String(),
 	}
-	slog.Error("invalid Zscore command")
-	return nil, fmt.Errorf("invalid Zscore command")
+	return cmd, nil
 }
 
 func ZrankCommandHandler(set []resp.Value) (Command, error) {
-	if len(set) == 3 {
-		cmd := ZrankCommand{
-			Key:    set[1].String(),
-			Member: set[2].String(),
-		}
-		return cmd, nil
+	cmd := ZrankCommand{
+		Key:    set[1].String(),
+		Member: set[2].String(),
+	}
+	return cmd, nil
+}
+
+func (c ZaddCommand) Name() string   { return commandZadd }
+func (c ZaddCommand) Keys() []string { return []string{c.Key} }
+
+func (c ZaddCommand) Execute(ctx *Context) error {
+	score, err := strconv.ParseFloat(c.Score, 64)
+	if err != nil || math.IsNaN(score) {
+		return fmt.Errorf("value is not a valid float")
+	}
+	added, err := ctx.DB.Zadd(c.Key, c.Member, score)
+	if err != nil {
+		return err
+	}
+	ctx.Reply.WriteInteger(int64(utils.Btoi(added)))
+	return nil
+}
+
+func (c ZscoreCommand) Name() string   { return commandZscore }
+func (c ZscoreCommand) Keys() []string { return []string{c.Key} }
+
+func (c ZscoreCommand) Execute(ctx *Context) error {
+	score, err := ctx.DB.Zscore(c.Key, c.Member)
+	if err == repo.ErrMemberNotExist {
+		ctx.Reply.WriteNull()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	ctx.Reply.WriteDouble(score)
+	return nil
+}
+
+func (c ZrankCommand) Name() string   { return commandZrank }
+func (c ZrankCommand) Keys() []string { return []string{c.Key} }
+
+func (c ZrankCommand) Execute(ctx *Context) error {
+	rank, err := ctx.DB.Zrank(c.Key, c.Member)
+	if err == repo.ErrMemberNotExist {
+		ctx.Reply.WriteNull()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	ctx.Reply.WriteInteger(int64(rank))
+	return nil
+}
+
+// ZscanCommandHandler parses ZSCAN key cursor [MATCH pattern] [COUNT count].
+func ZscanCommandHandler(set []resp.Value) (Command, error) {
+	args, err := parseScanArgs(set[2:], nil)
+	if err != nil {
+		return nil, err
+	}
+	return ZscanCommand{Key: set[1].String(), ScanArgs: args}, nil
+}
+
+func (c ZscanCommand) Name() string   { return commandZscan }
+func (c ZscanCommand) Keys() []string { return []string{c.Key} }
+
+// Execute replies the cursor and a flat array of members and their scores.
+func (c ZscanCommand) Execute(ctx *Context) error {
+	cursor, members, scores, err := ctx.DB.Zscan(c.Key, c.Cursor, c.Count, c.Match)
+	if err != nil {
+		return err
+	}
+	ctx.Reply.WriteArray(2)
+	ctx.Reply.WriteBulkString(strconv.FormatUint(cursor, 10))
+	ctx.Reply.WriteArray(len(members) * 2)
+	for i, member := range members {
+		ctx.Reply.WriteBulkString(member)
+		ctx.Reply.WriteBulkString(strconv.FormatFloat(scores[i], 'g', -1, 64))
 	}
-	slog.Error("invalid Zrank command")
-	return nil, fmt.Errorf("invalid Zrank command")
+	return nil
 }
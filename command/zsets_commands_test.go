@@ -0,0 +1,17 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZaddCommandHandler(t *testing.T) {
+	// the score comes before the member, as in ZADD key score member
+	cmd, err := commandTable.Parse(args("ZADD", "z", "1.5", "m"))
+	require.NoError(t, err)
+	assert.Equal(t, ZaddCommand{Key: "z", Score: "1.5", Member: "m"}, cmd)
+	_, err = commandTable.Parse(args("ZADD", "z", "1.5"))
+	assert.EqualError(t, err, "wrong number of arguments for 'zadd' command")
+}
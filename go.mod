@@ -2,10 +2,13 @@ module go-redis
 
 go 1.22.0
 
+require (
+	github.com/stretchr/testify v1.10.0
+	github.com/tidwall/resp v0.1.1
+)
+
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/testify v1.10.0 // indirect
-	github.com/tidwall/resp v0.1.1 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
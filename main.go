@@ -1,34 +1,64 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"go-redis/repo"
+	"go-redis/command"
 	"go-redis/server"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
+	conf, err := server.LoadConfig(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\n*** FATAL CONFIG FILE ERROR (Redis %s) ***\n%v\n", command.ServerVersion, err)
+		os.Exit(1)
+	}
+	if err := server.SetupLogging(conf); err != nil {
+		fmt.Fprintf(os.Stderr, "Can't open the log file: %v\n", err)
+		os.Exit(1)
+	}
+	s := server.NewServer(conf)
+	errCh := make(chan error, 1)
 	go func() {
-		s := server.NewServer(server.Config{})
 		fmt.Println("Server started.....")
-		s.Start()
+		errCh <- s.Start()
 	}()
-	// time.Sleep(time.Second)
-	// client := client.NewClient("localhost:50001") // blockhere
-	// for i := 0; i < 10; i++ {
-	// 	err := client.Set(context.Background(), "user", "1")
-	// 	if err != nil {
-	// 		log.Fatal(err)
-	// 	}
-	// }
-	// time.Sleep(time.Second)
-	// fmt.Println(repo.KvString)
-	// client.Get(context.Background(), "user")
-	select {}
-}
-
-func init() {
-	// inititialize memory data
-	repo.InitKV()
-	repo.InitKVList()
-	repo.InitKvZset()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	// The first signal shuts the server down gracefully, it keeps serving
+	// if the data can't be saved. Another signal exits at once.
+	shutdownCh := make(chan error, 1)
+	signaled := false
+	for {
+		select {
+		case sig := <-sigCh:
+			if signaled {
+				slog.Warn("You insist... exiting now.", "signal", sig)
+				os.Exit(1)
+			}
+			signaled = true
+			slog.Info("Received signal, scheduling shutdown...", "signal", sig)
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), server.ShutdownTimeout)
+				defer cancel()
+				shutdownCh <- s.Shutdown(ctx)
+			}()
+		case err := <-shutdownCh:
+			if err == nil {
+				return
+			}
+			slog.Error("Errors trying to shut down the server, still serving", "err", err)
+		case err := <-errCh:
+			// stopped by the SHUTDOWN command or a signal
+			if err != nil {
+				slog.Error("server stopped", "err", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
 }
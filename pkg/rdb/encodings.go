@@ -0,0 +1,244 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// The compact encodings Redis uses for small values, saved as a string
+// holding the memory layout. They all read as a flat list of elements,
+// alternating fields and values for hashes and members and scores for
+// sorted sets.
+
+func errEncoding(name string) error {
+	return fmt.Errorf("invalid %s encoding", name)
+}
+
+// ziplistEntries decodes a ziplist: its size in bytes, the offset of the
+// last entry and the number of entries, then the entries and 0xff. An
+// entry is the length of the previous one, an encoding byte telling the
+// length of a string or the size of an integer, then the data.
+func ziplistEntries(b []byte) ([]string, error) {
+	if len(b) < 11 || int(binary.LittleEndian.Uint32(b)) != len(b) {
+		return nil, errEncoding("ziplist")
+	}
+	var entries []string
+	p := b[10:]
+	for {
+		if len(p) == 0 {
+			return nil, errEncoding("ziplist")
+		}
+		if p[0] == 0xff {
+			return entries, nil
+		}
+		if p[0] < 254 {
+			p = p[1:]
+		} else if len(p) >= 5 {
+			p = p[5:]
+		} else {
+			return nil, errEncoding("ziplist")
+		}
+		if len(p) == 0 {
+			return nil, errEncoding("ziplist")
+		}
+		c := p[0]
+		var (
+			header, n int
+			val       int64
+			isInt     bool
+		)
+		switch {
+		case c>>6 == 0:
+			header, n = 1, int(c&0x3f)
+		case c>>6 == 1 && len(p) >= 2:
+			header, n = 2, int(c&0x3f)<<8|int(p[1])
+		case c == 0x80 && len(p) >= 5:
+			header, n = 5, int(binary.BigEndian.Uint32(p[1:]))
+		case c == 0xc0:
+			header, n, isInt = 1, 2, true
+		case c == 0xd0:
+			header, n, isInt = 1, 4, true
+		case c == 0xe0:
+			header, n, isInt = 1, 8, true
+		case c == 0xf0:
+			header, n, isInt = 1, 3, true
+		case c == 0xfe:
+			header, n, isInt = 1, 1, true
+		case c >= 0xf1 && c <= 0xfd:
+			// a 4 bit immediate from 0 to 12
+			header, isInt, val = 1, true, int64(c&0x0f)-1
+		default:
+			return nil, errEncoding("ziplist")
+		}
+		if n < 0 || len(p) < header+n {
+			return nil, errEncoding("ziplist")
+		}
+		data := p[header : header+n]
+		if !isInt {
+			entries = append(entries, string(data))
+		} else {
+			if n > 0 {
+				val = littleEndianInt(data)
+			}
+			entries = append(entries, strconv.FormatInt(val, 10))
+		}
+		p = p[header+n:]
+	}
+}
+
+// listpackEntries decodes a listpack: its size in bytes and the number of
+// entries, then the entries and 0xff. An entry is an encoding byte telling
+// the length of a string or the size of an integer, the data, then the
+// length of the entry so it can be walked backwards.
+func listpackEntries(b []byte) ([]string, error) {
+	if len(b) < 7 || int(binary.LittleEndian.Uint32(b)) != len(b) {
+		return nil, errEncoding("listpack")
+	}
+	count := int(binary.LittleEndian.Uint16(b[4:]))
+	var entries []string
+	p := b[6:]
+	for {
+		if len(p) == 0 {
+			return nil, errEncoding("listpack")
+		}
+		c := p[0]
+		if c == 0xff {
+			break
+		}
+		var (
+			header, n int
+			val       int64
+			isInt     bool
+		)
+		switch {
+		case c&0x80 == 0:
+			header, isInt, val = 1, true, int64(c&0x7f)
+		case c&0xc0 == 0x80:
+			header, n = 1, int(c&0x3f)
+		case c&0xe0 == 0xc0 && len(p) >= 2:
+			// 13 bit signed integer
+			header, isInt, val = 2, true, int64(c&0x1f)<<8|int64(p[1])
+			if val >= 1<<12 {
+				val -= 1 << 13
+			}
+		case c&0xf0 == 0xe0 && len(p) >= 2:
+			header, n = 2, int(c&0x0f)<<8|int(p[1])
+		case c == 0xf0 && len(p) >= 5:
+			header, n = 5, int(binary.LittleEndian.Uint32(p[1:]))
+		case c >= 0xf1 && c <= 0xf4:
+			header, n, isInt = 1, [...]int{2, 3, 4, 8}[c-0xf1], true
+		default:
+			return nil, errEncoding("listpack")
+		}
+		size := header + n
+		if n < 0 || len(p) < size {
+			return nil, errEncoding("listpack")
+		}
+		data := p[header:size]
+		if !isInt {
+			entries = append(entries, string(data))
+		} else {
+			if n > 0 {
+				val = littleEndianInt(data)
+			}
+			entries = append(entries, strconv.FormatInt(val, 10))
+		}
+		backlen := listpackBacklen(size)
+		if len(p) < size+backlen {
+			return nil, errEncoding("listpack")
+		}
+		p = p[size+backlen:]
+	}
+	// 65535 means there were too many entries to count in the header
+	if count != 0xffff && count != len(entries) {
+		return nil, errEncoding("listpack")
+	}
+	return entries, nil
+}
+
+// listpackBacklen is the size of the back length of an entry of size
+// bytes, it takes 7 bits per byte with the bounds of lpEncodeBacklen.
+func listpackBacklen(size int) int {
+	switch {
+	case size <= 127:
+		return 1
+	case size < 16383:
+		return 2
+	case size < 2097151:
+		return 3
+	case size < 268435455:
+		return 4
+	}
+	return 5
+}
+
+// intsetEntries decodes an intset: the size of its integers and their
+// number, then the integers in ascending order.
+func intsetEntries(b []byte) ([]string, error) {
+	if len(b) < 8 {
+		return nil, errEncoding("intset")
+	}
+	size := int(binary.LittleEndian.Uint32(b))
+	n := int(binary.LittleEndian.Uint32(b[4:]))
+	if (size != 2 && size != 4 && size != 8) || n < 0 || len(b) != 8+size*n {
+		return nil, errEncoding("intset")
+	}
+	entries := make([]string, 0, n)
+	for p := b[8:]; len(p) > 0; p = p[size:] {
+		entries = append(entries, strconv.FormatInt(littleEndianInt(p[:size]), 10))
+	}
+	return entries, nil
+}
+
+// zipmapEntries decodes a zipmap, the hash encoding before Redis 2.6: the
+// number of fields, then each field and value and 0xff. A length is a byte,
+// or 254 then 4 bytes, and a value is followed by unused bytes.
+func zipmapEntries(b []byte) ([]string, error) {
+	if len(b) < 2 {
+		return nil, errEncoding("zipmap")
+	}
+	var entries []string
+	p := b[1:]
+	length := func() (int, bool) {
+		switch {
+		case len(p) >= 1 && p[0] < 254:
+			n := int(p[0])
+			p = p[1:]
+			return n, true
+		case len(p) >= 5 && p[0] == 254:
+			n := int(binary.LittleEndian.Uint32(p[1:]))
+			p = p[5:]
+			return n, true
+		}
+		return 0, false
+	}
+	for len(p) > 0 && p[0] != 0xff {
+		n, ok := length()
+		if !ok || n < 0 || len(p) < n {
+			return nil, errEncoding("zipmap")
+		}
+		entries = append(entries, string(p[:n]))
+		p = p[n:]
+		if n, ok = length(); !ok || n < 0 || len(p) < 1+n || len(p) < 1+n+int(p[0]) {
+			return nil, errEncoding("zipmap")
+		}
+		free := int(p[0])
+		entries = append(entries, string(p[1:1+n]))
+		p = p[1+n+free:]
+	}
+	if len(p) == 0 {
+		return nil, errEncoding("zipmap")
+	}
+	return entries, nil
+}
+
+// littleEndianInt reads a signed little endian integer of 1 to 8 bytes.
+func littleEndianInt(b []byte) int64 {
+	var u uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		u = u<<8 | uint64(b[i])
+	}
+	shift := 64 - 8*len(b)
+	return int64(u<<shift) >> shift
+}
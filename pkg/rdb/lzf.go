@@ -0,0 +1,121 @@
+package rdb
+
+import "fmt"
+
+// LZF is the compression Redis uses for long strings. A control byte
+// below 32 starts a run of that many literal bytes plus one, any other is
+// a back reference: its three high bits are the length minus two, 7
+// meaning an extra length byte follows, and the low five bits with the
+// next byte the distance minus one.
+const (
+	lzfMaxLit = 1 << 5
+	lzfMaxOff = 1 << 13
+	lzfMaxRef = 1<<8 + 1<<3
+	lzfHash   = 14
+)
+
+var errLZF = fmt.Errorf("invalid LZF compressed string")
+
+// lzfDecompress decompresses in, which must give outLen bytes.
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, outLen)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < lzfMaxLit {
+			n := ctrl + 1
+			if i+n > len(in) || len(out)+n > outLen {
+				return nil, errLZF
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+		n := ctrl >> 5
+		if n == 7 {
+			if i == len(in) {
+				return nil, errLZF
+			}
+			n += int(in[i])
+			i++
+		}
+		if i == len(in) {
+			return nil, errLZF
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		n += 2
+		if ref < 0 || len(out)+n > outLen {
+			return nil, errLZF
+		}
+		// the reference may overlap the bytes it produces
+		for j := 0; j < n; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != outLen {
+		return nil, errLZF
+	}
+	return out, nil
+}
+
+// lzfCompress compresses in, the output is read by the lzf_decompress of
+// Redis.
+func lzfCompress(in []byte) []byte {
+	// table holds the last position plus one of each hashed 3 bytes
+	var table [1 << lzfHash]int
+	out := make([]byte, 0, len(in)+len(in)/lzfMaxLit+1)
+	// lit is the length of the literal run, its control byte is the one
+	// before the run
+	lit := 0
+	out = append(out, 0)
+	literal := func(c byte) {
+		out = append(out, c)
+		lit++
+		if lit == lzfMaxLit {
+			out[len(out)-lit-1] = byte(lit - 1)
+			lit = 0
+			out = append(out, 0)
+		}
+	}
+	i := 0
+	for i+2 < len(in) {
+		h := (uint32(in[i])<<16 | uint32(in[i+1])<<8 | uint32(in[i+2])) * 2654435761 >> (32 - lzfHash)
+		ref := table[h] - 1
+		table[h] = i + 1
+		off := i - ref - 1
+		if ref < 0 || off >= lzfMaxOff || in[ref] != in[i] || in[ref+1] != in[i+1] || in[ref+2] != in[i+2] {
+			literal(in[i])
+			i++
+			continue
+		}
+		limit := min(len(in)-i, lzfMaxRef)
+		n := 3
+		for n < limit && in[ref+n] == in[i+n] {
+			n++
+		}
+		if lit > 0 {
+			out[len(out)-lit-1] = byte(lit - 1)
+		} else {
+			out = out[:len(out)-1]
+		}
+		if l := n - 2; l < 7 {
+			out = append(out, byte(l<<5|off>>8))
+		} else {
+			out = append(out, byte(7<<5|off>>8), byte(l-7))
+		}
+		out = append(out, byte(off))
+		i += n
+		lit = 0
+		out = append(out, 0)
+	}
+	for ; i < len(in); i++ {
+		literal(in[i])
+	}
+	if lit > 0 {
+		out[len(out)-lit-1] = byte(lit - 1)
+	} else {
+		out = out[:len(out)-1]
+	}
+	return out
+}
@@ -0,0 +1,156 @@
+// Package rdb reads and writes the RDB files of Redis, so datasets can be
+// moved between Redis and this server. It knows nothing of the keyspace:
+// keys are read into and written from an Entry holding plain Go values.
+//
+// Files of versions 1 to 11 are read, with the compact encodings of Redis
+// 7.2 and before: ziplist, listpack, intset, zipmap and quicklist, and LZF
+// compressed strings. Versions 9 to 11 are written, values in the plain
+// encodings every one of them loads.
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc64"
+	"time"
+)
+
+// The versions of the format understood.
+const (
+	MinVersion      = 1
+	MaxVersion      = 11
+	MinWriteVersion = 9
+)
+
+// Value types, as stored in the file.
+const (
+	typeString           = 0
+	typeList             = 1
+	typeSet              = 2
+	typeZset             = 3
+	typeHash             = 4
+	typeZset2            = 5
+	typeModule           = 6
+	typeModule2          = 7
+	typeHashZipmap       = 9
+	typeListZiplist      = 10
+	typeSetIntset        = 11
+	typeZsetZiplist      = 12
+	typeHashZiplist      = 13
+	typeListQuicklist    = 14
+	typeStreamListpacks  = 15
+	typeHashListpack     = 16
+	typeZsetListpack     = 17
+	typeListQuicklist2   = 18
+	typeStreamListpacks2 = 19
+	typeSetListpack      = 20
+	typeStreamListpacks3 = 21
+)
+
+// Opcodes, found where a value type is expected.
+const (
+	opcodeFunction2    = 0xf5
+	opcodeFunctionPre  = 0xf6
+	opcodeModuleAux    = 0xf7
+	opcodeIdle         = 0xf8
+	opcodeFreq         = 0xf9
+	opcodeAux          = 0xfa
+	opcodeResizeDB     = 0xfb
+	opcodeExpireTimeMs = 0xfc
+	opcodeExpireTime   = 0xfd
+	opcodeSelectDB     = 0xfe
+	opcodeEOF          = 0xff
+)
+
+// Length encodings: the two high bits of the first byte tell the size of
+// the length, or that a special string encoding follows.
+const (
+	len6bit  = 0
+	len14bit = 1
+	len32bit = 0x80
+	len64bit = 0x81
+	encVal   = 3
+
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+)
+
+// The nodes of a quicklist2 list.
+const (
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+)
+
+var ErrChecksum = errors.New("wrong RDB checksum")
+
+// Entry is a key of an RDB file.
+type Entry struct {
+	DB    int
+	Key   string
+	Value Value
+	// Expire is when the key expires, zero when it does not.
+	Expire time.Time
+	// Idle and Freq are the LRU idle time and the LFU counter of the key,
+	// Redis saves one of them depending on its maxmemory-policy.
+	Idle *time.Duration
+	Freq *uint8
+}
+
+// Value is the value of a key: String, List, Set, Zset or Hash.
+type Value interface {
+	// Type is the name of the type, as TYPE replies.
+	Type() string
+}
+
+type String string
+
+type List []string
+
+type Set []string
+
+// Zset is a sorted set, in no particular order.
+type Zset []ZsetMember
+
+type ZsetMember struct {
+	Member string
+	Score  float64
+}
+
+// Hash is the fields of a hash, in no particular order.
+type Hash []HashField
+
+type HashField struct {
+	Field string
+	Value string
+}
+
+func (String) Type() string { return "string" }
+func (List) Type() string   { return "list" }
+func (Set) Type() string    { return "set" }
+func (Zset) Type() string   { return "zset" }
+func (Hash) Type() string   { return "hash" }
+
+// crcTable is the CRC-64/Jones of Redis, in the reflected form Go uses.
+var crcTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+// checksum is the RDB checksum, crc64 of Redis has no initial or final
+// inversion unlike hash/crc64.
+type checksum struct {
+	crc uint64
+}
+
+var _ hash.Hash64 = (*checksum)(nil)
+
+func (c *checksum) Write(p []byte) (int, error) {
+	c.crc = ^crc64.Update(^c.crc, crcTable, p)
+	return len(p), nil
+}
+
+func (c *checksum) Sum64() uint64       { return c.crc }
+func (c *checksum) Sum(b []byte) []byte { return binary.LittleEndian.AppendUint64(b, c.crc) }
+func (c *checksum) Reset()              { c.crc = 0 }
+func (c *checksum) Size() int           { return 8 }
+func (c *checksum) BlockSize() int      { return 1 }
@@ -0,0 +1,258 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The fixtures are RDB files, one per family of encodings. The first was
+// saved by Redis, the others are encoded by hand byte for byte as the
+// Redis versions named wrote them.
+var fixtures = map[string]string{
+	// a version 11 file saved by Redis 7.2: a quicklist of listpacks, a
+	// listpack sorted set and an LZF compressed string
+	"redis-7.2": "524544495330303131fa0972656469732d76657205372e322e34fe00fb030012046c69737401021010" +
+		"0000000300816102dfff02c3e802ff11047a73657414140000000400816d0283312e3504816e020201ff0003626967c30628016162e0" +
+		"1d01ff4371618a864d08af",
+	// ziplists of a list, a sorted set and a hash, as of Redis 2.6 to 6.2,
+	// with strings and every integer size
+	"ziplist": "524544495330303036fe000a046c69737433330000002f000000070000016103f202c02c0104f090eefe050b68656c6c6f20" +
+		"776f726c640de000f2052a010000000afe9cff0c047a736574191900000012000000040000016d03f202016e03042d322e35" +
+		"ff0d0468617368161600000013000000040000016603017603016e03fdffff54fd328f7d33a77b",
+	// listpacks of a hash, a sorted set and a set, as of Redis 7
+	"listpack": "524544495330303131fe0010046861736812120000000400816602817602816e026401ff11047a7365741515000000040081" +
+		"6d020101816e02842d322e3505ff140373657426260000000600816102dc1802f1307503f200ee8504f30094357705f4000e" +
+		"fad5feffffff09ffffe8bcd147b7b5ae73",
+	// intsets of 16 and 64 bit integers
+	"intset": "524544495330303036fe000b05736d616c6c0e0200000003000000fdff010002000b03626967180800000002000000000000" +
+		"0000ffffff0000000000010000ff3f3896bfd6fc323b",
+	// a zipmap hash with free bytes, as of Redis 2.4, without a checksum
+	"zipmap": "524544495330303034fe0009046861736815020166010076046e616d65050272656469730000ffff",
+	// a quicklist of two ziplists, as of Redis 3.2 to 6.2
+	"quicklist": "524544495330303037fe000e046c6973740211110000000d0000000200000161030162ff10100000000c000000020000f402" +
+		"0163ffff057aaf07854d9382",
+	// a quicklist of a listpack and a plain node, as of Redis 7
+	"quicklist2": "524544495330303130fe0012046c69737402020c0c00000002008161020701ff014064787878787878787878787878787878" +
+		"7878787878787878787878787878787878787878787878787878787878787878787878787878787878787878787878787878" +
+		"7878787878787878787878787878787878787878787878787878787878787878787878ff628b75c35a36ab05",
+	// an LZF compressed string, keys expiring in milliseconds and in
+	// seconds, and keys with their LRU idle time and LFU counter
+	"lzf-expire": "524544495330303039fe00000173c3051e0061e01400fc7b68e5cf8b01000000026d730176fd00f1536500037365630176f8" +
+		"405a000469646c650176f907000466726571c085ff7bbc567232167ae4",
+	// the first sorted set encoding, scores as strings
+	"zset": "524544495330303033fe0003017a03016103312e350162fe0163ffff",
+}
+
+func fixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(fixtures[name])
+	require.NoError(t, err)
+	return data
+}
+
+// readAll reads every key of data, up to the first error.
+func readAll(data []byte) ([]*Entry, error) {
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var entries []*Entry
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+}
+
+func TestReadEncodings(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    map[string]Value
+	}{
+		{"redis-7.2", map[string]Value{
+			"list": List{"a", "-1", "1000"},
+			"zset": Zset{{"m", 1.5}, {"n", 2}},
+			"big":  String(bytes.Repeat([]byte("ab"), 20)),
+		}},
+		{"ziplist", map[string]Value{
+			"list": List{"a", "1", "300", "-70000", "hello world", "5000000000", "-100"},
+			"zset": Zset{{"m", 1}, {"n", -2.5}},
+			"hash": Hash{{"f", "v"}, {"n", "12"}},
+		}},
+		{"listpack", map[string]Value{
+			"hash": Hash{{"f", "v"}, {"n", "100"}},
+			"zset": Zset{{"m", 1}, {"n", -2.5}},
+			"set":  Set{"a", "-1000", "30000", "-8000000", "2000000000", "-5000000000"},
+		}},
+		{"intset", map[string]Value{
+			"small": Set{"-3", "1", "2"},
+			"big":   Set{"-1099511627776", "1099511627776"},
+		}},
+		{"zipmap", map[string]Value{
+			"hash": Hash{{"f", "v"}, {"name", "redis"}},
+		}},
+		{"quicklist", map[string]Value{
+			"list": List{"a", "b", "3", "c"},
+		}},
+		{"quicklist2", map[string]Value{
+			"list": List{"a", "7", string(bytes.Repeat([]byte("x"), 100))},
+		}},
+		{"lzf-expire", map[string]Value{
+			"s":    String(bytes.Repeat([]byte("a"), 30)),
+			"ms":   String("v"),
+			"sec":  String("v"),
+			"idle": String("v"),
+			"freq": String("-123"),
+		}},
+		{"zset", map[string]Value{
+			"z": Zset{{"a", 1.5}, {"b", math.Inf(1)}, {"c", math.Inf(-1)}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			entries, err := readAll(fixture(t, tt.fixture))
+			require.NoError(t, err)
+			got := make(map[string]Value, len(entries))
+			for _, e := range entries {
+				got[e.Key] = e.Value
+			}
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestReadKeyInfo(t *testing.T) {
+	entries, err := readAll(fixture(t, "lzf-expire"))
+	require.NoError(t, err)
+	byKey := make(map[string]*Entry, len(entries))
+	for _, e := range entries {
+		byKey[e.Key] = e
+	}
+	require.True(t, byKey["s"].Expire.IsZero())
+	require.Equal(t, time.UnixMilli(1700000000123), byKey["ms"].Expire)
+	require.Equal(t, time.Unix(1700000000, 0), byKey["sec"].Expire)
+	require.Equal(t, 90*time.Second, *byKey["idle"].Idle)
+	require.Nil(t, byKey["idle"].Freq)
+	require.Equal(t, uint8(7), *byKey["freq"].Freq)
+
+	r, err := NewReader(bytes.NewReader(fixture(t, "redis-7.2")))
+	require.NoError(t, err)
+	require.Equal(t, 11, r.Version)
+	_, err = r.Next()
+	require.NoError(t, err)
+	require.Equal(t, "7.2.4", r.Aux["redis-ver"])
+}
+
+func TestChecksum(t *testing.T) {
+	c := &checksum{}
+	c.Write([]byte("123456789"))
+	require.Equal(t, uint64(0xe9c6d914c4b8d9ca), c.Sum64())
+
+	data := fixture(t, "redis-7.2")
+	_, err := readAll(data)
+	require.NoError(t, err)
+
+	wrong := bytes.Clone(data)
+	wrong[len(wrong)-1] ^= 1
+	_, err = readAll(wrong)
+	require.ErrorIs(t, err, ErrChecksum)
+
+	// a byte of a value changed is caught by the checksum alone
+	changed := bytes.Replace(data, []byte("redis-ver"), []byte("redis-vex"), 1)
+	_, err = readAll(changed)
+	require.ErrorIs(t, err, ErrChecksum)
+
+	// a zero checksum was not computed
+	unchecked := bytes.Clone(wrong)
+	copy(unchecked[len(unchecked)-8:], make([]byte, 8))
+	_, err = readAll(unchecked)
+	require.NoError(t, err)
+}
+
+func TestReadTruncated(t *testing.T) {
+	for name := range fixtures {
+		data := fixture(t, name)
+		for n := 0; n < len(data); n++ {
+			_, err := readAll(data[:n])
+			require.Error(t, err, "%s truncated to %d bytes", name, n)
+		}
+	}
+}
+
+func TestReadCorrupt(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		// old is replaced with new in the fixture
+		old, new string
+		err      string
+	}{
+		{"signature", "ziplist", "5245444953", "5245444958", "wrong signature"},
+		{"version", "ziplist", "524544495330303036", "524544495330303132", "can't handle RDB format version 0012"},
+		{"value type", "intset", "0b05736d616c6c", "6405736d616c6c", "unknown value type 100"},
+		{"ziplist size", "ziplist", "333300", "343300", "invalid ziplist encoding"},
+		{"ziplist entry", "ziplist", "0104f090eefe", "0104f590eefe", "invalid ziplist encoding"},
+		{"listpack count", "listpack", "1212000000040081", "1212000000050081", "invalid listpack encoding"},
+		{"intset size", "intset", "0e02000000", "0e03000000", "invalid intset encoding"},
+		{"zipmap end", "zipmap", "72656469730000ffff", "7265646973000000ff", "invalid zipmap encoding"},
+		{"quicklist2 container", "quicklist2", "0202", "0203", "unknown quicklist node container 3"},
+		{"lzf", "lzf-expire", "0061e01400", "0061e01410", "invalid LZF compressed string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			corrupt := fixtures[tt.fixture]
+			require.Contains(t, corrupt, tt.old)
+			data, err := hex.DecodeString(strings.Replace(corrupt, tt.old, tt.new, 1))
+			require.NoError(t, err)
+			_, err = readAll(data)
+			require.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	entries := []*Entry{
+		{Key: "s", Value: String("hello")},
+		{Key: "n", Value: String("-70000")},
+		{Key: "long", Value: String(bytes.Repeat([]byte("abc"), 100))},
+		{Key: "l", Value: List{"a", "1", ""}, Expire: time.UnixMilli(1700000000123)},
+		{Key: "set", Value: Set{"x", "y"}},
+		{Key: "z", Value: Zset{{"m", 1.5}, {"n", math.Inf(-1)}}},
+		{Key: "h", Value: Hash{{"f", "v"}}},
+	}
+	for version := MinWriteVersion; version <= MaxVersion; version++ {
+		for _, compress := range []bool{false, true} {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, version)
+			require.NoError(t, err)
+			w.Compress = compress
+			require.NoError(t, w.Aux("redis-ver", "7.2.4"))
+			require.NoError(t, w.SelectDB(0, len(entries), 1))
+			for _, e := range entries {
+				require.NoError(t, w.Write(e))
+			}
+			require.NoError(t, w.Close())
+			got, err := readAll(buf.Bytes())
+			require.NoError(t, err)
+			require.Equal(t, entries, got)
+		}
+	}
+	_, err := NewWriter(io.Discard, MinWriteVersion-1)
+	require.Error(t, err)
+	w, err := NewWriter(io.Discard, MaxVersion)
+	require.NoError(t, err)
+	require.ErrorContains(t, w.Write(&Entry{Key: "k"}), "unsupported value")
+	require.ErrorContains(t, w.Close(), "unsupported value")
+}
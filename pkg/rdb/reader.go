@@ -0,0 +1,483 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Reader reads the keys of an RDB file one at a time.
+type Reader struct {
+	d       decoder
+	crc     *checksum
+	Version int
+	// Aux holds the auxiliary fields read so far, like redis-ver.
+	Aux map[string]string
+	// Functions holds the code of the function libraries read so far.
+	Functions []string
+	db        int
+	done      bool
+}
+
+// NewReader reads the header of an RDB file. When r is a bufio.Reader it
+// is read from directly, so nothing past the end of the file is consumed.
+func NewReader(r io.Reader) (*Reader, error) {
+	crc := &checksum{}
+	rr := &Reader{d: decoder{r: bufio.NewReader(r), crc: crc}, crc: crc, Aux: make(map[string]string)}
+	header := make([]byte, 9)
+	if err := rr.d.full(header); err != nil {
+		return nil, err
+	}
+	if string(header[:5]) != "REDIS" {
+		return nil, fmt.Errorf("wrong signature trying to load DB from file")
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil || version < MinVersion || version > MaxVersion {
+		return nil, fmt.Errorf("can't handle RDB format version %s", header[5:])
+	}
+	rr.Version = version
+	return rr, nil
+}
+
+// Next returns the next key of the file, or io.EOF once the end of the
+// file is reached and its checksum verified.
+func (r *Reader) Next() (*Entry, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+	d := &r.d
+	e := &Entry{}
+	for {
+		t, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+		switch t {
+		case opcodeEOF:
+			r.done = true
+			if r.Version < 5 {
+				return nil, io.EOF
+			}
+			want := r.crc.Sum64()
+			got := make([]byte, 8)
+			if err := d.full(got); err != nil {
+				return nil, err
+			}
+			// a zero checksum means the writer did not compute one
+			if sum := binary.LittleEndian.Uint64(got); sum != 0 && sum != want {
+				return nil, ErrChecksum
+			}
+			return nil, io.EOF
+		case opcodeSelectDB:
+			n, err := d.length()
+			if err != nil {
+				return nil, err
+			}
+			r.db = int(n)
+			continue
+		case opcodeResizeDB:
+			if _, err := d.length(); err != nil {
+				return nil, err
+			}
+			if _, err := d.length(); err != nil {
+				return nil, err
+			}
+			continue
+		case opcodeAux:
+			key, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			val, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			r.Aux[key] = val
+			continue
+		case opcodeExpireTimeMs:
+			b := make([]byte, 8)
+			if err := d.full(b); err != nil {
+				return nil, err
+			}
+			e.Expire = time.UnixMilli(int64(binary.LittleEndian.Uint64(b)))
+			continue
+		case opcodeExpireTime:
+			b := make([]byte, 4)
+			if err := d.full(b); err != nil {
+				return nil, err
+			}
+			e.Expire = time.Unix(int64(int32(binary.LittleEndian.Uint32(b))), 0)
+			continue
+		case opcodeIdle:
+			n, err := d.length()
+			if err != nil {
+				return nil, err
+			}
+			idle := time.Duration(n) * time.Second
+			e.Idle = &idle
+			continue
+		case opcodeFreq:
+			b, err := d.byte()
+			if err != nil {
+				return nil, err
+			}
+			e.Freq = &b
+			continue
+		case opcodeFunction2:
+			code, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			r.Functions = append(r.Functions, code)
+			continue
+		case opcodeFunctionPre:
+			return nil, fmt.Errorf("functions saved by a pre-release Redis 7 are not supported")
+		case opcodeModuleAux:
+			return nil, fmt.Errorf("module data is not supported")
+		}
+		if e.Key, err = d.string(); err != nil {
+			return nil, err
+		}
+		if e.Value, err = d.value(t); err != nil {
+			return nil, fmt.Errorf("key %q: %w", e.Key, err)
+		}
+		e.DB = r.db
+		return e, nil
+	}
+}
+
+// decoder reads RDB encoded values, feeding the checksum.
+type decoder struct {
+	r   *bufio.Reader
+	crc *checksum
+}
+
+func (d *decoder) full(b []byte) error {
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("short read loading DB: %w", err)
+	}
+	d.crc.Write(b)
+	return nil
+}
+
+func (d *decoder) byte() (byte, error) {
+	b := make([]byte, 1)
+	err := d.full(b)
+	return b[0], err
+}
+
+// lengthOrEnc reads a length, or a special encoding when enc is set.
+func (d *decoder) lengthOrEnc() (n uint64, enc bool, err error) {
+	b, err := d.byte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case len6bit:
+		return uint64(b & 0x3f), false, nil
+	case len14bit:
+		b2, err := d.byte()
+		return uint64(b&0x3f)<<8 | uint64(b2), false, err
+	case encVal:
+		return uint64(b & 0x3f), true, nil
+	}
+	switch b {
+	case len32bit:
+		buf := make([]byte, 4)
+		err := d.full(buf)
+		return uint64(binary.BigEndian.Uint32(buf)), false, err
+	case len64bit:
+		buf := make([]byte, 8)
+		err := d.full(buf)
+		return binary.BigEndian.Uint64(buf), false, err
+	}
+	return 0, false, fmt.Errorf("unknown length encoding %d", b)
+}
+
+func (d *decoder) length() (uint64, error) {
+	n, enc, err := d.lengthOrEnc()
+	if err == nil && enc {
+		err = fmt.Errorf("unexpected string encoding where a length is expected")
+	}
+	return n, err
+}
+
+func (d *decoder) string() (string, error) {
+	n, enc, err := d.lengthOrEnc()
+	if err != nil {
+		return "", err
+	}
+	if enc {
+		switch n {
+		case encInt8:
+			b, err := d.byte()
+			return strconv.Itoa(int(int8(b))), err
+		case encInt16:
+			b := make([]byte, 2)
+			err := d.full(b)
+			return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(b)))), err
+		case encInt32:
+			b := make([]byte, 4)
+			err := d.full(b)
+			return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(b)))), err
+		case encLZF:
+			return d.lzfString()
+		}
+		return "", fmt.Errorf("unknown string encoding %d", n)
+	}
+	b, err := d.bytes(n)
+	return string(b), err
+}
+
+func (d *decoder) bytes(n uint64) ([]byte, error) {
+	if n > math.MaxInt32 {
+		return nil, fmt.Errorf("string of %d bytes is too long", n)
+	}
+	b := make([]byte, n)
+	err := d.full(b)
+	return b, err
+}
+
+// lzfString reads a compressed string: its compressed and its original
+// lengths, then the compressed bytes.
+func (d *decoder) lzfString() (string, error) {
+	clen, err := d.length()
+	if err != nil {
+		return "", err
+	}
+	n, err := d.length()
+	if err != nil {
+		return "", err
+	}
+	if n > math.MaxInt32 {
+		return "", fmt.Errorf("string of %d bytes is too long", n)
+	}
+	c, err := d.bytes(clen)
+	if err != nil {
+		return "", err
+	}
+	b, err := lzfDecompress(c, int(n))
+	return string(b), err
+}
+
+// strings reads a length then that many strings.
+func (d *decoder) strings() ([]string, error) {
+	n, err := d.length()
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]string, 0, min(n, 1024))
+	for i := uint64(0); i < n; i++ {
+		s, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, s)
+	}
+	return vals, nil
+}
+
+// pairs reads a number of pairs then that many pairs of strings.
+func (d *decoder) pairs() ([]string, error) {
+	n, err := d.length()
+	if err != nil {
+		return nil, err
+	}
+	if n > math.MaxInt32 {
+		return nil, fmt.Errorf("%d pairs are too many", n)
+	}
+	vals := make([]string, 0, min(2*n, 1024))
+	for i := uint64(0); i < 2*n; i++ {
+		s, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, s)
+	}
+	return vals, nil
+}
+
+// blob reads a string holding one of the compact encodings and decodes
+// it with decode.
+func (d *decoder) blob(decode func([]byte) ([]string, error)) ([]string, error) {
+	s, err := d.string()
+	if err != nil {
+		return nil, err
+	}
+	return decode([]byte(s))
+}
+
+func (d *decoder) value(t byte) (Value, error) {
+	switch t {
+	case typeString:
+		s, err := d.string()
+		return String(s), err
+	case typeList:
+		elems, err := d.strings()
+		return List(elems), err
+	case typeListZiplist:
+		elems, err := d.blob(ziplistEntries)
+		return List(elems), err
+	case typeListQuicklist, typeListQuicklist2:
+		return d.quicklist(t)
+	case typeSet:
+		members, err := d.strings()
+		return Set(members), err
+	case typeSetIntset:
+		members, err := d.blob(intsetEntries)
+		return Set(members), err
+	case typeSetListpack:
+		members, err := d.blob(listpackEntries)
+		return Set(members), err
+	case typeZset, typeZset2:
+		return d.zset(t)
+	case typeZsetZiplist:
+		return zsetFromPairs(d.blob(ziplistEntries))
+	case typeZsetListpack:
+		return zsetFromPairs(d.blob(listpackEntries))
+	case typeHash:
+		return hashFromPairs(d.pairs())
+	case typeHashZipmap:
+		return hashFromPairs(d.blob(zipmapEntries))
+	case typeHashZiplist:
+		return hashFromPairs(d.blob(ziplistEntries))
+	case typeHashListpack:
+		return hashFromPairs(d.blob(listpackEntries))
+	case typeModule, typeModule2:
+		return nil, fmt.Errorf("module values are not supported")
+	case typeStreamListpacks, typeStreamListpacks2, typeStreamListpacks3:
+		return nil, fmt.Errorf("stream values are not supported")
+	}
+	return nil, fmt.Errorf("unknown value type %d", t)
+}
+
+// hashFromPairs makes a hash of alternating fields and values, the way
+// every encoding stores them.
+func hashFromPairs(pairs []string, err error) (Value, error) {
+	if err != nil {
+		return nil, err
+	}
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("hash with an odd number of fields and values")
+	}
+	h := make(Hash, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		h = append(h, HashField{Field: pairs[i], Value: pairs[i+1]})
+	}
+	return h, nil
+}
+
+// zsetFromPairs makes a sorted set of alternating members and scores.
+func zsetFromPairs(pairs []string, err error) (Value, error) {
+	if err != nil {
+		return nil, err
+	}
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("sorted set with an odd number of members and scores")
+	}
+	z := make(Zset, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		score, err := strconv.ParseFloat(pairs[i+1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid score %q", pairs[i+1])
+		}
+		z = append(z, ZsetMember{Member: pairs[i], Score: score})
+	}
+	return z, nil
+}
+
+// quicklist reads a list of ziplists, or for quicklist2 of nodes which
+// are either a listpack or a single large element.
+func (d *decoder) quicklist(t byte) (Value, error) {
+	n, err := d.length()
+	if err != nil {
+		return nil, err
+	}
+	var elems List
+	for i := uint64(0); i < n; i++ {
+		container := uint64(quicklistNodePacked)
+		if t == typeListQuicklist2 {
+			if container, err = d.length(); err != nil {
+				return nil, err
+			}
+		}
+		switch {
+		case t == typeListQuicklist:
+			node, err := d.blob(ziplistEntries)
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, node...)
+		case container == quicklistNodePacked:
+			node, err := d.blob(listpackEntries)
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, node...)
+		case container == quicklistNodePlain:
+			elem, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, elem)
+		default:
+			return nil, fmt.Errorf("unknown quicklist node container %d", container)
+		}
+	}
+	return elems, nil
+}
+
+func (d *decoder) zset(t byte) (Value, error) {
+	n, err := d.length()
+	if err != nil {
+		return nil, err
+	}
+	z := make(Zset, 0, min(n, 1024))
+	for i := uint64(0); i < n; i++ {
+		member, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		var score float64
+		if t == typeZset2 {
+			b := make([]byte, 8)
+			if err := d.full(b); err != nil {
+				return nil, err
+			}
+			score = math.Float64frombits(binary.LittleEndian.Uint64(b))
+		} else if score, err = d.oldDouble(); err != nil {
+			return nil, err
+		}
+		z = append(z, ZsetMember{Member: member, Score: score})
+	}
+	return z, nil
+}
+
+// oldDouble reads a score of the first zset encoding, a length prefixed
+// string with special lengths for NaN and the infinities.
+func (d *decoder) oldDouble() (float64, error) {
+	n, err := d.byte()
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	b := make([]byte, n)
+	if err := d.full(b); err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(b), 64)
+}
@@ -0,0 +1,185 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Writer writes an RDB file. Its methods are called in the order of the
+// file: auxiliary fields, then the keys of each database after SelectDB,
+// then Close. The first error sticks, it is returned by every later call.
+type Writer struct {
+	w   io.Writer
+	bw  *bufio.Writer
+	crc *checksum
+	err error
+	// Compress makes strings over 20 bytes LZF compressed when it saves
+	// space, like rdbcompression does.
+	Compress bool
+}
+
+// NewWriter writes the header of an RDB file of the given version.
+func NewWriter(w io.Writer, version int) (*Writer, error) {
+	if version < MinWriteVersion || version > MaxVersion {
+		return nil, fmt.Errorf("can't write RDB format version %d", version)
+	}
+	crc := &checksum{}
+	rw := &Writer{w: w, bw: bufio.NewWriter(io.MultiWriter(w, crc)), crc: crc}
+	rw.raw([]byte(fmt.Sprintf("REDIS%04d", version)))
+	return rw, rw.err
+}
+
+// Aux writes an auxiliary field, like redis-ver.
+func (w *Writer) Aux(key, val string) error {
+	w.byte(opcodeAux)
+	w.string(key)
+	w.string(val)
+	return w.err
+}
+
+// SelectDB starts the keys of database db, size and expires are the
+// number of keys and of keys with an expiry, they let the loader size its
+// tables.
+func (w *Writer) SelectDB(db, size, expires int) error {
+	w.byte(opcodeSelectDB)
+	w.length(uint64(db))
+	w.byte(opcodeResizeDB)
+	w.length(uint64(size))
+	w.length(uint64(expires))
+	return w.err
+}
+
+// Write writes a key, its DB field is ignored.
+func (w *Writer) Write(e *Entry) error {
+	if !e.Expire.IsZero() {
+		w.byte(opcodeExpireTimeMs)
+		w.raw(binary.LittleEndian.AppendUint64(nil, uint64(e.Expire.UnixMilli())))
+	}
+	if e.Idle != nil {
+		w.byte(opcodeIdle)
+		w.length(uint64(e.Idle.Seconds()))
+	}
+	if e.Freq != nil {
+		w.byte(opcodeFreq)
+		w.byte(*e.Freq)
+	}
+	switch v := e.Value.(type) {
+	case String:
+		w.byte(typeString)
+		w.string(e.Key)
+		w.string(string(v))
+	case List:
+		w.byte(typeList)
+		w.string(e.Key)
+		w.strings(v)
+	case Set:
+		w.byte(typeSet)
+		w.string(e.Key)
+		w.strings(v)
+	case Zset:
+		w.byte(typeZset2)
+		w.string(e.Key)
+		w.length(uint64(len(v)))
+		for _, m := range v {
+			w.string(m.Member)
+			w.raw(binary.LittleEndian.AppendUint64(nil, math.Float64bits(m.Score)))
+		}
+	case Hash:
+		w.byte(typeHash)
+		w.string(e.Key)
+		w.length(uint64(len(v)))
+		for _, f := range v {
+			w.string(f.Field)
+			w.string(f.Value)
+		}
+	default:
+		if w.err == nil {
+			w.err = fmt.Errorf("key %q: unsupported value %T", e.Key, e.Value)
+		}
+	}
+	return w.err
+}
+
+// Close ends the file with its checksum, the underlying writer is left
+// open.
+func (w *Writer) Close() error {
+	w.byte(opcodeEOF)
+	if w.err != nil {
+		return w.err
+	}
+	if w.err = w.bw.Flush(); w.err != nil {
+		return w.err
+	}
+	_, w.err = w.w.Write(w.crc.Sum(nil))
+	return w.err
+}
+
+func (w *Writer) raw(b []byte) {
+	if w.err == nil {
+		_, w.err = w.bw.Write(b)
+	}
+}
+
+func (w *Writer) byte(b byte) {
+	if w.err == nil {
+		w.err = w.bw.WriteByte(b)
+	}
+}
+
+func (w *Writer) length(n uint64) {
+	switch {
+	case n < 1<<6:
+		w.byte(byte(n))
+	case n < 1<<14:
+		w.raw([]byte{len14bit<<6 | byte(n>>8), byte(n)})
+	case n <= math.MaxUint32:
+		w.byte(len32bit)
+		w.raw(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	default:
+		w.byte(len64bit)
+		w.raw(binary.BigEndian.AppendUint64(nil, n))
+	}
+}
+
+// string writes s, as an integer when it is the canonical form of one that
+// fits in 32 bits, like Redis does, or else compressed when enabled.
+func (w *Writer) string(s string) {
+	if len(s) <= 11 {
+		if n, err := strconv.ParseInt(s, 10, 32); err == nil && strconv.FormatInt(n, 10) == s {
+			switch {
+			case n >= math.MinInt8 && n <= math.MaxInt8:
+				w.raw([]byte{encVal<<6 | encInt8, byte(n)})
+			case n >= math.MinInt16 && n <= math.MaxInt16:
+				w.byte(encVal<<6 | encInt16)
+				w.raw(binary.LittleEndian.AppendUint16(nil, uint16(n)))
+			default:
+				w.byte(encVal<<6 | encInt32)
+				w.raw(binary.LittleEndian.AppendUint32(nil, uint32(n)))
+			}
+			return
+		}
+	}
+	if w.Compress && len(s) > 20 {
+		// Redis gives up unless at least 4 bytes are saved
+		if c := lzfCompress([]byte(s)); len(c) <= len(s)-4 {
+			w.byte(encVal<<6 | encLZF)
+			w.length(uint64(len(c)))
+			w.length(uint64(len(s)))
+			w.raw(c)
+			return
+		}
+	}
+	w.length(uint64(len(s)))
+	w.raw([]byte(s))
+}
+
+func (w *Writer) strings(vals []string) {
+	w.length(uint64(len(vals)))
+	for _, s := range vals {
+		w.string(s)
+	}
+}
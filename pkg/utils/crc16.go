@@ -0,0 +1,46 @@
+package utils
+
+import "strings"
+
+// ClusterSlots is the number of hash slots the keyspace of a cluster is
+// split into.
+const ClusterSlots = 16384
+
+// crc16Table is the CRC16-CCITT (XMODEM) table, the CRC Redis Cluster
+// hashes keys with.
+var crc16Table = func() [256]uint16 {
+	var t [256]uint16
+	for i := range t {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		t[i] = crc
+	}
+	return t
+}()
+
+// CRC16 returns the CRC16-CCITT (XMODEM) checksum of s.
+func CRC16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^s[i]]
+	}
+	return crc
+}
+
+// KeySlot returns the hash slot of key. Only the part within the first
+// {hash tag} is hashed when it is not empty, so related keys can be kept
+// in the same slot.
+func KeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(CRC16(key)) & (ClusterSlots - 1)
+}
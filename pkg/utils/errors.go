@@ -0,0 +1,19 @@
+package utils
+
+import "fmt"
+
+// CodedError is an error replied to clients with its own error code, like
+// WRONGTYPE or MOVED. Every other error is replied with the generic ERR.
+type CodedError struct {
+	Code string
+	Msg  string
+}
+
+func (e *CodedError) Error() string {
+	return e.Code + " " + e.Msg
+}
+
+// CodedErrorf returns a CodedError with code and the formatted message.
+func CodedErrorf(code, format string, args ...any) error {
+	return &CodedError{Code: code, Msg: fmt.Sprintf(format, args...)}
+}
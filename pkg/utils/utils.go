@@ -2,24 +2,33 @@ package utils
 
 import (
 	"fmt"
+	"math"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
 )
 
-func MsOrS(t, time string) (string, error) {
-	num, err := strconv.ParseInt(time, 10, 64)
+// MsOrS converts the time to live of SET, in seconds for EX or in
+// milliseconds for PX, to milliseconds. It must be positive, and the time
+// it expires at must fit in Unix milliseconds.
+func MsOrS(t, ttl string) (string, error) {
+	num, err := strconv.ParseInt(ttl, 10, 64)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return "", fmt.Errorf("time should be a number")
+		return "", fmt.Errorf("value is not an integer or out of range")
 	}
 	if t == "EX" {
-		// isnum?
-		return strconv.Itoa(int(num) * 1000), nil
-	} else if t == "PX" {
-		// millionsec to sec
-		return strconv.Itoa(int(num)), nil
+		if num > math.MaxInt64/1000 {
+			return "", fmt.Errorf("invalid expire time in 'set' command")
+		}
+		num *= 1000
+	} else if t != "PX" {
+		return "", fmt.Errorf("invalid SET command")
 	}
-	return "", fmt.Errorf("invalid SET command")
+	if num <= 0 || num > math.MaxInt64-time.Now().UnixMilli() {
+		return "", fmt.Errorf("invalid expire time in 'set' command")
+	}
+	return strconv.FormatInt(num, 10), nil
 }
 
 func IsNumeric(s string) bool {
@@ -27,9 +36,279 @@ func IsNumeric(s string) bool {
 	return re.MatchString(s)
 }
 
-func Btoi(b bool) string {
+func Btoi(b bool) int {
 	if b {
-		return "1"
+		return 1
+	}
+	return 0
+}
+
+// SplitArgs splits line into arguments the way Redis does for inline
+// commands and redis.conf lines: arguments are separated by white space and
+// may be "double quoted", with C style escapes such as \n or \x41, or
+// 'single quoted'.
+func SplitArgs(line string) ([]string, error) {
+	var args []string
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+		var (
+			arg        []byte
+			inDouble   bool
+			inSingle   bool
+			terminated bool
+		)
+		for !terminated {
+			if inDouble {
+				switch {
+				case i == len(line):
+					return nil, fmt.Errorf("unbalanced quotes")
+				case line[i] == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+					n, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					arg = append(arg, byte(n))
+					i += 3
+				case line[i] == '\\' && i+1 < len(line):
+					i++
+					switch line[i] {
+					case 'n':
+						arg = append(arg, '\n')
+					case 'r':
+						arg = append(arg, '\r')
+					case 't':
+						arg = append(arg, '\t')
+					case 'b':
+						arg = append(arg, '\b')
+					case 'a':
+						arg = append(arg, '\a')
+					default:
+						arg = append(arg, line[i])
+					}
+				case line[i] == '"':
+					// closing quote must be followed by a space or nothing
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, fmt.Errorf("unbalanced quotes")
+					}
+					terminated = true
+				default:
+					arg = append(arg, line[i])
+				}
+			} else if inSingle {
+				switch {
+				case i == len(line):
+					return nil, fmt.Errorf("unbalanced quotes")
+				case line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					i++
+					arg = append(arg, '\'')
+				case line[i] == '\'':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, fmt.Errorf("unbalanced quotes")
+					}
+					terminated = true
+				default:
+					arg = append(arg, line[i])
+				}
+			} else {
+				switch {
+				case i == len(line) || isSpace(line[i]):
+					terminated = true
+					i--
+				case line[i] == '"':
+					inDouble = true
+				case line[i] == '\'':
+					inSingle = true
+				default:
+					arg = append(arg, line[i])
+				}
+			}
+			i++
+		}
+		args = append(args, string(arg))
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\v' || c == '\f' || c == 0
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// ParseMemory parses a memory size the way redis.conf does: a plain number
+// of bytes, or a number followed by k, kb, m, mb, g or gb, case-insensitive.
+// k, m and g are powers of 1000, kb, mb and gb powers of 1024.
+func ParseMemory(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+	lower := strings.ToLower(s)
+	mul := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(lower, u.suffix) {
+			lower, mul = strings.TrimSuffix(lower, u.suffix), u.mul
+			break
+		}
+	}
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/mul {
+		return 0, fmt.Errorf("argument must be a memory value")
+	}
+	return n * mul, nil
+}
+
+// HumanBytes formats n the way INFO does, like 1.50M.
+func HumanBytes(n int64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	f := float64(n)
+	i := -1
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.2f%c", f, units[i])
+}
+
+// AppendCommand appends args to dst as a RESP array of bulk strings, the
+// way clients send commands and the AOF stores them.
+func AppendCommand(dst []byte, args ...string) []byte {
+	dst = append(dst, '*')
+	dst = strconv.AppendInt(dst, int64(len(args)), 10)
+	dst = append(dst, '\r', '\n')
+	for _, arg := range args {
+		dst = append(dst, '$')
+		dst = strconv.AppendInt(dst, int64(len(arg)), 10)
+		dst = append(dst, '\r', '\n')
+		dst = append(dst, arg...)
+		dst = append(dst, '\r', '\n')
+	}
+	return dst
+}
+
+// QuoteArg returns s as a single argument SplitArgs reads back unchanged,
+// it is only quoted when needed.
+func QuoteArg(s string) string {
+	plain := s != ""
+	for i := 0; i < len(s) && plain; i++ {
+		c := s[i]
+		plain = c > ' ' && c < 0x7f && c != '"' && c != '\'' && c != '\\'
+	}
+	if plain {
+		return s
+	}
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' || c == '"':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c == '\n':
+			sb.WriteString(`\n`)
+		case c == '\r':
+			sb.WriteString(`\r`)
+		case c == '\t':
+			sb.WriteString(`\t`)
+		case c < ' ' || c >= 0x7f:
+			fmt.Fprintf(&sb, `\x%02x`, c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// GlobMatch reports whether s matches the glob-style pattern, with the
+// syntax of Redis: * and ? wildcards, [abc], [^abc] and [a-z] classes, and
+// \ to escape a special character.
+func GlobMatch(pattern, s string, nocase bool) bool {
+	if nocase {
+		pattern, s = strings.ToLower(pattern), strings.ToLower(s)
+	}
+	return globMatch(pattern, s)
+}
+
+func globMatch(p, s string) bool {
+	for len(p) > 0 {
+		switch p[0] {
+		case '*':
+			for len(p) > 1 && p[1] == '*' {
+				p = p[1:]
+			}
+			if len(p) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(p[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			p = p[1:]
+			not := len(p) > 0 && p[0] == '^'
+			if not {
+				p = p[1:]
+			}
+			match := false
+			for len(p) > 0 && p[0] != ']' {
+				switch {
+				case p[0] == '\\' && len(p) >= 2:
+					p = p[1:]
+					match = match || p[0] == s[0]
+				case len(p) >= 3 && p[1] == '-':
+					lo, hi := p[0], p[2]
+					if lo > hi {
+						lo, hi = hi, lo
+					}
+					match = match || (s[0] >= lo && s[0] <= hi)
+					p = p[2:]
+				default:
+					match = match || p[0] == s[0]
+				}
+				p = p[1:]
+			}
+			if match == not {
+				return false
+			}
+			s = s[1:]
+			if len(p) == 0 {
+				// unterminated class, like Redis the pattern ends here
+				return len(s) == 0
+			}
+		case '\\':
+			if len(p) >= 2 {
+				p = p[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || p[0] != s[0] {
+				return false
+			}
+			s = s[1:]
+		}
+		p = p[1:]
 	}
-	return "0"
+	return len(s) == 0
 }
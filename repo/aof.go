@@ -0,0 +1,41 @@
+package repo
+
+import (
+	"bufio"
+	"go-redis/pkg/utils"
+	"io"
+	"strconv"
+)
+
+// WriteAOF writes the snapshot as the commands rebuilding it, the shortest
+// AOF holding the same data.
+func (s *Snapshot) WriteAOF(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	var buf []byte
+	for _, entry := range s.entries {
+		buf = buf[:0]
+		switch v := entry.val.(type) {
+		case *String:
+			buf = utils.AppendCommand(buf, "SET", entry.key, string(v.val))
+		case *QuickList:
+			for node := v.head; node != nil; node = node.next {
+				for _, elem := range node.data {
+					buf = utils.AppendCommand(buf, "RPUSH", entry.key, elem)
+				}
+			}
+		case *Zset:
+			for node := v.skiplist.head.forward[0]; node != nil; node = node.forward[0] {
+				score := strconv.FormatFloat(node.score, 'g', -1, 64)
+				buf = utils.AppendCommand(buf, "ZADD", entry.key, score, node.member)
+			}
+		}
+		if !entry.expire.IsZero() {
+			at := strconv.FormatInt(entry.expire.UnixMilli(), 10)
+			buf = utils.AppendCommand(buf, "PEXPIREAT", entry.key, at)
+		}
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
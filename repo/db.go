@@ -0,0 +1,465 @@
+package repo
+
+import (
+	"errors"
+	"go-redis/pkg/utils"
+	"sync"
+	"time"
+)
+
+// Type is the type of the value stored at a key.
+type Type int
+
+const (
+	TypeString Type = iota
+	TypeList
+	TypeZset
+)
+
+// String returns the name of the type, as reported by TYPE.
+func (t Type) String() string {
+	switch t {
+	case TypeList:
+		return "list"
+	case TypeZset:
+		return "zset"
+	}
+	return "string"
+}
+
+// Value is the value of a key: a *String, a *QuickList or a *Zset.
+type Value interface {
+	Type() Type
+	// clone returns a deep copy of the value.
+	clone() Value
+	// memUsage estimates the bytes the value takes.
+	memUsage() int64
+}
+
+var (
+	ErrWrongType = &utils.CodedError{Code: "WRONGTYPE", Msg: "Operation against a key holding the wrong kind of value"}
+	ErrNoSuchKey = errors.New("no such key")
+)
+
+// DB is the keyspace. Every key maps to a single typed value, and keys
+// with a time to live to the time they expire.
+type DB struct {
+	dict    *Dict[*object]
+	expires *Dict[time.Time]
+	mu      sync.Mutex
+
+	// used estimates the memory the keys and values take, peak is the
+	// highest it has been.
+	used    int64
+	peak    int64
+	memConf MemoryConfig
+	pool    []evictionCandidate
+	evicted int64
+
+	// dirty counts the changes made to the keyspace, for the save rules
+	dirty int64
+
+	// expireCursor is where the active expire cycle resumes scanning
+	// expires.
+	expireCursor uint64
+	stats        ExpireStats
+	// keepExpired hides the expired keys from lookups without deleting
+	// them, and expired lists the keys deleted since TakeExpired.
+	keepExpired bool
+	expired     []string
+
+	// snapGen is the generation of the latest snapshot, liveSnaps the
+	// generations of the snapshots not released yet.
+	snapGen   uint64
+	liveSnaps []uint64
+
+	// slots indexes the keys by hash slot in cluster mode, nil otherwise.
+	slots []map[string]struct{}
+}
+
+func NewDB() *DB {
+	return &DB{
+		dict:    NewDict[*object](),
+		expires: NewDict[time.Time](),
+	}
+}
+
+// lookup returns the value of key and records the access for eviction.
+// A key whose time to live is over is deleted first, or only hidden when
+// the expired keys are kept, so it is never seen. The caller holds mu.
+func (db *DB) lookup(key string) (Value, bool) {
+	obj, ok := db.lookupObject(key)
+	if !ok {
+		return nil, false
+	}
+	db.touch(obj, time.Now())
+	return obj.val, true
+}
+
+// lookupObject is lookup without recording the access, for commands that
+// go over the keyspace rather than use a key. The caller holds mu.
+func (db *DB) lookupObject(key string) (*object, bool) {
+	obj, ok := db.dict.Get(key)
+	if !ok {
+		return nil, false
+	}
+	if at, ok := db.expires.Get(key); ok && !time.Now().Before(at) {
+		if !db.keepExpired {
+			db.expire(key)
+		}
+		return nil, false
+	}
+	return obj, true
+}
+
+// expire deletes key, whose time to live is over. The caller holds mu.
+func (db *DB) expire(key string) {
+	db.remove(key)
+	db.stats.ExpiredKeys++
+	db.expired = append(db.expired, key)
+}
+
+// SetKeepExpired makes lookups hide the expired keys without deleting
+// them, the way a replica waits for the DEL of its master.
+func (db *DB) SetKeepExpired(keep bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.keepExpired = keep
+}
+
+// TakeExpired returns the keys deleted because their time to live was over
+// since the previous call, for the DEL to propagate.
+func (db *DB) TakeExpired() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	keys := db.expired
+	db.expired = nil
+	return keys
+}
+
+// store sets key to a new object holding val, whatever key held before.
+// The caller holds mu and handles the time to live.
+func (db *DB) store(key string, val Value) {
+	obj := newObject(val)
+	obj.gen = db.snapGen
+	db.setObject(key, obj)
+}
+
+// setObject sets key to obj, whatever key held before. The caller holds
+// mu.
+func (db *DB) setObject(key string, obj *object) {
+	if old, ok := db.dict.Get(key); ok {
+		db.used -= keyOverhead + int64(len(key)) + old.size
+	} else {
+		db.indexKey(key)
+	}
+	obj.size = obj.val.memUsage()
+	db.dict.Set(key, obj)
+	db.grow(keyOverhead + int64(len(key)) + obj.size)
+}
+
+// unshare clones the value of key if a snapshot still being written
+// holds it, so it can be modified in place. The caller holds mu.
+func (db *DB) unshare(key string) {
+	obj, ok := db.dict.Get(key)
+	if !ok {
+		return
+	}
+	for _, gen := range db.liveSnaps {
+		if gen > obj.gen {
+			obj.val = obj.val.clone()
+			obj.gen = db.snapGen
+			return
+		}
+	}
+}
+
+// resized accounts for the value of key changing in place. The caller
+// holds mu.
+func (db *DB) resized(key string) {
+	obj, ok := db.dict.Get(key)
+	if !ok {
+		return
+	}
+	size := obj.val.memUsage()
+	db.grow(size - obj.size)
+	obj.size = size
+	db.dirty++
+}
+
+func (db *DB) grow(delta int64) {
+	db.used += delta
+	if db.used > db.peak {
+		db.peak = db.used
+	}
+}
+
+// remove deletes key with its time to live and reports whether it existed.
+// The caller holds mu.
+func (db *DB) remove(key string) bool {
+	db.expires.Delete(key)
+	obj, ok := db.dict.Get(key)
+	if !ok {
+		return false
+	}
+	db.used -= keyOverhead + int64(len(key)) + obj.size
+	db.dirty++
+	db.unindexKey(key)
+	return db.dict.Delete(key)
+}
+
+// Delete removes key, whatever its type, and reports whether it existed.
+func (db *DB) Delete(key string) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	_, ok := db.lookup(key)
+	// an expired key kept for the DEL of the master is deleted all the same
+	return db.remove(key) && ok, nil
+}
+
+// Flush deletes every key, like a replica does before loading the dataset
+// of its master.
+func (db *DB) Flush() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.dirty += int64(db.dict.Len())
+	db.dict = NewDict[*object]()
+	db.expires = NewDict[time.Time]()
+	db.used = 0
+	db.pool = nil
+	db.expireCursor = 0
+	if db.slots != nil {
+		db.slots = make([]map[string]struct{}, len(db.slots))
+	}
+}
+
+func (db *DB) Exist(key string) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	_, ok := db.lookup(key)
+	return ok, nil
+}
+
+// Type returns the type of the value stored at key.
+func (db *DB) Type(key string) (Type, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	val, ok := db.lookup(key)
+	if !ok {
+		return 0, false
+	}
+	return val.Type(), true
+}
+
+// Keys returns the keys matching the glob-style pattern, in no particular
+// order.
+func (db *DB) Keys(pattern string) []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var matched []string
+	db.dict.Range(func(key string, _ *object) bool {
+		if pattern == "*" || utils.GlobMatch(pattern, key, false) {
+			matched = append(matched, key)
+		}
+		return true
+	})
+	// expired keys are deleted once the dict is no longer iterated
+	keys := []string{}
+	for _, key := range matched {
+		if _, ok := db.lookupObject(key); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Rename moves the value of src, with its time to live, to dst. With nx
+// set nothing happens when dst already exists, Rename then reports false.
+func (db *DB) Rename(src, dst string, nx bool) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	obj, ok := db.lookupObject(src)
+	if !ok {
+		return false, ErrNoSuchKey
+	}
+	if src == dst {
+		return !nx, nil
+	}
+	if _, exists := db.lookup(dst); exists && nx {
+		return false, nil
+	}
+	at, expires := db.expires.Get(src)
+	db.remove(src)
+	db.remove(dst)
+	// the object moves with its access clocks, like in Redis
+	db.setObject(dst, obj)
+	if expires {
+		db.expires.Set(dst, at)
+	}
+	return true, nil
+}
+
+// Copy stores a copy of the value of src, with its time to live, at dst.
+// It reports false when src does not exist, or when dst does and replace
+// is not set.
+func (db *DB) Copy(src, dst string, replace bool) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	val, ok := db.lookup(src)
+	if !ok {
+		return false, nil
+	}
+	if _, exists := db.lookup(dst); exists && !replace {
+		return false, nil
+	}
+	db.remove(dst)
+	db.store(dst, val.clone())
+	db.dirty++
+	if at, ok := db.expires.Get(src); ok {
+		db.expires.Set(dst, at)
+	}
+	return true, nil
+}
+
+// RandomKey returns a key picked at random, false if the keyspace is
+// empty.
+func (db *DB) RandomKey() (string, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for {
+		key, _, ok := db.dict.Random()
+		if !ok {
+			return "", false
+		}
+		if _, ok := db.lookupObject(key); ok {
+			return key, true
+		}
+	}
+}
+
+// Dirty returns the number of changes made to the keyspace so far.
+func (db *DB) Dirty() int64 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.dirty
+}
+
+// Len returns the number of keys, keys whose time to live is over are
+// counted until they are deleted.
+func (db *DB) Len() int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.dict.Len()
+}
+
+// Scan returns the keys found from cursor on, with the cursor the scan
+// continues from, 0 once it is complete. About count keys are visited per
+// call, those not matching the glob pattern match or not of type typ are
+// then left out, so fewer or none may be returned before the end. An empty
+// match or typ matches everything.
+func (db *DB) Scan(cursor uint64, count int, match, typ string) (uint64, []string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var visited []string
+	cursor = scanDict(db.dict, cursor, count, func(key string, _ *object) {
+		visited = append(visited, key)
+	})
+	keys := []string{}
+	for _, key := range visited {
+		if match != "" && !utils.GlobMatch(match, key, false) {
+			continue
+		}
+		obj, ok := db.lookupObject(key)
+		if !ok || (typ != "" && obj.val.Type().String() != typ) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return cursor, keys
+}
+
+// scanDict scans buckets until count entries are visited, giving up after
+// ten times as many empty buckets.
+func scanDict[V any](d *Dict[V], cursor uint64, count int, fn func(key string, val V)) uint64 {
+	visited := 0
+	for maxIter := count * 10; maxIter > 0; maxIter-- {
+		cursor = d.Scan(cursor, func(key string, val V) {
+			visited++
+			fn(key, val)
+		})
+		if cursor == 0 || visited >= count {
+			break
+		}
+	}
+	return cursor
+}
+
+// ExpireCond restricts when Expire sets a time to live, like the NX, XX,
+// GT and LT options of EXPIRE.
+type ExpireCond int
+
+const (
+	ExpireAlways ExpireCond = iota
+	// ExpireNX only sets a time to live on keys without one.
+	ExpireNX
+	// ExpireXX only sets a time to live on keys that have one.
+	ExpireXX
+	// ExpireGT only extends the time to live, a key without one counts as
+	// never expiring.
+	ExpireGT
+	// ExpireLT only shortens the time to live, a key without one counts as
+	// never expiring.
+	ExpireLT
+)
+
+// Expire makes key expire at at and reports whether it did, which it does
+// not when key does not exist or cond is not met. A time already over
+// deletes the key.
+func (db *DB) Expire(key string, at time.Time, cond ExpireCond) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.lookup(key); !ok {
+		return false, nil
+	}
+	cur, volatile := db.expires.Get(key)
+	switch {
+	case cond == ExpireNX && volatile,
+		cond == ExpireXX && !volatile,
+		cond == ExpireGT && (!volatile || !at.After(cur)),
+		cond == ExpireLT && volatile && !at.Before(cur):
+		return false, nil
+	}
+	if !at.After(time.Now()) {
+		db.remove(key)
+		return true, nil
+	}
+	db.expires.Set(key, at)
+	db.dirty++
+	return true, nil
+}
+
+// ExpireTime returns when key expires, the zero time when it never does.
+// exists is false if there is no such key.
+func (db *DB) ExpireTime(key string) (at time.Time, exists bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.lookup(key); !ok {
+		return time.Time{}, false
+	}
+	at, _ = db.expires.Get(key)
+	return at, true
+}
+
+// Persist removes the time to live of key and reports whether it had one.
+func (db *DB) Persist(key string) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.lookup(key); !ok {
+		return false
+	}
+	if !db.expires.Delete(key) {
+		return false
+	}
+	db.dirty++
+	return true
+}
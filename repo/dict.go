@@ -0,0 +1,180 @@
+package repo
+
+import (
+	"hash/maphash"
+	"math/bits"
+	"math/rand"
+)
+
+const dictMinSize = 4
+
+// Dict is a chained hash table whose size is a power of two. Unlike a Go
+// map it can be scanned with a cursor, the way Redis's dictScan does: the
+// cursor walks the buckets in reverse binary order, so an element present
+// for the whole scan is returned at least once even if the table grows or
+// shrinks between two calls. It may be returned more than once.
+type Dict[V any] struct {
+	seed    maphash.Seed
+	buckets []*dictEntry[V]
+	len     int
+}
+
+type dictEntry[V any] struct {
+	key  string
+	val  V
+	next *dictEntry[V]
+}
+
+func NewDict[V any]() *Dict[V] {
+	return &Dict[V]{
+		seed:    maphash.MakeSeed(),
+		buckets: make([]*dictEntry[V], dictMinSize),
+	}
+}
+
+func (d *Dict[V]) bucket(key string) int {
+	return int(maphash.String(d.seed, key) & uint64(len(d.buckets)-1))
+}
+
+func (d *Dict[V]) Len() int {
+	return d.len
+}
+
+func (d *Dict[V]) Get(key string) (V, bool) {
+	for e := d.buckets[d.bucket(key)]; e != nil; e = e.next {
+		if e.key == key {
+			return e.val, true
+		}
+	}
+	var zero V
+	return zero, false
+}
+
+// Set stores val at key and reports whether key was added rather than
+// updated.
+func (d *Dict[V]) Set(key string, val V) bool {
+	i := d.bucket(key)
+	for e := d.buckets[i]; e != nil; e = e.next {
+		if e.key == key {
+			e.val = val
+			return false
+		}
+	}
+	d.buckets[i] = &dictEntry[V]{key: key, val: val, next: d.buckets[i]}
+	d.len++
+	if d.len > len(d.buckets) {
+		d.resize(len(d.buckets) * 2)
+	}
+	return true
+}
+
+// Delete removes key and reports whether it was there.
+func (d *Dict[V]) Delete(key string) bool {
+	i := d.bucket(key)
+	for p := &d.buckets[i]; *p != nil; p = &(*p).next {
+		if (*p).key == key {
+			*p = (*p).next
+			d.len--
+			if len(d.buckets) > dictMinSize && d.len < len(d.buckets)/8 {
+				d.resize(len(d.buckets) / 2)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// resize rehashes every entry into size buckets at once.
+func (d *Dict[V]) resize(size int) {
+	old := d.buckets
+	d.buckets = make([]*dictEntry[V], size)
+	for _, e := range old {
+		for e != nil {
+			next := e.next
+			i := d.bucket(e.key)
+			e.next = d.buckets[i]
+			d.buckets[i] = e
+			e = next
+		}
+	}
+}
+
+// Range calls fn for every entry until it returns false. fn must not
+// modify the dict.
+func (d *Dict[V]) Range(fn func(key string, val V) bool) {
+	for _, e := range d.buckets {
+		for ; e != nil; e = e.next {
+			if !fn(e.key, e.val) {
+				return
+			}
+		}
+	}
+}
+
+// Scan calls fn for the entries of the bucket cursor designates and
+// returns the cursor of the next bucket, 0 once every bucket is visited.
+// A scan starts with cursor 0. fn must not modify the dict.
+func (d *Dict[V]) Scan(cursor uint64, fn func(key string, val V)) uint64 {
+	if d.len == 0 {
+		return 0
+	}
+	mask := uint64(len(d.buckets) - 1)
+	for e := d.buckets[cursor&mask]; e != nil; e = e.next {
+		fn(e.key, e.val)
+	}
+	// increment the bits under the mask starting from the highest one, so
+	// the buckets a smaller or larger table maps them to are visited too
+	cursor |= ^mask
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	return bits.Reverse64(cursor)
+}
+
+// Random returns an entry picked at random, false if the dict is empty.
+func (d *Dict[V]) Random() (string, V, bool) {
+	if d.len == 0 {
+		var zero V
+		return "", zero, false
+	}
+	var chain *dictEntry[V]
+	for chain == nil {
+		chain = d.buckets[rand.Intn(len(d.buckets))]
+	}
+	n := 0
+	for e := chain; e != nil; e = e.next {
+		n++
+	}
+	e := chain
+	for i := rand.Intn(n); i > 0; i-- {
+		e = e.next
+	}
+	return e.key, e.val, true
+}
+
+// Sample returns up to n distinct keys, picked from consecutive buckets
+// starting at a random one like Redis's dictGetSomeKeys. It is cheaper than
+// n calls to Random, the keys are less independent though.
+func (d *Dict[V]) Sample(n int) []string {
+	n = min(n, d.len)
+	keys := make([]string, 0, n)
+	mask := len(d.buckets) - 1
+	i := rand.Intn(len(d.buckets))
+	empty := 0
+	for steps := n * 10; len(keys) < n && steps > 0; steps-- {
+		e := d.buckets[i]
+		i = (i + 1) & mask
+		if e == nil {
+			// a run of empty buckets, try elsewhere
+			if empty++; empty >= 5 && empty > n {
+				i = rand.Intn(len(d.buckets))
+				empty = 0
+			}
+			continue
+		}
+		empty = 0
+		for ; e != nil && len(keys) < n; e = e.next {
+			keys = append(keys, e.key)
+		}
+	}
+	return keys
+}
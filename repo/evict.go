@@ -0,0 +1,273 @@
+package repo
+
+import (
+	"go-redis/pkg/utils"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// The sizes below estimate what the structures take, they need not be
+// exact: maxmemory only has to bound the memory used.
+const (
+	// keyOverhead is the dict entry, the object and the key header.
+	keyOverhead = 80
+	// expireOverhead is an entry of the expires dict.
+	expireOverhead   = 48
+	stringOverhead   = 24
+	listOverhead     = 48
+	listNodeOverhead = 48 + MAXSIZE*16
+	listElemOverhead = 16
+	zsetOverhead     = 96
+	// zsetMemberOverhead is the skiplist node, allocated with every level,
+	// and the dict entry.
+	zsetMemberOverhead = 80 + maxHeight*16 + 48
+)
+
+const (
+	// lfuInitVal is the counter of a new key, so it is not evicted before
+	// it has a chance to be used again.
+	lfuInitVal = 5
+	// evictionPoolSize is how many candidates are kept between evictions.
+	evictionPoolSize = 16
+)
+
+var ErrOOM = &utils.CodedError{Code: "OOM", Msg: "command not allowed when used memory > 'maxmemory'."}
+
+// object is what the keyspace stores at a key: the value with its size,
+// as accounted in used, and the clocks eviction decides on.
+type object struct {
+	val  Value
+	size int64
+	// gen is the snapshot generation val was made in, the snapshots of
+	// later generations hold it until it is cloned.
+	gen uint64
+	// atime is when the key was last used, in Unix milliseconds.
+	atime int64
+	// ldt is when the LFU counter was last decremented, in minutes, and
+	// counter the logarithmic access frequency, as in Redis.
+	ldt     uint16
+	counter uint8
+}
+
+func newObject(val Value) *object {
+	now := time.Now()
+	return &object{
+		val:     val,
+		atime:   now.UnixMilli(),
+		ldt:     lfuMinutes(now),
+		counter: lfuInitVal,
+	}
+}
+
+// MemoryConfig holds the maxmemory settings.
+type MemoryConfig struct {
+	// MaxMemory is the limit in bytes, 0 disables it.
+	MaxMemory int64
+	// Policy is a maxmemory-policy: noeviction, or allkeys- or volatile-
+	// followed by lru, lfu or random, or volatile-ttl.
+	Policy string
+	// Samples is how many keys are sampled to find one to evict.
+	Samples int
+	// LFULogFactor slows down the growth of the LFU counter, LFUDecayTime
+	// is how long it takes to decrement it.
+	LFULogFactor int
+	LFUDecayTime time.Duration
+}
+
+// MemoryStats reports the memory used by the keyspace.
+type MemoryStats struct {
+	Used        int64
+	Peak        int64
+	EvictedKeys int64
+}
+
+func (db *DB) SetMemoryConfig(conf MemoryConfig) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if conf.Policy != db.memConf.Policy {
+		db.pool = nil
+	}
+	db.memConf = conf
+}
+
+func (db *DB) MemoryStats() MemoryStats {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return MemoryStats{Used: db.usedMemory(), Peak: db.peak, EvictedKeys: db.evicted}
+}
+
+// usedMemory is the estimate of the keys and values with the time to live
+// entries. The caller holds mu.
+func (db *DB) usedMemory() int64 {
+	return db.used + int64(db.expires.Len())*expireOverhead
+}
+
+// touch records an access to obj for the LRU and LFU policies. The caller
+// holds mu.
+func (db *DB) touch(obj *object, now time.Time) {
+	obj.atime = now.UnixMilli()
+	obj.counter = lfuLogIncr(db.lfuDecr(obj, now), db.memConf.LFULogFactor)
+	obj.ldt = lfuMinutes(now)
+}
+
+// lfuMinutes is the LFU decrement clock, the low 16 bits of the time in
+// minutes.
+func lfuMinutes(now time.Time) uint16 {
+	return uint16(now.Unix() / 60)
+}
+
+// lfuDecr returns the counter of obj decremented once per decay period
+// elapsed since its last decrement.
+func (db *DB) lfuDecr(obj *object, now time.Time) uint8 {
+	if db.memConf.LFUDecayTime <= 0 {
+		return obj.counter
+	}
+	// the clock wraps around, an older ldt is taken as one lap behind
+	elapsed := time.Duration(lfuMinutes(now)-obj.ldt) * time.Minute
+	periods := elapsed / db.memConf.LFUDecayTime
+	if periods >= time.Duration(obj.counter) {
+		return 0
+	}
+	return obj.counter - uint8(periods)
+}
+
+// lfuLogIncr increments counter with a probability falling as it grows,
+// so it counts up to millions of accesses in 8 bits.
+func lfuLogIncr(counter uint8, logFactor int) uint8 {
+	if counter == math.MaxUint8 {
+		return counter
+	}
+	base := max(int(counter)-lfuInitVal, 0)
+	if rand.Float64() < 1/float64(base*logFactor+1) {
+		counter++
+	}
+	return counter
+}
+
+// FreeMemory evicts keys as the maxmemory policy allows until the memory
+// used is within the limit and returns them. It returns ErrOOM when it
+// can't get there.
+func (db *DB) FreeMemory() (evicted []string, err error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	conf := db.memConf
+	if conf.MaxMemory == 0 || db.usedMemory() <= conf.MaxMemory {
+		return nil, nil
+	}
+	if conf.Policy == "noeviction" {
+		return nil, ErrOOM
+	}
+	volatile := strings.HasPrefix(conf.Policy, "volatile-")
+	for db.usedMemory() > conf.MaxMemory {
+		var key string
+		var ok bool
+		if strings.HasSuffix(conf.Policy, "-random") {
+			key, ok = db.randomCandidate(volatile)
+		} else {
+			key, ok = db.bestCandidate(volatile)
+		}
+		if !ok {
+			return evicted, ErrOOM
+		}
+		db.remove(key)
+		db.evicted++
+		evicted = append(evicted, key)
+	}
+	return evicted, nil
+}
+
+func (db *DB) randomCandidate(volatile bool) (string, bool) {
+	if volatile {
+		key, _, ok := db.expires.Random()
+		return key, ok
+	}
+	key, _, ok := db.dict.Random()
+	return key, ok
+}
+
+// evictionCandidate is a key of the eviction pool, the higher idle the
+// better it is to evict.
+type evictionCandidate struct {
+	key  string
+	idle uint64
+}
+
+// bestCandidate returns the key to evict under the lru, lfu and ttl
+// policies, approximated like Redis does: a few keys are sampled and the
+// best of them kept in a pool across evictions, so every eviction picks
+// among more keys than it samples.
+func (db *DB) bestCandidate(volatile bool) (string, bool) {
+	for {
+		if db.dict.Len() == 0 || (volatile && db.expires.Len() == 0) {
+			return "", false
+		}
+		db.populateEvictionPool(volatile)
+		for len(db.pool) > 0 {
+			key := db.pool[len(db.pool)-1].key
+			db.pool = db.pool[:len(db.pool)-1]
+			// the key may have been deleted since it was sampled
+			if _, ok := db.dict.Get(key); !ok {
+				continue
+			}
+			if _, ok := db.expires.Get(key); volatile && !ok {
+				continue
+			}
+			return key, true
+		}
+	}
+}
+
+func (db *DB) populateEvictionPool(volatile bool) {
+	now := time.Now()
+	_, kind, _ := strings.Cut(db.memConf.Policy, "-")
+	var keys []string
+	if volatile {
+		keys = db.expires.Sample(db.memConf.Samples)
+	} else {
+		keys = db.dict.Sample(db.memConf.Samples)
+	}
+	for _, key := range keys {
+		obj, ok := db.dict.Get(key)
+		if !ok {
+			continue
+		}
+		var idle uint64
+		switch kind {
+		case "lru":
+			idle = uint64(max(now.UnixMilli()-obj.atime, 0))
+		case "lfu":
+			idle = math.MaxUint8 - uint64(db.lfuDecr(obj, now))
+		case "ttl":
+			at, _ := db.expires.Get(key)
+			idle = math.MaxUint64 - uint64(at.UnixMilli())
+		}
+		db.poolInsert(evictionCandidate{key: key, idle: idle})
+	}
+}
+
+// poolInsert adds c to the pool, sorted by ascending idle, dropping the
+// worst candidate when the pool is full.
+func (db *DB) poolInsert(c evictionCandidate) {
+	for i, old := range db.pool {
+		if old.key == c.key {
+			db.pool = append(db.pool[:i], db.pool[i+1:]...)
+			break
+		}
+	}
+	i := 0
+	for i < len(db.pool) && db.pool[i].idle < c.idle {
+		i++
+	}
+	if len(db.pool) == evictionPoolSize {
+		if i == 0 {
+			return
+		}
+		db.pool = db.pool[1:]
+		i--
+	}
+	db.pool = append(db.pool, evictionCandidate{})
+	copy(db.pool[i+1:], db.pool[i:])
+	db.pool[i] = c
+}
@@ -0,0 +1,119 @@
+package repo
+
+import "time"
+
+const (
+	// activeExpireKeysPerLoop is how many keys with a time to live a loop
+	// of the active expire cycle samples at the lowest effort.
+	activeExpireKeysPerLoop = 20
+	// activeExpireAcceptableStale is the percentage of expired keys in
+	// a sample under which the cycle stops, at the lowest effort.
+	activeExpireAcceptableStale = 10
+)
+
+// ExpireStats counts the work done expiring keys.
+type ExpireStats struct {
+	// ExpiredKeys counts the keys deleted because their time to live was
+	// over, lazily or by the active expire cycle.
+	ExpiredKeys int64
+	// StalePerc estimates the percentage of the keys with a time to live
+	// that are expired but not deleted yet.
+	StalePerc float64
+	// TimeCapReached counts the active expire cycles stopped because
+	// they ran out of time.
+	TimeCapReached int64
+	// AvgTTL estimates the remaining time to live of the keys that have
+	// one.
+	AvgTTL time.Duration
+}
+
+// ActiveExpireCycle deletes expired keys nobody reads, the way Redis's
+// activeExpireCycle does. It samples the keys with a time to live,
+// resuming where the previous cycle stopped, and samples again as long as
+// more than an acceptable share of them were expired, until deadline.
+// effort, from 1 to 10, makes the samples bigger and the acceptable share
+// smaller.
+func (db *DB) ActiveExpireCycle(effort int, deadline time.Time) {
+	keysPerLoop := activeExpireKeysPerLoop + activeExpireKeysPerLoop/4*(effort-1)
+	acceptableStale := activeExpireAcceptableStale - (effort - 1)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var sampled, expired int
+	for {
+		if db.expires.Len() == 0 {
+			db.stats.StalePerc = 0
+			db.stats.AvgTTL = 0
+			return
+		}
+		now := time.Now()
+		if now.After(deadline) {
+			db.stats.TimeCapReached++
+			break
+		}
+		var loopSampled int
+		var ttlSum time.Duration
+		var due []string
+		// a sparse table has many empty buckets, the number visited is
+		// bounded as well so a loop stays short
+		for buckets := 0; loopSampled < keysPerLoop && buckets < keysPerLoop*20; buckets++ {
+			db.expireCursor = db.expires.Scan(db.expireCursor, func(key string, at time.Time) {
+				loopSampled++
+				if ttl := at.Sub(now); ttl > 0 {
+					ttlSum += ttl
+				} else {
+					due = append(due, key)
+				}
+			})
+			if db.expireCursor == 0 {
+				break
+			}
+		}
+		for _, key := range due {
+			db.expire(key)
+		}
+		if alive := loopSampled - len(due); alive > 0 {
+			// a running average, so a single sample weighs little
+			avg := ttlSum / time.Duration(alive)
+			if db.stats.AvgTTL == 0 {
+				db.stats.AvgTTL = avg
+			} else {
+				db.stats.AvgTTL = db.stats.AvgTTL/50*49 + avg/50
+			}
+		}
+		sampled += loopSampled
+		expired += len(due)
+		if loopSampled == 0 || len(due)*100/loopSampled <= acceptableStale {
+			break
+		}
+	}
+	if sampled > 0 {
+		current := float64(expired) / float64(sampled)
+		db.stats.StalePerc = current*0.05 + db.stats.StalePerc*0.95
+	}
+}
+
+// ExpireStats returns the expiration counters.
+func (db *DB) ExpireStats() ExpireStats {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.stats
+}
+
+// ResetStats zeroes the expiration and eviction counters and the peak
+// memory, the estimates are kept.
+func (db *DB) ResetStats() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.stats.ExpiredKeys = 0
+	db.stats.TimeCapReached = 0
+	db.evicted = 0
+	db.peak = db.usedMemory()
+}
+
+// VolatileLen returns how many keys have a time to live, expired ones not
+// deleted yet included.
+func (db *DB) VolatileLen() int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.expires.Len()
+}
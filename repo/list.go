@@ -4,41 +4,26 @@ import (
 	"fmt"
 	"go-redis/pkg/utils"
 	"strconv"
-	"sync"
 )
 
 const (
 	MAXSIZE = 2
 )
 
-type List struct {
-	KvList map[string]*QuickList
-	mu     sync.Mutex
-}
-
 type Node struct {
 	data []string
 	next *Node
 	prev *Node
 }
+
+// QuickList is a list value, a linked list of small arrays.
 type QuickList struct {
 	head    *Node
 	tail    *Node
 	maxSize int // max size of the Node : how many ziplist can be stored in a Node
 	length  int // total number
-	mu      sync.Mutex
-}
-
-var KvList *List
-
-func NewKvList() *List {
-	return &List{
-		KvList: make(map[string]*QuickList),
-	}
-}
-
-func InitKVList() {
-	KvList = NewKvList()
+	// bytes estimates the memory taken by the nodes and elements
+	bytes int64
 }
 
 func NewQuickList() *QuickList {
@@ -49,70 +34,129 @@ func NewQuickList() *QuickList {
 
 func NewNode() *Node {
 	return &Node{
-		//  len set 0 , slice will not be fill by ""
-		//  it will affect append later
-		data: make([]string, MAXSIZE),
+		data: make([]string, 0, MAXSIZE),
+	}
+}
+
+func (ql *QuickList) Type() Type { return TypeList }
+
+func (ql *QuickList) clone() Value {
+	cp := &QuickList{maxSize: ql.maxSize, length: ql.length, bytes: ql.bytes}
+	for node := ql.head; node != nil; node = node.next {
+		n := &Node{data: append(make([]string, 0, ql.maxSize), node.data...), prev: cp.tail}
+		if cp.tail == nil {
+			cp.head = n
+		} else {
+			cp.tail.next = n
+		}
+		cp.tail = n
 	}
+	return cp
 }
 
-func (l *List) GetQuickList(key string) *QuickList {
-	if _, ok := l.KvList[key]; !ok {
-		l.KvList[key] = NewQuickList()
+func (ql *QuickList) memUsage() int64 { return listOverhead + ql.bytes }
+
+// list returns the list stored at key, nil if there is none unless create
+// is set. The caller holds mu.
+func (db *DB) list(key string, create bool) (*QuickList, error) {
+	val, ok := db.lookup(key)
+	if !ok {
+		if !create {
+			return nil, nil
+		}
+		ql := NewQuickList()
+		db.store(key, ql)
+		return ql, nil
 	}
-	return l.KvList[key]
+	ql, ok := val.(*QuickList)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return ql, nil
 }
 
-func (l *List) Lpush(key, value string) error {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	ql := l.GetQuickList(key)
+// Lpush inserts value at the head of the list and returns its new length.
+func (db *DB) Lpush(key, value string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.unshare(key)
+	ql, err := db.list(key, true)
+	if err != nil {
+		return 0, err
+	}
 	if ql.head == nil {
 		ql.head = NewNode()
 		ql.tail = ql.head
+		ql.bytes += listNodeOverhead
 	}
-	if ql.head.data[len(ql.head.data)-1] != "" {
+	if len(ql.head.data) >= ql.maxSize {
+		ql.bytes += listNodeOverhead
 		newNode := NewNode()
 		newNode.next = ql.head
 		ql.head.prev = newNode
 		ql.head = newNode
 	}
 	//  put new data to node head
-	newSlice := make([]string, MAXSIZE)
-	newSlice[0] = value
-	copy(newSlice[1:], ql.head.data)
-	ql.head.data = newSlice
+	ql.head.data = append(ql.head.data, "")
+	copy(ql.head.data[1:], ql.head.data)
+	ql.head.data[0] = value
 	ql.length++
-	return nil
+	ql.bytes += listElemOverhead + int64(len(value))
+	db.resized(key)
+	return ql.length, nil
 }
-func (l *List) Rpush(key, value string) error {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	ql := l.GetQuickList(key)
-	if ql.head == nil {
-		ql.head = NewNode()
-		ql.tail = ql.head
+
+// Rpush appends value at the tail of the list and returns its new length.
+func (db *DB) Rpush(key, value string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.unshare(key)
+	ql, err := db.list(key, true)
+	if err != nil {
+		return 0, err
 	}
-	if len(ql.head.data) >= ql.maxSize {
+	ql.push(value)
+	db.resized(key)
+	return ql.length, nil
+}
+
+// push appends value at the tail.
+func (ql *QuickList) push(value string) {
+	if ql.tail == nil {
+		ql.tail = NewNode()
+		ql.head = ql.tail
+		ql.bytes += listNodeOverhead
+	}
+	if len(ql.tail.data) >= ql.maxSize {
+		ql.bytes += listNodeOverhead
 		newNode := NewNode()
-		newNode.next = ql.head
-		ql.head.prev = newNode
-		ql.head = newNode
+		newNode.prev = ql.tail
+		ql.tail.next = newNode
+		ql.tail = newNode
 	}
-	//  put new data to node head
-	ql.head.data = append(ql.head.data, value)
+	//  put new data to node tail
+	ql.tail.data = append(ql.tail.data, value)
 	ql.length++
-	return nil
+	ql.bytes += listElemOverhead + int64(len(value))
 }
 
-func (l *List) Lrange(key, start, end string) ([]string, error) {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	ql := l.GetQuickList(key)
+// Lrange returns the elements between start and end inclusive, negative
+// indexes count from the tail like in Redis.
+func (db *DB) Lrange(key, start, end string) ([]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	ql, err := db.list(key, false)
+	if err != nil {
+		return nil, err
+	}
+	if ql == nil {
+		return []string{}, nil
+	}
 	err, s, e := isRangeValid(ql, start, end)
 	if err != nil {
 		return nil, err
 	}
-	if e == 0 && s == 0 {
+	if s > e {
 		return []string{}, nil
 	}
 	res := ql.iterQuickList(s, e)
@@ -120,14 +164,8 @@ func (l *List) Lrange(key, start, end string) ([]string, error) {
 }
 
 func (ql *QuickList) iterQuickList(start, end int) []string {
-	var res []string
+	res := make([]string, 0, end-start+1)
 	index := 0
-	if start == -1 {
-		for node := ql.head; node != nil; node = node.next {
-			res = append(res, node.data...)
-		}
-		return res
-	}
 	for node := ql.head; node != nil; node = node.next {
 		dataLen := len(node.data)
 		nodeEnd := index + dataLen - 1
@@ -142,38 +180,30 @@ func (ql *QuickList) iterQuickList(start, end int) []string {
 		res = append(res, node.data[relativeStart:relativeEnd]...)
 		index += dataLen
 		//  find end , break the loop
-		if end < nodeEnd {
+		if end <= nodeEnd {
 			break
 		}
-		// low performance
-		// for _, v := range node.data {
-		// 	if index >= start && index <= end {
-		// 		res = append(res, v)
-		// 	}
-		// 	index++
-		// 	if index > end {
-		// 		return res
-		// 	}
-		// }
-
 	}
 	return res
 }
 
+// isRangeValid converts start and end to absolute indexes clamped to the
+// list, an empty range is returned as start > end.
 func isRangeValid(ql *QuickList, start, end string) (error, int, int) {
 	if !utils.IsNumeric(start) || !utils.IsNumeric(end) {
-		return fmt.Errorf("range must be numeric"), 0, 0
+		return fmt.Errorf("value is not an integer or out of range"), 0, 0
 	}
 	s, _ := strconv.Atoi(start)
 	e, _ := strconv.Atoi(end)
-	if s < -1 || e < -1 {
-		return fmt.Errorf("range must be bigger than 0"), 0, 0
+	if s < 0 {
+		s += ql.length
 	}
-	if s > e {
-		return nil, 0, 0 // 返回空范围
+	if e < 0 {
+		e += ql.length
 	}
-	if s >= ql.length {
-		return nil, 0, 0 // `start` 超出范围，返回空
+	s = max(s, 0)
+	if s > e || s >= ql.length {
+		return nil, 1, 0 // 返回空范围
 	}
 	if e >= ql.length {
 		e = ql.length - 1 // 限制 `end` 到最大索引
@@ -0,0 +1,180 @@
+package repo
+
+import (
+	"fmt"
+	"go-redis/pkg/rdb"
+	"io"
+	"slices"
+	"time"
+)
+
+// The RDB format is the one of Redis, so snapshots can be read by Redis
+// and its tools. Version 9 is written, it is understood by Redis 5 and
+// later.
+const rdbVersion = 9
+
+// Snapshot is the keyspace at one point in time. It is written while the
+// DB keeps serving commands, the values it shares with the DB are cloned
+// by the first command modifying them until it is released.
+type Snapshot struct {
+	db      *DB
+	gen     uint64
+	entries []snapshotEntry
+	expires int
+}
+
+type snapshotEntry struct {
+	key    string
+	val    Value
+	expire time.Time
+}
+
+// Snapshot takes a snapshot of the keyspace, expired keys left out. Only
+// the keys are copied, in time linear to their number, the values are
+// shared. It is released once written.
+func (db *DB) Snapshot() *Snapshot {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := time.Now()
+	db.snapGen++
+	db.liveSnaps = append(db.liveSnaps, db.snapGen)
+	snap := &Snapshot{db: db, gen: db.snapGen, entries: make([]snapshotEntry, 0, db.dict.Len())}
+	db.dict.Range(func(key string, obj *object) bool {
+		at, volatile := db.expires.Get(key)
+		if volatile && !now.Before(at) {
+			return true
+		}
+		if volatile {
+			snap.expires++
+		}
+		snap.entries = append(snap.entries, snapshotEntry{key: key, val: obj.val, expire: at})
+		return true
+	})
+	return snap
+}
+
+// Release tells the DB the snapshot is no longer read, its values are not
+// cloned anymore before they are modified.
+func (s *Snapshot) Release() {
+	db := s.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.liveSnaps = slices.DeleteFunc(db.liveSnaps, func(gen uint64) bool { return gen == s.gen })
+}
+
+// Len returns the number of keys in the snapshot.
+func (s *Snapshot) Len() int {
+	return len(s.entries)
+}
+
+// WriteRDB encodes the snapshot in the RDB format. aux lists name and value
+// pairs written as auxiliary fields, like redis-ver.
+func (s *Snapshot) WriteRDB(w io.Writer, aux ...string) error {
+	rw, err := rdb.NewWriter(w, rdbVersion)
+	if err != nil {
+		return err
+	}
+	for i := 0; i+1 < len(aux); i += 2 {
+		rw.Aux(aux[i], aux[i+1])
+	}
+	if len(s.entries) > 0 {
+		rw.SelectDB(0, len(s.entries), s.expires)
+	}
+	for _, entry := range s.entries {
+		if err := rw.Write(&rdb.Entry{Key: entry.key, Value: exportValue(entry.val), Expire: entry.expire}); err != nil {
+			return err
+		}
+	}
+	return rw.Close()
+}
+
+// exportValue converts val to its RDB form.
+func exportValue(val Value) rdb.Value {
+	switch v := val.(type) {
+	case *String:
+		return rdb.String(v.val)
+	case *QuickList:
+		elems := make(rdb.List, 0, v.length)
+		for node := v.head; node != nil; node = node.next {
+			elems = append(elems, node.data...)
+		}
+		return elems
+	case *Zset:
+		members := make(rdb.Zset, 0, v.skiplist.length)
+		for node := v.skiplist.head.forward[0]; node != nil; node = node.forward[0] {
+			members = append(members, rdb.ZsetMember{Member: node.member, Score: node.score})
+		}
+		return members
+	}
+	panic(fmt.Sprintf("unknown value type %T", val))
+}
+
+// importValue converts an RDB value, sets and hashes have no equivalent
+// here.
+func importValue(val rdb.Value) (Value, error) {
+	switch v := val.(type) {
+	case rdb.String:
+		return &String{val: []byte(v)}, nil
+	case rdb.List:
+		ql := NewQuickList()
+		for _, elem := range v {
+			ql.push(elem)
+		}
+		return ql, nil
+	case rdb.Zset:
+		z := NewZset()
+		for _, m := range v {
+			z.add(m.Member, m.Score)
+		}
+		return z, nil
+	}
+	return nil, fmt.Errorf("%s values are not supported", val.Type())
+}
+
+// LoadRDB adds the keys of an RDB file to the DB, keys already expired are
+// skipped. It returns the number of keys loaded.
+func (db *DB) LoadRDB(r io.Reader) (int, error) {
+	rr, err := rdb.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := time.Now()
+	loaded := 0
+	for {
+		e, err := rr.Next()
+		if len(rr.Functions) > 0 {
+			return loaded, fmt.Errorf("functions are not supported")
+		}
+		if err == io.EOF {
+			return loaded, nil
+		}
+		if err != nil {
+			return loaded, err
+		}
+		if e.DB != 0 {
+			return loaded, fmt.Errorf("only database 0 is supported, the file has database %d", e.DB)
+		}
+		val, err := importValue(e.Value)
+		if err != nil {
+			return loaded, fmt.Errorf("key %q: %w", e.Key, err)
+		}
+		if !e.Expire.IsZero() && !now.Before(e.Expire) {
+			continue
+		}
+		obj := newObject(val)
+		obj.gen = db.snapGen
+		if e.Idle != nil {
+			obj.atime = now.Add(-*e.Idle).UnixMilli()
+		}
+		if e.Freq != nil {
+			obj.counter = *e.Freq
+		}
+		db.setObject(e.Key, obj)
+		if !e.Expire.IsZero() {
+			db.expires.Set(e.Key, e.Expire)
+		}
+		loaded++
+	}
+}
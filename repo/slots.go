@@ -0,0 +1,85 @@
+package repo
+
+import "go-redis/pkg/utils"
+
+// EnableSlotIndex makes the DB track which keys are in each hash slot, for
+// cluster mode. It is called before any key is stored.
+func (db *DB) EnableSlotIndex() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.slots = make([]map[string]struct{}, utils.ClusterSlots)
+	db.dict.Range(func(key string, _ *object) bool {
+		db.indexKey(key)
+		return true
+	})
+}
+
+// indexKey and unindexKey keep the slot index up to date, if enabled. The
+// caller holds mu.
+func (db *DB) indexKey(key string) {
+	if db.slots == nil {
+		return
+	}
+	slot := utils.KeySlot(key)
+	if db.slots[slot] == nil {
+		db.slots[slot] = make(map[string]struct{})
+	}
+	db.slots[slot][key] = struct{}{}
+}
+
+func (db *DB) unindexKey(key string) {
+	if db.slots == nil {
+		return
+	}
+	slot := utils.KeySlot(key)
+	delete(db.slots[slot], key)
+	if len(db.slots[slot]) == 0 {
+		db.slots[slot] = nil
+	}
+}
+
+// CountKeysInSlot returns the number of keys in slot, keys whose time to
+// live is over are counted until they are deleted.
+func (db *DB) CountKeysInSlot(slot int) int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.slots == nil {
+		return 0
+	}
+	return len(db.slots[slot])
+}
+
+// GetKeysInSlot returns up to count keys of slot.
+func (db *DB) GetKeysInSlot(slot, count int) []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	keys := []string{}
+	if db.slots == nil {
+		return keys
+	}
+	for key := range db.slots[slot] {
+		if len(keys) == count {
+			break
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// DelKeysInSlot deletes every key of slot, like a node does with the keys
+// of a slot given to another node, and returns the keys deleted.
+func (db *DB) DelKeysInSlot(slot int) []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.slots == nil {
+		return nil
+	}
+	var keys []string
+	for key := range db.slots[slot] {
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		db.remove(key)
+	}
+	return keys
+}
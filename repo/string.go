@@ -1,114 +1,139 @@
 package repo
 
 import (
-	"fmt"
+	"errors"
 	"go-redis/pkg/utils"
+	"math"
 	"strconv"
-	"sync"
 	"time"
 )
 
-var KvString KV
+var (
+	ErrNotExist   = errors.New("data not exist")
+	ErrNotInteger = errors.New("value is not an integer or out of range")
+	ErrOverflow   = errors.New("increment or decrement would overflow")
+)
 
-type KV struct {
-	kv       map[string][]byte
-	kvExpire map[string]time.Time
-	mu       sync.Mutex
+// String is a string value.
+type String struct {
+	val []byte
 }
 
-func NewKV() KV {
-	return KV{
-		kv:       make(map[string][]byte),
-		kvExpire: make(map[string]time.Time),
-	}
+func (s *String) Type() Type { return TypeString }
+
+func (s *String) clone() Value {
+	return &String{val: append([]byte(nil), s.val...)}
 }
 
-func InitKV() {
-	KvString = NewKV()
+func (s *String) memUsage() int64 { return stringOverhead + int64(len(s.val)) }
+
+// str returns the string stored at key, ErrNotExist if there is none.
+// The caller holds mu.
+func (db *DB) str(key string) (*String, error) {
+	val, ok := db.lookup(key)
+	if !ok {
+		return nil, ErrNotExist
+	}
+	s, ok := val.(*String)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return s, nil
 }
 
-func (kv KV) Set(key, val string, ex ...string) error {
-	kv.mu.Lock()
-	defer kv.mu.Unlock()
-	kv.kv[key] = []byte(val)
+// Set stores val at key, whatever the key held before. ex is an optional
+// time to live in milliseconds, a previous time to live is discarded.
+func (db *DB) Set(key, val string, ex ...string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.store(key, &String{val: []byte(val)})
+	db.dirty++
+	db.expires.Delete(key)
 	// set time limit
-	now := time.Now()
-	num := 0
 	if len(ex) != 0 {
 		if ex[0] != "" {
-			num, _ = strconv.Atoi(ex[0])
-			exp := now.Add(time.Duration(num) * time.Millisecond)
-			kv.kvExpire[key] = exp
+			num, _ := strconv.ParseInt(ex[0], 10, 64)
+			db.expires.Set(key, time.UnixMilli(time.Now().UnixMilli()+num))
 		}
 	}
 	return nil
 }
 
-func (kv KV) Get(key string) ([]byte, error) {
-	kv.mu.Lock()
-	defer kv.mu.Unlock()
-	val, ok := kv.kv[key]
-	if exp, exist := kv.kvExpire[key]; exist && ok {
-		if time.Since(exp) > 0 {
-			delete(kv.kv, key)
-			return nil, fmt.Errorf("data expired already")
-		}
-	}
-	if ok {
-		return val, nil
+func (db *DB) Get(key string) ([]byte, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	s, err := db.str(key)
+	if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("data not exist")
-}
-
-func (kv KV) Delete(key string) error {
-	kv.mu.Lock()
-	defer kv.mu.Unlock()
-	delete(kv.kv, key)
-	delete(kv.kvExpire, key)
-	return nil
+	return s.val, nil
 }
 
-func (kv KV) Exist(key string) (bool, error) {
-	kv.mu.Lock()
-	defer kv.mu.Unlock()
-	_, ok := kv.kv[key]
-	if ok {
-		return true, nil
+// Incr adds amount, 1 by default, to the integer stored at key and returns
+// the new value. A missing key counts as 0.
+func (db *DB) Incr(key string, amount ...string) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	plus := int64(1)
+	if len(amount) != 0 {
+		if amount[0] != "" {
+			n, err := strconv.ParseInt(amount[0], 10, 64)
+			if err != nil {
+				return 0, ErrNotInteger
+			}
+			plus = n
+		}
 	}
-	return false, nil
+	return db.incrBy(key, plus)
 }
 
-func (kv KV) Incr(key string, amount ...string) error {
-	kv.mu.Lock()
-	defer kv.mu.Unlock()
-	plus := 1
+// Decr subtracts amount, 1 by default, from the integer stored at key and
+// returns the new value.
+func (db *DB) Decr(key string, amount ...string) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	plus := int64(-1)
 	if len(amount) != 0 {
 		if amount[0] != "" {
-			plus, _ = strconv.Atoi(amount[0])
+			n, err := strconv.ParseInt(amount[0], 10, 64)
+			if err != nil {
+				return 0, ErrNotInteger
+			}
+			// -math.MinInt64 does not fit in an int64
+			if n == math.MinInt64 {
+				return 0, ErrOverflow
+			}
+			plus = -n
 		}
 	}
-	if val, ok := kv.kv[key]; ok && utils.IsNumeric(string(val)) {
-		now, _ := strconv.Atoi(string(val))
-		v := []byte(fmt.Sprintf("%d", plus+now))
-		kv.kv[key] = v
-	}
-	return nil
+	return db.incrBy(key, plus)
 }
 
-func (kv KV) Decr(key string, amount ...string) error {
-	kv.mu.Lock()
-	defer kv.mu.Unlock()
-	plus := -1
-	if len(amount) != 0 {
-		if amount[0] != "" {
-			plus, _ = strconv.Atoi(amount[0])
-			plus *= -1
+// incrBy keeps the time to live of key, like Redis does.
+func (db *DB) incrBy(key string, plus int64) (int64, error) {
+	var now int64
+	db.unshare(key)
+	s, err := db.str(key)
+	switch err {
+	case nil:
+		if !utils.IsNumeric(string(s.val)) {
+			return 0, ErrNotInteger
 		}
+		n, err := strconv.ParseInt(string(s.val), 10, 64)
+		if err != nil {
+			return 0, ErrNotInteger
+		}
+		now = n
+	case ErrNotExist:
+		s = &String{}
+		db.store(key, s)
+	default:
+		return 0, err
 	}
-	if val, ok := kv.kv[key]; ok && utils.IsNumeric(string(val)) {
-		now, _ := strconv.Atoi(string(val))
-		v := []byte(fmt.Sprintf("%d", plus+now))
-		kv.kv[key] = v
+	if (plus > 0 && now > math.MaxInt64-plus) || (plus < 0 && now < math.MinInt64-plus) {
+		return 0, ErrOverflow
 	}
-	return nil
+	s.val = []byte(strconv.FormatInt(now+plus, 10))
+	db.resized(key)
+	return now + plus, nil
 }
@@ -1,10 +1,10 @@
 package repo
 
 import (
-	"fmt"
+	"errors"
+	"go-redis/pkg/utils"
 	"math"
 	"math/rand"
-	"sync"
 )
 
 const (
@@ -28,6 +28,7 @@ type SkipListNode struct {
 	member  string
 	score   float64
 	forward []*SkipListNode // next floor
+	span    []int           // how many level 0 nodes forward[i] jumps over
 }
 
 type SkipList struct {
@@ -36,33 +37,22 @@ type SkipList struct {
 	length int
 }
 
+// Zset is a sorted set value.
 type Zset struct {
-	dict     map[string]*SkipListNode
+	dict     *Dict[*SkipListNode]
 	skiplist *SkipList
+	// bytes estimates the memory taken by the members
+	bytes int64
 }
 
-type KvZset struct {
-	Zset map[string]*Zset
-	mu   sync.Mutex
-}
-
-var MemoryZset *KvZset
-
-func NewMemoryZset() *KvZset {
-	return &KvZset{
-		Zset: make(map[string]*Zset),
-	}
-}
-
-func InitKvZset() {
-	MemoryZset = NewMemoryZset()
-}
+var ErrMemberNotExist = errors.New("member is not exist")
 
 func NewSkipListNode(member string, score float64) *SkipListNode {
 	return &SkipListNode{
 		member:  member,
 		score:   score,
 		forward: make([]*SkipListNode, maxHeight),
+		span:    make([]int, maxHeight),
 	}
 }
 
@@ -76,137 +66,251 @@ func NewSkipList() *SkipList {
 
 func NewZset() *Zset {
 	return &Zset{
-		dict:     make(map[string]*SkipListNode),
+		dict:     NewDict[*SkipListNode](),
 		skiplist: NewSkipList(),
 	}
 }
 
-func (kz *KvZset) GetZset(key string) *Zset {
-	if _, ok := kz.Zset[key]; !ok {
-		kz.Zset[key] = NewZset()
+func (z *Zset) Type() Type { return TypeZset }
+
+func (z *Zset) clone() Value {
+	cp := NewZset()
+	for node := z.skiplist.head.forward[0]; node != nil; node = node.forward[0] {
+		cp.dict.Set(node.member, cp.skiplist.insert(node.member, node.score))
 	}
-	return kz.Zset[key]
+	cp.bytes = z.bytes
+	return cp
 }
 
-func (kz *KvZset) Insert(key, member string, score float64) error {
-	kz.mu.Lock()
-	defer kz.mu.Unlock()
-	// update represent insert pos
-	update := make([]*SkipListNode, maxHeight)
-	zset := kz.GetZset(key)
+func (z *Zset) memUsage() int64 { return zsetOverhead + z.bytes }
+
+// zset returns the sorted set stored at key, nil if there is none unless
+// create is set. The caller holds mu.
+func (db *DB) zset(key string, create bool) (*Zset, error) {
+	val, ok := db.lookup(key)
+	if !ok {
+		if !create {
+			return nil, nil
+		}
+		zset := NewZset()
+		db.store(key, zset)
+		return zset, nil
+	}
+	zset, ok := val.(*Zset)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return zset, nil
+}
+
+// Zadd sets the score of member, it reports whether member was added
+// rather than updated.
+func (db *DB) Zadd(key, member string, score float64) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.unshare(key)
+	zset, err := db.zset(key, true)
+	if err != nil {
+		return false, err
+	}
+	if node, ok := zset.dict.Get(member); ok {
+		if node.score == score {
+			return false, nil
+		}
+		zset.skiplist.delete(member, node.score)
+		zset.dict.Set(member, zset.skiplist.insert(member, score))
+		db.dirty++
+		return false, nil
+	}
+	zset.add(member, score)
+	db.resized(key)
+	return true, nil
+}
 
-	if zset.skiplist.head == nil {
-		zset.skiplist.head = NewSkipListNode("", math.Inf(-1))
+// add inserts member, which is not in the set yet.
+func (z *Zset) add(member string, score float64) {
+	z.dict.Set(member, z.skiplist.insert(member, score))
+	z.bytes += zsetMemberOverhead + int64(len(member))
+}
+
+func (db *DB) Zscore(key string, member string) (float64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	zset, err := db.zset(key, false)
+	if err != nil {
+		return 0, err
+	}
+	if zset == nil {
+		return 0, ErrMemberNotExist
+	}
+	node, ok := zset.dict.Get(member)
+	if !ok {
+		return 0, ErrMemberNotExist
+	}
+	return node.score, nil
+}
+
+// Zrank returns the 0 based rank of member, ordered by score then member.
+func (db *DB) Zrank(key string, member string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	zset, err := db.zset(key, false)
+	if err != nil {
+		return -1, err
+	}
+	if zset == nil {
+		return -1, ErrMemberNotExist
+	}
+	node, ok := zset.dict.Get(member)
+	if !ok {
+		return -1, ErrMemberNotExist
+	}
+	return zset.skiplist.rank(member, node.score) - 1, nil
+}
+
+// Zrem removes member, the key is deleted with its last member.
+func (db *DB) Zrem(key, member string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.unshare(key)
+	zset, err := db.zset(key, false)
+	if err != nil {
+		return err
+	}
+	if zset == nil {
+		return ErrMemberNotExist
+	}
+	node, ok := zset.dict.Get(member)
+	if !ok {
+		return ErrMemberNotExist
+	}
+	zset.skiplist.delete(member, node.score)
+	zset.dict.Delete(member)
+	zset.bytes -= zsetMemberOverhead + int64(len(member))
+	if zset.dict.Len() == 0 {
+		db.remove(key)
+	} else {
+		db.resized(key)
+	}
+	return nil
+}
+
+// Zscan is Scan for the members of a sorted set, returned with their
+// scores.
+func (db *DB) Zscan(key string, cursor uint64, count int, match string) (uint64, []string, []float64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	zset, err := db.zset(key, false)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	members, scores := []string{}, []float64{}
+	if zset == nil {
+		return 0, members, scores, nil
 	}
-	list := zset.skiplist
+	cursor = scanDict(zset.dict, cursor, count, func(member string, node *SkipListNode) {
+		if match == "" || utils.GlobMatch(match, member, false) {
+			members = append(members, member)
+			scores = append(scores, node.score)
+		}
+	})
+	return cursor, members, scores, nil
+}
+
+// less reports whether (score, member) sorts before node.
+func less(node *SkipListNode, score float64, member string) bool {
+	return node.score < score || (node.score == score && node.member < member)
+}
+
+func (list *SkipList) insert(member string, score float64) *SkipListNode {
+	update := make([]*SkipListNode, maxHeight)
+	rank := make([]int, maxHeight)
 	curr := list.head
-	// find insert spot pre node
-	for i := list.height - 1; i > 0; i-- {
-		for curr.forward[i] != nil {
-			if curr.forward[i].score < score {
-				curr = curr.forward[i]
-			}
+	// find insert spot pre node on every level
+	for i := list.height - 1; i >= 0; i-- {
+		if i < list.height-1 {
+			rank[i] = rank[i+1]
+		}
+		for curr.forward[i] != nil && less(curr.forward[i], score, member) {
+			rank[i] += curr.span[i]
+			curr = curr.forward[i]
 		}
 		update[i] = curr
-
 	}
 	// generate level
 	level := randomLevel()
 	if level > list.height {
-		for i := list.height - 1; i <= level; i++ {
+		for i := list.height; i < level; i++ {
 			update[i] = list.head
+			update[i].span[i] = list.length
 		}
 		list.height = level
 	}
 
 	// insert and change forward
 	newNode := NewSkipListNode(member, score)
-	for i := list.height - 1; i > 0; i-- {
-		if update[i].forward[i] != nil {
-			newNode.forward[i] = update[i].forward[i]
-			update[i].forward[i] = newNode
-		}
-		newNode.forward[i] = nil
+	for i := 0; i < level; i++ {
+		newNode.forward[i] = update[i].forward[i]
 		update[i].forward[i] = newNode
+		newNode.span[i] = update[i].span[i] - (rank[0] - rank[i])
+		update[i].span[i] = rank[0] - rank[i] + 1
 	}
-	zset.dict[member] = newNode
-	// add length of list
-	list.length++
-	return nil
-}
-
-func (kv *KvZset) GetScore(key string, value string) float64 {
-	kv.mu.Lock()
-	defer kv.mu.Unlock()
-	return kv.Zset[key].dict[value].score
-}
-
-func (kv *KvZset) Zrank(key string, member string) (error, int) {
-	kv.mu.Lock()
-	defer kv.mu.Unlock()
-	zset := kv.GetZset(key)
-	var memberScore float64
-	if _, ok := zset.dict[member]; !ok {
-		return fmt.Errorf("member is not exist"), -1
-	}
-	memberScore = zset.dict[member].score
-	list := zset.skiplist
-	curr := zset.skiplist.head
-	rank := 1
-	for i := list.height - 1; i >= 0; i-- {
-		for curr.forward[i] != nil {
-			if curr.forward[i].score < memberScore {
-				rank++
-				curr = curr.forward[i]
-			} else if curr.forward[i].score == memberScore {
-				return nil, rank
-			}
-		}
+	// levels above the new node now jump over one more node
+	for i := level; i < list.height; i++ {
+		update[i].span[i]++
 	}
-	return nil, 0
+	list.length++
+	return newNode
 }
 
-func (kz *KvZset) Zrm(key, member string) error {
-	zset := kz.GetZset(key)
-	if _, ok := zset.dict[member]; !ok {
-		return fmt.Errorf("member not exist")
-	}
-	list := zset.skiplist
+func (list *SkipList) delete(member string, score float64) {
+	update := make([]*SkipListNode, maxHeight)
 	curr := list.head
-	update := make([]*SkipListNode, list.height)
-	// make sure level 0 be delete
 	for i := list.height - 1; i >= 0; i-- {
-		for curr.forward[i] != nil &&
-			(curr.forward[i].score < zset.dict[member].score ||
-				(curr.forward[i].score == zset.dict[member].score &&
-					curr.forward[i].member == member)) {
+		for curr.forward[i] != nil && less(curr.forward[i], score, member) {
 			curr = curr.forward[i]
 		}
 		update[i] = curr
 	}
 	target := curr.forward[0]
-
-	for i := range list.height {
-		if update[i].forward[i] != target {
-			break
-		}
-		update[i].forward[i] = target.forward[i]
+	if target == nil || target.member != member {
+		return
 	}
-
-	for i, v := range curr.forward {
-		if v.member == member {
-			curr.forward[i] = curr.forward[i].forward[i]
+	for i := 0; i < list.height; i++ {
+		if update[i].forward[i] == target {
+			update[i].span[i] += target.span[i] - 1
+			update[i].forward[i] = target.forward[i]
+		} else {
+			update[i].span[i]--
 		}
 	}
-
 	for list.height > 1 && list.head.forward[list.height-1] == nil {
 		list.height--
 	}
-
-	delete(zset.dict, member)
 	list.length--
-	return nil
 }
+
+// rank returns the 1 based position of member, or 0 if it is not found.
+func (list *SkipList) rank(member string, score float64) int {
+	rank := 0
+	curr := list.head
+	for i := list.height - 1; i >= 0; i-- {
+		for curr.forward[i] != nil && (less(curr.forward[i], score, member) ||
+			(curr.forward[i].score == score && curr.forward[i].member == member)) {
+			rank += curr.span[i]
+			curr = curr.forward[i]
+		}
+		if curr.member == member && curr != list.head {
+			return rank
+		}
+	}
+	return 0
+}
+
 func randomLevel() int {
-	return rand.Intn(maxHeight)
+	level := 1
+	for level < maxHeight && rand.Intn(4) == 0 {
+		level++
+	}
+	return level
 }
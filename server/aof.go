@@ -0,0 +1,709 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"go-redis/command"
+	"go-redis/pkg/utils"
+	"go-redis/repo"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+// aofRewriteRetryDelay is how long automatic rewrites wait after a failed
+// one before trying again.
+const aofRewriteRetryDelay = 5 * time.Second
+
+// aofState is the append only file, it is only used from the loop. Its
+// files are listed by the manifest and writes are appended to the last
+// incremental file.
+type aofState struct {
+	manifest aofManifest
+
+	// file is the incremental file written to, nil while appendonly is
+	// off, and size its size.
+	file *os.File
+	size int64
+	// buf holds the commands propagated since the last write, they are
+	// written before the replies of the commands are sent.
+	buf []byte
+	// otherSize is the size of the other files of the manifest, and
+	// rewriteBaseSize the size of the whole AOF after the last rewrite or
+	// at startup, automatic rewrites trigger on growth relative to it.
+	otherSize       int64
+	rewriteBaseSize int64
+	// writeErr is the last write error, write commands are refused until
+	// a write succeeds again.
+	writeErr error
+
+	// under everysec the fsyncs run in a goroutine of their own, woken by
+	// fsyncCh with the replication offset written so far. It reports
+	// failures on fsyncErr and closes fsyncDone once fsyncCh is closed.
+	fsyncCh   chan int64
+	fsyncErr  chan error
+	fsyncDone chan struct{}
+	lastFsync time.Time
+	// unsynced is set when data was written since the last fsync.
+	unsynced bool
+	// writtenOffset is the replication offset of the last command
+	// written, fsyncedOffset of the last one on disk, for WAITAOF. The
+	// fsync goroutine updates it.
+	writtenOffset int64
+	fsyncedOffset atomic.Int64
+
+	// rewrite is the rewrite running, nil when there is none.
+	rewrite           *aofRewrite
+	rewrites          int64
+	lastRewriteOK     bool
+	lastRewriteTry    time.Time
+	lastRewriteLength time.Duration
+}
+
+// aofRewrite is a new base file being written by its own goroutine, which
+// sends the outcome on done. The incremental files before keep hold writes
+// the new base includes, they are dropped with the old base once it is
+// done.
+type aofRewrite struct {
+	start time.Time
+	base  aofInfo
+	keep  int
+	done  chan error
+}
+
+// errAOFTruncated is returned by readAOF when the file ends in the middle
+// of a command.
+var errAOFTruncated = errors.New("unexpected end of file")
+
+func (s *Server) aofDir() string {
+	return filepath.Join(s.config.Dir, s.config.AppendDirname)
+}
+
+func (s *Server) aofManifestPath() string {
+	return filepath.Join(s.aofDir(), s.config.AppendFilename+".manifest")
+}
+
+// writeAOFManifest replaces the manifest on disk with m.
+func (s *Server) writeAOFManifest(m *aofManifest) error {
+	if err := writeFileAtomic(s.aofManifestPath(), m.encode()); err != nil {
+		return fmt.Errorf("can't persist the AOF manifest: %w", err)
+	}
+	return nil
+}
+
+// initAppendOnly opens the AOF at startup when appendonly is on. Without
+// one, it is first written from the data loaded from the RDB file.
+func (s *Server) initAppendOnly() error {
+	if !s.config.AppendOnly {
+		return nil
+	}
+	m := &s.aof.manifest
+	if m.empty() {
+		return s.startAppendOnly()
+	}
+	if len(m.incrs) == 0 {
+		return s.openNewIncr(true)
+	}
+	last := m.incrs[len(m.incrs)-1]
+	f, err := os.OpenFile(filepath.Join(s.aofDir(), last.name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("can't open the append-only file %s: %w", last.name, err)
+	}
+	return s.useIncr(f, last.name)
+}
+
+// startAppendOnly turns the AOF on: a new incremental file takes the
+// writes while the dataset is written as the new base, waited for. The
+// files of a previous AOF are replaced.
+func (s *Server) startAppendOnly() error {
+	s.waitRewrite()
+	if err := s.rewriteAppendOnlyFileBackground(); err != nil {
+		s.stopAppendOnly()
+		return err
+	}
+	s.waitRewrite()
+	if !s.aof.lastRewriteOK {
+		s.stopAppendOnly()
+		return fmt.Errorf("Redis needs to enable the AOF but can't rewrite it")
+	}
+	slog.Info("AOF enabled")
+	return nil
+}
+
+// openNewIncr switches writes to a new incremental file. persist writes
+// the manifest listing it right away, it is not when the base file is
+// about to be written for the first time or replaced after appendonly was
+// off, as the files listed so far do not hold the data.
+func (s *Server) openNewIncr(persist bool) error {
+	if err := os.MkdirAll(s.aofDir(), 0o755); err != nil {
+		return fmt.Errorf("can't create the AOF directory: %w", err)
+	}
+	next := s.aof.manifest.clone()
+	incr := next.nextIncr(s.config.AppendFilename)
+	next.incrs = append(next.incrs, incr)
+	path := filepath.Join(s.aofDir(), incr.name)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("can't open the append-only file %s: %w", incr.name, err)
+	}
+	if persist {
+		if err := s.writeAOFManifest(&next); err != nil {
+			f.Close()
+			os.Remove(path)
+			return err
+		}
+	}
+	s.aof.manifest = next
+	if err := s.closeIncr(); err != nil {
+		slog.Warn("Error closing the previous AOF file", "err", err)
+	}
+	return s.useIncr(f, incr.name)
+}
+
+// useIncr starts appending to f, the incremental file called name.
+func (s *Server) useIncr(f *os.File, name string) error {
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("can't open the append-only file %s: %w", name, err)
+	}
+	s.aof.file = f
+	s.aof.size = info.Size()
+	s.aof.fsyncCh = make(chan int64, 1)
+	s.aof.fsyncErr = make(chan error, 1)
+	s.aof.fsyncDone = make(chan struct{})
+	s.aof.lastFsync = time.Now()
+	s.aof.unsynced = false
+	// what came before is in files already synced, or in the base file
+	// about to be written
+	s.aof.writtenOffset = s.repl.offset
+	s.aof.fsyncedOffset.Store(s.repl.offset)
+	go fsyncLoop(f, s.aof.fsyncCh, s.aof.fsyncErr, s.aof.fsyncDone, &s.aof.fsyncedOffset)
+	s.updateAOFSize()
+	return nil
+}
+
+// fsyncLoop fsyncs f every time it is woken on ch, then stores the
+// offset received in synced.
+func fsyncLoop(f *os.File, ch <-chan int64, errCh chan<- error, done chan<- struct{}, synced *atomic.Int64) {
+	defer close(done)
+	for offset := range ch {
+		if err := f.Sync(); err != nil {
+			select {
+			case errCh <- err:
+			default:
+			}
+			continue
+		}
+		synced.Store(offset)
+	}
+}
+
+// closeIncr writes what is left, fsyncs and closes the incremental file.
+func (s *Server) closeIncr() error {
+	if s.aof.file == nil {
+		return nil
+	}
+	err := s.writeAppendOnlyFile()
+	close(s.aof.fsyncCh)
+	<-s.aof.fsyncDone
+	if serr := s.aof.file.Sync(); err == nil {
+		err = serr
+	}
+	if err == nil {
+		s.aof.fsyncedOffset.Store(s.aof.writtenOffset)
+	}
+	if cerr := s.aof.file.Close(); err == nil {
+		err = cerr
+	}
+	s.aof.file = nil
+	s.aof.size = 0
+	s.aof.buf = nil
+	s.aof.writeErr = nil
+	s.updateAOFSize()
+	return err
+}
+
+// stopAppendOnly turns the AOF off, its files are kept.
+func (s *Server) stopAppendOnly() error {
+	return s.closeIncr()
+}
+
+// updateAOFSize sums the size of the files of the manifest but the one
+// written to.
+func (s *Server) updateAOFSize() {
+	s.aof.otherSize = 0
+	current := ""
+	if s.aof.file != nil {
+		current = filepath.Base(s.aof.file.Name())
+	}
+	for _, info := range s.aof.manifest.files() {
+		if info.name == current {
+			continue
+		}
+		if fi, err := os.Stat(filepath.Join(s.aofDir(), info.name)); err == nil {
+			s.aof.otherSize += fi.Size()
+		}
+	}
+}
+
+func (s *Server) aofCurrentSize() int64 {
+	return s.aof.otherSize + s.aof.size
+}
+
+// propagate logs a command that changed the dataset and, on a master,
+// streams it to the replicas. A replica relays the stream of its master
+// instead, see handleMasterMessage.
+func (s *Server) propagate(args ...string) {
+	master := s.config.MasterHost == ""
+	if s.aof.file == nil && (!master || s.repl.backlog == nil) {
+		return
+	}
+	cmd := utils.AppendCommand(nil, args...)
+	if s.aof.file != nil {
+		s.aof.buf = append(s.aof.buf, cmd...)
+	}
+	if master {
+		s.feedReplicationStream(cmd)
+	}
+}
+
+// flushAppendOnlyFile writes the commands propagated so far and fsyncs as
+// appendfsync asks. It is called before replies are sent, so under always
+// a client is only told a write succeeded once it is on disk.
+func (s *Server) flushAppendOnlyFile(now time.Time) error {
+	if s.aof.file == nil {
+		return nil
+	}
+	select {
+	case err := <-s.aof.fsyncErr:
+		slog.Warn("Error syncing the append only file in the background", "err", err)
+		s.aof.writeErr = err
+		s.aof.unsynced = true
+	default:
+	}
+	if err := s.writeAppendOnlyFile(); err != nil {
+		return err
+	}
+	if !s.aof.unsynced {
+		return nil
+	}
+	policy := s.config.AppendFsync
+	if policy == "no" && s.waitingLocalAOF() {
+		// WAITAOF needs the writes on disk, they are synced every second
+		// while a client waits for them
+		policy = "everysec"
+	}
+	switch policy {
+	case "always":
+		if err := s.aof.file.Sync(); err != nil {
+			slog.Error("Can't persist AOF for fsync error when the AOF fsync policy is 'always'", "err", err)
+			s.aof.writeErr = err
+			return err
+		}
+		s.aof.fsyncedOffset.Store(s.aof.writtenOffset)
+	case "everysec":
+		if now.Sub(s.aof.lastFsync) < time.Second {
+			return nil
+		}
+		select {
+		case s.aof.fsyncCh <- s.aof.writtenOffset:
+		default:
+			// the previous fsync is still running
+			return nil
+		}
+	}
+	s.aof.unsynced = false
+	s.aof.lastFsync = now
+	return nil
+}
+
+// writeAppendOnlyFile writes the buffer to the file. A short write is
+// undone so the file never ends with half a command, or the rest of the
+// command is kept for the next attempt when that fails too.
+func (s *Server) writeAppendOnlyFile() error {
+	if len(s.aof.buf) == 0 {
+		return nil
+	}
+	n, err := s.aof.file.Write(s.aof.buf)
+	if err == nil {
+		s.aof.size += int64(n)
+		s.aof.buf = s.aof.buf[:0]
+		s.aof.unsynced = true
+		s.aof.writtenOffset = s.repl.offset
+		if s.aof.writeErr != nil {
+			slog.Warn("AOF write error looks solved, Redis can write again.")
+			s.aof.writeErr = nil
+		}
+		return nil
+	}
+	if s.aof.writeErr == nil {
+		slog.Warn("Error writing to the AOF file", "err", err, "written", n, "buffered", len(s.aof.buf))
+	}
+	if n > 0 {
+		if terr := s.aof.file.Truncate(s.aof.size); terr != nil {
+			s.aof.size += int64(n)
+			s.aof.buf = s.aof.buf[n:]
+		}
+	}
+	s.aof.writeErr = err
+	return err
+}
+
+// aofCron retries a failed write, starts the everysec fsync when nothing
+// was written lately and rewrites the AOF once it grew enough.
+func (s *Server) aofCron(now time.Time) {
+	if s.aof.file == nil {
+		return
+	}
+	if s.aof.writeErr != nil && len(s.aof.buf) == 0 {
+		// a failed fsync has nothing left to write, an fsync tells if
+		// the disk is back
+		if err := s.aof.file.Sync(); err == nil {
+			slog.Warn("AOF write error looks solved, Redis can write again.")
+			s.aof.writeErr = nil
+		}
+	}
+	s.flushAppendOnlyFile(now)
+
+	perc := int64(s.config.AutoAOFRewritePercentage)
+	size := s.aofCurrentSize()
+	if perc == 0 || s.aof.rewrite != nil || size < s.config.AutoAOFRewriteMinSize {
+		return
+	}
+	if !s.aof.lastRewriteOK && now.Sub(s.aof.lastRewriteTry) < aofRewriteRetryDelay {
+		return
+	}
+	if growth := size*100/max(s.aof.rewriteBaseSize, 1) - 100; growth >= perc {
+		slog.Info(fmt.Sprintf("Starting automatic rewriting of AOF on %d%% growth", growth))
+		s.rewriteAppendOnlyFileBackground()
+	}
+}
+
+// BGRewriteAOF rewrites the AOF in the background.
+func (s *Server) BGRewriteAOF() error {
+	if s.aof.rewrite != nil {
+		return fmt.Errorf("Background append only file rewriting already in progress")
+	}
+	return s.rewriteAppendOnlyFileBackground()
+}
+
+// rewriteAppendOnlyFileBackground writes the dataset as a new base file
+// from a goroutine. Writes go to a new incremental file in the meantime,
+// the only one kept with the new base once it is written.
+func (s *Server) rewriteAppendOnlyFileBackground() error {
+	if err := os.MkdirAll(s.aofDir(), 0o755); err != nil {
+		return fmt.Errorf("can't create the AOF directory: %w", err)
+	}
+	keep := len(s.aof.manifest.incrs)
+	if s.config.AppendOnly {
+		if err := s.openNewIncr(s.aof.file != nil); err != nil {
+			slog.Warn("Can't open a new AOF file for the rewrite", "err", err)
+			return err
+		}
+	}
+	ext, aux := "aof", []string(nil)
+	if s.config.AOFUseRDBPreamble {
+		ext, aux = "rdb", s.rdbAux(true)
+	}
+	rw := &aofRewrite{
+		start: time.Now(),
+		base:  s.aof.manifest.nextBase(s.config.AppendFilename, ext),
+		keep:  keep,
+		done:  make(chan error, 1),
+	}
+	snap := s.db.Snapshot()
+	path := filepath.Join(s.aofDir(), rw.base.name)
+	s.aof.rewrite = rw
+	s.aof.lastRewriteTry = rw.start
+	go func() {
+		defer snap.Release()
+		rw.done <- writeDataFile(path, func(w *bufio.Writer) error {
+			if aux != nil {
+				return snap.WriteRDB(w, aux...)
+			}
+			return snap.WriteAOF(w)
+		})
+	}()
+	slog.Info("Background append only file rewriting started", "keys", snap.Len(), "copy", time.Since(rw.start))
+	return nil
+}
+
+// rewriteDone is called by the loop once the rewrite is over. The new
+// base replaces the old one and the incremental files it includes in a
+// new manifest, then those files are deleted.
+func (s *Server) rewriteDone(err error) {
+	rw := s.aof.rewrite
+	s.aof.rewrite = nil
+	s.aof.lastRewriteLength = time.Since(rw.start)
+	m := &s.aof.manifest
+	next := m.clone()
+	if err == nil {
+		if m.base != nil {
+			next.history = append(next.history, *m.base)
+		}
+		next.history = append(next.history, m.incrs[:rw.keep]...)
+		next.base = &rw.base
+		next.incrs = next.incrs[rw.keep:]
+		err = s.writeAOFManifest(&next)
+	}
+	if err != nil {
+		os.Remove(filepath.Join(s.aofDir(), rw.base.name))
+		s.aof.lastRewriteOK = false
+		slog.Warn("Background AOF rewrite failed", "err", err)
+		return
+	}
+	for _, info := range next.history {
+		os.Remove(filepath.Join(s.aofDir(), info.name))
+	}
+	next.history = nil
+	*m = next
+	s.updateAOFSize()
+	s.aof.rewriteBaseSize = s.aofCurrentSize()
+	s.aof.rewrites++
+	s.aof.lastRewriteOK = true
+	slog.Info("Background AOF rewrite finished successfully")
+}
+
+// aofRewriteCh is the channel the running rewrite reports on, nil when
+// there is none.
+func (s *Server) aofRewriteCh() chan error {
+	if s.aof.rewrite == nil {
+		return nil
+	}
+	return s.aof.rewrite.done
+}
+
+// waitRewrite waits for the rewrite running, if any.
+func (s *Server) waitRewrite() {
+	if ch := s.aofRewriteCh(); ch != nil {
+		s.rewriteDone(<-ch)
+	}
+}
+
+// loadAppendOnlyFiles loads the files listed by the AOF manifest through
+// the command dispatch path. The last file cut in the middle of its last
+// command, like after a crash, is truncated to the last whole command when
+// aof-load-truncated is set. A single file AOF, as written before the
+// manifest existed, is moved to the AOF directory first.
+func (s *Server) loadAppendOnlyFiles() error {
+	start := time.Now()
+	data, err := os.ReadFile(s.aofManifestPath())
+	if errors.Is(err, os.ErrNotExist) {
+		if err := s.upgradeAppendOnlyFile(); err != nil {
+			return err
+		}
+		data, err = os.ReadFile(s.aofManifestPath())
+	}
+	if err != nil {
+		return err
+	}
+	m, err := parseAOFManifest(data)
+	if err != nil {
+		return err
+	}
+	// the replies of the replayed commands go nowhere
+	client := &Conn{addr: "AOF"}
+	var commands int
+	files := m.files()
+	for i, info := range files {
+		path := filepath.Join(s.aofDir(), info.name)
+		valid, err := readAOFFile(path, s.db, func(val resp.Value) error {
+			cmd, err := command.ParseValue(val)
+			if err != nil {
+				return fmt.Errorf("%v reading the append only file", err)
+			}
+			s.call(client, cmd, val.Array())
+			client.reply.reset()
+			commands++
+			return nil
+		})
+		if errors.Is(err, errAOFTruncated) && i == len(files)-1 && s.config.AOFLoadTruncated {
+			slog.Warn("!!! Warning: short read while loading the AOF file !!!", "file", info.name)
+			slog.Warn("!!! Truncating the AOF at offset !!!", "offset", valid)
+			if err := os.Truncate(path, valid); err != nil {
+				return fmt.Errorf("error truncating the AOF file %s: %w", info.name, err)
+			}
+			slog.Warn("AOF loaded anyway because aof-load-truncated is enabled")
+		} else if err != nil {
+			return fmt.Errorf("bad file format reading the append only file %s at offset %d: %w. "+
+				"Make a backup of your AOF file, then use redis-check-aof --fix <filename.manifest>", info.name, valid, err)
+		}
+	}
+	// files replaced by a rewrite the server stopped before deleting
+	for _, info := range m.history {
+		os.Remove(filepath.Join(s.aofDir(), info.name))
+	}
+	m.history = nil
+	s.aof.manifest = m
+	s.updateAOFSize()
+	s.aof.rewriteBaseSize = s.aofCurrentSize()
+	s.rdb.dirtyAtSave = s.db.Dirty()
+	slog.Info("DB loaded from append only file", "commands", commands, "keys", s.db.Len(),
+		"seconds", time.Since(start).Seconds())
+	return nil
+}
+
+// upgradeAppendOnlyFile moves a single file AOF in dir to the AOF
+// directory, where it becomes the base file of a new manifest.
+func (s *Server) upgradeAppendOnlyFile() error {
+	old := filepath.Join(s.config.Dir, s.config.AppendFilename)
+	if _, err := os.Stat(old); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.aofDir(), 0o755); err != nil {
+		return fmt.Errorf("can't create the AOF directory: %w", err)
+	}
+	if err := os.Rename(old, filepath.Join(s.aofDir(), s.config.AppendFilename)); err != nil {
+		return fmt.Errorf("can't move the AOF to the AOF directory: %w", err)
+	}
+	m := aofManifest{base: &aofInfo{name: s.config.AppendFilename, seq: 1, typ: aofBase}, baseSeq: 1}
+	if err := s.writeAOFManifest(&m); err != nil {
+		return err
+	}
+	slog.Info("Successfully migrated an old-style AOF into the AOF directory", "dir", s.config.AppendDirname)
+	return nil
+}
+
+// readAOFFile reads a file of the AOF: an RDB preamble, if any, is loaded
+// into db and fn is called with every command after it. It returns the
+// offset just past the last command read whole, with errAOFTruncated when
+// the file ends in the middle of a command.
+func readAOFFile(path string, db *repo.DB, fn func(val resp.Value) error) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	br := bufio.NewReaderSize(f, readBufSize)
+	var start int64
+	if sig, _ := br.Peek(5); string(sig) == "REDIS" {
+		// LoadRDB reads from br itself, so it stops right after the
+		// preamble
+		if _, err := db.LoadRDB(br); err != nil {
+			return 0, fmt.Errorf("loading the RDB preamble: %w", err)
+		}
+		pos, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, err
+		}
+		start = pos - int64(br.Buffered())
+	}
+	valid, err := readAOF(br, fn)
+	return start + valid, err
+}
+
+// readAOF calls fn with every command of r. It returns the offset just past
+// the last command read whole, with errAOFTruncated when r ends in the
+// middle of a command.
+func readAOF(r io.Reader, fn func(val resp.Value) error) (int64, error) {
+	p := command.NewRESPParser()
+	buf := make([]byte, readBufSize)
+	var fed, valid int64
+	for {
+		for {
+			val, ok, err := p.Next()
+			if err != nil {
+				return valid, err
+			}
+			if !ok {
+				break
+			}
+			for _, arg := range val.Array() {
+				if arg.Type() != resp.BulkString {
+					return valid, fmt.Errorf("command arguments must be bulk strings")
+				}
+			}
+			if err := fn(val); err != nil {
+				return valid, err
+			}
+			valid = fed - int64(p.Buffered())
+		}
+		n, err := r.Read(buf)
+		p.Feed(buf[:n])
+		fed += int64(n)
+		if n == 0 && err == io.EOF {
+			if p.Buffered() > 0 {
+				return valid, errAOFTruncated
+			}
+			return valid, nil
+		}
+		if err != nil && err != io.EOF {
+			return valid, err
+		}
+	}
+}
+
+// CheckAOF verifies an AOF like redis-check-aof and reports on out. path
+// is either a manifest, whose files are all checked, or a single file.
+// With fix, a last file cut in the middle of a command is truncated to its
+// last whole command. It returns an error when the AOF is, or remains,
+// invalid.
+func CheckAOF(path string, fix bool, out io.Writer) error {
+	if !strings.HasSuffix(path, ".manifest") {
+		return checkAOFFile(path, true, fix, out)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot open file %s: %w", path, err)
+	}
+	m, err := parseAOFManifest(data)
+	if err != nil {
+		fmt.Fprintf(out, "Invalid AOF manifest %s: %v\n", path, err)
+		return err
+	}
+	fmt.Fprintln(out, "Start checking Multi Part AOF")
+	files := m.files()
+	for i, info := range files {
+		last := i == len(files)-1
+		if err := checkAOFFile(filepath.Join(filepath.Dir(path), info.name), last, fix && last, out); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(out, "All AOF files and manifest are valid")
+	return nil
+}
+
+// checkAOFFile checks one file of an AOF, only the last one may be fixed
+// by truncation.
+func checkAOFFile(path string, last, fix bool, out io.Writer) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("cannot open file %s: %w", path, err)
+	}
+	var commands int
+	valid, err := readAOFFile(path, repo.NewDB(), func(resp.Value) error {
+		commands++
+		return nil
+	})
+	diff := info.Size() - valid
+	fmt.Fprintf(out, "AOF analyzed: filename=%s, size=%d, ok_up_to=%d, ok_up_to_command=%d, diff=%d\n",
+		path, info.Size(), valid, commands, diff)
+	if err == nil {
+		fmt.Fprintf(out, "AOF %s is valid\n", path)
+		return nil
+	}
+	fmt.Fprintf(out, "0x%x: %v\n", valid, err)
+	if !last {
+		fmt.Fprintf(out, "AOF %s is not the last file, it can't be fixed by truncation\n", path)
+		return fmt.Errorf("AOF %s is not valid", path)
+	}
+	if !fix {
+		fmt.Fprintf(out, "AOF %s is not valid. Use the --fix option to try fixing it.\n", path)
+		return fmt.Errorf("AOF %s is not valid", path)
+	}
+	fmt.Fprintf(out, "This will shrink the AOF %s from %d bytes, with %d bytes, to %d bytes\n", path, info.Size(), diff, valid)
+	if err := os.Truncate(path, valid); err != nil {
+		fmt.Fprintf(out, "Failed to truncate AOF %s\n", path)
+		return err
+	}
+	fmt.Fprintf(out, "Successfully truncated AOF %s\n", path)
+	return nil
+}
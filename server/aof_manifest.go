@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go-redis/pkg/utils"
+	"strconv"
+	"strings"
+)
+
+// The types of the files listed by the AOF manifest.
+const (
+	aofBase    = 'b'
+	aofIncr    = 'i'
+	aofHistory = 'h'
+)
+
+// aofInfo is a file of the AOF, as listed by the manifest.
+type aofInfo struct {
+	name string
+	seq  int64
+	typ  byte
+}
+
+// aofManifest lists the files making up the AOF, like Redis 7's manifest:
+// the base file holds the dataset as of the last rewrite and the
+// incremental files the writes since, loaded in order.
+type aofManifest struct {
+	base  *aofInfo
+	incrs []aofInfo
+	// history lists the files replaced by a rewrite, they are deleted
+	// once the manifest without them is on disk.
+	history []aofInfo
+	// baseSeq and incrSeq are the highest sequence numbers used so far.
+	baseSeq int64
+	incrSeq int64
+}
+
+func (m *aofManifest) empty() bool {
+	return m.base == nil && len(m.incrs) == 0
+}
+
+// files returns the files to load, in order.
+func (m *aofManifest) files() []aofInfo {
+	var files []aofInfo
+	if m.base != nil {
+		files = append(files, *m.base)
+	}
+	return append(files, m.incrs...)
+}
+
+// clone copies m, the copy shares nothing with it.
+func (m *aofManifest) clone() aofManifest {
+	cp := *m
+	if m.base != nil {
+		base := *m.base
+		cp.base = &base
+	}
+	cp.incrs = append([]aofInfo(nil), m.incrs...)
+	cp.history = append([]aofInfo(nil), m.history...)
+	return cp
+}
+
+// nextBase names the base file of the next rewrite, ext is "rdb" or
+// "aof".
+func (m *aofManifest) nextBase(prefix, ext string) aofInfo {
+	m.baseSeq++
+	return aofInfo{name: fmt.Sprintf("%s.%d.base.%s", prefix, m.baseSeq, ext), seq: m.baseSeq, typ: aofBase}
+}
+
+func (m *aofManifest) nextIncr(prefix string) aofInfo {
+	m.incrSeq++
+	return aofInfo{name: fmt.Sprintf("%s.%d.incr.aof", prefix, m.incrSeq), seq: m.incrSeq, typ: aofIncr}
+}
+
+// encode formats the manifest, a line per file such as
+// "file appendonly.aof.1.base.rdb seq 1 type b".
+func (m *aofManifest) encode() []byte {
+	var buf bytes.Buffer
+	line := func(info aofInfo, typ byte) {
+		fmt.Fprintf(&buf, "file %s seq %d type %c\n", utils.QuoteArg(info.name), info.seq, typ)
+	}
+	if m.base != nil {
+		line(*m.base, aofBase)
+	}
+	for _, info := range m.history {
+		line(info, aofHistory)
+	}
+	for _, info := range m.incrs {
+		line(info, aofIncr)
+	}
+	return buf.Bytes()
+}
+
+func parseAOFManifest(data []byte) (aofManifest, error) {
+	var m aofManifest
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		args, err := utils.SplitArgs(line)
+		if err != nil || len(args)%2 != 0 {
+			return m, fmt.Errorf("invalid AOF manifest file format at line %d", n)
+		}
+		var info aofInfo
+		for i := 0; i < len(args); i += 2 {
+			switch strings.ToLower(args[i]) {
+			case "file":
+				info.name = args[i+1]
+			case "seq":
+				info.seq, err = strconv.ParseInt(args[i+1], 10, 64)
+			case "type":
+				if len(args[i+1]) == 1 {
+					info.typ = args[i+1][0]
+				}
+			}
+			// unknown keys are left for later versions
+		}
+		if err != nil || info.name == "" || info.seq < 1 || !isFilename(info.name) {
+			return m, fmt.Errorf("invalid AOF manifest file format at line %d", n)
+		}
+		switch info.typ {
+		case aofBase:
+			if m.base != nil {
+				return m, fmt.Errorf("found duplicate base file information in the AOF manifest")
+			}
+			m.base = &info
+			m.baseSeq = info.seq
+		case aofIncr:
+			if info.seq <= m.incrSeq {
+				return m, fmt.Errorf("found a non-monotonic sequence number in the AOF manifest")
+			}
+			m.incrs = append(m.incrs, info)
+			m.incrSeq = info.seq
+		case aofHistory:
+			m.history = append(m.history, info)
+		default:
+			return m, fmt.Errorf("unknown AOF file type '%s' at line %d", string(info.typ), n)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return m, err
+	}
+	if m.empty() {
+		return m, fmt.Errorf("found an empty AOF manifest")
+	}
+	return m, nil
+}
@@ -0,0 +1,835 @@
+package server
+
+import (
+	"fmt"
+	"go-redis/command"
+	"go-redis/pkg/utils"
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// clusterForgetTTL is how long a forgotten node is not added back from
+	// the gossip of the other nodes.
+	clusterForgetTTL = time.Minute
+	// clusterFailReportValidityMult times the node timeout is how long a
+	// failure report of another node counts.
+	clusterFailReportValidityMult = 2
+	// clusterFailUndoTimeMult times the node timeout is how long a master
+	// still serving slots stays failed once reachable again.
+	clusterFailUndoTimeMult = 2
+	// clusterBusPortIncr is added to the port to get the default bus port.
+	clusterBusPortIncr = 10000
+)
+
+// nodeFlags are the flags of a cluster node, as listed by CLUSTER NODES.
+type nodeFlags uint16
+
+const (
+	nodeMyself nodeFlags = 1 << iota
+	nodeMaster
+	nodeReplica
+	// nodePFail is set when this node can't reach the node, nodeFail
+	// once enough masters agree.
+	nodePFail
+	nodeFail
+	// nodeHandshake is a node met by address whose ID is not known yet,
+	// nodeMeet makes it be sent a MEET rather than a PING.
+	nodeHandshake
+	nodeMeet
+	// nodeNoAddr is a node whose address is not known.
+	nodeNoAddr
+)
+
+var nodeFlagNames = []struct {
+	flag nodeFlags
+	name string
+}{
+	{nodeMyself, "myself"},
+	{nodeMaster, "master"},
+	{nodeReplica, "slave"},
+	{nodePFail, "fail?"},
+	{nodeFail, "fail"},
+	{nodeHandshake, "handshake"},
+	{nodeNoAddr, "noaddr"},
+}
+
+func (f nodeFlags) String() string {
+	var names []string
+	for _, fn := range nodeFlagNames {
+		if f&fn.flag != 0 {
+			names = append(names, fn.name)
+		}
+	}
+	if len(names) == 0 {
+		return "noflags"
+	}
+	return strings.Join(names, ",")
+}
+
+// slotBitmap has a bit set for every slot a node serves.
+type slotBitmap [utils.ClusterSlots / 8]byte
+
+func (b *slotBitmap) has(slot int) bool { return b[slot/8]&(1<<(slot%8)) != 0 }
+func (b *slotBitmap) set(slot int)      { b[slot/8] |= 1 << (slot % 8) }
+func (b *slotBitmap) clear(slot int)    { b[slot/8] &^= 1 << (slot % 8) }
+
+// clusterNode is a node of the cluster as this node knows it, myself
+// included.
+type clusterNode struct {
+	id    string
+	flags nodeFlags
+	ip    string
+	port  int
+	// busPort is the port of the cluster bus of the node.
+	busPort int
+	// configEpoch orders the claims of masters on slots, the highest wins.
+	configEpoch uint64
+	slots       slotBitmap
+	numSlots    int
+	// master is the master of a replica, replicas the ones of a master.
+	master     *clusterNode
+	replicas   []*clusterNode
+	replOffset int64
+
+	// ctime is when the node was created, for the handshake timeout.
+	ctime time.Time
+	// pingSent is when the PING waiting for a PONG was sent, zero when
+	// none is, pongReceived when the last PONG arrived.
+	pingSent     time.Time
+	pongReceived time.Time
+	failTime     time.Time
+	// link is the connection this node opened to the node, dialing is set
+	// while it is opened.
+	link    *clusterLink
+	dialing bool
+	// failReports are the masters reporting the node as failing, with
+	// when they last did.
+	failReports map[*clusterNode]time.Time
+}
+
+func (n *clusterNode) has(f nodeFlags) bool { return n.flags&f != 0 }
+
+func (n *clusterNode) addr() string {
+	return net.JoinHostPort(n.ip, strconv.Itoa(n.port))
+}
+
+// clusterState is the cluster as this node sees it, only used from the
+// loop.
+type clusterState struct {
+	myself       *clusterNode
+	nodes        map[string]*clusterNode
+	currentEpoch uint64
+	// ok is set when the cluster serves every slot.
+	ok bool
+	// slots are the masters serving each slot. A slot being moved to
+	// another node is migrating on its owner, and importing on the node it
+	// is moved to.
+	slots     [utils.ClusterSlots]*clusterNode
+	migrating [utils.ClusterSlots]*clusterNode
+	importing [utils.ClusterSlots]*clusterNode
+	// forgotten are the nodes removed by CLUSTER FORGET, until when they
+	// are not added back.
+	forgotten map[string]time.Time
+
+	// ln is the listener of the cluster bus, busCh carries what the bus
+	// goroutines hand to the loop.
+	ln      net.Listener
+	busCh   chan clusterEvent
+	inbound map[*clusterLink]bool
+
+	// todoSave is set when the config file has to be written,
+	// todoUpdateState when the state has to be computed again.
+	todoSave        bool
+	todoUpdateState bool
+	lastPing        time.Time
+
+	sent     [clusterMsgTypes]int64
+	received [clusterMsgTypes]int64
+}
+
+// clusterInit sets up the node from the cluster config file, creating it
+// when there is none, and opens the cluster bus.
+func (s *Server) clusterInit() error {
+	if !s.config.ClusterEnabled {
+		return nil
+	}
+	c := &s.cluster
+	c.nodes = make(map[string]*clusterNode)
+	c.forgotten = make(map[string]time.Time)
+	c.inbound = make(map[*clusterLink]bool)
+	c.busCh = make(chan clusterEvent)
+	s.db.EnableSlotIndex()
+	loaded, err := s.clusterLoadConfig()
+	if err != nil {
+		return err
+	}
+	if !loaded {
+		// node IDs are random like replication IDs
+		c.myself = &clusterNode{id: newReplID(), flags: nodeMyself | nodeMaster, ctime: time.Now()}
+		c.nodes[c.myself.id] = c.myself
+		slog.Info("No cluster configuration found, I'm " + c.myself.id)
+	}
+	if c.ln == nil {
+		host := ""
+		if len(s.config.Bind) > 0 {
+			host = strings.TrimPrefix(s.config.Bind[0], "-")
+		}
+		port := s.config.ClusterPort
+		if port == 0 {
+			port = s.config.Port + clusterBusPortIncr
+		}
+		ln, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			return fmt.Errorf("fail to listen on the cluster bus: %v", err)
+		}
+		c.ln = ln
+	}
+	c.myself.port = s.config.Port
+	c.myself.busPort = c.ln.Addr().(*net.TCPAddr).Port
+	if m := c.myself.master; m != nil {
+		s.config.MasterHost, s.config.MasterPort = m.ip, m.port
+		s.db.SetKeepExpired(true)
+	}
+	c.todoSave, c.todoUpdateState = true, true
+	s.clusterBeforeSleep()
+	go s.clusterAcceptLoop(c.ln)
+	return nil
+}
+
+// clusterStop closes the cluster bus on shutdown.
+func (s *Server) clusterStop() {
+	if !s.config.ClusterEnabled {
+		return
+	}
+	s.cluster.ln.Close()
+	for _, n := range s.cluster.nodes {
+		if n.link != nil {
+			n.link.close()
+		}
+	}
+	for link := range s.cluster.inbound {
+		link.close()
+	}
+	if err := s.clusterSaveConfig(); err != nil {
+		slog.Warn("Error saving the cluster config before exiting", "err", err)
+	}
+}
+
+// clusterBeforeSleep does what the changes made since it last ran call
+// for.
+func (s *Server) clusterBeforeSleep() {
+	c := &s.cluster
+	if c.todoUpdateState {
+		c.todoUpdateState = false
+		s.clusterUpdateState()
+	}
+	if c.todoSave {
+		c.todoSave = false
+		if err := s.clusterSaveConfig(); err != nil {
+			slog.Warn("Error saving the cluster config", "err", err)
+		}
+	}
+}
+
+func (s *Server) clusterChanged() {
+	s.cluster.todoSave, s.cluster.todoUpdateState = true, true
+}
+
+// clusterUpdateState computes whether the cluster is ok: every slot is
+// served by a master not failing, and this node reaches the majority of
+// the masters serving slots.
+func (s *Server) clusterUpdateState() {
+	c := &s.cluster
+	ok := true
+	if s.config.ClusterRequireFullCoverage {
+		for _, n := range c.slots {
+			if n == nil || n.has(nodeFail) {
+				ok = false
+				break
+			}
+		}
+	}
+	size, reachable := 0, 0
+	for _, n := range c.nodes {
+		if n.has(nodeMaster) && n.numSlots > 0 {
+			size++
+			if !n.has(nodePFail | nodeFail) {
+				reachable++
+			}
+		}
+	}
+	if reachable < size/2+1 {
+		ok = false
+	}
+	if ok != c.ok {
+		c.ok = ok
+		state := "fail"
+		if ok {
+			state = "ok"
+		}
+		slog.Info("Cluster state changed: " + state)
+	}
+}
+
+// clusterSize returns the number of masters serving slots.
+func (s *Server) clusterSize() int {
+	size := 0
+	for _, n := range s.cluster.nodes {
+		if n.has(nodeMaster) && n.numSlots > 0 {
+			size++
+		}
+	}
+	return size
+}
+
+func (s *Server) clusterAddSlot(n *clusterNode, slot int) bool {
+	if s.cluster.slots[slot] != nil {
+		return false
+	}
+	n.slots.set(slot)
+	n.numSlots++
+	s.cluster.slots[slot] = n
+	return true
+}
+
+func (s *Server) clusterDelSlot(slot int) bool {
+	n := s.cluster.slots[slot]
+	if n == nil {
+		return false
+	}
+	n.slots.clear(slot)
+	n.numSlots--
+	s.cluster.slots[slot] = nil
+	return true
+}
+
+// clusterDelNodeSlots unassigns every slot of n.
+func (s *Server) clusterDelNodeSlots(n *clusterNode) {
+	for slot := range s.cluster.slots {
+		if s.cluster.slots[slot] == n {
+			s.clusterDelSlot(slot)
+		}
+	}
+}
+
+// clusterStartHandshake adds a node met by address, named randomly until
+// the handshake tells its ID. It reports false when one is in progress
+// already.
+func (s *Server) clusterStartHandshake(ip string, port, busPort int, meet bool) bool {
+	for _, n := range s.cluster.nodes {
+		if n.has(nodeHandshake) && n.ip == ip && n.port == port && n.busPort == busPort {
+			// already in progress
+			return false
+		}
+	}
+	flags := nodeHandshake
+	if meet {
+		flags |= nodeMeet
+	}
+	n := &clusterNode{id: newReplID(), flags: flags, ip: ip, port: port, busPort: busPort, ctime: time.Now()}
+	s.cluster.nodes[n.id] = n
+	return true
+}
+
+// clusterDelNode forgets n, with the slots it serves and what it reported.
+func (s *Server) clusterDelNode(n *clusterNode) {
+	c := &s.cluster
+	for slot := range c.slots {
+		if c.importing[slot] == n {
+			c.importing[slot] = nil
+		}
+		if c.migrating[slot] == n {
+			c.migrating[slot] = nil
+		}
+		if c.slots[slot] == n {
+			s.clusterDelSlot(slot)
+		}
+	}
+	for _, other := range c.nodes {
+		delete(other.failReports, n)
+	}
+	s.clusterSetNodeMaster(n, nil)
+	for _, r := range n.replicas {
+		r.master = nil
+	}
+	if n.link != nil {
+		n.link.close()
+		n.link = nil
+	}
+	delete(c.nodes, n.id)
+	s.clusterChanged()
+}
+
+// clusterRenameNode gives a node met by address the ID it told.
+func (s *Server) clusterRenameNode(n *clusterNode, id string) {
+	slog.Info("Renaming node", "from", n.id, "to", id)
+	delete(s.cluster.nodes, n.id)
+	n.id = id
+	s.cluster.nodes[id] = n
+	s.clusterChanged()
+}
+
+// clusterSetNodeMaster makes n a replica of master, or a master when
+// master is nil.
+func (s *Server) clusterSetNodeMaster(n, master *clusterNode) {
+	if old := n.master; old != nil {
+		for i, r := range old.replicas {
+			if r == n {
+				old.replicas = append(old.replicas[:i], old.replicas[i+1:]...)
+				break
+			}
+		}
+	}
+	n.master = master
+	if master == nil {
+		return
+	}
+	master.replicas = append(master.replicas, n)
+}
+
+// clusterNodeFailureReports returns the number of masters reporting n as
+// failing, dropping the reports too old to count.
+func (s *Server) clusterNodeFailureReports(n *clusterNode, now time.Time) int {
+	validity := s.config.ClusterNodeTimeout * clusterFailReportValidityMult
+	for reporter, at := range n.failReports {
+		if now.Sub(at) > validity {
+			delete(n.failReports, reporter)
+		}
+	}
+	return len(n.failReports)
+}
+
+// clusterMarkFailingIfNeeded turns the PFAIL of n into a FAIL once the
+// majority of the masters agree, and tells every node.
+func (s *Server) clusterMarkFailingIfNeeded(n *clusterNode, now time.Time) {
+	myself := s.cluster.myself
+	if !n.has(nodePFail) || n.has(nodeFail) {
+		return
+	}
+	failures := s.clusterNodeFailureReports(n, now)
+	if myself.has(nodeMaster) {
+		failures++
+	}
+	if failures < s.clusterSize()/2+1 {
+		return
+	}
+	slog.Info("Marking node as failing (quorum reached).", "node", n.id)
+	n.flags = n.flags&^nodePFail | nodeFail
+	n.failTime = now
+	s.clusterBroadcastFail(n)
+	s.clusterChanged()
+}
+
+// clusterClearFailureIfNeeded clears the FAIL of a node reachable again.
+// A master serving slots stays failed a while, so the other nodes agree
+// it is back.
+func (s *Server) clusterClearFailureIfNeeded(n *clusterNode, now time.Time) {
+	if !n.has(nodeFail) {
+		return
+	}
+	undo := s.config.ClusterNodeTimeout * clusterFailUndoTimeMult
+	if n.has(nodeReplica) || n.numSlots == 0 || now.Sub(n.failTime) > undo {
+		slog.Info("Clear FAIL state for node: is reachable again.", "node", n.id)
+		n.flags &^= nodeFail
+		s.clusterChanged()
+	}
+}
+
+// clusterBumpConfigEpoch gives myself a config epoch no other node has,
+// for a slot taken without the agreement of the others.
+func (s *Server) clusterBumpConfigEpoch() {
+	c := &s.cluster
+	var max uint64
+	for _, n := range c.nodes {
+		if n.configEpoch > max {
+			max = n.configEpoch
+		}
+	}
+	if c.myself.configEpoch == 0 || c.myself.configEpoch != max {
+		c.currentEpoch++
+		c.myself.configEpoch = c.currentEpoch
+		s.clusterChanged()
+		slog.Info("New configEpoch set", "epoch", c.myself.configEpoch)
+	}
+}
+
+// clusterRedirect returns the error redirecting the client to the node
+// serving the keys of cmd, nil when this node does.
+func (s *Server) clusterRedirect(conn *Conn, cmd command.Command, spec *command.Spec) error {
+	asking := conn.asking
+	conn.asking = false
+	keys := cmd.Keys()
+	if len(keys) == 0 {
+		return nil
+	}
+	slot := utils.KeySlot(keys[0])
+	for _, key := range keys[1:] {
+		if utils.KeySlot(key) != slot {
+			return utils.CodedErrorf("CROSSSLOT", "Keys in request don't hash to the same slot")
+		}
+	}
+	c := &s.cluster
+	if !c.ok {
+		return utils.CodedErrorf("CLUSTERDOWN", "The cluster is down")
+	}
+	n := c.slots[slot]
+	if n == nil {
+		return utils.CodedErrorf("CLUSTERDOWN", "Hash slot not served")
+	}
+	migrating := n == c.myself && c.migrating[slot] != nil
+	importing := c.importing[slot] != nil
+	missing := 0
+	if migrating || importing {
+		for _, key := range keys {
+			if ok, _ := s.db.Exist(key); !ok {
+				missing++
+			}
+		}
+	}
+	switch {
+	case migrating && missing == len(keys):
+		// the keys may have been moved already
+		target := c.migrating[slot]
+		return utils.CodedErrorf("ASK", "%d %s", slot, target.addr())
+	case migrating && missing > 0, importing && asking && missing > 0 && len(keys) > 1:
+		return utils.CodedErrorf("TRYAGAIN", "Multiple keys request during rehashing of slot")
+	case importing && asking, n == c.myself:
+		return nil
+	case conn.readOnly && !spec.Has(command.FlagWrite) && c.myself.master == n:
+		return nil
+	}
+	return utils.CodedErrorf("MOVED", "%d %s", slot, n.addr())
+}
+
+// ClusterEnabled reports whether the server is a cluster node.
+func (s *Server) ClusterEnabled() bool {
+	return s.config.ClusterEnabled
+}
+
+func (s *Server) ClusterMyID() string {
+	return s.cluster.myself.id
+}
+
+func (s *Server) ClusterInfo() string {
+	c := &s.cluster
+	var sb strings.Builder
+	state := "fail"
+	if c.ok {
+		state = "ok"
+	}
+	assigned, pfail, fail := 0, 0, 0
+	for _, n := range c.slots {
+		switch {
+		case n == nil:
+			continue
+		case n.has(nodeFail):
+			fail++
+		case n.has(nodePFail):
+			pfail++
+		}
+		assigned++
+	}
+	infoField(&sb, "cluster_state", state)
+	infoField(&sb, "cluster_slots_assigned", assigned)
+	infoField(&sb, "cluster_slots_ok", assigned-pfail-fail)
+	infoField(&sb, "cluster_slots_pfail", pfail)
+	infoField(&sb, "cluster_slots_fail", fail)
+	infoField(&sb, "cluster_known_nodes", len(c.nodes))
+	infoField(&sb, "cluster_size", s.clusterSize())
+	infoField(&sb, "cluster_current_epoch", c.currentEpoch)
+	myEpoch := c.myself.configEpoch
+	if m := c.myself.master; m != nil {
+		myEpoch = m.configEpoch
+	}
+	infoField(&sb, "cluster_my_epoch", myEpoch)
+	var sent, received int64
+	for typ := range c.sent {
+		if c.sent[typ] > 0 {
+			infoField(&sb, "cluster_stats_messages_"+clusterMsgTypeNames[typ]+"_sent", c.sent[typ])
+		}
+		sent += c.sent[typ]
+	}
+	infoField(&sb, "cluster_stats_messages_sent", sent)
+	for typ := range c.received {
+		if c.received[typ] > 0 {
+			infoField(&sb, "cluster_stats_messages_"+clusterMsgTypeNames[typ]+"_received", c.received[typ])
+		}
+		received += c.received[typ]
+	}
+	infoField(&sb, "cluster_stats_messages_received", received)
+	infoField(&sb, "total_cluster_links_buffer_limit_exceeded", 0)
+	return sb.String()
+}
+
+func (s *Server) ClusterNodes() string {
+	var sb strings.Builder
+	for _, n := range s.clusterSortedNodes() {
+		sb.WriteString(s.clusterNodeDescription(n))
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// clusterSortedNodes returns the nodes ordered by ID, so listings are
+// stable.
+func (s *Server) clusterSortedNodes() []*clusterNode {
+	nodes := make([]*clusterNode, 0, len(s.cluster.nodes))
+	for _, n := range s.cluster.nodes {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].id < nodes[j].id })
+	return nodes
+}
+
+// clusterSlotRanges returns the ranges of consecutive slots served by n.
+func (s *Server) clusterSlotRanges(n *clusterNode) [][2]int {
+	var ranges [][2]int
+	start := -1
+	for slot := 0; slot <= utils.ClusterSlots; slot++ {
+		if slot < utils.ClusterSlots && s.cluster.slots[slot] == n {
+			if start < 0 {
+				start = slot
+			}
+			continue
+		}
+		if start >= 0 {
+			ranges = append(ranges, [2]int{start, slot - 1})
+			start = -1
+		}
+	}
+	return ranges
+}
+
+func (s *Server) clusterNodeInfo(n *clusterNode) command.ClusterNode {
+	role, health := "master", "online"
+	if n.has(nodeReplica) {
+		role = "replica"
+	}
+	if n.has(nodeFail | nodePFail) {
+		health = "fail"
+	}
+	offset := n.replOffset
+	if n == s.cluster.myself {
+		offset = s.repl.offset
+	}
+	return command.ClusterNode{ID: n.id, IP: n.ip, Port: n.port, Role: role, ReplOffset: offset, Health: health}
+}
+
+// clusterShardNodes returns the master n followed by its replicas not
+// failing.
+func (s *Server) clusterShardNodes(n *clusterNode, all bool) []command.ClusterNode {
+	nodes := []command.ClusterNode{s.clusterNodeInfo(n)}
+	for _, r := range n.replicas {
+		if all || !r.has(nodeFail) {
+			nodes = append(nodes, s.clusterNodeInfo(r))
+		}
+	}
+	return nodes
+}
+
+func (s *Server) ClusterSlots() []command.ClusterSlotRange {
+	var ranges []command.ClusterSlotRange
+	for _, n := range s.clusterSortedNodes() {
+		if !n.has(nodeMaster) || n.numSlots == 0 {
+			continue
+		}
+		nodes := s.clusterShardNodes(n, false)
+		for _, r := range s.clusterSlotRanges(n) {
+			ranges = append(ranges, command.ClusterSlotRange{Start: r[0], End: r[1], Nodes: nodes})
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+	return ranges
+}
+
+func (s *Server) ClusterShards() []command.ClusterShard {
+	var shards []command.ClusterShard
+	for _, n := range s.clusterSortedNodes() {
+		if !n.has(nodeMaster) {
+			continue
+		}
+		shards = append(shards, command.ClusterShard{Slots: s.clusterSlotRanges(n), Nodes: s.clusterShardNodes(n, true)})
+	}
+	return shards
+}
+
+func (s *Server) ClusterMeet(ip string, port, busPort int) error {
+	if net.ParseIP(ip) == nil || port <= 0 || port > 65535 || busPort <= 0 || busPort > 65535 {
+		return fmt.Errorf("Invalid node address specified: %s:%d", ip, port)
+	}
+	s.clusterStartHandshake(ip, port, busPort, true)
+	return nil
+}
+
+func (s *Server) ClusterForget(id string) error {
+	c := &s.cluster
+	n, ok := c.nodes[id]
+	switch {
+	case !ok:
+		return fmt.Errorf("Unknown node %s", id)
+	case n == c.myself:
+		return fmt.Errorf("I tried hard but I can't forget myself...")
+	case n == c.myself.master:
+		return fmt.Errorf("Can't forget my master!")
+	}
+	c.forgotten[id] = time.Now().Add(clusterForgetTTL)
+	s.clusterDelNode(n)
+	s.clusterBeforeSleep()
+	return nil
+}
+
+// ClusterAddSlots assigns slots to this node, either all of them or none.
+func (s *Server) ClusterAddSlots(slots []int) error {
+	c := &s.cluster
+	seen := make(map[int]bool, len(slots))
+	for _, slot := range slots {
+		if c.slots[slot] != nil {
+			return fmt.Errorf("Slot %d is already busy", slot)
+		}
+		if seen[slot] {
+			return fmt.Errorf("Slot %d specified multiple times", slot)
+		}
+		seen[slot] = true
+	}
+	for _, slot := range slots {
+		if c.importing[slot] == c.myself {
+			c.importing[slot] = nil
+		}
+		s.clusterAddSlot(c.myself, slot)
+	}
+	s.clusterChanged()
+	s.clusterBeforeSleep()
+	return nil
+}
+
+// ClusterDelSlots unassigns slots, either all of them or none.
+func (s *Server) ClusterDelSlots(slots []int) error {
+	c := &s.cluster
+	seen := make(map[int]bool, len(slots))
+	for _, slot := range slots {
+		if c.slots[slot] == nil {
+			return fmt.Errorf("Slot %d is already unassigned", slot)
+		}
+		if seen[slot] {
+			return fmt.Errorf("Slot %d specified multiple times", slot)
+		}
+		seen[slot] = true
+	}
+	for _, slot := range slots {
+		s.clusterDelSlot(slot)
+	}
+	s.clusterChanged()
+	s.clusterBeforeSleep()
+	return nil
+}
+
+// ClusterSetSlot moves a slot between nodes: MIGRATING on the owner and
+// IMPORTING on the node it goes to, then NODE once its keys are moved.
+// STABLE cancels a move.
+func (s *Server) ClusterSetSlot(slot int, state, id string) error {
+	c := &s.cluster
+	if c.myself.has(nodeReplica) {
+		return fmt.Errorf("Please use SETSLOT only with masters.")
+	}
+	var n *clusterNode
+	if state != "STABLE" {
+		var ok bool
+		if n, ok = c.nodes[id]; !ok {
+			return fmt.Errorf("I don't know about node %s", id)
+		}
+		if n.has(nodeReplica) {
+			return fmt.Errorf("Target node is not a master")
+		}
+	}
+	switch state {
+	case "MIGRATING":
+		if c.slots[slot] != c.myself {
+			return fmt.Errorf("I'm not the owner of hash slot %d", slot)
+		}
+		c.migrating[slot] = n
+	case "IMPORTING":
+		if c.slots[slot] == c.myself {
+			return fmt.Errorf("I'm already the owner of hash slot %d", slot)
+		}
+		c.importing[slot] = n
+	case "STABLE":
+		c.migrating[slot], c.importing[slot] = nil, nil
+	case "NODE":
+		keys := s.db.CountKeysInSlot(slot)
+		if c.slots[slot] == c.myself && n != c.myself && keys > 0 {
+			return fmt.Errorf("Can't assign hashslot %d to a different node while I still hold keys for this hash slot.", slot)
+		}
+		if keys == 0 && c.migrating[slot] != nil {
+			c.migrating[slot] = nil
+		}
+		if n == c.myself && c.importing[slot] != nil {
+			// the other nodes learn the slot moved from the new epoch
+			c.importing[slot] = nil
+			s.clusterBumpConfigEpoch()
+		}
+		s.clusterDelSlot(slot)
+		s.clusterAddSlot(n, slot)
+		s.clusterBroadcastPong()
+	}
+	s.clusterChanged()
+	s.clusterBeforeSleep()
+	return nil
+}
+
+// ClusterReplicate makes this node a replica of the master id.
+func (s *Server) ClusterReplicate(id string) error {
+	c := &s.cluster
+	n, ok := c.nodes[id]
+	switch {
+	case !ok:
+		return fmt.Errorf("Unknown node %s", id)
+	case n == c.myself:
+		return fmt.Errorf("Can't replicate myself")
+	case n.has(nodeReplica):
+		return fmt.Errorf("I can only replicate a master, not a replica.")
+	case c.myself.has(nodeMaster) && (c.myself.numSlots != 0 || s.db.Len() != 0):
+		return fmt.Errorf("To set a master the node must be empty and without assigned slots.")
+	}
+	s.clusterSetNodeMaster(c.myself, n)
+	c.myself.flags = c.myself.flags&^nodeMaster | nodeReplica
+	s.setMaster(n.ip, n.port)
+	slog.Info("Configured node as replica", "master", n.id)
+	s.clusterBroadcastPong()
+	s.clusterChanged()
+	s.clusterBeforeSleep()
+	return nil
+}
+
+func (s *Server) ClusterSaveConfig() error {
+	if err := s.clusterSaveConfig(); err != nil {
+		return fmt.Errorf("error saving the cluster node config: %v", err)
+	}
+	return nil
+}
+
+func (s *Server) ClusterCountFailureReports(id string) (int, error) {
+	n, ok := s.cluster.nodes[id]
+	if !ok {
+		return 0, fmt.Errorf("Unknown node %s", id)
+	}
+	return s.clusterNodeFailureReports(n, time.Now()), nil
+}
+
+// Asking lets the next command of client use a slot being imported.
+func (s *Server) Asking(client command.Client) {
+	client.(*Conn).asking = true
+}
+
+// ReadOnly lets client read from the replica the slots of its master.
+func (s *Server) ReadOnly(client command.Client, on bool) {
+	client.(*Conn).readOnly = on
+}
+
+func (s *Server) infoCluster(sb *strings.Builder) {
+	infoField(sb, "cluster_enabled", utils.Btoi(s.config.ClusterEnabled))
+}
@@ -0,0 +1,704 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"go-redis/pkg/utils"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// The cluster bus carries binary messages between nodes. Each one starts
+// with the header of the sender: its epochs, the slots it serves and its
+// master, followed for PING, PONG and MEET by gossip about other nodes,
+// and for FAIL by the ID of the node failing.
+const (
+	clusterMsgPing uint16 = iota
+	clusterMsgPong
+	clusterMsgMeet
+	clusterMsgFail
+	clusterMsgTypes
+)
+
+var clusterMsgTypeNames = [clusterMsgTypes]string{"ping", "pong", "meet", "fail"}
+
+const (
+	clusterMsgSig     = "RCmb"
+	clusterMsgVersion = 1
+	clusterIDLen      = 40
+	clusterIPLen      = 46
+	// clusterMsgHeaderLen is the size of the header: signature, length,
+	// version, type, gossip count, current and config epochs, replication
+	// offset, sender, slots, master, port, bus port, flags and state.
+	clusterMsgHeaderLen = 4 + 4 + 2 + 2 + 2 + 8 + 8 + 8 + clusterIDLen + len(slotBitmap{}) + clusterIDLen + 2 + 2 + 2 + 1
+	// clusterGossipLen is the size of a gossip entry: ID, ping sent and pong
+	// received in seconds, IP, port, bus port and flags.
+	clusterGossipLen = clusterIDLen + 4 + 4 + clusterIPLen + 2 + 2 + 2
+	// clusterMaxGossip bounds the gossip entries of a message read.
+	clusterMaxGossip = 1 << 14
+	// clusterLinkQueue is how many messages a link queues before dropping
+	// new ones, gossip is sent again anyway.
+	clusterLinkQueue = 256
+)
+
+// clusterMsg is a message of the cluster bus.
+type clusterMsg struct {
+	typ          uint16
+	currentEpoch uint64
+	configEpoch  uint64
+	offset       int64
+	sender       string
+	slots        slotBitmap
+	// master is the master of the sender, empty for a master.
+	master  string
+	port    int
+	busPort int
+	flags   nodeFlags
+	// fail is set when the sender sees the cluster as down.
+	fail   bool
+	gossip []clusterGossip
+	// failing is the node a FAIL message is about.
+	failing string
+}
+
+// clusterGossip is what the sender of a message knows about another node.
+type clusterGossip struct {
+	id           string
+	pingSent     uint32
+	pongReceived uint32
+	ip           string
+	port         int
+	busPort      int
+	flags        nodeFlags
+}
+
+func appendFixed(b []byte, s string, n int) []byte {
+	field := make([]byte, n)
+	copy(field, s)
+	return append(b, field...)
+}
+
+func readFixed(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// encode returns the message as sent on the bus.
+func (m *clusterMsg) encode() []byte {
+	b := make([]byte, 0, clusterMsgHeaderLen+len(m.gossip)*clusterGossipLen+clusterIDLen)
+	b = append(b, clusterMsgSig...)
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b = binary.BigEndian.AppendUint16(b, clusterMsgVersion)
+	b = binary.BigEndian.AppendUint16(b, m.typ)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(m.gossip)))
+	b = binary.BigEndian.AppendUint64(b, m.currentEpoch)
+	b = binary.BigEndian.AppendUint64(b, m.configEpoch)
+	b = binary.BigEndian.AppendUint64(b, uint64(m.offset))
+	b = appendFixed(b, m.sender, clusterIDLen)
+	b = append(b, m.slots[:]...)
+	b = appendFixed(b, m.master, clusterIDLen)
+	b = binary.BigEndian.AppendUint16(b, uint16(m.port))
+	b = binary.BigEndian.AppendUint16(b, uint16(m.busPort))
+	b = binary.BigEndian.AppendUint16(b, uint16(m.flags))
+	b = append(b, byte(utils.Btoi(m.fail)))
+	for _, g := range m.gossip {
+		b = appendFixed(b, g.id, clusterIDLen)
+		b = binary.BigEndian.AppendUint32(b, g.pingSent)
+		b = binary.BigEndian.AppendUint32(b, g.pongReceived)
+		b = appendFixed(b, g.ip, clusterIPLen)
+		b = binary.BigEndian.AppendUint16(b, uint16(g.port))
+		b = binary.BigEndian.AppendUint16(b, uint16(g.busPort))
+		b = binary.BigEndian.AppendUint16(b, uint16(g.flags))
+	}
+	if m.typ == clusterMsgFail {
+		b = appendFixed(b, m.failing, clusterIDLen)
+	}
+	binary.BigEndian.PutUint32(b[4:], uint32(len(b)))
+	return b
+}
+
+var errClusterMsg = errors.New("invalid cluster bus message")
+
+// readClusterMsg reads the next message of the bus from r.
+func readClusterMsg(r io.Reader) (*clusterMsg, error) {
+	var prefix [8]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	if string(prefix[:4]) != clusterMsgSig {
+		return nil, errClusterMsg
+	}
+	total := int(binary.BigEndian.Uint32(prefix[4:]))
+	if total < clusterMsgHeaderLen || total > clusterMsgHeaderLen+clusterMaxGossip*clusterGossipLen+clusterIDLen {
+		return nil, errClusterMsg
+	}
+	b := make([]byte, total)
+	copy(b, prefix[:])
+	if _, err := io.ReadFull(r, b[8:]); err != nil {
+		return nil, err
+	}
+	m := &clusterMsg{}
+	p := b[8:]
+	if binary.BigEndian.Uint16(p) != clusterMsgVersion {
+		return nil, errClusterMsg
+	}
+	m.typ = binary.BigEndian.Uint16(p[2:])
+	count := int(binary.BigEndian.Uint16(p[4:]))
+	m.currentEpoch = binary.BigEndian.Uint64(p[6:])
+	m.configEpoch = binary.BigEndian.Uint64(p[14:])
+	m.offset = int64(binary.BigEndian.Uint64(p[22:]))
+	p = p[30:]
+	m.sender = readFixed(p[:clusterIDLen])
+	p = p[clusterIDLen:]
+	copy(m.slots[:], p)
+	p = p[len(m.slots):]
+	m.master = readFixed(p[:clusterIDLen])
+	p = p[clusterIDLen:]
+	m.port = int(binary.BigEndian.Uint16(p))
+	m.busPort = int(binary.BigEndian.Uint16(p[2:]))
+	m.flags = nodeFlags(binary.BigEndian.Uint16(p[4:]))
+	m.fail = p[6] != 0
+	p = p[7:]
+	if m.typ >= clusterMsgTypes || len(p) < count*clusterGossipLen {
+		return nil, errClusterMsg
+	}
+	for i := 0; i < count; i++ {
+		g := clusterGossip{id: readFixed(p[:clusterIDLen])}
+		p = p[clusterIDLen:]
+		g.pingSent = binary.BigEndian.Uint32(p)
+		g.pongReceived = binary.BigEndian.Uint32(p[4:])
+		g.ip = readFixed(p[8 : 8+clusterIPLen])
+		p = p[8+clusterIPLen:]
+		g.port = int(binary.BigEndian.Uint16(p))
+		g.busPort = int(binary.BigEndian.Uint16(p[2:]))
+		g.flags = nodeFlags(binary.BigEndian.Uint16(p[4:]))
+		p = p[6:]
+		m.gossip = append(m.gossip, g)
+	}
+	if m.typ == clusterMsgFail {
+		if len(p) < clusterIDLen {
+			return nil, errClusterMsg
+		}
+		m.failing = readFixed(p[:clusterIDLen])
+	}
+	return m, nil
+}
+
+// clusterLink is a bus connection with another node. Every node opens one
+// to each node it knows to send it PINGs, and answers the messages
+// received on the ones the others opened.
+type clusterLink struct {
+	conn net.Conn
+	// node is the node the link was opened to, nil for a link opened by
+	// another node.
+	node *clusterNode
+	// out is the queue of the writer goroutine.
+	out    chan []byte
+	closed bool
+}
+
+// clusterEvent is what a bus goroutine hands to the loop: a connection
+// accepted, the outcome of a dial to node, or a message read on link, the
+// link being broken when msg is nil.
+type clusterEvent struct {
+	conn net.Conn
+	node *clusterNode
+	err  error
+	link *clusterLink
+	msg  *clusterMsg
+}
+
+func (l *clusterLink) send(b []byte) {
+	if l.closed {
+		return
+	}
+	select {
+	case l.out <- b:
+	default:
+	}
+}
+
+func (l *clusterLink) close() {
+	if l.closed {
+		return
+	}
+	l.closed = true
+	close(l.out)
+	l.conn.Close()
+}
+
+func (s *Server) clusterAcceptLoop(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			slog.Error("Error accepting cluster bus connection", "err", err)
+			continue
+		}
+		s.clusterSendEvent(clusterEvent{conn: conn})
+	}
+}
+
+// clusterSendEvent hands ev to the loop, unless the server is gone.
+func (s *Server) clusterSendEvent(ev clusterEvent) bool {
+	select {
+	case s.cluster.busCh <- ev:
+		return true
+	case <-s.doneCh:
+		if ev.conn != nil {
+			ev.conn.Close()
+		}
+		return false
+	}
+}
+
+// clusterNewLink starts the goroutines reading and writing conn.
+func (s *Server) clusterNewLink(conn net.Conn, node *clusterNode) *clusterLink {
+	link := &clusterLink{conn: conn, node: node, out: make(chan []byte, clusterLinkQueue)}
+	if s.cluster.myself.ip == "" {
+		// a node learns its address from the connections of the bus
+		if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+			s.cluster.myself.ip = addr.IP.String()
+			s.cluster.todoSave = true
+		}
+	}
+	timeout := s.config.ClusterNodeTimeout
+	go func() {
+		for b := range link.out {
+			conn.SetWriteDeadline(time.Now().Add(timeout))
+			if _, err := conn.Write(b); err != nil {
+				conn.Close()
+			}
+		}
+	}()
+	go func() {
+		r := bufio.NewReader(conn)
+		for {
+			msg, err := readClusterMsg(r)
+			if err != nil {
+				s.clusterSendEvent(clusterEvent{link: link})
+				return
+			}
+			if !s.clusterSendEvent(clusterEvent{link: link, msg: msg}) {
+				return
+			}
+		}
+	}()
+	return link
+}
+
+// clusterHandleEvent runs in the loop for everything the bus goroutines
+// hand to it.
+func (s *Server) clusterHandleEvent(ev clusterEvent) {
+	c := &s.cluster
+	switch {
+	case ev.link != nil && ev.msg != nil:
+		if !ev.link.closed {
+			s.clusterProcessPacket(ev.link, ev.msg)
+		}
+	case ev.link != nil:
+		ev.link.close()
+		delete(c.inbound, ev.link)
+		if n := ev.link.node; n != nil && n.link == ev.link {
+			n.link = nil
+		}
+	case ev.node != nil:
+		n := ev.node
+		n.dialing = false
+		if c.nodes[n.id] != n {
+			// forgotten or renamed meanwhile
+			if ev.conn != nil {
+				ev.conn.Close()
+			}
+			break
+		}
+		if ev.err != nil {
+			// a node never reached fails like one that stopped answering
+			if n.pingSent.IsZero() {
+				n.pingSent = time.Now()
+			}
+			break
+		}
+		n.link = s.clusterNewLink(ev.conn, n)
+		typ := clusterMsgPing
+		if n.has(nodeMeet) {
+			typ = clusterMsgMeet
+		}
+		s.clusterSendPing(n.link, typ)
+		n.flags &^= nodeMeet
+	default:
+		c.inbound[s.clusterNewLink(ev.conn, nil)] = true
+	}
+	s.clusterBeforeSleep()
+}
+
+// clusterDial opens the link to n in the background.
+func (s *Server) clusterDial(n *clusterNode) {
+	n.dialing = true
+	addr := net.JoinHostPort(n.ip, fmt.Sprint(n.busPort))
+	timeout := s.config.ClusterNodeTimeout
+	go func() {
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		s.clusterSendEvent(clusterEvent{node: n, conn: conn, err: err})
+	}()
+}
+
+// clusterHeader returns a message of type typ with the header describing
+// myself. A replica announces the slots and epoch of its master.
+func (s *Server) clusterHeader(typ uint16) *clusterMsg {
+	c := &s.cluster
+	myself := c.myself
+	m := &clusterMsg{
+		typ:          typ,
+		currentEpoch: c.currentEpoch,
+		configEpoch:  myself.configEpoch,
+		offset:       s.repl.offset,
+		sender:       myself.id,
+		slots:        myself.slots,
+		port:         myself.port,
+		busPort:      myself.busPort,
+		flags:        myself.flags,
+		fail:         !c.ok,
+	}
+	if master := myself.master; master != nil {
+		m.master = master.id
+		m.slots = master.slots
+		m.configEpoch = master.configEpoch
+	}
+	return m
+}
+
+func (s *Server) clusterSend(link *clusterLink, m *clusterMsg) {
+	s.cluster.sent[m.typ]++
+	link.send(m.encode())
+}
+
+// clusterSendPing sends a PING, PONG or MEET with gossip about a tenth of
+// the nodes, three at least, and about every node failing.
+func (s *Server) clusterSendPing(link *clusterLink, typ uint16) {
+	c := &s.cluster
+	m := s.clusterHeader(typ)
+	var candidates, failing []*clusterNode
+	for _, n := range c.nodes {
+		if n == c.myself || n.has(nodeHandshake|nodeNoAddr) || (n.link == nil && n.numSlots == 0) {
+			continue
+		}
+		if n.has(nodePFail) {
+			failing = append(failing, n)
+			continue
+		}
+		candidates = append(candidates, n)
+	}
+	wanted := max(3, len(c.nodes)/10)
+	rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	if len(candidates) > wanted {
+		candidates = candidates[:wanted]
+	}
+	for _, n := range append(candidates, failing...) {
+		m.gossip = append(m.gossip, clusterGossip{
+			id:           n.id,
+			pingSent:     unixSeconds(n.pingSent),
+			pongReceived: unixSeconds(n.pongReceived),
+			ip:           n.ip,
+			port:         n.port,
+			busPort:      n.busPort,
+			flags:        n.flags,
+		})
+	}
+	if (typ == clusterMsgPing || typ == clusterMsgMeet) && link.node != nil && link.node.pingSent.IsZero() {
+		link.node.pingSent = time.Now()
+	}
+	s.clusterSend(link, m)
+}
+
+func unixSeconds(t time.Time) uint32 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint32(t.Unix())
+}
+
+// clusterBroadcastPong tells every node the configuration of myself right
+// away, after it changed.
+func (s *Server) clusterBroadcastPong() {
+	for _, n := range s.cluster.nodes {
+		if n.link != nil && !n.has(nodeHandshake) {
+			s.clusterSendPing(n.link, clusterMsgPong)
+		}
+	}
+}
+
+func (s *Server) clusterBroadcastFail(failing *clusterNode) {
+	m := s.clusterHeader(clusterMsgFail)
+	m.failing = failing.id
+	for _, n := range s.cluster.nodes {
+		if n.link != nil && !n.has(nodeHandshake) {
+			s.clusterSend(n.link, m)
+		}
+	}
+}
+
+// clusterProcessPacket handles a message received on link.
+func (s *Server) clusterProcessPacket(link *clusterLink, m *clusterMsg) {
+	c := &s.cluster
+	now := time.Now()
+	c.received[m.typ]++
+	sender := c.nodes[m.sender]
+	if sender != nil && sender.has(nodeHandshake) {
+		sender = nil
+	}
+	if sender != nil {
+		if m.currentEpoch > c.currentEpoch {
+			c.currentEpoch = m.currentEpoch
+			c.todoSave = true
+		}
+		if m.configEpoch > sender.configEpoch && m.master == "" {
+			sender.configEpoch = m.configEpoch
+			s.clusterChanged()
+		}
+		sender.replOffset = m.offset
+	}
+	ip := ""
+	if addr, ok := link.conn.RemoteAddr().(*net.TCPAddr); ok {
+		ip = addr.IP.String()
+	}
+
+	switch m.typ {
+	case clusterMsgMeet, clusterMsgPing:
+		if sender == nil && m.typ == clusterMsgMeet {
+			if until, ok := c.forgotten[m.sender]; ok && now.Before(until) {
+				break
+			}
+			sender = &clusterNode{id: m.sender, ip: ip, port: m.port, busPort: m.busPort, ctime: now, configEpoch: m.configEpoch}
+			sender.flags = m.flags &^ (nodeMyself | nodePFail | nodeFail | nodeHandshake | nodeMeet)
+			c.nodes[sender.id] = sender
+			slog.Info("Node met us", "node", sender.id, "addr", sender.addr())
+			s.clusterChanged()
+		}
+		if sender != nil && link.node == nil && (sender.ip != ip || sender.port != m.port || sender.busPort != m.busPort) {
+			// the node restarted elsewhere, the link to the old address is
+			// useless
+			slog.Info("Address updated for node", "node", sender.id, "addr", net.JoinHostPort(ip, fmt.Sprint(m.port)))
+			sender.ip, sender.port, sender.busPort = ip, m.port, m.busPort
+			sender.flags &^= nodeNoAddr
+			if sender.link != nil {
+				sender.link.close()
+				sender.link = nil
+			}
+			s.clusterChanged()
+		}
+		s.clusterSendPing(link, clusterMsgPong)
+	case clusterMsgPong:
+		n := link.node
+		if n == nil {
+			break
+		}
+		if n.has(nodeHandshake) {
+			if sender != nil {
+				// the node is known already, under its ID
+				s.clusterDelNode(n)
+				return
+			}
+			s.clusterRenameNode(n, m.sender)
+			n.flags = n.flags&^(nodeHandshake|nodeMeet) | m.flags&(nodeMaster|nodeReplica)
+			sender = n
+		} else if n.id != m.sender {
+			// another node answers at that address
+			slog.Info("PONG contains mismatching sender ID", "node", n.id, "sender", m.sender)
+			n.flags |= nodeNoAddr
+			n.ip = ""
+			n.link = nil
+			link.close()
+			s.clusterChanged()
+			return
+		}
+		n.pongReceived = now
+		n.pingSent = time.Time{}
+		if n.has(nodePFail) {
+			n.flags &^= nodePFail
+			s.clusterChanged()
+		}
+		s.clusterClearFailureIfNeeded(n, now)
+	case clusterMsgFail:
+		if sender == nil {
+			break
+		}
+		if n := c.nodes[m.failing]; n != nil && n != c.myself && !n.has(nodeFail) {
+			slog.Info("FAIL message received", "from", sender.id, "about", n.id)
+			n.flags = n.flags&^nodePFail | nodeFail
+			n.failTime = now
+			s.clusterChanged()
+		}
+		return
+	}
+	if sender == nil {
+		return
+	}
+	s.clusterUpdateSender(sender, m)
+	s.clusterProcessGossip(sender, m, now)
+}
+
+// clusterUpdateSender applies what the header says about its sender: its
+// role, and for a master the slots it claims.
+func (s *Server) clusterUpdateSender(sender *clusterNode, m *clusterMsg) {
+	c := &s.cluster
+	if m.master == "" {
+		if sender.has(nodeReplica) {
+			slog.Info("Node is now a master", "node", sender.id)
+			s.clusterSetNodeMaster(sender, nil)
+			sender.flags = sender.flags&^nodeReplica | nodeMaster
+			s.clusterChanged()
+		}
+	} else {
+		if sender.has(nodeMaster) {
+			s.clusterDelNodeSlots(sender)
+			sender.flags = sender.flags&^nodeMaster | nodeReplica
+			s.clusterChanged()
+		}
+		if master := c.nodes[m.master]; master != nil && sender.master != master {
+			s.clusterSetNodeMaster(sender, master)
+			s.clusterChanged()
+		}
+		return
+	}
+	if sender.slots != m.slots {
+		s.clusterUpdateSlotsConfigWith(sender, m.configEpoch, &m.slots)
+	}
+	myself := c.myself
+	if myself.has(nodeMaster) && sender.configEpoch == myself.configEpoch && sender.id > myself.id {
+		// two masters can't have the same epoch, the one with the lowest
+		// ID takes a new one
+		c.currentEpoch++
+		myself.configEpoch = c.currentEpoch
+		s.clusterChanged()
+		slog.Info("configEpoch collision with node, configEpoch set", "node", sender.id, "epoch", myself.configEpoch)
+	}
+}
+
+// clusterUpdateSlotsConfigWith gives sender the slots it claims, unless
+// their owner has a more recent config epoch. The keys of the slots this
+// node loses are deleted.
+func (s *Server) clusterUpdateSlotsConfigWith(sender *clusterNode, configEpoch uint64, slots *slotBitmap) {
+	c := &s.cluster
+	for slot := range c.slots {
+		if !slots.has(slot) || c.slots[slot] == sender || c.importing[slot] != nil {
+			continue
+		}
+		owner := c.slots[slot]
+		if owner != nil && owner.configEpoch >= configEpoch {
+			continue
+		}
+		if owner == c.myself {
+			for _, key := range s.db.DelKeysInSlot(slot) {
+				s.propagate("DEL", key)
+			}
+			c.migrating[slot] = nil
+		}
+		s.clusterDelSlot(slot)
+		s.clusterAddSlot(sender, slot)
+		s.clusterChanged()
+	}
+}
+
+// clusterProcessGossip records the failure reports of a master sender and
+// meets the nodes it knows that this node doesn't.
+func (s *Server) clusterProcessGossip(sender *clusterNode, m *clusterMsg, now time.Time) {
+	c := &s.cluster
+	for _, g := range m.gossip {
+		n := c.nodes[g.id]
+		if n == nil {
+			if until, ok := c.forgotten[g.id]; ok && now.Before(until) {
+				continue
+			}
+			if g.flags&nodeNoAddr == 0 && g.ip != "" {
+				s.clusterStartHandshake(g.ip, g.port, g.busPort, false)
+			}
+			continue
+		}
+		if n == c.myself || !sender.has(nodeMaster) {
+			continue
+		}
+		if g.flags&(nodePFail|nodeFail) != 0 {
+			if n.failReports == nil {
+				n.failReports = make(map[*clusterNode]time.Time)
+			}
+			n.failReports[sender] = now
+			s.clusterMarkFailingIfNeeded(n, now)
+		} else {
+			delete(n.failReports, sender)
+		}
+	}
+}
+
+// clusterCron connects to the nodes, pings them and detects the ones not
+// answering.
+func (s *Server) clusterCron(now time.Time) {
+	if !s.config.ClusterEnabled {
+		return
+	}
+	c := &s.cluster
+	timeout := s.config.ClusterNodeTimeout
+	for id, until := range c.forgotten {
+		if now.After(until) {
+			delete(c.forgotten, id)
+		}
+	}
+	for _, n := range c.nodes {
+		if n == c.myself || n.has(nodeNoAddr) {
+			continue
+		}
+		if n.has(nodeHandshake) && now.Sub(n.ctime) > max(timeout, time.Second) {
+			s.clusterDelNode(n)
+			continue
+		}
+		if n.link == nil && !n.dialing {
+			s.clusterDial(n)
+		}
+	}
+	if now.Sub(c.lastPing) >= time.Second {
+		// the node heard from last among a few, so all are pinged in time
+		c.lastPing = now
+		var oldest *clusterNode
+		i := 0
+		for _, n := range c.nodes {
+			if i == 5 {
+				break
+			}
+			if n.link == nil || !n.pingSent.IsZero() || n.has(nodeMyself|nodeHandshake) {
+				continue
+			}
+			i++
+			if oldest == nil || n.pongReceived.Before(oldest.pongReceived) {
+				oldest = n
+			}
+		}
+		if oldest != nil {
+			s.clusterSendPing(oldest.link, clusterMsgPing)
+		}
+	}
+	for _, n := range c.nodes {
+		if n == c.myself || n.has(nodeNoAddr|nodeHandshake) {
+			continue
+		}
+		if n.link != nil && n.pingSent.IsZero() && now.Sub(n.pongReceived) > timeout/2 {
+			s.clusterSendPing(n.link, clusterMsgPing)
+		}
+		if !n.pingSent.IsZero() && now.Sub(n.pingSent) > timeout && !n.has(nodePFail|nodeFail) {
+			slog.Info("Node is not reachable, marking it as possibly failing", "node", n.id)
+			n.flags |= nodePFail
+			s.clusterChanged()
+			s.clusterMarkFailingIfNeeded(n, now)
+		}
+	}
+	s.clusterBeforeSleep()
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"go-redis/pkg/utils"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The cluster config file lists the nodes in the format of CLUSTER NODES,
+// followed by the epochs of this node:
+//
+//	<id> <ip:port@cport> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> ...
+//	vars currentEpoch <epoch> lastVoteEpoch 0
+
+func (s *Server) clusterConfigPath() string {
+	return filepath.Join(s.config.Dir, s.config.ClusterConfigFile)
+}
+
+// clusterNodeDescription renders n as a line of CLUSTER NODES. The slots
+// myself is moving are listed after the ones it serves.
+func (s *Server) clusterNodeDescription(n *clusterNode) string {
+	c := &s.cluster
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s %s@%d %s ", n.id, n.addr(), n.busPort, n.flags)
+	if n.master != nil {
+		sb.WriteString(n.master.id)
+	} else {
+		sb.WriteByte('-')
+	}
+	link := "disconnected"
+	if n == c.myself || n.link != nil {
+		link = "connected"
+	}
+	fmt.Fprintf(&sb, " %d %d %d %s", unixMilli(n.pingSent), unixMilli(n.pongReceived), n.configEpoch, link)
+	for _, r := range s.clusterSlotRanges(n) {
+		if r[0] == r[1] {
+			fmt.Fprintf(&sb, " %d", r[0])
+		} else {
+			fmt.Fprintf(&sb, " %d-%d", r[0], r[1])
+		}
+	}
+	if n == c.myself {
+		for slot := range c.slots {
+			if t := c.migrating[slot]; t != nil {
+				fmt.Fprintf(&sb, " [%d->-%s]", slot, t.id)
+			}
+			if t := c.importing[slot]; t != nil {
+				fmt.Fprintf(&sb, " [%d-<-%s]", slot, t.id)
+			}
+		}
+	}
+	return sb.String()
+}
+
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+// clusterSaveConfig writes the cluster config file, leaving out the nodes
+// in handshake.
+func (s *Server) clusterSaveConfig() error {
+	var sb strings.Builder
+	for _, n := range s.clusterSortedNodes() {
+		if n.has(nodeHandshake) {
+			continue
+		}
+		sb.WriteString(s.clusterNodeDescription(n))
+		sb.WriteByte('\n')
+	}
+	fmt.Fprintf(&sb, "vars currentEpoch %d lastVoteEpoch 0\n", s.cluster.currentEpoch)
+	return writeFileAtomic(s.clusterConfigPath(), []byte(sb.String()))
+}
+
+// clusterLoadConfig reads the cluster config file, reporting false when
+// there is none yet.
+func (s *Server) clusterLoadConfig() (bool, error) {
+	path := s.clusterConfigPath()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("can't open the cluster config file %s: %v", path, err)
+	}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return false, nil
+	}
+	c := &s.cluster
+	// masters may be listed after their replicas
+	masters := make(map[*clusterNode]string)
+	node := func(id string) *clusterNode {
+		n, ok := c.nodes[id]
+		if !ok {
+			n = &clusterNode{id: id, ctime: time.Now()}
+			c.nodes[id] = n
+		}
+		return n
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		if err := s.clusterLoadLine(args, node, masters); err != nil {
+			return false, fmt.Errorf("invalid cluster config file %s, line %d: %v", path, i+1, err)
+		}
+	}
+	if c.myself == nil {
+		return false, fmt.Errorf("invalid cluster config file %s: myself is not listed", path)
+	}
+	for n, id := range masters {
+		s.clusterSetNodeMaster(n, node(id))
+	}
+	slog.Info("Node configuration loaded, I'm " + c.myself.id)
+	return true, nil
+}
+
+func (s *Server) clusterLoadLine(args []string, node func(string) *clusterNode, masters map[*clusterNode]string) error {
+	c := &s.cluster
+	if args[0] == "vars" {
+		for i := 1; i+1 < len(args); i += 2 {
+			if args[i] == "currentEpoch" {
+				epoch, err := strconv.ParseUint(args[i+1], 10, 64)
+				if err != nil {
+					return err
+				}
+				c.currentEpoch = epoch
+			}
+		}
+		return nil
+	}
+	if len(args) < 8 || len(args[0]) != clusterIDLen {
+		return fmt.Errorf("wrong number of arguments")
+	}
+	n := node(args[0])
+	// a hostname may follow the address
+	addr, _, _ := strings.Cut(args[1], ",")
+	hostport, bus, ok := strings.Cut(addr, "@")
+	colon := strings.LastIndexByte(hostport, ':')
+	if !ok || colon < 0 {
+		return fmt.Errorf("invalid address %s", args[1])
+	}
+	var err1, err2 error
+	n.ip = strings.Trim(hostport[:colon], "[]")
+	n.port, err1 = strconv.Atoi(hostport[colon+1:])
+	n.busPort, err2 = strconv.Atoi(bus)
+	if err1 != nil || err2 != nil {
+		return fmt.Errorf("invalid address %s", args[1])
+	}
+	for _, name := range strings.Split(args[2], ",") {
+		for _, fn := range nodeFlagNames {
+			if fn.name == name {
+				n.flags |= fn.flag
+			}
+		}
+	}
+	if n.has(nodeMyself) {
+		c.myself = n
+	}
+	if n.has(nodeFail) {
+		n.failTime = time.Now()
+	}
+	if args[3] != "-" {
+		masters[n] = args[3]
+	}
+	epoch, err := strconv.ParseUint(args[6], 10, 64)
+	if err != nil {
+		return err
+	}
+	n.configEpoch = epoch
+	for _, arg := range args[8:] {
+		if strings.HasPrefix(arg, "[") {
+			// [slot->-id] is migrating to id, [slot-<-id] importing from it
+			arg = strings.Trim(arg, "[]")
+			slotArg, id, migrating := strings.Cut(arg, "->-")
+			if !migrating {
+				slotArg, id, _ = strings.Cut(arg, "-<-")
+			}
+			slot, err := strconv.Atoi(slotArg)
+			if err != nil || slot < 0 || slot >= utils.ClusterSlots {
+				return fmt.Errorf("invalid slot %s", arg)
+			}
+			if migrating {
+				c.migrating[slot] = node(id)
+			} else {
+				c.importing[slot] = node(id)
+			}
+			continue
+		}
+		first, last, isRange := strings.Cut(arg, "-")
+		start, err1 := strconv.Atoi(first)
+		end := start
+		var err2 error
+		if isRange {
+			end, err2 = strconv.Atoi(last)
+		}
+		if err1 != nil || err2 != nil || start < 0 || end >= utils.ClusterSlots || start > end {
+			return fmt.Errorf("invalid slot %s", arg)
+		}
+		for slot := start; slot <= end; slot++ {
+			s.clusterAddSlot(n, slot)
+		}
+	}
+	return nil
+}
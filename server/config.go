@@ -0,0 +1,722 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"go-redis/pkg/utils"
+	"go-redis/repo"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultPort is the port the server has always listened on.
+const defaultPort = 50001
+
+// Config is the server configuration. Start from DefaultConfig, the zero
+// value is not usable.
+type Config struct {
+	// Bind lists the addresses to listen on, every interface when empty.
+	// "*" and "::*" stand for every IPv4 and IPv6 interface, a leading "-"
+	// makes an address optional.
+	Bind []string
+	// Port is the TCP port, 0 disables TCP.
+	Port int
+	// UnixSocket is the path of a unix socket to listen on as well.
+	UnixSocket     string
+	UnixSocketPerm os.FileMode
+	MaxClients     int
+	// Timeout closes clients idle for that long, 0 disables it.
+	Timeout time.Duration
+	// TCPKeepAlive is the period of TCP keepalive probes, 0 disables them.
+	TCPKeepAlive time.Duration
+
+	// Dir is the working directory persistence files are written to.
+	Dir        string
+	DBFilename string
+	// Save lists the rules triggering a background save, none disables
+	// snapshotting.
+	Save []SaveRule
+
+	// AppendOnly logs every write to the AOF.
+	AppendOnly bool
+	// AppendDirname is the directory, within Dir, holding the files of
+	// the AOF, named after AppendFilename.
+	AppendDirname  string
+	AppendFilename string
+	AppendFsync    string
+	// AOFUseRDBPreamble writes the base file of a rewritten AOF as an RDB
+	// snapshot rather than as commands.
+	AOFUseRDBPreamble bool
+	// AutoAOFRewritePercentage rewrites the AOF once it grew by that much
+	// since the last rewrite, if it is AutoAOFRewriteMinSize bytes at
+	// least. 0 disables automatic rewrites.
+	AutoAOFRewritePercentage int
+	AutoAOFRewriteMinSize    int64
+	// AOFLoadTruncated loads an AOF whose last command was cut short, like
+	// after a crash, instead of refusing to start.
+	AOFLoadTruncated bool
+
+	// Hz is how many times per second background tasks such as the active
+	// expire cycle run.
+	Hz int
+	// ActiveExpireEffort, from 1 to 10, trades CPU for memory held by
+	// expired keys nobody reads.
+	ActiveExpireEffort int
+
+	// MaxMemory is the memory limit in bytes, 0 means no limit.
+	MaxMemory        int64
+	MaxMemoryPolicy  string
+	MaxMemorySamples int
+	// LFULogFactor and LFUDecayTime tune the access frequency counter of
+	// the lfu policies.
+	LFULogFactor int
+	LFUDecayTime time.Duration
+
+	// MasterHost and MasterPort are the master this server replicates, a
+	// master has no MasterHost. REPLICAOF changes them.
+	MasterHost string
+	MasterPort int
+	// ReplicaReadOnly refuses writes from clients on a replica.
+	ReplicaReadOnly bool
+	// ReplBacklogSize is the size of the replication backlog, the recent
+	// stream kept so a replica that reconnects gets what it missed. It is
+	// freed after ReplBacklogTTL without replicas, 0 keeps it forever.
+	ReplBacklogSize int64
+	ReplBacklogTTL  time.Duration
+	// ReplTimeout drops a replication link silent for that long.
+	ReplTimeout time.Duration
+	// ReplPingReplicaPeriod is how often a master pings its replicas
+	// through the stream, so they know the link is alive.
+	ReplPingReplicaPeriod time.Duration
+
+	// ClusterEnabled makes the server a node of a Redis Cluster, whose
+	// state is kept in ClusterConfigFile within Dir. The nodes talk on
+	// the cluster bus, on ClusterPort or the port plus 10000 when 0.
+	ClusterEnabled    bool
+	ClusterConfigFile string
+	ClusterPort       int
+	// ClusterNodeTimeout is how long a node may not answer before it is
+	// considered failing.
+	ClusterNodeTimeout time.Duration
+	// ClusterRequireFullCoverage stops serving queries while some slot is
+	// not served.
+	ClusterRequireFullCoverage bool
+
+	// SlowlogLogSlowerThan is the execution time from which a command is
+	// logged in the slow log, negative disables the slow log.
+	SlowlogLogSlowerThan time.Duration
+	SlowlogMaxLen        int
+
+	LogLevel string
+	// LogFile is the file logs are appended to, standard output when empty.
+	LogFile string
+
+	// OutputBufferLimits overrides the default output buffer limit of
+	// a client class.
+	OutputBufferLimits map[ClientClass]OutputBufferLimit
+
+	// File is the configuration file the server was started from, CONFIG
+	// REWRITE writes to it.
+	File string
+}
+
+// SaveRule saves the dataset once Changes writes happened within Interval.
+type SaveRule struct {
+	Interval time.Duration
+	Changes  int
+}
+
+// DefaultConfig returns the configuration used when no file or flag
+// overrides it.
+func DefaultConfig() Config {
+	limits := make(map[ClientClass]OutputBufferLimit, len(defaultOutputBufferLimits))
+	for class, limit := range defaultOutputBufferLimits {
+		limits[class] = limit
+	}
+	return Config{
+		Port:         defaultPort,
+		MaxClients:   10000,
+		TCPKeepAlive: 300 * time.Second,
+		Dir:          ".",
+		DBFilename:   "dump.rdb",
+		Save: []SaveRule{
+			{Interval: 3600 * time.Second, Changes: 1},
+			{Interval: 300 * time.Second, Changes: 100},
+			{Interval: 60 * time.Second, Changes: 10000},
+		},
+		AppendDirname:              "appendonlydir",
+		AppendFilename:             "appendonly.aof",
+		AppendFsync:                "everysec",
+		AOFLoadTruncated:           true,
+		AOFUseRDBPreamble:          true,
+		AutoAOFRewritePercentage:   100,
+		AutoAOFRewriteMinSize:      64 << 20,
+		Hz:                         10,
+		ActiveExpireEffort:         1,
+		MaxMemoryPolicy:            "noeviction",
+		MaxMemorySamples:           5,
+		LFULogFactor:               10,
+		LFUDecayTime:               time.Minute,
+		ReplicaReadOnly:            true,
+		ReplBacklogSize:            1 << 20,
+		ReplBacklogTTL:             3600 * time.Second,
+		ReplTimeout:                60 * time.Second,
+		ReplPingReplicaPeriod:      10 * time.Second,
+		ClusterConfigFile:          "nodes.conf",
+		ClusterNodeTimeout:         15 * time.Second,
+		ClusterRequireFullCoverage: true,
+		SlowlogLogSlowerThan:       10 * time.Millisecond,
+		SlowlogMaxLen:              128,
+		LogLevel:                   "notice",
+		OutputBufferLimits:         limits,
+	}
+}
+
+var (
+	fsyncPolicies     = []string{"always", "everysec", "no"}
+	maxMemoryPolicies = []string{
+		"volatile-lru", "allkeys-lru", "volatile-lfu", "allkeys-lfu",
+		"volatile-random", "allkeys-random", "volatile-ttl", "noeviction",
+	}
+	logLevels = []string{"debug", "verbose", "notice", "warning", "nothing"}
+)
+
+// Validate reports the first setting that is out of range or inconsistent
+// with the others.
+func (c *Config) Validate() error {
+	if c.Port < 0 || c.Port > 65535 {
+		return fmt.Errorf("invalid port %d", c.Port)
+	}
+	if c.Port == 0 && c.UnixSocket == "" {
+		return fmt.Errorf("port is 0 and no unixsocket is set, nothing to listen on")
+	}
+	if c.MaxClients < 1 {
+		return fmt.Errorf("maxclients must be at least 1")
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("timeout can't be negative")
+	}
+	if c.TCPKeepAlive < 0 {
+		return fmt.Errorf("tcp-keepalive can't be negative")
+	}
+	if info, err := os.Stat(c.Dir); err != nil {
+		return fmt.Errorf("can't use dir %s: %v", c.Dir, err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("dir %s is not a directory", c.Dir)
+	}
+	if !isFilename(c.DBFilename) {
+		return fmt.Errorf("dbfilename can't be a path, just a filename")
+	}
+	if !isFilename(c.AppendFilename) {
+		return fmt.Errorf("appendfilename can't be a path, just a filename")
+	}
+	if !isFilename(c.AppendDirname) {
+		return fmt.Errorf("appenddirname can't be a path, just a dirname")
+	}
+	if c.AutoAOFRewritePercentage < 0 || c.AutoAOFRewriteMinSize < 0 {
+		return fmt.Errorf("auto-aof-rewrite-percentage and auto-aof-rewrite-min-size can't be negative")
+	}
+	for _, rule := range c.Save {
+		if rule.Interval < time.Second || rule.Changes < 1 {
+			return fmt.Errorf("invalid save rule %v %d", rule.Interval, rule.Changes)
+		}
+	}
+	if !oneOf(c.AppendFsync, fsyncPolicies) {
+		return fmt.Errorf("invalid appendfsync %s, must be one of %s", c.AppendFsync, strings.Join(fsyncPolicies, ", "))
+	}
+	if c.Hz < 1 || c.Hz > 500 {
+		return fmt.Errorf("hz must be between 1 and 500")
+	}
+	if c.ActiveExpireEffort < 1 || c.ActiveExpireEffort > 10 {
+		return fmt.Errorf("active-expire-effort must be between 1 and 10")
+	}
+	if c.MaxMemory < 0 {
+		return fmt.Errorf("maxmemory can't be negative")
+	}
+	if !oneOf(c.MaxMemoryPolicy, maxMemoryPolicies) {
+		return fmt.Errorf("invalid maxmemory-policy %s, must be one of %s", c.MaxMemoryPolicy, strings.Join(maxMemoryPolicies, ", "))
+	}
+	if c.MaxMemorySamples < 1 || c.MaxMemorySamples > 64 {
+		return fmt.Errorf("maxmemory-samples must be between 1 and 64")
+	}
+	if c.LFULogFactor < 0 || c.LFUDecayTime < 0 {
+		return fmt.Errorf("lfu-log-factor and lfu-decay-time can't be negative")
+	}
+	if c.MasterHost != "" && (c.MasterPort < 1 || c.MasterPort > 65535) {
+		return fmt.Errorf("invalid master port %d", c.MasterPort)
+	}
+	if c.ReplBacklogSize < 1 || c.ReplBacklogTTL < 0 {
+		return fmt.Errorf("repl-backlog-size must be at least 1 and repl-backlog-ttl can't be negative")
+	}
+	if c.ReplTimeout < time.Second || c.ReplPingReplicaPeriod < time.Second {
+		return fmt.Errorf("repl-timeout and repl-ping-replica-period must be at least 1")
+	}
+	if !isFilename(c.ClusterConfigFile) {
+		return fmt.Errorf("cluster-config-file can't be a path, just a filename")
+	}
+	if c.ClusterPort < 0 || c.ClusterPort > 65535 || (c.ClusterEnabled && c.ClusterPort == 0 && c.Port+clusterBusPortIncr > 65535) {
+		return fmt.Errorf("invalid cluster-port %d", c.ClusterPort)
+	}
+	if c.ClusterEnabled && c.Port == 0 {
+		return fmt.Errorf("cluster-enabled requires a TCP port")
+	}
+	if c.ClusterNodeTimeout < time.Millisecond {
+		return fmt.Errorf("cluster-node-timeout must be at least 1")
+	}
+	if c.SlowlogMaxLen < 0 {
+		return fmt.Errorf("slowlog-max-len can't be negative")
+	}
+	if !oneOf(c.LogLevel, logLevels) {
+		return fmt.Errorf("invalid loglevel %s, must be one of %s", c.LogLevel, strings.Join(logLevels, ", "))
+	}
+	for class, limit := range c.OutputBufferLimits {
+		if limit.Hard < 0 || limit.Soft < 0 || limit.SoftSeconds < 0 {
+			return fmt.Errorf("invalid client-output-buffer-limit for class %s", class)
+		}
+	}
+	return nil
+}
+
+func (c *Config) memoryConfig() repo.MemoryConfig {
+	return repo.MemoryConfig{
+		MaxMemory:    c.MaxMemory,
+		Policy:       c.MaxMemoryPolicy,
+		Samples:      c.MaxMemorySamples,
+		LFULogFactor: c.LFULogFactor,
+		LFUDecayTime: c.LFUDecayTime,
+	}
+}
+
+func isFilename(name string) bool {
+	return name != "" && filepath.Base(name) == name && name != "." && name != ".."
+}
+
+func oneOf(s string, values []string) bool {
+	for _, v := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// configParam is a directive of the configuration file. set parses its
+// arguments into the config and get formats them back, immutable ones can
+// only be set at startup. The CONFIG SET value of a multiArg directive is
+// split into arguments, other directives take it whole. alias is the name
+// the directive had before Redis renamed slaves replicas.
+type configParam struct {
+	name      string
+	alias     string
+	set       func(c *Config, args []string) error
+	get       func(c *Config) []string
+	immutable bool
+	multiArg  bool
+}
+
+var configParams = []configParam{
+	{
+		name: "bind",
+		set: func(c *Config, args []string) error {
+			c.Bind = append([]string(nil), args...)
+			return nil
+		},
+		get:       func(c *Config) []string { return c.Bind },
+		immutable: true,
+		multiArg:  true,
+	},
+	immutable(intParam("port", func(c *Config) *int { return &c.Port })),
+	immutable(stringParam("unixsocket", func(c *Config) *string { return &c.UnixSocket })),
+	{
+		name: "unixsocketperm",
+		set: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return errConfigArgs
+			}
+			perm, err := strconv.ParseUint(args[0], 8, 32)
+			if err != nil || perm > 0o777 {
+				return fmt.Errorf("argument must be an octal file mode")
+			}
+			c.UnixSocketPerm = os.FileMode(perm)
+			return nil
+		},
+		get: func(c *Config) []string {
+			return []string{strconv.FormatUint(uint64(c.UnixSocketPerm), 8)}
+		},
+		immutable: true,
+	},
+	intParam("maxclients", func(c *Config) *int { return &c.MaxClients }),
+	durationParam("timeout", time.Second, func(c *Config) *time.Duration { return &c.Timeout }),
+	immutable(durationParam("tcp-keepalive", time.Second, func(c *Config) *time.Duration { return &c.TCPKeepAlive })),
+	stringParam("dir", func(c *Config) *string { return &c.Dir }),
+	stringParam("dbfilename", func(c *Config) *string { return &c.DBFilename }),
+	{
+		name: "save",
+		set: func(c *Config, args []string) error {
+			if len(args) == 1 && args[0] == "" {
+				c.Save = nil
+				return nil
+			}
+			if len(args) == 0 || len(args)%2 != 0 {
+				return errConfigArgs
+			}
+			rules := make([]SaveRule, 0, len(args)/2)
+			for i := 0; i < len(args); i += 2 {
+				secs, err1 := strconv.Atoi(args[i])
+				changes, err2 := strconv.Atoi(args[i+1])
+				if err1 != nil || err2 != nil {
+					return fmt.Errorf("invalid save parameters")
+				}
+				rules = append(rules, SaveRule{Interval: time.Duration(secs) * time.Second, Changes: changes})
+			}
+			c.Save = rules
+			return nil
+		},
+		get: func(c *Config) []string {
+			if len(c.Save) == 0 {
+				return []string{""}
+			}
+			var args []string
+			for _, rule := range c.Save {
+				args = append(args, strconv.Itoa(int(rule.Interval/time.Second)), strconv.Itoa(rule.Changes))
+			}
+			return args
+		},
+		multiArg: true,
+	},
+	boolParam("appendonly", func(c *Config) *bool { return &c.AppendOnly }),
+	immutable(stringParam("appenddirname", func(c *Config) *string { return &c.AppendDirname })),
+	immutable(stringParam("appendfilename", func(c *Config) *string { return &c.AppendFilename })),
+	enumParam("appendfsync", func(c *Config) *string { return &c.AppendFsync }),
+	boolParam("aof-load-truncated", func(c *Config) *bool { return &c.AOFLoadTruncated }),
+	boolParam("aof-use-rdb-preamble", func(c *Config) *bool { return &c.AOFUseRDBPreamble }),
+	intParam("auto-aof-rewrite-percentage", func(c *Config) *int { return &c.AutoAOFRewritePercentage }),
+	memoryParam("auto-aof-rewrite-min-size", func(c *Config) *int64 { return &c.AutoAOFRewriteMinSize }),
+	intParam("hz", func(c *Config) *int { return &c.Hz }),
+	intParam("active-expire-effort", func(c *Config) *int { return &c.ActiveExpireEffort }),
+	memoryParam("maxmemory", func(c *Config) *int64 { return &c.MaxMemory }),
+	enumParam("maxmemory-policy", func(c *Config) *string { return &c.MaxMemoryPolicy }),
+	intParam("maxmemory-samples", func(c *Config) *int { return &c.MaxMemorySamples }),
+	intParam("lfu-log-factor", func(c *Config) *int { return &c.LFULogFactor }),
+	durationParam("lfu-decay-time", time.Minute, func(c *Config) *time.Duration { return &c.LFUDecayTime }),
+	{
+		// replicaof <host> <port>, or "no one" for a master
+		name:  "replicaof",
+		alias: "slaveof",
+		set: func(c *Config, args []string) error {
+			if len(args) == 1 && args[0] == "" {
+				c.MasterHost, c.MasterPort = "", 0
+				return nil
+			}
+			if len(args) != 2 {
+				return errConfigArgs
+			}
+			if strings.EqualFold(args[0], "no") && strings.EqualFold(args[1], "one") {
+				c.MasterHost, c.MasterPort = "", 0
+				return nil
+			}
+			port, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("Invalid master port")
+			}
+			c.MasterHost, c.MasterPort = args[0], port
+			return nil
+		},
+		get: func(c *Config) []string {
+			if c.MasterHost == "" {
+				return []string{""}
+			}
+			return []string{c.MasterHost, strconv.Itoa(c.MasterPort)}
+		},
+		immutable: true,
+		multiArg:  true,
+	},
+	withAlias(boolParam("replica-read-only", func(c *Config) *bool { return &c.ReplicaReadOnly }), "slave-read-only"),
+	memoryParam("repl-backlog-size", func(c *Config) *int64 { return &c.ReplBacklogSize }),
+	durationParam("repl-backlog-ttl", time.Second, func(c *Config) *time.Duration { return &c.ReplBacklogTTL }),
+	durationParam("repl-timeout", time.Second, func(c *Config) *time.Duration { return &c.ReplTimeout }),
+	withAlias(durationParam("repl-ping-replica-period", time.Second, func(c *Config) *time.Duration { return &c.ReplPingReplicaPeriod }), "repl-ping-slave-period"),
+	immutable(boolParam("cluster-enabled", func(c *Config) *bool { return &c.ClusterEnabled })),
+	immutable(stringParam("cluster-config-file", func(c *Config) *string { return &c.ClusterConfigFile })),
+	immutable(intParam("cluster-port", func(c *Config) *int { return &c.ClusterPort })),
+	durationParam("cluster-node-timeout", time.Millisecond, func(c *Config) *time.Duration { return &c.ClusterNodeTimeout }),
+	boolParam("cluster-require-full-coverage", func(c *Config) *bool { return &c.ClusterRequireFullCoverage }),
+	durationParam("slowlog-log-slower-than", time.Microsecond, func(c *Config) *time.Duration { return &c.SlowlogLogSlowerThan }),
+	intParam("slowlog-max-len", func(c *Config) *int { return &c.SlowlogMaxLen }),
+	enumParam("loglevel", func(c *Config) *string { return &c.LogLevel }),
+	immutable(stringParam("logfile", func(c *Config) *string { return &c.LogFile })),
+	{
+		// client-output-buffer-limit <class> <hard> <soft> <soft seconds>,
+		// repeated for as many classes as needed
+		name: "client-output-buffer-limit",
+		set: func(c *Config, args []string) error {
+			if len(args) == 0 || len(args)%4 != 0 {
+				return errConfigArgs
+			}
+			limits := make(map[ClientClass]OutputBufferLimit, len(c.OutputBufferLimits))
+			for class, limit := range c.OutputBufferLimits {
+				limits[class] = limit
+			}
+			for ; len(args) > 0; args = args[4:] {
+				class, ok := parseClientClass(args[0])
+				if !ok {
+					return fmt.Errorf("invalid client class specified in buffer limit configuration")
+				}
+				hard, err1 := utils.ParseMemory(args[1])
+				soft, err2 := utils.ParseMemory(args[2])
+				secs, err3 := strconv.Atoi(args[3])
+				if err1 != nil || err2 != nil || err3 != nil || secs < 0 {
+					return fmt.Errorf("error in hard, soft or soft_seconds setting in buffer limit configuration")
+				}
+				limits[class] = OutputBufferLimit{Hard: hard, Soft: soft, SoftSeconds: time.Duration(secs) * time.Second}
+			}
+			c.OutputBufferLimits = limits
+			return nil
+		},
+		get: func(c *Config) []string {
+			var args []string
+			for _, class := range []ClientClass{ClientNormal, ClientReplica, ClientPubsub} {
+				limit := c.OutputBufferLimits[class]
+				args = append(args, class.String(),
+					strconv.FormatInt(limit.Hard, 10),
+					strconv.FormatInt(limit.Soft, 10),
+					strconv.Itoa(int(limit.SoftSeconds/time.Second)))
+			}
+			return args
+		},
+		multiArg: true,
+	},
+}
+
+var errConfigArgs = fmt.Errorf("wrong number of arguments")
+
+func parseClientClass(name string) (ClientClass, bool) {
+	switch strings.ToLower(name) {
+	case "normal":
+		return ClientNormal, true
+	case "replica", "slave":
+		return ClientReplica, true
+	case "pubsub":
+		return ClientPubsub, true
+	}
+	return 0, false
+}
+
+func immutable(p configParam) configParam {
+	p.immutable = true
+	return p
+}
+
+func withAlias(p configParam, alias string) configParam {
+	p.alias = alias
+	return p
+}
+
+func intParam(name string, field func(*Config) *int) configParam {
+	return configParam{
+		name: name,
+		set: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return errConfigArgs
+			}
+			n, err := strconv.Atoi(args[0])
+			if err != nil {
+				return fmt.Errorf("argument couldn't be parsed into an integer")
+			}
+			*field(c) = n
+			return nil
+		},
+		get: func(c *Config) []string { return []string{strconv.Itoa(*field(c))} },
+	}
+}
+
+// durationParam is an integer directive counting units.
+func durationParam(name string, unit time.Duration, field func(*Config) *time.Duration) configParam {
+	return configParam{
+		name: name,
+		set: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return errConfigArgs
+			}
+			n, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("argument couldn't be parsed into an integer")
+			}
+			*field(c) = time.Duration(n) * unit
+			return nil
+		},
+		get: func(c *Config) []string { return []string{strconv.FormatInt(int64(*field(c)/unit), 10)} },
+	}
+}
+
+// memoryParam is a size in bytes, with an optional unit like 64mb.
+func memoryParam(name string, field func(*Config) *int64) configParam {
+	return configParam{
+		name: name,
+		set: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return errConfigArgs
+			}
+			n, err := utils.ParseMemory(args[0])
+			if err != nil {
+				return err
+			}
+			*field(c) = n
+			return nil
+		},
+		get: func(c *Config) []string { return []string{strconv.FormatInt(*field(c), 10)} },
+	}
+}
+
+func stringParam(name string, field func(*Config) *string) configParam {
+	return configParam{
+		name: name,
+		set: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return errConfigArgs
+			}
+			*field(c) = args[0]
+			return nil
+		},
+		get: func(c *Config) []string { return []string{*field(c)} },
+	}
+}
+
+// enumParam is a string directive whose allowed values are checked by
+// Validate, they are matched case-insensitively.
+func enumParam(name string, field func(*Config) *string) configParam {
+	p := stringParam(name, field)
+	set := p.set
+	p.set = func(c *Config, args []string) error {
+		if err := set(c, args); err != nil {
+			return err
+		}
+		*field(c) = strings.ToLower(*field(c))
+		return nil
+	}
+	return p
+}
+
+func boolParam(name string, field func(*Config) *bool) configParam {
+	return configParam{
+		name: name,
+		set: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return errConfigArgs
+			}
+			switch strings.ToLower(args[0]) {
+			case "yes":
+				*field(c) = true
+			case "no":
+				*field(c) = false
+			default:
+				return fmt.Errorf("argument must be 'yes' or 'no'")
+			}
+			return nil
+		},
+		get: func(c *Config) []string {
+			if *field(c) {
+				return []string{"yes"}
+			}
+			return []string{"no"}
+		},
+	}
+}
+
+func lookupConfigParam(name string) (*configParam, bool) {
+	name = strings.ToLower(name)
+	for i := range configParams {
+		p := &configParams[i]
+		if p.name == name || (p.alias != "" && p.alias == name) {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// Set applies a configuration directive, as written in the configuration
+// file. Values are only checked for syntax, Validate checks their range.
+func (c *Config) Set(name string, args ...string) error {
+	param, ok := lookupConfigParam(name)
+	if !ok {
+		return fmt.Errorf("Bad directive or wrong number of arguments")
+	}
+	return param.set(c, args)
+}
+
+// ConfigError locates a bad directive in the configuration file or on the
+// command line.
+type ConfigError struct {
+	Source string
+	Line   int
+	Text   string
+	Err    error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("Reading the configuration %s, at line %d\n>>> '%s'\n%v", e.Source, e.Line, e.Text, e.Err)
+}
+
+// Load applies every directive of a redis.conf style file. Blank lines and
+// lines starting with # are ignored, arguments may be quoted.
+func (c *Config) Load(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		args, err := utils.SplitArgs(line)
+		if err == nil && len(args) > 0 {
+			err = c.Set(args[0], args[1:]...)
+		}
+		if err != nil {
+			return &ConfigError{Source: "file", Line: n, Text: line, Err: err}
+		}
+	}
+	return sc.Err()
+}
+
+// LoadConfig builds the configuration from command line arguments, in the
+// form of redis-server: an optional configuration file followed by
+// directives written as flags, like --port 6380 --save "". Flags override
+// the file, the result is validated.
+func LoadConfig(args []string) (Config, error) {
+	conf := DefaultConfig()
+	if len(args) > 0 && !strings.HasPrefix(args[0], "--") {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return conf, fmt.Errorf("Fatal error, can't open config file '%s': %v", args[0], err)
+		}
+		err = conf.Load(f)
+		f.Close()
+		if err != nil {
+			return conf, err
+		}
+		conf.File = args[0]
+		args = args[1:]
+	}
+	for n := 1; len(args) > 0; n++ {
+		if !strings.HasPrefix(args[0], "--") || len(args[0]) == 2 {
+			return conf, &ConfigError{Source: "command line", Line: n, Text: args[0], Err: fmt.Errorf("expected a --directive")}
+		}
+		end := 1
+		for end < len(args) && !strings.HasPrefix(args[end], "--") {
+			end++
+		}
+		name, values := args[0][2:], args[1:end]
+		if err := conf.Set(name, values...); err != nil {
+			text := strings.Join(append([]string{name}, values...), " ")
+			return conf, &ConfigError{Source: "command line", Line: n, Text: text, Err: err}
+		}
+		args = args[end:]
+	}
+	if err := conf.Validate(); err != nil {
+		return conf, err
+	}
+	return conf, nil
+}
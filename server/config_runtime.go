@@ -0,0 +1,269 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"go-redis/pkg/utils"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// logLevel is the level of the logger installed by SetupLogging, CONFIG
+// SET loglevel changes it.
+var logLevel = new(slog.LevelVar)
+
+// SetupLogging points the default logger at the configured log file, with
+// the configured level.
+func SetupLogging(conf Config) error {
+	out := os.Stdout
+	if conf.LogFile != "" {
+		f, err := os.OpenFile(conf.LogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return err
+		}
+		out = f
+	}
+	logLevel.Set(slogLevel(conf.LogLevel))
+	slog.SetDefault(slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: logLevel})))
+	return nil
+}
+
+func slogLevel(level string) slog.Level {
+	switch level {
+	case "debug", "verbose":
+		return slog.LevelDebug
+	case "warning":
+		return slog.LevelWarn
+	case "nothing":
+		return slog.LevelError + 4
+	}
+	return slog.LevelInfo
+}
+
+// clone copies c, the copy shares nothing with it.
+func (c *Config) clone() Config {
+	conf := *c
+	conf.Bind = append([]string(nil), c.Bind...)
+	conf.Save = append([]SaveRule(nil), c.Save...)
+	conf.OutputBufferLimits = make(map[ClientClass]OutputBufferLimit, len(c.OutputBufferLimits))
+	for class, limit := range c.OutputBufferLimits {
+		conf.OutputBufferLimits[class] = limit
+	}
+	return conf
+}
+
+// ConfigGet matches the patterns against the names of the parameters and
+// their aliases, each name matched is replied with the value.
+func (s *Server) ConfigGet(patterns []string) []string {
+	var pairs []string
+	for _, param := range configParams {
+		for _, name := range []string{param.name, param.alias} {
+			if name == "" {
+				continue
+			}
+			for _, pattern := range patterns {
+				if utils.GlobMatch(pattern, name, true) {
+					pairs = append(pairs, name, strings.Join(param.get(&s.config), " "))
+					break
+				}
+			}
+		}
+	}
+	return pairs
+}
+
+func (s *Server) ConfigSet(pairs []string) error {
+	conf := s.config.clone()
+	seen := make(map[string]bool)
+	for i := 0; i < len(pairs); i += 2 {
+		param, ok := lookupConfigParam(pairs[i])
+		if !ok {
+			return fmt.Errorf("Unknown option or number of arguments for CONFIG SET - '%s'", pairs[i])
+		}
+		if param.immutable {
+			return errConfigSet(param.name, "can't set immutable config")
+		}
+		if seen[param.name] {
+			return errConfigSet(param.name, "duplicate parameter")
+		}
+		seen[param.name] = true
+		args := []string{pairs[i+1]}
+		if param.multiArg && pairs[i+1] != "" {
+			var err error
+			if args, err = utils.SplitArgs(pairs[i+1]); err != nil {
+				return errConfigSet(param.name, err.Error())
+			}
+		}
+		if err := param.set(&conf, args); err != nil {
+			return errConfigSet(param.name, err.Error())
+		}
+	}
+	if err := conf.Validate(); err != nil {
+		return fmt.Errorf("CONFIG SET failed - %v", err)
+	}
+	s.applyConfig(conf)
+	switch {
+	case conf.AppendOnly && s.aof.file == nil:
+		if err := s.startAppendOnly(); err != nil {
+			s.config.AppendOnly = false
+			return errConfigSet("appendonly", err.Error())
+		}
+	case !conf.AppendOnly && s.aof.file != nil:
+		if err := s.stopAppendOnly(); err != nil {
+			slog.Warn("Error closing the append only file", "err", err)
+		}
+	}
+	return nil
+}
+
+// errConfigSet is the error of a parameter CONFIG SET can't set.
+func errConfigSet(name, reason string) error {
+	return fmt.Errorf("CONFIG SET failed (possibly related to argument '%s') - %s", name, reason)
+}
+
+// applyConfig switches the running server to conf, the settings read
+// outside the loop are published atomically.
+func (s *Server) applyConfig(conf Config) {
+	if conf.Hz != s.config.Hz {
+		s.cron.Reset(time.Second / time.Duration(conf.Hz))
+	}
+	s.config = conf
+	s.maxClients.Store(int64(conf.MaxClients))
+	s.idleTimeout.Store(int64(conf.Timeout))
+	logLevel.Set(slogLevel(conf.LogLevel))
+	for peer := range s.peers {
+		peer.limit = conf.OutputBufferLimits[peer.class]
+	}
+	s.slowlog.trim(conf.SlowlogMaxLen)
+	if b := s.repl.backlog; b != nil && max(conf.ReplBacklogSize, replBacklogMinSize) != int64(len(b.buf)) {
+		s.repl.backlog = b.resize(conf.ReplBacklogSize)
+	}
+	s.db.SetMemoryConfig(conf.memoryConfig())
+	evicted, err := s.db.FreeMemory()
+	for _, key := range evicted {
+		s.propagate("DEL", key)
+	}
+	if err != nil {
+		slog.Warn("The new maxmemory value set via CONFIG SET is smaller than the current memory usage", "maxmemory", conf.MaxMemory)
+	}
+}
+
+// ConfigRewrite writes the running configuration to the file the server
+// was started from. Comments and unknown lines are kept, known directives
+// are updated in place and the ones missing from the file are appended
+// when they differ from the default.
+func (s *Server) ConfigRewrite() error {
+	if s.config.File == "" {
+		return fmt.Errorf("The server is running without a config file")
+	}
+	if err := rewriteConfig(s.config.File, &s.config); err != nil {
+		slog.Warn("CONFIG REWRITE failed", "err", err)
+		return fmt.Errorf("Rewriting config file: %v", err)
+	}
+	slog.Info("CONFIG REWRITE executed with success.")
+	return nil
+}
+
+// rewriteMarker starts the directives appended by CONFIG REWRITE.
+const rewriteMarker = "# Generated by CONFIG REWRITE"
+
+func rewriteConfig(path string, conf *Config) error {
+	old, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	var out []string
+	written := make(map[string]bool)
+	sc := bufio.NewScanner(bytes.NewReader(old))
+	for sc.Scan() {
+		line := sc.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == rewriteMarker {
+			continue
+		}
+		if trimmed == "" || trimmed[0] == '#' {
+			out = append(out, line)
+			continue
+		}
+		args, err := utils.SplitArgs(trimmed)
+		if err != nil || len(args) == 0 {
+			out = append(out, line)
+			continue
+		}
+		param, ok := lookupConfigParam(args[0])
+		if !ok {
+			out = append(out, line)
+			continue
+		}
+		if written[param.name] {
+			// a directive given several times is collapsed into one line
+			continue
+		}
+		written[param.name] = true
+		out = append(out, formatConfigLine(param, conf))
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	defaults := DefaultConfig()
+	marked := false
+	for i := range configParams {
+		param := &configParams[i]
+		if written[param.name] {
+			continue
+		}
+		if formatConfigLine(param, conf) == formatConfigLine(param, &defaults) {
+			continue
+		}
+		if !marked {
+			out = append(out, rewriteMarker)
+			marked = true
+		}
+		out = append(out, formatConfigLine(param, conf))
+	}
+	return writeFileAtomic(path, []byte(strings.Join(out, "\n")+"\n"))
+}
+
+func formatConfigLine(param *configParam, conf *Config) string {
+	var sb strings.Builder
+	sb.WriteString(param.name)
+	for _, arg := range param.get(conf) {
+		sb.WriteByte(' ')
+		sb.WriteString(utils.QuoteArg(arg))
+	}
+	return sb.String()
+}
+
+// writeFileAtomic replaces path with data, readers see either the old or
+// the new content.
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
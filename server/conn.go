@@ -1,51 +1,312 @@
 package server
 
 import (
+	"go-redis/command"
+	"log/slog"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/tidwall/resp"
+)
+
+const (
+	// readBufSize is how much is read from the socket at once, frames larger
+	// than this are accumulated by the parser across reads.
+	readBufSize = 16 * 1024
 )
 
+var _ command.Client = (*Conn)(nil)
+
+// nextConnID hands out unique client ids, as reported by HELLO.
+var nextConnID atomic.Int64
+
 type Conn struct {
+	id         int64
+	name       string
 	addr       string
 	conn       net.Conn
 	createTime time.Time
 	msgCh      chan Message
+	serverDone <-chan struct{}
+	// idleTimeout closes the connection once the client is idle that long,
+	// it is shared with the server which may change it at any time
+	idleTimeout *atomic.Int64
+	parser      *command.Parser
+	reply       ReplyWriter
+
+	class ClientClass
+	limit OutputBufferLimit
+	// replica is set once the client announced itself as a replica.
+	replica *replica
+	// raw makes read keep the bytes of every frame in the messages, for
+	// the link to the master whose stream is relayed as received.
+	raw bool
+	// woff is the replication offset after the last write of the client,
+	// what WAIT waits for.
+	woff int64
+	// wait is set while the client is blocked by WAIT or WAITAOF, the
+	// messages it sends meanwhile are deferred.
+	wait     *waitRequest
+	deferred []Message
+	// asking lets the next command use a slot being imported, readOnly
+	// lets a replica in cluster mode serve reads of its master's slots.
+	asking   bool
+	readOnly bool
+	// softLimitSince is when the output queue went over the soft limit,
+	// zero while it is below.
+	softLimitSince time.Time
+
+	// the output queue is filled by the server loop and drained by the
+	// connection's writer goroutine, so a slow client never blocks the loop
+	mu           sync.Mutex
+	pending      [][]byte
+	pendingBytes int64
+	// uncounted is the part of pending left out of pendingBytes.
+	uncounted  int64
+	closeAfter bool
+	wakeCh     chan struct{}
+	closeCh    chan struct{}
+	closeOnce  sync.Once
 }
 
-func NewConn(conn net.Conn, msgCh chan Message) Conn {
-	c := Conn{
+func NewConn(conn net.Conn, msgCh chan Message) *Conn {
+	c := &Conn{
+		id:         nextConnID.Add(1),
 		addr:       conn.RemoteAddr().String(),
 		conn:       conn,
 		createTime: time.Now(),
 		msgCh:      msgCh,
+		parser:     command.NewParser(),
+		wakeCh:     make(chan struct{}, 1),
+		closeCh:    make(chan struct{}),
 	}
 	return c
 }
 
+func (c *Conn) ID() int64 {
+	return c.id
+}
+
+func (c *Conn) Name() string {
+	return c.name
+}
+
+func (c *Conn) SetName(name string) {
+	c.name = name
+}
+
+// SetProtocol switches the RESP version replies are encoded with.
+func (c *Conn) SetProtocol(proto int) {
+	c.reply.SetProtocol(proto)
+}
+
 func (c *Conn) read() error {
-	readBuf := make([]byte, 1024)
+	readBuf := make([]byte, readBufSize)
 	// write msg to client
-	go func() {
-		for {
-			select {}
-		}
-	}()
+	go c.writeLoop()
 	for {
+		// the parser may hold data from before the connection was handed
+		// over, like the start of the stream of a master
+		if err := c.dispatch(); err != nil {
+			return err
+		}
+		if c.idleTimeout != nil {
+			if timeout := time.Duration(c.idleTimeout.Load()); timeout > 0 {
+				c.conn.SetReadDeadline(time.Now().Add(timeout))
+			} else {
+				c.conn.SetReadDeadline(time.Time{})
+			}
+		}
 		count, err := c.conn.Read(readBuf)
 		if err != nil {
 			return err
 		}
-		msg := make([]byte, count)
-		copy(msg, readBuf[:count])
-		data := NewMessage(*c, msg)
-		c.msgCh <- data
+		c.parser.Feed(readBuf[:count])
+	}
+}
+
+// dispatch sends the complete frames buffered by the parser to the server
+// loop. A pipelining client sends many commands in one write, they are
+// handed over together so they run back to back and their replies go out
+// in a single write. A partial frame stays buffered until the rest of it
+// arrives.
+func (c *Conn) dispatch() error {
+	var (
+		vals []resp.Value
+		raws [][]byte
+	)
+	for {
+		val, raw, ok, err := c.next()
+		if err != nil {
+			// the commands before the bad frame still run, the error is
+			// replied after them and the connection closed
+			msg := NewMessage(c, vals)
+			msg.Raw = raws
+			msg.Err = err
+			c.send(msg)
+			return err
+		}
+		if !ok {
+			break
+		}
+		vals = append(vals, val)
+		if c.raw {
+			raws = append(raws, raw)
+		}
+	}
+	if len(vals) == 0 {
+		return nil
 	}
+	msg := NewMessage(c, vals)
+	msg.Raw = raws
+	if !c.send(msg) {
+		return net.ErrClosed
+	}
+	return nil
 }
 
+// next takes the next frame out of the parser, with its bytes when they
+// are kept.
+func (c *Conn) next() (resp.Value, []byte, bool, error) {
+	if c.raw {
+		return c.parser.NextRaw()
+	}
+	val, ok, err := c.parser.Next()
+	return val, nil, ok, err
+}
+
+// send hands msg to the server loop, it reports false when the server is
+// already shut down.
+func (c *Conn) send(msg Message) bool {
+	select {
+	case c.msgCh <- msg:
+		return true
+	case <-c.serverDone:
+		return false
+	}
+}
+
+// Write queues an already encoded reply, replies are sent in the order they
+// were queued when flush is called.
 func (c *Conn) Write(msg []byte) error {
-	_, err := c.conn.Write(msg)
-	if err != nil {
+	c.reply.buf = append(c.reply.buf, msg...)
+	return nil
+}
+
+// flush hands the buffered replies over to the writer goroutine. A client
+// whose output queue grows past its hard limit, or stays over its soft
+// limit for too long, is disconnected.
+func (c *Conn) flush() error {
+	if c.reply.Len() == 0 {
+		return nil
+	}
+	select {
+	case <-c.closeCh:
+		// nobody is left to read it
+		c.reply.reset()
+		return nil
+	default:
+	}
+	data := c.reply.buf
+	c.reply.buf = nil
+	queued := c.enqueue(data, true)
+	if err := c.checkOutputLimit(queued, time.Now()); err != nil {
+		c.Close()
 		return err
 	}
 	return nil
 }
+
+// enqueue hands data over to the writer goroutine after what is already
+// queued and returns the bytes queued that count towards the output buffer
+// limits. The RDB file of a full sync does not count, like in Redis.
+func (c *Conn) enqueue(data []byte, counted bool) int64 {
+	c.mu.Lock()
+	c.pending = append(c.pending, data)
+	if counted {
+		c.pendingBytes += int64(len(data))
+	} else {
+		c.uncounted += int64(len(data))
+	}
+	queued := c.pendingBytes
+	c.mu.Unlock()
+	select {
+	case c.wakeCh <- struct{}{}:
+	default:
+	}
+	return queued
+}
+
+func (c *Conn) checkOutputLimit(queued int64, now time.Time) error {
+	if c.limit.Hard > 0 && queued >= c.limit.Hard {
+		return errOutputLimit(c, "hard", queued)
+	}
+	if c.limit.Soft > 0 && queued >= c.limit.Soft {
+		if c.softLimitSince.IsZero() {
+			c.softLimitSince = now
+		} else if now.Sub(c.softLimitSince) >= c.limit.SoftSeconds {
+			return errOutputLimit(c, "soft", queued)
+		}
+		return nil
+	}
+	c.softLimitSince = time.Time{}
+	return nil
+}
+
+// closeAfterReply closes the connection once every queued reply is written.
+func (c *Conn) closeAfterReply() {
+	c.mu.Lock()
+	c.closeAfter = true
+	c.mu.Unlock()
+	select {
+	case c.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// writeLoop writes queued replies to the socket in order until the
+// connection is closed.
+func (c *Conn) writeLoop() {
+	for {
+		select {
+		case <-c.wakeCh:
+		case <-c.closeCh:
+			return
+		}
+		c.mu.Lock()
+		bufs := net.Buffers(c.pending)
+		uncounted := c.uncounted
+		c.pending = nil
+		c.uncounted = 0
+		closeAfter := c.closeAfter
+		c.mu.Unlock()
+		n := -uncounted
+		for _, b := range bufs {
+			n += int64(len(b))
+		}
+		if _, err := bufs.WriteTo(c.conn); err != nil {
+			slog.Error("fail to write reply", "addr", c.addr, "err", err)
+			c.Close()
+			return
+		}
+		c.mu.Lock()
+		c.pendingBytes -= n
+		c.mu.Unlock()
+		if closeAfter {
+			c.Close()
+			return
+		}
+	}
+}
+
+// Close closes the connection, replies still queued are dropped.
+func (c *Conn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closeCh)
+		err = c.conn.Close()
+	})
+	return err
+}
@@ -0,0 +1,37 @@
+package server
+
+import "time"
+
+// activeExpireCPU is the share of a cron period, in percent, the active
+// expire cycle may use at the lowest effort. Each effort level adds 2.
+const activeExpireCPU = 25
+
+// serverCron runs the background tasks, hz times per second.
+func (s *Server) serverCron() {
+	now := time.Now()
+	s.activeExpireCycle()
+	s.aofCron(now)
+	s.rdbCron(now)
+	s.replicationCron(now)
+	s.clusterCron(now)
+}
+
+// activeExpireCycle deletes expired keys within its share of the cron
+// period. A replica leaves them to the DEL of its master.
+func (s *Server) activeExpireCycle() {
+	if s.config.MasterHost != "" {
+		return
+	}
+	effort := s.config.ActiveExpireEffort
+	budget := time.Second * time.Duration(activeExpireCPU+2*(effort-1)) / time.Duration(s.config.Hz) / 100
+	s.db.ActiveExpireCycle(effort, time.Now().Add(budget))
+	s.propagateExpired()
+}
+
+// propagateExpired propagates a DEL for every key deleted because its time
+// to live was over.
+func (s *Server) propagateExpired() {
+	for _, key := range s.db.TakeExpired() {
+		s.propagate("DEL", key)
+	}
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"fmt"
+	"go-redis/command"
+	"go-redis/pkg/utils"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// infoSection is a section of the INFO reply, write appends its fields.
+type infoSection struct {
+	name  string
+	write func(s *Server, sb *strings.Builder)
+}
+
+var infoSections = []infoSection{
+	{name: "server", write: (*Server).infoServer},
+	{name: "clients", write: (*Server).infoClients},
+	{name: "memory", write: (*Server).infoMemory},
+	{name: "persistence", write: (*Server).infoPersistence},
+	{name: "stats", write: (*Server).infoStats},
+	{name: "replication", write: (*Server).infoReplication},
+	{name: "cluster", write: (*Server).infoCluster},
+	{name: "keyspace", write: (*Server).infoKeyspace},
+}
+
+// Info renders the requested INFO sections, every section for "all",
+// "everything" and "default" or when none is named. Unknown sections are
+// ignored.
+func (s *Server) Info(sections []string) string {
+	all := len(sections) == 0
+	wanted := make(map[string]bool, len(sections))
+	for _, name := range sections {
+		name = strings.ToLower(name)
+		switch name {
+		case "all", "everything", "default":
+			all = true
+		}
+		wanted[name] = true
+	}
+	var sb strings.Builder
+	for _, section := range infoSections {
+		if !all && !wanted[section.name] {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\r\n")
+		}
+		fmt.Fprintf(&sb, "# %s\r\n", strings.ToUpper(section.name[:1])+section.name[1:])
+		section.write(s, &sb)
+	}
+	return sb.String()
+}
+
+func infoField(sb *strings.Builder, name string, val any) {
+	fmt.Fprintf(sb, "%s:%v\r\n", name, val)
+}
+
+func (s *Server) infoServer(sb *strings.Builder) {
+	uptime := time.Since(s.startTime)
+	infoField(sb, "redis_version", command.ServerVersion)
+	infoField(sb, "redis_mode", "standalone")
+	infoField(sb, "os", runtime.GOOS+" "+runtime.GOARCH)
+	infoField(sb, "go_version", runtime.Version())
+	infoField(sb, "process_id", os.Getpid())
+	infoField(sb, "tcp_port", s.config.Port)
+	infoField(sb, "uptime_in_seconds", int64(uptime/time.Second))
+	infoField(sb, "uptime_in_days", int64(uptime/(24*time.Hour)))
+	infoField(sb, "hz", s.config.Hz)
+	infoField(sb, "configured_hz", s.config.Hz)
+	infoField(sb, "config_file", s.config.File)
+}
+
+func (s *Server) infoClients(sb *strings.Builder) {
+	infoField(sb, "connected_clients", s.clients.Load())
+	infoField(sb, "maxclients", s.config.MaxClients)
+}
+
+func (s *Server) infoMemory(sb *strings.Builder) {
+	mem := s.db.MemoryStats()
+	infoField(sb, "used_memory", mem.Used)
+	infoField(sb, "used_memory_human", utils.HumanBytes(mem.Used))
+	infoField(sb, "used_memory_peak", mem.Peak)
+	infoField(sb, "used_memory_peak_human", utils.HumanBytes(mem.Peak))
+	infoField(sb, "maxmemory", s.config.MaxMemory)
+	infoField(sb, "maxmemory_human", utils.HumanBytes(s.config.MaxMemory))
+	infoField(sb, "maxmemory_policy", s.config.MaxMemoryPolicy)
+}
+
+func (s *Server) infoPersistence(sb *strings.Builder) {
+	bgsaveInProgress, bgsaveTime := 0, int64(-1)
+	if bg := s.rdb.bgsave; bg != nil {
+		bgsaveInProgress, bgsaveTime = 1, int64(time.Since(bg.start)/time.Second)
+	}
+	lastBgsaveTime := int64(-1)
+	if !s.rdb.lastBgsaveTry.IsZero() {
+		lastBgsaveTime = int64(s.rdb.lastBgsaveLength / time.Second)
+	}
+	infoField(sb, "loading", 0)
+	infoField(sb, "rdb_changes_since_last_save", s.db.Dirty()-s.rdb.dirtyAtSave)
+	infoField(sb, "rdb_bgsave_in_progress", bgsaveInProgress)
+	infoField(sb, "rdb_last_save_time", s.rdb.lastSave.Unix())
+	infoField(sb, "rdb_last_bgsave_status", okOrErr(s.rdb.lastBgsaveOK))
+	infoField(sb, "rdb_last_bgsave_time_sec", lastBgsaveTime)
+	infoField(sb, "rdb_current_bgsave_time_sec", bgsaveTime)
+	infoField(sb, "rdb_saves", s.rdb.saves)
+	rewriteInProgress, rewriteTime := 0, int64(-1)
+	if rw := s.aof.rewrite; rw != nil {
+		rewriteInProgress, rewriteTime = 1, int64(time.Since(rw.start)/time.Second)
+	}
+	lastRewriteTime := int64(-1)
+	if !s.aof.lastRewriteTry.IsZero() {
+		lastRewriteTime = int64(s.aof.lastRewriteLength / time.Second)
+	}
+	infoField(sb, "aof_enabled", utils.Btoi(s.aof.file != nil))
+	infoField(sb, "aof_rewrite_in_progress", rewriteInProgress)
+	infoField(sb, "aof_rewrite_scheduled", 0)
+	infoField(sb, "aof_last_rewrite_time_sec", lastRewriteTime)
+	infoField(sb, "aof_current_rewrite_time_sec", rewriteTime)
+	infoField(sb, "aof_last_bgrewrite_status", okOrErr(s.aof.lastRewriteOK))
+	infoField(sb, "aof_rewrites", s.aof.rewrites)
+	infoField(sb, "aof_last_write_status", okOrErr(s.aof.writeErr == nil))
+	if s.aof.file != nil {
+		infoField(sb, "aof_current_size", s.aofCurrentSize())
+		infoField(sb, "aof_base_size", s.aof.rewriteBaseSize)
+		infoField(sb, "aof_buffer_length", len(s.aof.buf))
+	}
+}
+
+func okOrErr(ok bool) string {
+	if ok {
+		return "ok"
+	}
+	return "err"
+}
+
+func (s *Server) infoStats(sb *strings.Builder) {
+	expire := s.db.ExpireStats()
+	infoField(sb, "total_connections_received", s.stats.connectionsReceived.Load())
+	infoField(sb, "total_commands_processed", s.stats.commandsProcessed.Load())
+	infoField(sb, "rejected_connections", s.stats.rejectedConnections.Load())
+	infoField(sb, "sync_full", s.stats.syncFull.Load())
+	infoField(sb, "sync_partial_ok", s.stats.syncPartialOK.Load())
+	infoField(sb, "sync_partial_err", s.stats.syncPartialErr.Load())
+	infoField(sb, "expired_keys", expire.ExpiredKeys)
+	infoField(sb, "expired_stale_perc", fmt.Sprintf("%.2f", expire.StalePerc*100))
+	infoField(sb, "expired_time_cap_reached_count", expire.TimeCapReached)
+	infoField(sb, "evicted_keys", s.db.MemoryStats().EvictedKeys)
+}
+
+func (s *Server) infoKeyspace(sb *strings.Builder) {
+	keys := s.db.Len()
+	if keys == 0 {
+		return
+	}
+	expire := s.db.ExpireStats()
+	fmt.Fprintf(sb, "db0:keys=%d,expires=%d,avg_ttl=%d\r\n", keys, s.db.VolatileLen(), expire.AvgTTL.Milliseconds())
+}
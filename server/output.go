@@ -0,0 +1,48 @@
+package server
+
+import (
+	"fmt"
+	"time"
+)
+
+// ClientClass groups clients that share output buffer limits, like the
+// classes of Redis's client-output-buffer-limit.
+type ClientClass int
+
+const (
+	ClientNormal ClientClass = iota
+	ClientReplica
+	ClientPubsub
+)
+
+func (c ClientClass) String() string {
+	switch c {
+	case ClientReplica:
+		return "replica"
+	case ClientPubsub:
+		return "pubsub"
+	}
+	return "normal"
+}
+
+// OutputBufferLimit bounds the replies queued for a client that does not
+// read them fast enough. The client is disconnected once its queue reaches
+// Hard bytes, or stays at or above Soft bytes for SoftSeconds. Zero
+// disables a limit.
+type OutputBufferLimit struct {
+	Hard        int64
+	Soft        int64
+	SoftSeconds time.Duration
+}
+
+// defaultOutputBufferLimits are the Redis defaults.
+var defaultOutputBufferLimits = map[ClientClass]OutputBufferLimit{
+	ClientNormal:  {},
+	ClientReplica: {Hard: 256 << 20, Soft: 64 << 20, SoftSeconds: 60 * time.Second},
+	ClientPubsub:  {Hard: 32 << 20, Soft: 8 << 20, SoftSeconds: 60 * time.Second},
+}
+
+func errOutputLimit(c *Conn, which string, queued int64) error {
+	return fmt.Errorf("client %d (%s) scheduled to be closed ASAP for overcoming of output buffer %s limit: %d bytes queued",
+		c.id, c.class, which, queued)
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"go-redis/command"
+	"go-redis/pkg/utils"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// rdbRetryDelay is how long the save rules wait after a failed background
+// save before trying again.
+const rdbRetryDelay = 5 * time.Second
+
+// rdbState tracks the snapshots, it is only used from the loop.
+type rdbState struct {
+	// lastSave is when the dataset was last saved, or loaded at startup,
+	// and dirtyAtSave the DB change counter at that point.
+	lastSave    time.Time
+	dirtyAtSave int64
+	saves       int64
+
+	// bgsave is the background save running, nil when there is none.
+	bgsave           *bgsave
+	lastBgsaveOK     bool
+	lastBgsaveTry    time.Time
+	lastBgsaveLength time.Duration
+}
+
+// bgsave is a snapshot being written by its own goroutine, which sends the
+// outcome on done.
+type bgsave struct {
+	start time.Time
+	dirty int64
+	done  chan error
+}
+
+func (s *Server) rdbPath() string {
+	return filepath.Join(s.config.Dir, s.config.DBFilename)
+}
+
+// rdbAux is the auxiliary fields written at the start of the RDB file,
+// aofBase is set for the RDB preamble of an AOF.
+func (s *Server) rdbAux(aofBase bool) []string {
+	return []string{
+		"redis-ver", command.ServerVersion,
+		"redis-bits", strconv.Itoa(strconv.IntSize),
+		"ctime", strconv.FormatInt(time.Now().Unix(), 10),
+		"used-mem", strconv.FormatInt(s.db.MemoryStats().Used, 10),
+		"aof-base", strconv.Itoa(utils.Btoi(aofBase)),
+	}
+}
+
+// loadDataFromDisk loads the AOF when appendonly is on and there is one,
+// as it is the most up to date, or else the RDB file if there is one.
+func (s *Server) loadDataFromDisk() error {
+	if s.config.AppendOnly {
+		err := s.loadAppendOnlyFiles()
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	start := time.Now()
+	f, err := os.Open(s.rdbPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := s.db.LoadRDB(f)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", s.rdbPath(), err)
+	}
+	s.rdb.dirtyAtSave = s.db.Dirty()
+	slog.Info("DB loaded from disk", "keys", n, "seconds", time.Since(start).Seconds())
+	return nil
+}
+
+// writeDataFile writes a persistence file to a temporary file renamed over
+// path once complete and synced, so path is always whole.
+func writeDataFile(path string, write func(w *bufio.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), fmt.Sprintf("temp-%d-*%s", os.Getpid(), filepath.Ext(path)))
+	if err != nil {
+		return fmt.Errorf("failed opening the temp file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	w := bufio.NewWriter(f)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write error saving DB on disk: %w", err)
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Save writes the dataset to disk from the loop, clients wait until it is
+// done.
+func (s *Server) Save() error {
+	if s.rdb.bgsave != nil {
+		return fmt.Errorf("Background save already in progress")
+	}
+	dirty := s.db.Dirty()
+	snap := s.db.Snapshot()
+	err := writeDataFile(s.rdbPath(), func(w *bufio.Writer) error {
+		return snap.WriteRDB(w, s.rdbAux(false)...)
+	})
+	snap.Release()
+	if err != nil {
+		slog.Warn("Error saving DB on disk", "err", err)
+		return err
+	}
+	slog.Info("DB saved on disk")
+	s.rdb.lastSave = time.Now()
+	s.rdb.dirtyAtSave = dirty
+	s.rdb.saves++
+	s.rdb.lastBgsaveOK = true
+	return nil
+}
+
+// BGSave starts writing the dataset to disk in the background. It writes a
+// snapshot, so commands keep modifying the dataset meanwhile.
+func (s *Server) BGSave() error {
+	if s.rdb.bgsave != nil {
+		return fmt.Errorf("Background save already in progress")
+	}
+	bg := &bgsave{start: time.Now(), dirty: s.db.Dirty(), done: make(chan error, 1)}
+	snap := s.db.Snapshot()
+	aux := s.rdbAux(false)
+	path := s.rdbPath()
+	s.rdb.bgsave = bg
+	s.rdb.lastBgsaveTry = bg.start
+	go func() {
+		defer snap.Release()
+		bg.done <- writeDataFile(path, func(w *bufio.Writer) error {
+			return snap.WriteRDB(w, aux...)
+		})
+	}()
+	slog.Info("Background saving started", "keys", snap.Len(), "copy", time.Since(bg.start))
+	return nil
+}
+
+// bgsaveDone is called by the loop once the background save is over.
+func (s *Server) bgsaveDone(err error) {
+	bg := s.rdb.bgsave
+	s.rdb.bgsave = nil
+	s.rdb.lastBgsaveLength = time.Since(bg.start)
+	if err != nil {
+		s.rdb.lastBgsaveOK = false
+		slog.Warn("Background saving error", "err", err)
+		return
+	}
+	s.rdb.lastBgsaveOK = true
+	s.rdb.lastSave = time.Now()
+	s.rdb.dirtyAtSave = bg.dirty
+	s.rdb.saves++
+	slog.Info("Background saving terminated with success")
+}
+
+// bgsaveCh is the channel the running background save reports on, nil when
+// there is none.
+func (s *Server) bgsaveCh() chan error {
+	if s.rdb.bgsave == nil {
+		return nil
+	}
+	return s.rdb.bgsave.done
+}
+
+// waitBgsave waits for the background save running, if any.
+func (s *Server) waitBgsave() {
+	if ch := s.bgsaveCh(); ch != nil {
+		s.bgsaveDone(<-ch)
+	}
+}
+
+func (s *Server) LastSave() time.Time {
+	return s.rdb.lastSave
+}
+
+// rdbCron starts a background save when a save rule is met. After a
+// failure it waits a bit before trying again.
+func (s *Server) rdbCron(now time.Time) {
+	if s.rdb.bgsave != nil {
+		return
+	}
+	changes := s.db.Dirty() - s.rdb.dirtyAtSave
+	for _, rule := range s.config.Save {
+		if changes < int64(rule.Changes) || now.Sub(s.rdb.lastSave) < rule.Interval {
+			continue
+		}
+		if !s.rdb.lastBgsaveOK && now.Sub(s.rdb.lastBgsaveTry) < rdbRetryDelay {
+			continue
+		}
+		slog.Info(fmt.Sprintf("%d changes in %d seconds. Saving...", rule.Changes, int(rule.Interval/time.Second)))
+		s.BGSave()
+		return
+	}
+}
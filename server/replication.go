@@ -0,0 +1,907 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go-redis/command"
+	"go-redis/pkg/utils"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// replIDLen is the length of a replication ID, in hex characters.
+	replIDLen = 40
+	// replBacklogMinSize is the smallest backlog, a smaller
+	// repl-backlog-size is raised to it like in Redis.
+	replBacklogMinSize = 16 * 1024
+	// replReconnectDelay is how long a replica waits after a failed sync
+	// before connecting to its master again.
+	replReconnectDelay = time.Second
+	// replAckPeriod is how often a replica acknowledges the stream.
+	replAckPeriod = time.Second
+)
+
+// noReplID is the secondary replication ID of a server that has none.
+var noReplID = strings.Repeat("0", replIDLen)
+
+func newReplID() string {
+	b := make([]byte, replIDLen/2)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// replState is the replication state, only used from the loop.
+//
+// The writes of a master form a stream sent to its replicas and kept in the
+// backlog, a position in it is an offset under the replication ID of the
+// master. A replica relays the stream of its master to its own replicas as
+// received, so a whole chain shares the ID and the offsets. A promoted
+// replica takes a new ID and keeps the old one as replid2, up to
+// secondOffset, so the other replicas of its old master can continue from
+// it.
+type replState struct {
+	replid  string
+	replid2 string
+	offset  int64
+	// secondOffset is the first offset that is not part of the history of
+	// replid2, -1 without replid2.
+	secondOffset int64
+	// backlog is created once a replica attaches, nil until then.
+	backlog *backlog
+
+	// replicas are the connections the stream is sent to. noReplicasSince
+	// is when the last one left, the backlog is freed some time after.
+	replicas        map[*Conn]bool
+	noReplicasSince time.Time
+	lastPing        time.Time
+	// rdbCh carries the RDB files encoded for full syncs.
+	rdbCh chan fullSync
+
+	// on a replica, link is the handshake with the master in progress,
+	// which reports on syncCh, and master the connection the stream is
+	// then read from. At most one of them is set.
+	link          *masterLink
+	syncCh        chan masterSync
+	master        *Conn
+	masterLastIO  time.Time
+	lastAck       time.Time
+	linkDownSince time.Time
+	nextConnect   time.Time
+
+	// waiting are the clients blocked by WAIT and WAITAOF, getAck is set
+	// when the replicas are to be asked for their offset.
+	waiting map[*Conn]bool
+	getAck  bool
+}
+
+func newReplState() replState {
+	return replState{
+		replid:       newReplID(),
+		replid2:      noReplID,
+		secondOffset: -1,
+		replicas:     make(map[*Conn]bool),
+		waiting:      make(map[*Conn]bool),
+		rdbCh:        make(chan fullSync),
+		syncCh:       make(chan masterSync),
+	}
+}
+
+// The states of a replica on its master.
+const (
+	// replicaWaitBgsave buffers the stream until the RDB file of the full
+	// sync is sent.
+	replicaWaitBgsave = iota
+	replicaOnline
+)
+
+// replica is the replication state of a client that announced itself as a
+// replica with REPLCONF, PSYNC or SYNC.
+type replica struct {
+	state   int
+	pending []byte
+	// listeningPort and ip are where the replica says it can be reached,
+	// for INFO.
+	listeningPort int
+	ip            string
+	// rdbOnly replicas only want the RDB file, like redis-cli --rdb.
+	rdbOnly bool
+	// ackOffset is the offset the replica last acknowledged, at ackTime,
+	// and aofOffset the one it has in its AOF on disk, -1 without AOF.
+	ackOffset int64
+	aofOffset int64
+	ackTime   time.Time
+}
+
+// replicaOf returns the replication state of c, created on first use.
+func replicaOf(c *Conn) *replica {
+	if c.replica == nil {
+		c.replica = &replica{aofOffset: -1}
+	}
+	return c.replica
+}
+
+// fullSync is the RDB file encoded for a replica.
+type fullSync struct {
+	conn *Conn
+	rdb  []byte
+	err  error
+}
+
+// backlog keeps the last bytes of the stream in a ring buffer, a replica
+// whose link broke for a moment gets what it missed from there rather than
+// a full sync.
+type backlog struct {
+	buf []byte
+	// idx is where the next byte goes and histlen how many bytes are
+	// held, end the offset of the last one.
+	idx     int
+	histlen int
+	end     int64
+}
+
+func newBacklog(size, offset int64) *backlog {
+	return &backlog{buf: make([]byte, max(size, replBacklogMinSize)), end: offset}
+}
+
+func (b *backlog) write(p []byte) {
+	b.end += int64(len(p))
+	if len(p) >= len(b.buf) {
+		copy(b.buf, p[len(p)-len(b.buf):])
+		b.idx, b.histlen = 0, len(b.buf)
+		return
+	}
+	n := copy(b.buf[b.idx:], p)
+	copy(b.buf, p[n:])
+	b.idx = (b.idx + len(p)) % len(b.buf)
+	b.histlen = min(b.histlen+len(p), len(b.buf))
+}
+
+// firstOffset is the offset of the first byte held.
+func (b *backlog) firstOffset() int64 {
+	return b.end - int64(b.histlen) + 1
+}
+
+// since returns the stream from offset on, false when part of it is not
+// held anymore.
+func (b *backlog) since(offset int64) ([]byte, bool) {
+	if offset < b.firstOffset() || offset > b.end+1 {
+		return nil, false
+	}
+	n := int(b.end + 1 - offset)
+	start := (b.idx - n + len(b.buf)) % len(b.buf)
+	out := make([]byte, 0, n)
+	if start+n <= len(b.buf) {
+		return append(out, b.buf[start:start+n]...), true
+	}
+	out = append(out, b.buf[start:]...)
+	return append(out, b.buf[:n-(len(b.buf)-start)]...), true
+}
+
+// resize returns a backlog of the new size holding as much of the history
+// as fits.
+func (b *backlog) resize(size int64) *backlog {
+	nb := newBacklog(size, b.end-int64(b.histlen))
+	data, _ := b.since(b.firstOffset())
+	nb.write(data)
+	return nb
+}
+
+func (s *Server) IsReplica() bool {
+	return s.config.MasterHost != ""
+}
+
+// ReplicaOf follows the master at host:port, or turns a replica into a
+// master when host is empty.
+func (s *Server) ReplicaOf(host string, port int) (string, error) {
+	if s.config.ClusterEnabled {
+		return "", fmt.Errorf("REPLICAOF not allowed in cluster mode.")
+	}
+	if host == "" {
+		if s.config.MasterHost != "" {
+			s.unsetMaster()
+			slog.Info("MASTER MODE enabled")
+		}
+		return "OK", nil
+	}
+	if s.config.MasterHost == host && s.config.MasterPort == port {
+		return "OK Already connected to specified master", nil
+	}
+	s.setMaster(host, port)
+	slog.Info("REPLICAOF enabled", "master", net.JoinHostPort(host, strconv.Itoa(port)))
+	return "OK", nil
+}
+
+// setMaster drops the current master, if any, for host:port. The replicas
+// are disconnected to sync again with the new history.
+func (s *Server) setMaster(host string, port int) {
+	s.dropMasterLink()
+	s.config.MasterHost, s.config.MasterPort = host, port
+	s.db.SetKeepExpired(true)
+	s.disconnectReplicas()
+	s.repl.linkDownSince = time.Time{}
+	s.connectToMaster()
+}
+
+// unsetMaster makes the replica a master. The stream received so far stays
+// valid under the old ID, so the other replicas of the old master can
+// continue from this server.
+func (s *Server) unsetMaster() {
+	s.dropMasterLink()
+	s.config.MasterHost, s.config.MasterPort = "", 0
+	s.db.SetKeepExpired(false)
+	s.repl.replid2 = s.repl.replid
+	s.repl.secondOffset = s.repl.offset + 1
+	s.repl.replid = newReplID()
+	// the replicas learn the new ID when they reconnect
+	s.disconnectReplicas()
+	s.repl.linkDownSince = time.Time{}
+}
+
+func (s *Server) dropMasterLink() {
+	if s.repl.link != nil {
+		s.repl.link.cancel()
+		s.repl.link = nil
+	}
+	if s.repl.master != nil {
+		s.repl.master.Close()
+		s.repl.master = nil
+	}
+}
+
+func (s *Server) disconnectReplicas() {
+	for c := range s.repl.replicas {
+		c.Close()
+		delete(s.repl.replicas, c)
+	}
+	s.repl.noReplicasSince = time.Now()
+}
+
+// ReplConf records the options a replica sends its master, and on a
+// replica answers GETACK from the master.
+func (s *Server) ReplConf(client command.Client, args []string) error {
+	c := client.(*Conn)
+	for i := 0; i+1 < len(args); i += 2 {
+		val := args[i+1]
+		switch strings.ToLower(args[i]) {
+		case "listening-port":
+			port, err := strconv.Atoi(val)
+			if err != nil {
+				return fmt.Errorf("value is not an integer or out of range")
+			}
+			replicaOf(c).listeningPort = port
+		case "ip-address":
+			replicaOf(c).ip = val
+		case "capa":
+			// the RDB is always sent with its length and PSYNC is the
+			// PSYNC2 flavour, nothing depends on capabilities
+		case "rdb-only":
+			replicaOf(c).rdbOnly = val == "1"
+		case "ack":
+			if !s.repl.replicas[c] {
+				return nil
+			}
+			if offset, err := strconv.ParseInt(val, 10, 64); err == nil && offset > c.replica.ackOffset {
+				c.replica.ackOffset = offset
+			}
+			c.replica.ackTime = time.Now()
+		case "fack":
+			if !s.repl.replicas[c] {
+				return nil
+			}
+			if offset, err := strconv.ParseInt(val, 10, 64); err == nil && offset > c.replica.aofOffset {
+				c.replica.aofOffset = offset
+			}
+		case "getack":
+			if c == s.repl.master {
+				s.sendAck(time.Now())
+			}
+		default:
+			return fmt.Errorf("Unrecognized REPLCONF option: %s", args[i])
+		}
+	}
+	return nil
+}
+
+// Psync continues the stream from offset when replid is part of the
+// history and the backlog still holds it, or else starts a full sync.
+func (s *Server) Psync(client command.Client, replid string, offset int64) error {
+	return s.syncReplica(client.(*Conn), replid, offset, true)
+}
+
+// Sync starts a full sync without the +FULLRESYNC line, for replicas older
+// than PSYNC.
+func (s *Server) Sync(client command.Client) error {
+	return s.syncReplica(client.(*Conn), "", 0, false)
+}
+
+func (s *Server) syncReplica(c *Conn, replid string, offset int64, psync bool) error {
+	if c == s.repl.master || s.repl.replicas[c] {
+		// a replica can't ask twice, nor a master sync with its replica
+		return nil
+	}
+	if s.config.MasterHost != "" && s.repl.master == nil {
+		return utils.CodedErrorf("NOMASTERLINK", "Can't SYNC while not connected with my master")
+	}
+	r := replicaOf(c)
+	c.class = ClientReplica
+	c.limit = s.config.OutputBufferLimits[ClientReplica]
+	if s.repl.backlog == nil {
+		if s.config.MasterHost == "" {
+			// there is no history to continue from before the backlog
+			s.repl.replid, s.repl.replid2, s.repl.secondOffset = newReplID(), noReplID, -1
+		}
+		s.repl.backlog = newBacklog(s.config.ReplBacklogSize, s.repl.offset)
+	}
+	if psync {
+		if s.partialResync(c, replid, offset) {
+			s.stats.syncPartialOK.Add(1)
+			return nil
+		}
+		if replid != "?" {
+			s.stats.syncPartialErr.Add(1)
+		}
+	}
+	s.stats.syncFull.Add(1)
+	if psync {
+		c.Write(fmt.Appendf(nil, "+FULLRESYNC %s %d\r\n", s.repl.replid, s.repl.offset))
+	}
+	r.state = replicaWaitBgsave
+	s.repl.replicas[c] = true
+	snap := s.db.Snapshot()
+	aux := append(s.rdbAux(false), "repl-id", s.repl.replid, "repl-offset", strconv.FormatInt(s.repl.offset, 10))
+	go func() {
+		var buf bytes.Buffer
+		err := snap.WriteRDB(&buf, aux...)
+		snap.Release()
+		select {
+		case s.repl.rdbCh <- fullSync{conn: c, rdb: buf.Bytes(), err: err}:
+		case <-s.doneCh:
+		}
+	}()
+	slog.Info("Starting full sync", "replica", c.addr, "keys", snap.Len())
+	return nil
+}
+
+func (s *Server) partialResync(c *Conn, replid string, offset int64) bool {
+	if replid != s.repl.replid && (replid != s.repl.replid2 || offset > s.repl.secondOffset) {
+		if replid != "?" {
+			slog.Info("Partial resynchronization not accepted: replication ID mismatch", "replica", c.addr)
+		}
+		return false
+	}
+	data, ok := s.repl.backlog.since(offset)
+	if !ok {
+		slog.Info("Unable to partial resync with the replica: lack of backlog", "replica", c.addr, "offset", offset)
+		return false
+	}
+	c.replica.state = replicaOnline
+	s.repl.replicas[c] = true
+	c.Write(fmt.Appendf(nil, "+CONTINUE %s\r\n", s.repl.replid))
+	c.Write(data)
+	slog.Info("Partial resynchronization request accepted", "replica", c.addr, "offset", offset, "bytes", len(data))
+	return true
+}
+
+// fullSyncDone sends the RDB file to the replica, then the stream buffered
+// while it was encoded.
+func (s *Server) fullSyncDone(res fullSync) {
+	c := res.conn
+	if !s.repl.replicas[c] {
+		return
+	}
+	if res.err != nil {
+		slog.Warn("Error preparing the RDB for the replica", "replica", c.addr, "err", res.err)
+		c.Close()
+		return
+	}
+	c.enqueue(fmt.Appendf(nil, "$%d\r\n", len(res.rdb)), false)
+	c.enqueue(res.rdb, false)
+	if c.replica.rdbOnly {
+		delete(s.repl.replicas, c)
+		c.closeAfterReply()
+		return
+	}
+	c.replica.state = replicaOnline
+	c.Write(c.replica.pending)
+	c.replica.pending = nil
+	if err := c.flush(); err != nil {
+		slog.Warn("Disconnecting replica", "replica", c.addr, "err", err)
+		return
+	}
+	slog.Info("Synchronization with replica succeeded", "replica", c.addr, "bytes", len(res.rdb))
+}
+
+// feedReplicationStream appends data to the stream: it is kept in the
+// backlog and sent to the replicas, flushed by flushReplicas, once there
+// is a backlog.
+func (s *Server) feedReplicationStream(data []byte) {
+	// the offset moves without replicas too, WAITAOF counts on it
+	s.repl.offset += int64(len(data))
+	if s.repl.backlog == nil {
+		return
+	}
+	s.repl.backlog.write(data)
+	for c := range s.repl.replicas {
+		if c.replica.state == replicaOnline {
+			c.Write(data)
+			continue
+		}
+		c.replica.pending = append(c.replica.pending, data...)
+		if hard := c.limit.Hard; hard > 0 && int64(len(c.replica.pending)) >= hard {
+			slog.Warn("Disconnecting replica", "replica", c.addr, "err", errOutputLimit(c, "hard", int64(len(c.replica.pending))))
+			delete(s.repl.replicas, c)
+			c.Close()
+		}
+	}
+}
+
+func (s *Server) flushReplicas() {
+	for c := range s.repl.replicas {
+		if c.replica.state != replicaOnline {
+			continue
+		}
+		if err := c.flush(); err != nil {
+			slog.Warn("Disconnecting replica", "replica", c.addr, "err", err)
+		}
+	}
+}
+
+// masterLink is the handshake with a master, run by its own goroutine.
+// cancel interrupts it.
+type masterLink struct {
+	addr      string
+	mu        sync.Mutex
+	conn      net.Conn
+	cancelled bool
+}
+
+var errLinkCancelled = errors.New("replication link cancelled")
+
+func (l *masterLink) cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cancelled = true
+	if l.conn != nil {
+		l.conn.Close()
+	}
+}
+
+func (l *masterLink) setConn(conn net.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cancelled {
+		conn.Close()
+		return false
+	}
+	l.conn = conn
+	return true
+}
+
+// masterSync is the outcome of a handshake. After a full sync rdb holds
+// the dataset of the master at offset, the stream follows on conn, part of
+// it maybe already buffered in br.
+type masterSync struct {
+	link   *masterLink
+	conn   net.Conn
+	br     *bufio.Reader
+	full   bool
+	replid string
+	offset int64
+	rdb    []byte
+	err    error
+}
+
+// connectToMaster starts the handshake with the master, asking for the
+// stream after what this server already has.
+func (s *Server) connectToMaster() {
+	link := &masterLink{addr: net.JoinHostPort(s.config.MasterHost, strconv.Itoa(s.config.MasterPort))}
+	s.repl.link = link
+	replid, offset := s.repl.replid, s.repl.offset+1
+	port, timeout := s.config.Port, s.config.ReplTimeout
+	slog.Info("Connecting to MASTER", "master", link.addr)
+	go func() {
+		res := link.sync(replid, offset, port, timeout)
+		select {
+		case s.repl.syncCh <- res:
+		case <-s.doneCh:
+			if res.conn != nil {
+				res.conn.Close()
+			}
+		}
+	}()
+}
+
+// deadlineReader fails a read once the master is silent for the timeout,
+// however long the whole transfer takes.
+type deadlineReader struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (r deadlineReader) Read(p []byte) (int, error) {
+	r.conn.SetReadDeadline(time.Now().Add(r.timeout))
+	return r.conn.Read(p)
+}
+
+// sync runs the handshake: PING, REPLCONF with what the replica supports,
+// then PSYNC and the RDB file of a full sync.
+func (l *masterLink) sync(replid string, offset int64, port int, timeout time.Duration) masterSync {
+	res := masterSync{link: l}
+	conn, err := net.DialTimeout("tcp", l.addr, timeout)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	if !l.setConn(conn) {
+		res.err = errLinkCancelled
+		return res
+	}
+	br := bufio.NewReader(deadlineReader{conn: conn, timeout: timeout})
+	fail := func(err error) masterSync {
+		conn.Close()
+		res.err = err
+		return res
+	}
+	send := func(args ...string) (string, error) {
+		conn.SetWriteDeadline(time.Now().Add(timeout))
+		if _, err := conn.Write(utils.AppendCommand(nil, args...)); err != nil {
+			return "", err
+		}
+		return readMasterReply(br)
+	}
+	reply, err := send("PING")
+	if err != nil {
+		return fail(err)
+	}
+	if strings.HasPrefix(reply, "-") {
+		return fail(fmt.Errorf("error reply to PING from master: %s", reply))
+	}
+	// errors are not critical, an older master may not know the options
+	if reply, err = send("REPLCONF", "listening-port", strconv.Itoa(port)); err != nil {
+		return fail(err)
+	} else if strings.HasPrefix(reply, "-") {
+		slog.Info("(Non critical) Master does not understand REPLCONF listening-port", "reply", reply)
+	}
+	if reply, err = send("REPLCONF", "capa", "eof", "capa", "psync2"); err != nil {
+		return fail(err)
+	} else if strings.HasPrefix(reply, "-") {
+		slog.Info("(Non critical) Master does not understand REPLCONF capa", "reply", reply)
+	}
+	if reply, err = send("PSYNC", replid, strconv.FormatInt(offset, 10)); err != nil {
+		return fail(err)
+	}
+	fields := strings.Fields(reply)
+	switch {
+	case fields[0] == "+FULLRESYNC" && len(fields) == 3:
+		res.full, res.replid = true, fields[1]
+		if res.offset, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+			return fail(fmt.Errorf("bad reply to PSYNC from master: %s", reply))
+		}
+		if res.rdb, err = readMasterPayload(br); err != nil {
+			return fail(err)
+		}
+	case fields[0] == "+CONTINUE":
+		// a master older than PSYNC2 does not send its ID, it is unchanged
+		res.replid = replid
+		if len(fields) == 2 {
+			res.replid = fields[1]
+		}
+	default:
+		return fail(fmt.Errorf("unexpected reply to PSYNC from master: %s", reply))
+	}
+	conn.SetDeadline(time.Time{})
+	res.conn, res.br = conn, br
+	return res
+}
+
+// readMasterReply reads a reply line of the master, skipping the newlines
+// it sends to keep the link alive while it prepares the RDB file.
+func readMasterReply(br *bufio.Reader) (string, error) {
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		if line = strings.TrimRight(line, "\r\n"); line != "" {
+			return line, nil
+		}
+	}
+}
+
+// readMasterPayload reads the RDB file of a full sync: $ and its length,
+// or $EOF: and a 40 bytes mark that also ends it when the master streams
+// it without knowing its length.
+func readMasterPayload(br *bufio.Reader) ([]byte, error) {
+	line, err := readMasterReply(br)
+	if err != nil {
+		return nil, err
+	}
+	if line[0] != '$' {
+		return nil, fmt.Errorf("bad protocol from MASTER, the first byte is not '$' (we received '%s')", line)
+	}
+	if mark, ok := strings.CutPrefix(line, "$EOF:"); ok && len(mark) == replIDLen {
+		var data []byte
+		chunk := make([]byte, readBufSize)
+		for {
+			n, err := br.Read(chunk)
+			data = append(data, chunk[:n]...)
+			if bytes.HasSuffix(data, []byte(mark)) {
+				return data[:len(data)-len(mark)], nil
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	size, err := strconv.Atoi(line[1:])
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("bad RDB length from MASTER: %s", line)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(br, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// masterSyncDone is called by the loop once a handshake is over. The
+// dataset of a full sync replaces this one, then the stream is read.
+func (s *Server) masterSyncDone(res masterSync) {
+	if res.link != s.repl.link {
+		// REPLICAOF changed the master meanwhile
+		if res.conn != nil {
+			res.conn.Close()
+		}
+		return
+	}
+	s.repl.link = nil
+	if res.err != nil {
+		slog.Warn("Unable to sync with MASTER", "master", res.link.addr, "err", res.err)
+		s.repl.nextConnect = time.Now().Add(replReconnectDelay)
+		return
+	}
+	if res.full {
+		// the replicas of this server hold the old dataset
+		s.disconnectReplicas()
+		if err := s.loadMasterRDB(res.rdb); err != nil {
+			slog.Warn("Failed trying to load the MASTER synchronization DB from socket", "err", err)
+			res.conn.Close()
+			s.repl.nextConnect = time.Now().Add(replReconnectDelay)
+			return
+		}
+		s.repl.replid, s.repl.replid2, s.repl.secondOffset = res.replid, noReplID, -1
+		s.repl.offset = res.offset
+		s.repl.backlog = newBacklog(s.config.ReplBacklogSize, res.offset)
+		slog.Info("MASTER <-> REPLICA sync: Finished with success", "bytes", len(res.rdb))
+	} else {
+		if res.replid != s.repl.replid {
+			// the master was promoted, the history goes on under its ID
+			s.repl.replid2, s.repl.secondOffset = s.repl.replid, s.repl.offset+1
+			s.repl.replid = res.replid
+			s.disconnectReplicas()
+		}
+		if s.repl.backlog == nil {
+			s.repl.backlog = newBacklog(s.config.ReplBacklogSize, s.repl.offset)
+		}
+		slog.Info("Successful partial resynchronization with master", "master", res.link.addr)
+	}
+	s.attachMaster(res.conn, res.br)
+}
+
+// loadMasterRDB replaces the dataset with the one of the master. The AOF
+// is rewritten to hold it.
+func (s *Server) loadMasterRDB(data []byte) error {
+	s.db.Flush()
+	if _, err := s.db.LoadRDB(bytes.NewReader(data)); err != nil {
+		s.db.Flush()
+		return err
+	}
+	if s.aof.file != nil {
+		s.stopAppendOnly()
+		if err := s.startAppendOnly(); err != nil {
+			slog.Warn("Failed enabling the AOF after successful master synchronization", "err", err)
+		}
+	}
+	return nil
+}
+
+// attachMaster reads the stream from conn, the bytes br read past the
+// handshake first.
+func (s *Server) attachMaster(conn net.Conn, br *bufio.Reader) {
+	c := NewConn(conn, s.msgCh)
+	c.serverDone = s.doneCh
+	c.raw = true
+	if n := br.Buffered(); n > 0 {
+		data, _ := br.Peek(n)
+		c.parser.Feed(data)
+	}
+	s.repl.master = c
+	s.repl.masterLastIO = time.Now()
+	s.addPeer(c)
+	s.sendAck(time.Now())
+	go func() {
+		err := c.read()
+		c.Close()
+		if err != nil && err != io.EOF && !errors.Is(err, net.ErrClosed) {
+			slog.Warn("Error reading the replication stream", "err", err)
+		}
+		select {
+		case s.delPeerCh <- c:
+		case <-s.doneCh:
+		}
+	}()
+}
+
+// handleMasterMessage executes the stream of the master. Nothing is
+// replied, and every command is relayed to the replicas of this server as
+// received once executed.
+func (s *Server) handleMasterMessage(message Message) error {
+	c := message.Conn
+	if c != s.repl.master {
+		// what was read before the link was dropped
+		return nil
+	}
+	s.repl.masterLastIO = time.Now()
+	for i, val := range message.Values {
+		cmd, err := command.ParseValue(val)
+		if err == nil {
+			err = s.call(c, cmd, val.Array())
+			s.stats.commandsProcessed.Add(1)
+		}
+		if err != nil {
+			slog.Warn("Error executing a command of the MASTER", "err", err)
+		}
+		c.reply.reset()
+		s.feedReplicationStream(message.Raw[i])
+	}
+	if err := s.flushAppendOnlyFile(time.Now()); err != nil {
+		slog.Warn("Error writing the stream of the MASTER to the AOF", "err", err)
+	}
+	s.flushReplicas()
+	if message.Err != nil {
+		c.Close()
+		return message.Err
+	}
+	return nil
+}
+
+// sendAck tells the master the offset processed so far and, with the AOF
+// on, the one on disk.
+func (s *Server) sendAck(now time.Time) {
+	if s.repl.master == nil {
+		return
+	}
+	s.repl.lastAck = now
+	args := []string{"REPLCONF", "ACK", strconv.FormatInt(s.repl.offset, 10)}
+	if s.aof.file != nil {
+		args = append(args, "FACK", strconv.FormatInt(s.aof.fsyncedOffset.Load(), 10))
+	}
+	s.repl.master.enqueue(utils.AppendCommand(nil, args...), true)
+}
+
+// replicationCron keeps the links alive. A replica acknowledges the
+// stream, reconnects to its master and drops it once silent for too long.
+// A master pings its replicas through the stream, drops the ones silent
+// for too long and frees the backlog once unused for a while.
+func (s *Server) replicationCron(now time.Time) {
+	if s.config.MasterHost != "" {
+		switch {
+		case s.repl.master != nil && now.Sub(s.repl.masterLastIO) > s.config.ReplTimeout:
+			slog.Warn("MASTER timeout: no data nor PING received...")
+			s.repl.master.Close()
+		case s.repl.master != nil && now.Sub(s.repl.lastAck) >= replAckPeriod:
+			s.sendAck(now)
+		case s.repl.master == nil && s.repl.link == nil && !now.Before(s.repl.nextConnect):
+			s.connectToMaster()
+		}
+	} else if len(s.repl.replicas) > 0 && now.Sub(s.repl.lastPing) >= s.config.ReplPingReplicaPeriod {
+		s.repl.lastPing = now
+		s.feedReplicationStream(utils.AppendCommand(nil, "PING"))
+	}
+	for c := range s.repl.replicas {
+		// replicas older than PSYNC never acknowledge
+		if c.replica.state == replicaOnline && !c.replica.ackTime.IsZero() && now.Sub(c.replica.ackTime) > s.config.ReplTimeout {
+			slog.Warn("Disconnecting timedout replica", "replica", c.addr)
+			c.Close()
+		}
+	}
+	if s.repl.backlog != nil && s.config.MasterHost == "" && len(s.repl.replicas) == 0 &&
+		s.config.ReplBacklogTTL > 0 && now.Sub(s.repl.noReplicasSince) > s.config.ReplBacklogTTL {
+		// replicas coming back can't continue anyway, the new ID tells
+		// them so
+		s.repl.replid, s.repl.replid2, s.repl.secondOffset = newReplID(), noReplID, -1
+		s.repl.backlog = nil
+		slog.Info("Replication backlog freed after its TTL without replicas", "ttl", s.config.ReplBacklogTTL)
+	}
+	s.flushReplicas()
+	s.processWaiting(now)
+}
+
+// removeReplicationPeer forgets a closed connection that was a replica,
+// the master or blocked by WAIT.
+func (s *Server) removeReplicationPeer(c *Conn) {
+	delete(s.repl.waiting, c)
+	if s.repl.replicas[c] {
+		delete(s.repl.replicas, c)
+		slog.Info("Connection with replica lost", "replica", c.addr)
+		if len(s.repl.replicas) == 0 {
+			s.repl.noReplicasSince = time.Now()
+		}
+	}
+	if c == s.repl.master {
+		s.repl.master = nil
+		s.repl.linkDownSince = time.Now()
+		slog.Info("Connection with master lost")
+	}
+}
+
+func (s *Server) infoReplication(sb *strings.Builder) {
+	now := time.Now()
+	if s.config.MasterHost == "" {
+		infoField(sb, "role", "master")
+	} else {
+		infoField(sb, "role", "slave")
+		infoField(sb, "master_host", s.config.MasterHost)
+		infoField(sb, "master_port", s.config.MasterPort)
+		status, lastIO := "down", int64(-1)
+		if s.repl.master != nil {
+			status, lastIO = "up", int64(now.Sub(s.repl.masterLastIO)/time.Second)
+		}
+		infoField(sb, "master_link_status", status)
+		infoField(sb, "master_last_io_seconds_ago", lastIO)
+		infoField(sb, "master_sync_in_progress", utils.Btoi(s.repl.link != nil))
+		infoField(sb, "slave_repl_offset", s.repl.offset)
+		infoField(sb, "slave_read_only", utils.Btoi(s.config.ReplicaReadOnly))
+		if s.repl.master == nil {
+			downSince := int64(-1)
+			if !s.repl.linkDownSince.IsZero() {
+				downSince = int64(now.Sub(s.repl.linkDownSince) / time.Second)
+			}
+			infoField(sb, "master_link_down_since_seconds", downSince)
+		}
+	}
+	infoField(sb, "connected_slaves", len(s.repl.replicas))
+	i := 0
+	for c := range s.repl.replicas {
+		ip, _, _ := net.SplitHostPort(c.addr)
+		if c.replica.ip != "" {
+			ip = c.replica.ip
+		}
+		state := "online"
+		if c.replica.state == replicaWaitBgsave {
+			state = "wait_bgsave"
+		}
+		lag := int64(-1)
+		if !c.replica.ackTime.IsZero() {
+			lag = int64(now.Sub(c.replica.ackTime) / time.Second)
+		}
+		infoField(sb, fmt.Sprintf("slave%d", i), fmt.Sprintf("ip=%s,port=%d,state=%s,offset=%d,lag=%d",
+			ip, c.replica.listeningPort, state, c.replica.ackOffset, lag))
+		i++
+	}
+	infoField(sb, "master_replid", s.repl.replid)
+	infoField(sb, "master_replid2", s.repl.replid2)
+	infoField(sb, "master_repl_offset", s.repl.offset)
+	infoField(sb, "second_repl_offset", s.repl.secondOffset)
+	if b := s.repl.backlog; b != nil {
+		infoField(sb, "repl_backlog_active", 1)
+		infoField(sb, "repl_backlog_size", len(b.buf))
+		infoField(sb, "repl_backlog_first_byte_offset", b.firstOffset())
+		infoField(sb, "repl_backlog_histlen", b.histlen)
+	} else {
+		infoField(sb, "repl_backlog_active", 0)
+		infoField(sb, "repl_backlog_size", s.config.ReplBacklogSize)
+		infoField(sb, "repl_backlog_first_byte_offset", 0)
+		infoField(sb, "repl_backlog_histlen", 0)
+	}
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"errors"
+	"go-redis/command"
+	"go-redis/pkg/utils"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var _ command.ReplyWriter = (*ReplyWriter)(nil)
+
+// ReplyWriter encodes typed RESP replies into a connection's output buffer.
+// Nothing reaches the socket until the connection is flushed.
+//
+// RESP3 types are only emitted once the client negotiated protocol 3 with
+// HELLO, for RESP2 clients they fall back to the closest RESP2 encoding, as
+// Redis does.
+type ReplyWriter struct {
+	buf   []byte
+	proto int
+}
+
+// Protocol returns the negotiated RESP version, 2 or 3.
+func (w *ReplyWriter) Protocol() int {
+	if w.proto == 0 {
+		return 2
+	}
+	return w.proto
+}
+
+func (w *ReplyWriter) SetProtocol(proto int) {
+	w.proto = proto
+}
+
+func (w *ReplyWriter) resp3() bool {
+	return w.proto == 3
+}
+
+func (w *ReplyWriter) WriteOK() {
+	w.buf = append(w.buf, OK...)
+}
+
+// WriteSimpleString writes a +status reply, s must not contain CR or LF.
+func (w *ReplyWriter) WriteSimpleString(s string) {
+	w.buf = append(w.buf, '+')
+	w.buf = append(w.buf, s...)
+	w.buf = append(w.buf, '\r', '\n')
+}
+
+// WriteError writes an -error reply. A *utils.CodedError is replied with
+// its code, like WRONGTYPE, any other error with the generic ERR code.
+func (w *ReplyWriter) WriteError(err error) {
+	code, msg := "ERR", err.Error()
+	var coded *utils.CodedError
+	if errors.As(err, &coded) {
+		code, msg = coded.Code, coded.Msg
+	}
+	w.buf = append(w.buf, '-')
+	w.buf = append(w.buf, code...)
+	w.buf = append(w.buf, ' ')
+	w.buf = append(w.buf, strings.NewReplacer("\r", " ", "\n", " ").Replace(msg)...)
+	w.buf = append(w.buf, '\r', '\n')
+}
+
+func (w *ReplyWriter) WriteInteger(n int64) {
+	w.buf = append(w.buf, ':')
+	w.buf = strconv.AppendInt(w.buf, n, 10)
+	w.buf = append(w.buf, '\r', '\n')
+}
+
+// WriteBulk writes a length prefixed bulk string, a nil slice is written as
+// an empty string and not as null.
+func (w *ReplyWriter) WriteBulk(b []byte) {
+	w.writeLen('$', len(b))
+	w.buf = append(w.buf, b...)
+	w.buf = append(w.buf, '\r', '\n')
+}
+
+func (w *ReplyWriter) WriteBulkString(s string) {
+	w.writeLen('$', len(s))
+	w.buf = append(w.buf, s...)
+	w.buf = append(w.buf, '\r', '\n')
+}
+
+// WriteFloat writes f as a bulk string, formatted the way Redis does.
+func (w *ReplyWriter) WriteFloat(f float64) {
+	w.WriteBulkString(formatFloat(f))
+}
+
+// WriteNull writes the null bulk string, or the RESP3 null.
+func (w *ReplyWriter) WriteNull() {
+	if w.resp3() {
+		w.buf = append(w.buf, "_\r\n"...)
+		return
+	}
+	w.buf = append(w.buf, "$-1\r\n"...)
+}
+
+// WriteNullArray writes the null array, or the RESP3 null.
+func (w *ReplyWriter) WriteNullArray() {
+	if w.resp3() {
+		w.buf = append(w.buf, "_\r\n"...)
+		return
+	}
+	w.buf = append(w.buf, "*-1\r\n"...)
+}
+
+// WriteMap writes the header of a map of n key value pairs, 2n elements
+// must be written next. RESP2 clients get a flat array.
+func (w *ReplyWriter) WriteMap(n int) {
+	if w.resp3() {
+		w.writeLen('%', n)
+		return
+	}
+	w.writeLen('*', 2*n)
+}
+
+// WriteSet writes the header of a set of n elements.
+func (w *ReplyWriter) WriteSet(n int) {
+	if w.resp3() {
+		w.writeLen('~', n)
+		return
+	}
+	w.writeLen('*', n)
+}
+
+// WritePush writes the header of an out of band push message of n
+// elements.
+func (w *ReplyWriter) WritePush(n int) {
+	if w.resp3() {
+		w.writeLen('>', n)
+		return
+	}
+	w.writeLen('*', n)
+}
+
+// WriteAttribute writes pairs as an attribute of the reply that follows.
+// Attributes are auxiliary data, RESP2 clients do not get them at all.
+func (w *ReplyWriter) WriteAttribute(pairs ...string) {
+	if !w.resp3() {
+		return
+	}
+	w.writeLen('|', len(pairs)/2)
+	for _, p := range pairs[:len(pairs)/2*2] {
+		w.WriteBulkString(p)
+	}
+}
+
+// WriteDouble writes f as a RESP3 double, or as a bulk string for RESP2.
+func (w *ReplyWriter) WriteDouble(f float64) {
+	if !w.resp3() {
+		w.WriteFloat(f)
+		return
+	}
+	w.buf = append(w.buf, ',')
+	if math.IsNaN(f) {
+		w.buf = append(w.buf, "nan"...)
+	} else {
+		w.buf = append(w.buf, formatFloat(f)...)
+	}
+	w.buf = append(w.buf, '\r', '\n')
+}
+
+// WriteBool writes a RESP3 boolean, or 1 and 0 for RESP2.
+func (w *ReplyWriter) WriteBool(b bool) {
+	if !w.resp3() {
+		w.WriteInteger(int64(utils.Btoi(b)))
+		return
+	}
+	if b {
+		w.buf = append(w.buf, "#t\r\n"...)
+	} else {
+		w.buf = append(w.buf, "#f\r\n"...)
+	}
+}
+
+// WriteBigNumber writes n, a decimal integer of any size, as a RESP3 big
+// number, or as a bulk string for RESP2.
+func (w *ReplyWriter) WriteBigNumber(n string) {
+	if !w.resp3() {
+		w.WriteBulkString(n)
+		return
+	}
+	w.buf = append(w.buf, '(')
+	w.buf = append(w.buf, n...)
+	w.buf = append(w.buf, '\r', '\n')
+}
+
+// WriteVerbatim writes s as a RESP3 verbatim string of the given three
+// letter format, such as "txt" or "mkd", or as a bulk string for RESP2.
+func (w *ReplyWriter) WriteVerbatim(format, s string) {
+	if !w.resp3() {
+		w.WriteBulkString(s)
+		return
+	}
+	w.writeLen('=', len(format)+1+len(s))
+	w.buf = append(w.buf, format...)
+	w.buf = append(w.buf, ':')
+	w.buf = append(w.buf, s...)
+	w.buf = append(w.buf, '\r', '\n')
+}
+
+// WriteArray writes the header of an array of n elements, the elements
+// must be written next.
+func (w *ReplyWriter) WriteArray(n int) {
+	w.writeLen('*', n)
+}
+
+// WriteBulks writes an array of bulk strings.
+func (w *ReplyWriter) WriteBulks(vals []string) {
+	w.WriteArray(len(vals))
+	for _, v := range vals {
+		w.WriteBulkString(v)
+	}
+}
+
+func (w *ReplyWriter) writeLen(prefix byte, n int) {
+	w.buf = append(w.buf, prefix)
+	w.buf = strconv.AppendInt(w.buf, int64(n), 10)
+	w.buf = append(w.buf, '\r', '\n')
+}
+
+// Len returns the number of buffered bytes.
+func (w *ReplyWriter) Len() int {
+	return len(w.buf)
+}
+
+func (w *ReplyWriter) reset() {
+	w.buf = w.buf[:0]
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case f == math.Trunc(f) && math.Abs(f) < 1e17:
+		// integral scores are printed without an exponent, like Redis
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplyWriterFallback(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(w *ReplyWriter)
+		resp2 string
+		resp3 string
+	}{
+		{"push", func(w *ReplyWriter) { w.WritePush(2); w.WriteBulkString("a"); w.WriteInteger(1) },
+			"*2\r\n$1\r\na\r\n:1\r\n", ">2\r\n$1\r\na\r\n:1\r\n"},
+		{"attribute", func(w *ReplyWriter) { w.WriteAttribute("ttl", "10"); w.WriteOK() },
+			"+OK\r\n", "|1\r\n$3\r\nttl\r\n$2\r\n10\r\n+OK\r\n"},
+		{"true", func(w *ReplyWriter) { w.WriteBool(true) }, ":1\r\n", "#t\r\n"},
+		{"false", func(w *ReplyWriter) { w.WriteBool(false) }, ":0\r\n", "#f\r\n"},
+		{"big number", func(w *ReplyWriter) { w.WriteBigNumber("12345678901234567890") },
+			"$20\r\n12345678901234567890\r\n", "(12345678901234567890\r\n"},
+		{"map", func(w *ReplyWriter) { w.WriteMap(1); w.WriteBulkString("k"); w.WriteInteger(1) },
+			"*2\r\n$1\r\nk\r\n:1\r\n", "%1\r\n$1\r\nk\r\n:1\r\n"},
+		{"double", func(w *ReplyWriter) { w.WriteDouble(1.5) }, "$3\r\n1.5\r\n", ",1.5\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &ReplyWriter{}
+			tt.write(w)
+			assert.Equal(t, tt.resp2, string(w.buf))
+			w = &ReplyWriter{proto: 3}
+			tt.write(w)
+			assert.Equal(t, tt.resp3, string(w.buf))
+		})
+	}
+}
@@ -1,368 +1,419 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"go-redis/command"
 	"go-redis/pkg/utils"
 	"go-redis/repo"
+	"io"
 	"log/slog"
 	"net"
-	"reflect"
+	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/tidwall/resp"
 )
 
 const (
-	listenAddress = ":50001"
-	OK            = "+OK\r\n"
+	OK = "+OK\r\n"
 )
 
-type Config struct {
-	listenAddress string
-}
-type Server struct {
-	config Config
-	ln     net.Listener
-	quitCh chan struct{}
-	peerCh chan Conn
-	peers  map[Conn]bool
-	msgCh  chan Message
-}
+var _ command.Server = (*Server)(nil)
 
+type Server struct {
+	config    Config
+	listeners []net.Listener
+	// clients counts the connected clients, for maxclients
+	clients atomic.Int64
+	// maxClients and idleTimeout mirror the config for the connection
+	// goroutines, CONFIG SET changes them while they run
+	maxClients  atomic.Int64
+	idleTimeout atomic.Int64
+	stats       stats
+	slowlog     slowlog
+	rdb         rdbState
+	aof         aofState
+	repl        replState
+	cluster     clusterState
+	db          *repo.DB
+	startTime   time.Time
+	// cron fires hz times per second, the loop then runs the background
+	// tasks
+	cron      *time.Ticker
+	peerCh    chan *Conn
+	delPeerCh chan *Conn
+	peers     map[*Conn]bool
+	msgCh     chan Message
+
+	// shutdownCh carries shutdown requests to the loop, doneCh is closed
+	// once the loop has shut the server down.
+	shutdownCh chan shutdownRequest
+	doneCh     chan struct{}
+	// pendingShutdown is set by the SHUTDOWN command, the loop shuts down
+	// once the command's message is handled.
+	pendingShutdown *shutdownRequest
+}
+
+// stats are the server counters, zeroed by CONFIG RESETSTAT.
+type stats struct {
+	connectionsReceived atomic.Int64
+	rejectedConnections atomic.Int64
+	commandsProcessed   atomic.Int64
+	// syncFull counts the full syncs of replicas, syncPartialOK and
+	// syncPartialErr the PSYNC requests continued and refused.
+	syncFull       atomic.Int64
+	syncPartialOK  atomic.Int64
+	syncPartialErr atomic.Int64
+}
+
+func (s *Server) ResetStats() {
+	s.stats.connectionsReceived.Store(0)
+	s.stats.rejectedConnections.Store(0)
+	s.stats.commandsProcessed.Store(0)
+	s.stats.syncFull.Store(0)
+	s.stats.syncPartialOK.Store(0)
+	s.stats.syncPartialErr.Store(0)
+	s.db.ResetStats()
+}
+
+// Message holds the complete frames received from a client in one read,
+// in the order they were sent. Err is set when the stream turned out not to
+// be valid RESP after Values. Raw holds the bytes of each value for the
+// stream of a master, which is relayed as received.
 type Message struct {
-	Conn Conn
-	Data []byte
+	Conn   *Conn
+	Values []resp.Value
+	Raw    [][]byte
+	Err    error
 }
 
-func NewMessage(conn Conn, data []byte) Message {
+func NewMessage(conn *Conn, vals []resp.Value) Message {
 	return Message{
-		Conn: conn,
-		Data: data,
+		Conn:   conn,
+		Values: vals,
 	}
 }
 
 func NewServer(conf Config) *Server {
-	if conf.listenAddress == "" {
-		conf.listenAddress = listenAddress
-	}
-	return &Server{
-		quitCh: make(chan struct{}, 1),
-		config: conf,
-		peerCh: make(chan Conn),
-		peers:  make(map[Conn]bool),
-		msgCh:  make(chan Message),
-	}
-}
-
+	limits := make(map[ClientClass]OutputBufferLimit, len(defaultOutputBufferLimits))
+	for class, limit := range defaultOutputBufferLimits {
+		limits[class] = limit
+	}
+	for class, limit := range conf.OutputBufferLimits {
+		limits[class] = limit
+	}
+	conf.OutputBufferLimits = limits
+	s := &Server{
+		config:     conf,
+		peerCh:     make(chan *Conn),
+		delPeerCh:  make(chan *Conn),
+		peers:      make(map[*Conn]bool),
+		msgCh:      make(chan Message),
+		shutdownCh: make(chan shutdownRequest),
+		doneCh:     make(chan struct{}),
+		db:         repo.NewDB(),
+		startTime:  time.Now(),
+		rdb:        rdbState{lastSave: time.Now(), lastBgsaveOK: true},
+		aof:        aofState{lastRewriteOK: true},
+		repl:       newReplState(),
+		cron:       time.NewTicker(time.Second / time.Duration(conf.Hz)),
+	}
+	s.maxClients.Store(int64(conf.MaxClients))
+	s.idleTimeout.Store(int64(conf.Timeout))
+	s.db.SetMemoryConfig(conf.memoryConfig())
+	s.db.SetKeepExpired(conf.MasterHost != "")
+	return s
+}
+
+// Start serves clients until the server is shut down, by Shutdown or by
+// the SHUTDOWN command.
 func (s *Server) Start() error {
-	ln, err := net.Listen("tcp", s.config.listenAddress)
-	if err != nil {
-		return fmt.Errorf("fail to listen: %v", err)
-	}
-	s.ln = ln
-	go s.loop()
-	s.acceptLoop()
-	return nil
-}
-
-func (s *Server) loop() {
-	for {
-		select {
-		case message := <-s.msgCh:
-			if err := s.HandleRawMsg(message); err != nil {
-				slog.Error("Error handling raw message", "err", err)
-			}
-		case peer := <-s.peerCh:
-			s.peers[peer] = true
-		case <-s.quitCh:
-			return
-		}
-	}
-}
-
-func (s *Server) set(key, val string, ex string) error {
-	err := repo.KvString.Set(key, val, ex)
-	if err != nil {
+	if err := s.loadDataFromDisk(); err != nil {
 		return err
 	}
-	slog.Info("save to memroy success", "data", key+val)
-	return nil
-}
-
-func (s *Server) get(key string) ([]byte, error) {
-	bytes, err := repo.KvString.Get(key)
-	if err != nil {
-		return nil, err
-	}
-	return bytes, nil
-}
-
-func (s *Server) del(key string) error {
-	err := repo.KvString.Delete(key)
-	if err != nil {
+	if err := s.initAppendOnly(); err != nil {
 		return err
 	}
-	return nil
-}
-
-func (s *Server) exist(key string) (bool, error) {
-	ok, err := repo.KvString.Exist(key)
-	if err != nil {
-		return ok, err
-	}
-	return ok, nil
-}
-
-func (s *Server) incr(key, amount string) error {
-	err := repo.KvString.Incr(key, amount)
-	if err != nil {
+	if err := s.listen(); err != nil {
 		return err
 	}
-	return nil
-}
-
-func (s *Server) decr(key, amount string) error {
-	err := repo.KvString.Decr(key, amount)
-	if err != nil {
+	if err := s.clusterInit(); err != nil {
+		s.closeListeners()
 		return err
 	}
-	return nil
-}
-
-func (s *Server) zadd(key, member, score string) error {
-	flt, err := strconv.ParseFloat(score, 64)
-	if err != nil {
-		return err
-	}
-	err = repo.MemoryZset.Insert(key, member, flt)
-	if err != nil {
-		return err
+	go s.loop()
+	for _, ln := range s.listeners {
+		go s.acceptLoop(ln)
 	}
+	<-s.doneCh
 	return nil
 }
 
-func (s *Server) zrank(key, member string) (int, error) {
-	err, rank := repo.MemoryZset.Zrank(key, member)
-	if err != nil {
-		return rank, err
+// listen opens the TCP listeners of every bind address and the unix socket.
+func (s *Server) listen() error {
+	lc := net.ListenConfig{KeepAlive: s.config.TCPKeepAlive}
+	if lc.KeepAlive == 0 {
+		lc.KeepAlive = -1
 	}
-	return rank, err
-}
-
-func (s *Server) zscore(key, member string) (float64, error) {
-	flt := repo.MemoryZset.GetScore(key, member)
-	return flt, nil
-}
-
-func (s *Server) push(t, key, value string) {
-	switch t {
-	case "LPUSH":
-		repo.KvList.Lpush(key, value)
-	case "RPUSH":
-		repo.KvList.Rpush(key, value)
+	if s.config.Port != 0 {
+		binds := s.config.Bind
+		if len(binds) == 0 {
+			binds = []string{""}
+		}
+		for _, addr := range binds {
+			optional := strings.HasPrefix(addr, "-")
+			addr = strings.TrimPrefix(addr, "-")
+			switch addr {
+			case "*":
+				addr = "0.0.0.0"
+			case "::*":
+				addr = "::"
+			}
+			ln, err := lc.Listen(context.Background(), "tcp", net.JoinHostPort(addr, strconv.Itoa(s.config.Port)))
+			if err != nil && optional {
+				slog.Warn("Skipping optional bind address", "addr", addr, "err", err)
+				continue
+			}
+			if err != nil {
+				s.closeListeners()
+				return fmt.Errorf("fail to listen: %v", err)
+			}
+			s.listeners = append(s.listeners, ln)
+		}
 	}
-}
-
-func (s *Server) lrange(key, start, end string) ([]string, error) {
-	res, err := repo.KvList.Lrange(key, start, end)
-	if err != nil {
-		return nil, err
+	if path := s.config.UnixSocket; path != "" {
+		// a socket left behind by a previous run
+		os.Remove(path)
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			s.closeListeners()
+			return fmt.Errorf("fail to listen on unix socket: %v", err)
+		}
+		s.listeners = append(s.listeners, ln)
+		if perm := s.config.UnixSocketPerm; perm != 0 {
+			if err := os.Chmod(path, perm); err != nil {
+				s.closeListeners()
+				return fmt.Errorf("fail to set unix socket permissions: %v", err)
+			}
+		}
 	}
-	return res, nil
-}
-
-func (s *Server) commandHandlers() map[reflect.Type]func(Message, command.Command) error {
-	return map[reflect.Type]func(Message, command.Command) error{
-		reflect.TypeOf(command.SetCommand{}):    s.executeSetCommand,
-		reflect.TypeOf(command.GetCommand{}):    s.executeGetCommand,
-		reflect.TypeOf(command.DelCommand{}):    s.executeDelCommand,
-		reflect.TypeOf(command.ExistCommand{}):  s.executeExistCommand,
-		reflect.TypeOf(command.IncrCommand{}):   s.executeIncrCommand,
-		reflect.TypeOf(command.DecrCommand{}):   s.executeDecrCommand,
-		reflect.TypeOf(command.PushCommand{}):   s.executePushCommand,
-		reflect.TypeOf(command.LrangeCommand{}): s.executeLrangeCommand,
-		reflect.TypeOf(command.ZaddCommand{}):   s.executeZaddCommand,
-		reflect.TypeOf(command.ZscoreCommand{}): s.executeZscoreCommand,
-		reflect.TypeOf(command.ZrankCommand{}):  s.executeZrankCommand,
+	if len(s.listeners) == 0 {
+		return fmt.Errorf("fail to listen: no address available")
 	}
-}
-
-func (s *Server) executeZaddCommand(message Message, c command.Command) error {
-	cmd := c.(command.ZaddCommand)
-	err := s.zadd(cmd.Key, cmd.Member, cmd.Score)
-	if err != nil {
-		slog.Error("zset add err", "err", err)
-		s.handleErr(message, err)
-		return err
+	for _, ln := range s.listeners {
+		slog.Info("Ready to accept connections", "addr", ln.Addr().String())
 	}
-	s.handleSuccess(message, []byte(OK))
-
-	slog.Info("range list", "list:", cmd.Member, "score:", cmd.Score)
 	return nil
 }
 
-func (s *Server) executeZrankCommand(message Message, c command.Command) error {
-	cmd := c.(command.ZrankCommand)
-	rank, err := s.zrank(cmd.Key, cmd.Member)
-	str := strconv.Itoa(rank)
-	if err != nil {
-		slog.Error("zset rank err", "err", err)
-		s.handleErr(message, err)
-		return err
+func (s *Server) closeListeners() {
+	for _, ln := range s.listeners {
+		ln.Close()
 	}
-	respClient(message.Conn, []byte((str)), "data")
-	slog.Info("zset rank ", "zset:", cmd.Key, "member:", cmd.Member, "rank:", rank)
-	return nil
 }
 
-func (s *Server) executeZscoreCommand(message Message, c command.Command) error {
-	cmd := c.(command.ZscoreCommand)
-	flt, err := s.zscore(cmd.Key, cmd.Member)
-	if err != nil {
-		slog.Error("zset getscore err", "err", err)
-		s.handleErr(message, err)
-		return err
+func (s *Server) loop() {
+	defer close(s.doneCh)
+	defer s.cron.Stop()
+	for {
+		select {
+		case message := <-s.msgCh:
+			if err := s.HandleRawMsg(message); err != nil {
+				slog.Error("Error handling raw message", "err", err)
+			}
+			s.processWaiting(time.Now())
+			if req := s.pendingShutdown; req != nil {
+				s.pendingShutdown = nil
+				if err := s.shutdown(req); err != nil {
+					message.Conn.reply.WriteError(err)
+					message.Conn.flush()
+					continue
+				}
+				return
+			}
+		case <-s.cron.C:
+			s.serverCron()
+		case err := <-s.bgsaveCh():
+			s.bgsaveDone(err)
+		case err := <-s.aofRewriteCh():
+			s.rewriteDone(err)
+		case res := <-s.repl.rdbCh:
+			s.fullSyncDone(res)
+		case res := <-s.repl.syncCh:
+			s.masterSyncDone(res)
+		case ev := <-s.cluster.busCh:
+			s.clusterHandleEvent(ev)
+		case peer := <-s.peerCh:
+			s.addPeer(peer)
+		case peer := <-s.delPeerCh:
+			s.removePeer(peer)
+		case req := <-s.shutdownCh:
+			err := s.shutdown(&req)
+			req.errCh <- err
+			if err == nil {
+				return
+			}
+		}
 	}
-	str := strconv.FormatFloat(flt, 'f', 2, 64)
-	respClient(message.Conn, []byte(str), "data")
-	slog.Info("zset get score", "zset:", cmd.Key, "member:", cmd.Member)
-	return nil
 }
 
-func (s *Server) executeLrangeCommand(message Message, c command.Command) error {
-	cmd := c.(command.LrangeCommand)
-	res, err := s.lrange(cmd.Key, cmd.Start, cmd.End)
-	if err != nil {
-		slog.Error("lrange err", "err", err)
-		s.handleErr(message, err)
-		return err
-	}
-	data := strings.Join(res, " ")
-	respClient(message.Conn, []byte(data), "data")
-	slog.Info("range list", "list:", data)
-	return nil
+func (s *Server) addPeer(peer *Conn) {
+	peer.limit = s.config.OutputBufferLimits[peer.class]
+	s.peers[peer] = true
 }
 
-func (s *Server) executePushCommand(message Message, c command.Command) error {
-	cmd := c.(command.PushCommand)
-	s.push(cmd.T, cmd.Key, cmd.Value)
-	s.handleSuccess(message, []byte(OK))
-	slog.Info("PUSH command executed", "push type", cmd.T, "key", cmd.Key, "value", cmd.Value)
-	// slog.Info("now memory list", "list", repo.KvList.KvList["user"])
-	return nil
+func (s *Server) removePeer(peer *Conn) {
+	delete(s.peers, peer)
+	s.removeReplicationPeer(peer)
 }
 
-func (s *Server) executeSetCommand(message Message, c command.Command) error {
-	cmd := c.(command.SetCommand)
-	err := s.set(cmd.Key, cmd.Val, cmd.EX)
-	if err := s.handleErr(message, err); err != nil {
-		return err
+// executeCommand runs cmd for the connection of message, args is the
+// command as received. A failed command has its error sent as the reply.
+func (s *Server) executeCommand(message Message, cmd command.Command, args []resp.Value) error {
+	spec, ok := command.Lookup(cmd.Name())
+	write := ok && spec.Has(command.FlagWrite)
+	if s.config.ClusterEnabled && ok {
+		if err := s.clusterRedirect(message.Conn, cmd, spec); err != nil {
+			message.Conn.reply.WriteError(err)
+			return fmt.Errorf("%s: %w", cmd.Name(), err)
+		}
 	}
-	s.handleSuccess(message, []byte(OK))
-	slog.Info("SET command executed", "key", cmd.Key, "value", cmd.Val)
-	return nil
-}
-
-func (s *Server) executeGetCommand(message Message, c command.Command) error {
-	cmd := c.(command.GetCommand)
-	bytes, err := s.get(cmd.Key)
-	if err := s.handleErr(message, err); err != nil {
-		return err
+	if s.aof.writeErr != nil && write {
+		err := utils.CodedErrorf("MISCONF", "Errors writing to the AOF file: %v", s.aof.writeErr)
+		message.Conn.reply.WriteError(err)
+		return fmt.Errorf("%s: %w", cmd.Name(), err)
+	}
+	if s.config.MasterHost != "" && s.config.ReplicaReadOnly && write {
+		err := utils.CodedErrorf("READONLY", "You can't write against a read only replica.")
+		message.Conn.reply.WriteError(err)
+		return fmt.Errorf("%s: %w", cmd.Name(), err)
+	}
+	// a replica gets its evictions from the master, like every write
+	if s.config.MaxMemory > 0 && s.config.MasterHost == "" {
+		// keys are evicted before the command runs, the ones that may
+		// need more memory are refused if that is not enough
+		evicted, err := s.db.FreeMemory()
+		for _, key := range evicted {
+			s.propagate("DEL", key)
+		}
+		if ok && err != nil && spec.Has(command.FlagDenyOOM) {
+			message.Conn.reply.WriteError(err)
+			return fmt.Errorf("%s: %w", cmd.Name(), err)
+		}
 	}
-	respClient(message.Conn, bytes, "data")
-	return nil
+	return s.call(message.Conn, cmd, args)
 }
 
-func (s *Server) executeDelCommand(message Message, c command.Command) error {
-	cmd := c.(command.DelCommand)
-	err := s.del(cmd.Key)
-	if err := s.handleErr(message, err); err != nil {
-		return err
+// call executes cmd and propagates it when it changed the dataset, the
+// part of the dispatch the AOF replay goes through as well. args is the
+// command as received.
+func (s *Server) call(conn *Conn, cmd command.Command, args []resp.Value) error {
+	ctx := &command.Context{
+		Reply:  &conn.reply,
+		Client: conn,
+		Server: s,
+		DB:     s.db,
 	}
-	s.handleSuccess(message, []byte(OK))
-	return nil
-}
-
-func (s *Server) executeExistCommand(message Message, c command.Command) error {
-	cmd := c.(command.ExistCommand)
-	ok, err := s.exist(cmd.Key)
-	if err := s.handleErr(message, err); err != nil {
-		return err
+	dirty := s.db.Dirty()
+	err := cmd.Execute(ctx)
+	if err != nil {
+		conn.reply.WriteError(err)
+		err = fmt.Errorf("%s: %w", cmd.Name(), err)
 	}
-	respClient(message.Conn, []byte(utils.Btoi(ok)), "data")
-	return nil
-}
-
-func (s *Server) executeIncrCommand(message Message, c command.Command) error {
-	cmd := c.(command.IncrCommand)
-	err := s.incr(cmd.Key, cmd.Amount)
-	if err := s.handleErr(message, err); err != nil {
+	// the keys the command found expired are deleted before it ran
+	s.propagateExpired()
+	spec, ok := command.Lookup(cmd.Name())
+	if s.db.Dirty() == dirty || !ok || !spec.Has(command.FlagWrite) {
 		return err
 	}
-	s.handleSuccess(message, []byte(OK))
-	return nil
-}
-
-func (s *Server) executeDecrCommand(message Message, c command.Command) error {
-	cmd := c.(command.DecrCommand)
-	err := s.decr(cmd.Key, cmd.Amount)
-	if err := s.handleErr(message, err); err != nil {
+	if propagated := ctx.Propagated(); propagated != nil {
+		for _, argv := range propagated {
+			s.propagate(argv...)
+		}
+		conn.woff = s.repl.offset
 		return err
 	}
-	s.handleSuccess(message, []byte(OK))
-	return nil
-}
-
-func (s *Server) handleUnknownCommand(message Message) {
-	respClient(message.Conn, []byte("Unknown command"), "err")
-}
-
-func (s *Server) executeCommand(message Message, cmd command.Command) error {
-	handler := s.commandHandlers()
-	if handler == nil {
-		s.handleUnknownCommand(message)
-		return fmt.Errorf("unknown command: %v", cmd)
+	argv := make([]string, len(args))
+	for i, arg := range args {
+		argv[i] = arg.String()
 	}
-	return handler[reflect.TypeOf(cmd)](message, cmd)
+	s.propagate(argv...)
+	conn.woff = s.repl.offset
+	return err
 }
 
+// HandleRawMsg executes every command of a message in order and then
+// flushes their replies together. A failing command only gets an error
+// reply, the ones pipelined after it still run. The error returned is a
+// failure of the connection or of the protocol, not of a command.
 func (s *Server) HandleRawMsg(message Message) error {
-	cmd, err := command.ParseRawMsg(string(message.Data))
-	if err != nil {
-		respClient(message.Conn, []byte(err.Error()), "err")
+	if message.Conn.raw {
+		return s.handleMasterMessage(message)
+	}
+	if message.Conn.wait != nil {
+		// a blocked client runs nothing until it is unblocked
+		message.Conn.deferred = append(message.Conn.deferred, message)
+		return nil
+	}
+	for i, val := range message.Values {
+		if s.pendingShutdown != nil {
+			// commands pipelined after SHUTDOWN are never run
+			break
+		}
+		if message.Conn.wait != nil {
+			// the commands pipelined after a blocking one run once it is
+			// unblocked, a protocol error is reported after them
+			rest := message
+			rest.Values, message.Err = message.Values[i:], nil
+			message.Conn.deferred = append([]Message{rest}, message.Conn.deferred...)
+			break
+		}
+		cmd, err := command.ParseValue(val)
+		if err != nil {
+			message.Conn.reply.WriteError(err)
+		} else {
+			start := time.Now()
+			err = s.executeCommand(message, cmd, val.Array())
+			s.slowlogRecord(message.Conn, val.Array(), start, time.Since(start))
+			s.stats.commandsProcessed.Add(1)
+		}
+		if err != nil {
+			// the client got the error, it is not the server's
+			slog.Debug("Command failed", "addr", message.Conn.addr, "err", err)
+		}
+	}
+	if message.Err != nil {
+		message.Conn.reply.WriteError(message.Err)
+	}
+	if err := s.flushAppendOnlyFile(time.Now()); err != nil && s.config.AppendFsync == "always" {
+		// the writes are not on disk, they must not be acknowledged
+		message.Conn.reply.reset()
+		message.Conn.closeAfterReply()
 		return err
 	}
-	return s.executeCommand(message, cmd)
-}
-
-func respClient(conn Conn, data []byte, t string) error {
-	switch t {
-	case "err":
-		data = []byte("-ERR " + string(data) + "\r\n")
-	case "ok":
-		data = []byte(OK)
-	case "data":
-		data = []byte("$" + string(data) + "\r\n")
-	}
-	return conn.Write(data)
-}
-
-func (s *Server) handleErr(message Message, err error) error {
-	if err != nil {
-		respClient(message.Conn, []byte(err.Error()), "err")
+	s.flushReplicas()
+	if err := message.Conn.flush(); err != nil {
 		return err
 	}
+	if message.Err != nil {
+		message.Conn.closeAfterReply()
+		return message.Err
+	}
 	return nil
 }
 
-func (s *Server) handleSuccess(message Message, resp []byte) {
-	respClient(message.Conn, resp, "ok")
-}
-
 // listen new conn
-func (s *Server) acceptLoop() {
+func (s *Server) acceptLoop(ln net.Listener) {
 	for {
-		conn, err := s.ln.Accept()
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			slog.Error("Error accepting connection", "err", err)
 			continue
@@ -372,10 +423,38 @@ func (s *Server) acceptLoop() {
 	}
 }
 func (s *Server) handleConn(conn net.Conn) {
+	s.stats.connectionsReceived.Add(1)
+	defer s.clients.Add(-1)
+	if s.clients.Add(1) > s.maxClients.Load() {
+		s.stats.rejectedConnections.Add(1)
+		conn.Write([]byte("-ERR max number of clients reached\r\n"))
+		conn.Close()
+		return
+	}
 	peer := NewConn(conn, s.msgCh)
-	s.peerCh <- peer
+	peer.idleTimeout = &s.idleTimeout
+	peer.serverDone = s.doneCh
+	select {
+	case s.peerCh <- peer:
+	case <-s.doneCh:
+		peer.Close()
+		return
+	}
 	slog.Info("new connected: ", "add:", peer.addr)
-	if err := peer.read(); err != nil {
+	err := peer.read()
+	var perr *command.ProtocolError
+	if !errors.As(err, &perr) {
+		// a protocol error reply is still queued, the connection is closed
+		// once it is written
+		peer.Close()
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		slog.Info("Closing idle client", "addr", peer.addr)
+	} else if err != nil && err != io.EOF {
 		slog.Error("fail to read msg ", "err", err)
 	}
+	select {
+	case s.delPeerCh <- peer:
+	case <-s.doneCh:
+	}
 }
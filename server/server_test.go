@@ -0,0 +1,1132 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"go-redis/command"
+	"go-redis/pkg/rdb"
+	"go-redis/repo"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/resp"
+)
+
+// startTestServer runs a server on a random local port and returns a
+// connection to it.
+func startTestServer(t *testing.T) net.Conn {
+	t.Helper()
+	conf := DefaultConfig()
+	conf.Dir = t.TempDir()
+	return startTestServerWithConfig(t, conf)
+}
+
+func startTestServerWithConfig(t *testing.T, conf Config) net.Conn {
+	t.Helper()
+	if conf.Dir == "." {
+		// keep the snapshot saved at shutdown out of the source tree
+		conf.Dir = t.TempDir()
+	}
+	s := NewServer(conf)
+	require.NoError(t, s.loadDataFromDisk())
+	require.NoError(t, s.initAppendOnly())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s.listeners = []net.Listener{ln}
+	if conf.ClusterEnabled {
+		// nodes announce the ports they really listen on
+		s.config.Port = ln.Addr().(*net.TCPAddr).Port
+		s.cluster.ln, err = net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		require.NoError(t, s.clusterInit())
+	}
+	go s.loop()
+	t.Cleanup(func() {
+		s.Shutdown(context.Background())
+		<-s.doneCh
+	})
+	go s.acceptLoop(ln)
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	return conn
+}
+
+func TestPipelinedSetGet(t *testing.T) {
+	conn := startTestServer(t)
+	const pairs = 10000
+	var buf bytes.Buffer
+	for i := 0; i < pairs; i++ {
+		key, val := fmt.Sprintf("key:%d", i), fmt.Sprintf("val:%d", i)
+		fmt.Fprintf(&buf, "*3\r\n$3\r\nSET\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(key), key, len(val), val)
+		fmt.Fprintf(&buf, "*2\r\n$3\r\nGET\r\n$%d\r\n%s\r\n", len(key), key)
+	}
+	_, err := conn.Write(buf.Bytes())
+	require.NoError(t, err)
+
+	rd := bufio.NewReader(conn)
+	for i := 0; i < pairs; i++ {
+		line, err := rd.ReadString('\n')
+		require.NoError(t, err)
+		require.Equal(t, "+OK\r\n", line)
+		val := fmt.Sprintf("val:%d", i)
+		reply := make([]byte, len(fmt.Sprintf("$%d\r\n%s\r\n", len(val), val)))
+		_, err = io.ReadFull(rd, reply)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("$%d\r\n%s\r\n", len(val), val), string(reply))
+	}
+}
+
+// roundTrip sends each command RESP encoded and checks the raw replies
+// read back against want.
+func roundTrip(t *testing.T, conn net.Conn, want string, cmds ...[]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, args := range cmds {
+		fmt.Fprintf(&buf, "*%d\r\n", len(args))
+		for _, a := range args {
+			fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(a), a)
+		}
+	}
+	_, err := conn.Write(buf.Bytes())
+	require.NoError(t, err)
+	got := make([]byte, len(want))
+	_, err = io.ReadFull(conn, got)
+	require.NoError(t, err)
+	require.Equal(t, want, string(got))
+}
+
+func TestReplyEncoding(t *testing.T) {
+	conn := startTestServer(t)
+	roundTrip(t, conn, "$-1\r\n", []string{"GET", "missing"})
+	roundTrip(t, conn, ":0\r\n:1\r\n:11\r\n", []string{"EXISTS", "n"}, []string{"INCR", "n"}, []string{"INCR", "n", "10"})
+	roundTrip(t, conn, "-ERR increment or decrement would overflow\r\n:10\r\n",
+		[]string{"DECR", "n", "-9223372036854775808"}, []string{"DECR", "n"})
+	roundTrip(t, conn, "+OK\r\n-ERR value is not an integer or out of range\r\n",
+		[]string{"SET", "s", "abc"}, []string{"INCR", "s"})
+	// errors are ERR unless they carry their own code
+	roundTrip(t, conn, "-ERR GT and LT options at the same time are not compatible\r\n-WRONGTYPE Operation against a key holding the wrong kind of value\r\n",
+		[]string{"EXPIRE", "s", "1", "GT", "LT"}, []string{"LPUSH", "s", "a"})
+	roundTrip(t, conn, ":1\r\n:2\r\n:3\r\n*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n",
+		[]string{"RPUSH", "l", "b"}, []string{"LPUSH", "l", "a"}, []string{"RPUSH", "l", "c"}, []string{"LRANGE", "l", "0", "-1"})
+	roundTrip(t, conn, ":1\r\n:1\r\n:0\r\n:1\r\n$3\r\n1.5\r\n$-1\r\n",
+		[]string{"ZADD", "z", "2", "b"}, []string{"ZADD", "z", "1.5", "a"}, []string{"ZADD", "z", "1.5", "a"},
+		[]string{"ZRANK", "z", "b"}, []string{"ZSCORE", "z", "a"}, []string{"ZRANK", "z", "c"})
+}
+
+func TestHelloResp3(t *testing.T) {
+	conn := startTestServer(t)
+	roundTrip(t, conn, "-NOPROTO unsupported protocol version\r\n", []string{"HELLO", "4"})
+	_, err := conn.Write([]byte("*2\r\n$5\r\nHELLO\r\n$1\r\n3\r\n"))
+	require.NoError(t, err)
+	rd := bufio.NewReader(conn)
+	header, err := rd.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "%7\r\n", header)
+	want := fmt.Sprintf("$6\r\nserver\r\n$5\r\nredis\r\n$7\r\nversion\r\n$%d\r\n%s\r\n"+
+		"$5\r\nproto\r\n:3\r\n$2\r\nid\r\n:%d\r\n$4\r\nmode\r\n$10\r\nstandalone\r\n"+
+		"$4\r\nrole\r\n$6\r\nmaster\r\n$7\r\nmodules\r\n*0\r\n",
+		len(command.ServerVersion), command.ServerVersion, nextConnID.Load())
+	got := make([]byte, len(want))
+	_, err = io.ReadFull(rd, got)
+	require.NoError(t, err)
+	require.Equal(t, want, string(got))
+
+	roundTrip(t, conn, ":1\r\n,1.5\r\n_\r\n",
+		[]string{"ZADD", "z", "1.5", "a"}, []string{"ZSCORE", "z", "a"}, []string{"GET", "missing"})
+}
+
+func TestCommandIntrospection(t *testing.T) {
+	conn := startTestServer(t)
+	roundTrip(t, conn, fmt.Sprintf(":%d\r\n", len(command.Specs())), []string{"COMMAND", "COUNT"})
+	roundTrip(t, conn, "*1\r\n$1\r\na\r\n", []string{"COMMAND", "GETKEYS", "SET", "a", "b"})
+	roundTrip(t, conn, "-ERR The command has no key arguments\r\n", []string{"COMMAND", "GETKEYS", "HELLO"})
+	roundTrip(t, conn, "*2\r\n*-1\r\n*10\r\n$3\r\nget\r\n:2\r\n*2\r\n+readonly\r\n+fast\r\n:1\r\n:1\r\n:1\r\n"+
+		"*3\r\n+@read\r\n+@fast\r\n+@string\r\n*0\r\n"+
+		"*1\r\n*6\r\n$5\r\nflags\r\n*1\r\n+RO\r\n"+
+		"$12\r\nbegin_search\r\n*4\r\n$4\r\ntype\r\n$5\r\nindex\r\n$4\r\nspec\r\n*2\r\n$5\r\nindex\r\n:1\r\n"+
+		"$9\r\nfind_keys\r\n*4\r\n$4\r\ntype\r\n$5\r\nrange\r\n$4\r\nspec\r\n*6\r\n$7\r\nlastkey\r\n:0\r\n$7\r\nkeystep\r\n:1\r\n$5\r\nlimit\r\n:0\r\n"+
+		"*0\r\n", []string{"COMMAND", "INFO", "nope", "GET"})
+	roundTrip(t, conn, "*2\r\n$5\r\nhello\r\n*8\r\n$7\r\nsummary\r\n$33\r\nHandshakes with the Redis server.\r\n"+
+		"$5\r\nsince\r\n$5\r\n6.0.0\r\n$5\r\ngroup\r\n$10\r\nconnection\r\n$10\r\ncomplexity\r\n$4\r\nO(1)\r\n",
+		[]string{"COMMAND", "DOCS", "hello"})
+}
+
+func TestOutputBufferHardLimit(t *testing.T) {
+	conf := DefaultConfig()
+	conf.OutputBufferLimits[ClientNormal] = OutputBufferLimit{Hard: 1024}
+	conn := startTestServerWithConfig(t, conf)
+	value := strings.Repeat("x", 2048)
+	roundTrip(t, conn, "+OK\r\n", []string{"SET", "big", value})
+	_, err := conn.Write([]byte("GET big\r\n"))
+	require.NoError(t, err)
+	_, err = io.ReadAll(conn)
+	require.NoError(t, err, "the connection must be closed without the reply")
+}
+
+func TestOutputBufferSoftLimit(t *testing.T) {
+	c := &Conn{limit: OutputBufferLimit{Soft: 100, SoftSeconds: time.Minute}}
+	now := time.Now()
+	require.NoError(t, c.checkOutputLimit(200, now))
+	require.NoError(t, c.checkOutputLimit(200, now.Add(30*time.Second)))
+	// going under the soft limit resets the timer
+	require.NoError(t, c.checkOutputLimit(50, now.Add(40*time.Second)))
+	require.NoError(t, c.checkOutputLimit(200, now.Add(90*time.Second)))
+	require.Error(t, c.checkOutputLimit(200, now.Add(150*time.Second)))
+}
+
+func TestShutdownCommand(t *testing.T) {
+	conn := startTestServer(t)
+	roundTrip(t, conn, "-ERR No shutdown in progress.\r\n", []string{"SHUTDOWN", "ABORT"})
+	roundTrip(t, conn, "-ERR syntax error\r\n", []string{"SHUTDOWN", "SAVE", "NOSAVE"})
+	// SET runs before the shutdown, the GET pipelined after it never does
+	_, err := conn.Write([]byte("SET k v\r\nSHUTDOWN NOSAVE\r\nGET k\r\n"))
+	require.NoError(t, err)
+	rest, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, "+OK\r\n", string(rest))
+}
+
+func TestMaxClients(t *testing.T) {
+	conf := DefaultConfig()
+	conf.MaxClients = 1
+	conn := startTestServerWithConfig(t, conf)
+	roundTrip(t, conn, "+OK\r\n", []string{"SET", "k", "v"})
+	other, err := net.Dial("tcp", conn.RemoteAddr().String())
+	require.NoError(t, err)
+	defer other.Close()
+	other.SetDeadline(time.Now().Add(5 * time.Second))
+	reply, err := io.ReadAll(other)
+	require.NoError(t, err)
+	require.Equal(t, "-ERR max number of clients reached\r\n", string(reply))
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis.conf")
+	require.NoError(t, os.WriteFile(path, []byte(`# comment
+bind 127.0.0.1 -::1
+port 6380
+maxmemory 100mb
+maxmemory-policy ALLKEYS-LRU
+save ""
+client-output-buffer-limit pubsub 64mb 16mb 90
+logfile "/tmp/redis log.txt"
+`), 0o644))
+	conf, err := LoadConfig([]string{path, "--port", "6381", "--appendonly", "yes"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"127.0.0.1", "-::1"}, conf.Bind)
+	require.Equal(t, 6381, conf.Port)
+	require.Equal(t, int64(100<<20), conf.MaxMemory)
+	require.Equal(t, "allkeys-lru", conf.MaxMemoryPolicy)
+	require.Empty(t, conf.Save)
+	require.True(t, conf.AppendOnly)
+	require.Equal(t, "/tmp/redis log.txt", conf.LogFile)
+	require.Equal(t, OutputBufferLimit{Hard: 64 << 20, Soft: 16 << 20, SoftSeconds: 90 * time.Second},
+		conf.OutputBufferLimits[ClientPubsub])
+
+	require.NoError(t, os.WriteFile(path, []byte("port 6380\nport abc\n"), 0o644))
+	_, err = LoadConfig([]string{path})
+	var cerr *ConfigError
+	require.ErrorAs(t, err, &cerr)
+	require.Equal(t, 2, cerr.Line)
+
+	_, err = LoadConfig([]string{"--nosuchdirective", "1"})
+	require.ErrorAs(t, err, &cerr)
+	_, err = LoadConfig([]string{"--port", "70000"})
+	require.ErrorContains(t, err, "invalid port")
+	_, err = LoadConfig([]string{"--appendfsync", "sometimes"})
+	require.ErrorContains(t, err, "appendfsync")
+}
+
+func TestConfigGetSet(t *testing.T) {
+	conn := startTestServer(t)
+	roundTrip(t, conn, "*6\r\n$9\r\nmaxmemory\r\n$1\r\n0\r\n$16\r\nmaxmemory-policy\r\n$10\r\nnoeviction\r\n"+
+		"$17\r\nmaxmemory-samples\r\n$1\r\n5\r\n",
+		[]string{"CONFIG", "GET", "maxmemory*"})
+	roundTrip(t, conn, "+OK\r\n", []string{"CONFIG", "SET", "maxmemory", "10mb", "save", ""})
+	roundTrip(t, conn, "*4\r\n$4\r\nsave\r\n$0\r\n\r\n$9\r\nmaxmemory\r\n$8\r\n10485760\r\n",
+		[]string{"CONFIG", "GET", "MAXMEMORY", "save"})
+	roundTrip(t, conn, "-ERR CONFIG SET failed (possibly related to argument 'port') - can't set immutable config\r\n",
+		[]string{"CONFIG", "SET", "port", "6380"})
+	roundTrip(t, conn, "-ERR CONFIG SET failed (possibly related to argument 'maxmemory') - argument must be a memory value\r\n",
+		[]string{"CONFIG", "SET", "maxmemory", "lots"})
+	// nothing is applied when one of the values is rejected
+	roundTrip(t, conn, "-ERR CONFIG SET failed - invalid appendfsync sometimes, must be one of always, everysec, no\r\n",
+		[]string{"CONFIG", "SET", "timeout", "5", "appendfsync", "sometimes"})
+	roundTrip(t, conn, "*2\r\n$7\r\ntimeout\r\n$1\r\n0\r\n", []string{"CONFIG", "GET", "timeout"})
+	// aliases are matched too
+	roundTrip(t, conn, "+OK\r\n*2\r\n$15\r\nslave-read-only\r\n$2\r\nno\r\n"+
+		"*4\r\n$17\r\nreplica-read-only\r\n$2\r\nno\r\n$15\r\nslave-read-only\r\n$2\r\nno\r\n",
+		[]string{"CONFIG", "SET", "slave-read-only", "no"}, []string{"CONFIG", "GET", "slave-read-only"},
+		[]string{"CONFIG", "GET", "*-read-only"})
+	roundTrip(t, conn, "-ERR The server is running without a config file\r\n", []string{"CONFIG", "REWRITE"})
+}
+
+func TestConfigRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis.conf")
+	require.NoError(t, os.WriteFile(path, []byte("# my settings\nmaxmemory 1mb\nfuture-directive 1\nmaxmemory 2mb\n"), 0o600))
+	conf := DefaultConfig()
+	conf.File = path
+	conf.Dir = filepath.Dir(path)
+	conn := startTestServerWithConfig(t, conf)
+	roundTrip(t, conn, "+OK\r\n+OK\r\n",
+		[]string{"CONFIG", "SET", "maxmemory", "3mb", "loglevel", "warning"},
+		[]string{"CONFIG", "REWRITE"})
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "# my settings\nmaxmemory 3145728\nfuture-directive 1\n"+
+		"# Generated by CONFIG REWRITE\ndir "+conf.Dir+"\nloglevel warning\n", string(data))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestSlowlog(t *testing.T) {
+	conn := startTestServer(t)
+	// the new threshold already applies to the CONFIG SET changing it, and
+	// SLOWLOG RESET is logged after the log is emptied
+	roundTrip(t, conn, "+OK\r\n+OK\r\n:2\r\n+OK\r\n+OK\r\n:1\r\n",
+		[]string{"CONFIG", "SET", "slowlog-log-slower-than", "0"},
+		[]string{"SET", "k", "v"},
+		[]string{"SLOWLOG", "LEN"},
+		[]string{"SLOWLOG", "RESET"},
+		[]string{"CONFIG", "SET", "slowlog-log-slower-than", "-1"},
+		[]string{"SLOWLOG", "LEN"},
+	)
+}
+
+func TestWrongType(t *testing.T) {
+	conn := startTestServer(t)
+	wrongType := "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+	roundTrip(t, conn, ":1\r\n"+wrongType+wrongType+wrongType+wrongType+":1\r\n",
+		[]string{"LPUSH", "k", "a"},
+		[]string{"GET", "k"},
+		[]string{"INCR", "k"},
+		[]string{"ZADD", "k", "1", "m"},
+		[]string{"ZSCORE", "k", "m"},
+		[]string{"EXISTS", "k"},
+	)
+	// SET replaces a value of any type
+	roundTrip(t, conn, "+OK\r\n"+wrongType+"$1\r\nv\r\n",
+		[]string{"SET", "k", "v"},
+		[]string{"LRANGE", "k", "0", "-1"},
+		[]string{"GET", "k"},
+	)
+	roundTrip(t, conn, ":1\r\n"+wrongType+":1\r\n$-1\r\n",
+		[]string{"ZADD", "z", "1", "m"},
+		[]string{"RPUSH", "z", "a"},
+		[]string{"DEL", "z"},
+		[]string{"ZSCORE", "z", "m"},
+	)
+}
+
+func TestGenericKeyCommands(t *testing.T) {
+	conn := startTestServer(t)
+	roundTrip(t, conn, "+OK\r\n:1\r\n:1\r\n:3\r\n+string\r\n+list\r\n+zset\r\n+none\r\n:3\r\n",
+		[]string{"SET", "s", "v"},
+		[]string{"RPUSH", "l", "a"},
+		[]string{"ZADD", "z", "1", "m"},
+		[]string{"EXISTS", "s", "l", "missing", "s"},
+		[]string{"TYPE", "s"},
+		[]string{"TYPE", "l"},
+		[]string{"TYPE", "z"},
+		[]string{"TYPE", "missing"},
+		[]string{"DBSIZE"},
+	)
+	roundTrip(t, conn, "*1\r\n$1\r\nl\r\n", []string{"KEYS", "[lm]*"})
+	roundTrip(t, conn, "+OK\r\n:0\r\n-ERR no such key\r\n:1\r\n",
+		[]string{"RENAME", "l", "l2"},
+		[]string{"RENAMENX", "s", "l2"},
+		[]string{"RENAME", "l", "l3"},
+		[]string{"RENAMENX", "l2", "l"},
+	)
+	// the copy is independent from the original
+	roundTrip(t, conn, ":1\r\n:0\r\n:1\r\n:2\r\n*1\r\n$1\r\na\r\n",
+		[]string{"COPY", "l", "c"},
+		[]string{"COPY", "s", "c"},
+		[]string{"COPY", "s", "c", "REPLACE"},
+		[]string{"RPUSH", "l", "b"},
+		[]string{"LRANGE", "l", "0", "0"},
+	)
+	roundTrip(t, conn, "-ERR source and destination objects are the same\r\n-ERR DB index is out of range\r\n",
+		[]string{"COPY", "s", "s"},
+		[]string{"COPY", "s", "d", "DB", "1"},
+	)
+	roundTrip(t, conn, "+OK\r\n-ERR DB index is out of range\r\n", []string{"SELECT", "0"}, []string{"SELECT", "1"})
+	roundTrip(t, conn, ":3\r\n:1\r\n:3\r\n$-1\r\n:0\r\n",
+		[]string{"TOUCH", "s", "l", "c", "nope"},
+		[]string{"UNLINK", "s", "nope"},
+		[]string{"DEL", "l", "c", "z", "nope"},
+		[]string{"RANDOMKEY"},
+		[]string{"DBSIZE"},
+	)
+}
+
+// readReply decodes the next reply from conn.
+func readReply(t *testing.T, conn net.Conn, p *command.Parser) resp.Value {
+	t.Helper()
+	buf := make([]byte, 16*1024)
+	for {
+		val, ok, err := p.Next()
+		require.NoError(t, err)
+		if ok {
+			return val
+		}
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+		p.Feed(buf[:n])
+	}
+}
+
+func TestScan(t *testing.T) {
+	conn := startTestServer(t)
+	p := command.NewParser()
+	const keys = 1000
+	var buf bytes.Buffer
+	for i := 0; i < keys; i++ {
+		fmt.Fprintf(&buf, "SET key:%d v\r\n", i)
+	}
+	buf.WriteString("RPUSH list a\r\n")
+	conn.Write(buf.Bytes())
+	for i := 0; i <= keys; i++ {
+		readReply(t, conn, p)
+	}
+
+	seen := make(map[string]int)
+	cursor := "0"
+	for step := 0; ; step++ {
+		if step == 5 {
+			// the table grows under the scan
+			buf.Reset()
+			for i := 0; i < 3*keys; i++ {
+				fmt.Fprintf(&buf, "SET other:%d v\r\n", i)
+			}
+			conn.Write(buf.Bytes())
+			for i := 0; i < 3*keys; i++ {
+				readReply(t, conn, p)
+			}
+		}
+		args := []string{"SCAN", cursor, "MATCH", "key:*", "COUNT", "50", "TYPE", "string"}
+		buf.Reset()
+		fmt.Fprintf(&buf, "*%d\r\n", len(args))
+		for _, a := range args {
+			fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(a), a)
+		}
+		conn.Write(buf.Bytes())
+		reply := readReply(t, conn, p).Array()
+		require.Len(t, reply, 2)
+		for _, key := range reply[1].Array() {
+			seen[key.String()]++
+		}
+		cursor = reply[0].String()
+		if cursor == "0" {
+			break
+		}
+	}
+	require.Len(t, seen, keys, "every key:* key is returned, and nothing else")
+}
+
+func TestZscan(t *testing.T) {
+	conn := startTestServer(t)
+	roundTrip(t, conn, ":1\r\n:1\r\n:1\r\n", []string{"ZADD", "z", "1", "a"}, []string{"ZADD", "z", "2.5", "b"},
+		[]string{"ZADD", "z", "3", "c"})
+	p := command.NewParser()
+	conn.Write([]byte("ZSCAN z 0 MATCH [ab] COUNT 100\r\n"))
+	reply := readReply(t, conn, p).Array()
+	require.Equal(t, "0", reply[0].String())
+	got := make(map[string]string)
+	items := reply[1].Array()
+	for i := 0; i < len(items); i += 2 {
+		got[items[i].String()] = items[i+1].String()
+	}
+	require.Equal(t, map[string]string{"a": "1", "b": "2.5"}, got)
+	roundTrip(t, conn, "*2\r\n$1\r\n0\r\n*0\r\n+OK\r\n-WRONGTYPE Operation against a key holding the wrong kind of value\r\n",
+		[]string{"ZSCAN", "missing", "0"},
+		[]string{"SET", "s", "v"},
+		[]string{"ZSCAN", "s", "0"},
+	)
+}
+
+func TestExpire(t *testing.T) {
+	conn := startTestServer(t)
+	roundTrip(t, conn, "+OK\r\n:-1\r\n:-2\r\n:1\r\n:0\r\n:0\r\n:1\r\n:1\r\n:4102444800\r\n:4102444800000\r\n:1\r\n:-1\r\n:0\r\n",
+		[]string{"SET", "k", "v"},
+		[]string{"TTL", "k"},
+		[]string{"TTL", "missing"},
+		[]string{"EXPIRE", "k", "100", "NX"},
+		[]string{"EXPIRE", "k", "200", "NX"},
+		[]string{"EXPIRE", "k", "50", "GT"},
+		[]string{"EXPIRE", "k", "50", "LT"},
+		[]string{"EXPIREAT", "k", "4102444800", "XX"},
+		[]string{"EXPIRETIME", "k"},
+		[]string{"PEXPIRETIME", "k"},
+		[]string{"PERSIST", "k"},
+		[]string{"TTL", "k"},
+		[]string{"PERSIST", "k"},
+	)
+
+	// every type expires lazily
+	roundTrip(t, conn, ":1\r\n:1\r\n:1\r\n:1\r\n", []string{"LPUSH", "l", "a"}, []string{"ZADD", "z", "1", "a"},
+		[]string{"PEXPIRE", "l", "20"}, []string{"PEXPIRE", "z", "20"})
+	time.Sleep(50 * time.Millisecond)
+	roundTrip(t, conn, ":0\r\n+none\r\n*0\r\n:-2\r\n",
+		[]string{"EXISTS", "l", "z"},
+		[]string{"TYPE", "l"},
+		[]string{"LRANGE", "l", "0", "-1"},
+		[]string{"PTTL", "z"},
+	)
+	// a time in the past deletes the key
+	roundTrip(t, conn, "+OK\r\n:1\r\n:0\r\n", []string{"SET", "k", "v"}, []string{"EXPIRE", "k", "-1"},
+		[]string{"EXISTS", "k"})
+
+	// SET only takes a positive time to live
+	invalid := "-ERR invalid expire time in 'set' command\r\n"
+	roundTrip(t, conn, invalid+invalid+invalid+invalid+"-ERR value is not an integer or out of range\r\n:0\r\n",
+		[]string{"SET", "k", "v", "EX", "0"},
+		[]string{"SET", "k", "v", "EX", "-1"},
+		[]string{"SET", "k", "v", "PX", "-5"},
+		[]string{"SET", "k", "v", "EX", "9223372036854775"},
+		[]string{"SET", "k", "v", "PX", "soon"},
+		[]string{"EXISTS", "k"},
+	)
+	roundTrip(t, conn, "+OK\r\n:100\r\n", []string{"SET", "k", "v", "EX", "100"}, []string{"TTL", "k"})
+}
+
+func TestActiveExpire(t *testing.T) {
+	conf := DefaultConfig()
+	conf.Hz = 100
+	conn := startTestServerWithConfig(t, conf)
+	var cmds [][]string
+	var want string
+	for i := 0; i < 50; i++ {
+		cmds = append(cmds, []string{"SET", fmt.Sprintf("k%d", i), "v", "PX", "10"})
+		want += "+OK\r\n"
+	}
+	roundTrip(t, conn, want+"+OK\r\n", append(cmds, []string{"SET", "kept", "v"})...)
+
+	r := resp.NewReader(conn)
+	info := func(args string) string {
+		conn.Write([]byte("INFO " + args + "\r\n"))
+		val, _, err := r.ReadValue()
+		require.NoError(t, err)
+		return val.String()
+	}
+	// nobody reads the keys again, the active cycle deletes them anyway
+	require.Eventually(t, func() bool {
+		s := info("keyspace stats")
+		return strings.Contains(s, "expired_keys:50\r\n") && strings.Contains(s, "db0:keys=1,expires=0,")
+	}, 2*time.Second, 20*time.Millisecond)
+
+	all := info("")
+	for _, section := range []string{"# Server\r\n", "# Clients\r\n", "# Stats\r\n", "# Keyspace\r\n"} {
+		require.Contains(t, all, section)
+	}
+	require.NotContains(t, info("clients"), "# Server")
+}
+
+// respClient sends commands and reads their replies, whatever their type.
+type respClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *resp.Reader
+}
+
+func newRespClient(t *testing.T, conn net.Conn) *respClient {
+	return &respClient{t: t, conn: conn, r: resp.NewReader(conn)}
+}
+
+func (c *respClient) do(args ...string) resp.Value {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(a), a)
+	}
+	_, err := c.conn.Write(buf.Bytes())
+	require.NoError(c.t, err)
+	val, _, err := c.r.ReadValue()
+	require.NoError(c.t, err)
+	return val
+}
+
+func (c *respClient) info(section, field string) string {
+	for _, line := range strings.Split(c.do("INFO", section).String(), "\r\n") {
+		if v, ok := strings.CutPrefix(line, field+":"); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+func TestMaxmemory(t *testing.T) {
+	value := strings.Repeat("x", 100)
+	// fill sets 100 keys and makes them the memory limit
+	fill := func(c *respClient, policy string) {
+		for i := 0; i < 100; i++ {
+			require.Equal(t, "OK", c.do("SET", fmt.Sprintf("k%d", i), value).String())
+		}
+		require.Equal(t, "OK", c.do("CONFIG", "SET", "maxmemory", c.info("memory", "used_memory"),
+			"maxmemory-policy", policy).String())
+	}
+
+	t.Run("noeviction", func(t *testing.T) {
+		c := newRespClient(t, startTestServer(t))
+		fill(c, "noeviction")
+		// memory is checked before a command, this one goes over the limit
+		require.Equal(t, "OK", c.do("SET", "new", value).String())
+		// then only the writes that may need memory are refused
+		require.EqualError(t, c.do("SET", "new2", value).Error(), "OOM command not allowed when used memory > 'maxmemory'.")
+		require.Equal(t, value, c.do("GET", "k1").String())
+		require.Equal(t, 2, c.do("DEL", "k1", "new").Integer())
+		require.Equal(t, "OK", c.do("SET", "new2", value).String())
+	})
+
+	// the keys used recently, or often, survive the eviction of half the
+	// keyspace
+	for _, policy := range []string{"allkeys-lru", "allkeys-lfu"} {
+		t.Run(policy, func(t *testing.T) {
+			conf := DefaultConfig()
+			conf.MaxMemorySamples = 10
+			c := newRespClient(t, startTestServerWithConfig(t, conf))
+			fill(c, policy)
+			time.Sleep(10 * time.Millisecond)
+			for i := 0; i < 50; i++ {
+				c.do("GET", fmt.Sprintf("k%d", i))
+			}
+			time.Sleep(10 * time.Millisecond)
+			for i := 0; i < 50; i++ {
+				require.Equal(t, "OK", c.do("SET", fmt.Sprintf("new%d", i), value).String())
+			}
+			kept := 0
+			for i := 0; i < 50; i++ {
+				kept += c.do("EXISTS", fmt.Sprintf("k%d", i)).Integer()
+			}
+			require.GreaterOrEqual(t, kept, 40)
+			evicted, _ := strconv.Atoi(c.info("stats", "evicted_keys"))
+			require.GreaterOrEqual(t, evicted, 50)
+		})
+	}
+
+	t.Run("volatile", func(t *testing.T) {
+		c := newRespClient(t, startTestServer(t))
+		fill(c, "noeviction")
+		require.Equal(t, 1, c.do("EXPIRE", "k1", "1000").Integer())
+		require.Equal(t, 1, c.do("EXPIRE", "k2", "10").Integer())
+		require.Equal(t, "OK", c.do("CONFIG", "SET", "maxmemory", c.info("memory", "used_memory"),
+			"maxmemory-policy", "volatile-ttl").String())
+		// every command frees memory before it runs, the key closest to
+		// expiring goes first
+		require.Equal(t, "OK", c.do("SET", "new", value).String())
+		require.Equal(t, 0, c.do("EXISTS", "k2").Integer())
+		require.Equal(t, 1, c.do("EXISTS", "k1").Integer())
+		require.Equal(t, "OK", c.do("SET", "new2", value).String())
+		require.Equal(t, 0, c.do("EXISTS", "k1").Integer())
+		// there is nothing left to evict once no key has a time to live
+		require.Equal(t, "OK", c.do("SET", "new3", value).String())
+		require.EqualError(t, c.do("SET", "new4", value).Error(), "OOM command not allowed when used memory > 'maxmemory'.")
+	})
+}
+
+func TestRDBPersistence(t *testing.T) {
+	conf := DefaultConfig()
+	conf.Dir = t.TempDir()
+	conf.Save = nil
+	c := newRespClient(t, startTestServerWithConfig(t, conf))
+	for _, cmd := range [][]string{
+		{"SET", "s", "hello"}, {"SET", "n", "-70000"}, {"RPUSH", "l", "a"}, {"RPUSH", "l", "b"},
+		{"ZADD", "z", "1.5", "m"}, {"ZADD", "z", "-2", "n"}, {"SET", "tmp", "v"}, {"EXPIRE", "tmp", "100"},
+	} {
+		require.Nil(t, c.do(cmd...).Error())
+	}
+	require.Equal(t, "8", c.info("persistence", "rdb_changes_since_last_save"))
+	require.Equal(t, "OK", c.do("SAVE").String())
+	require.InDelta(t, time.Now().Unix(), c.do("LASTSAVE").Integer(), 1)
+	require.Equal(t, "0", c.info("persistence", "rdb_changes_since_last_save"))
+
+	// the snapshot is taken when BGSAVE runs, later writes are not in it
+	require.Equal(t, "OK", c.do("SET", "after", "v").String())
+	require.Equal(t, "Background saving started", c.do("BGSAVE").String())
+	require.Equal(t, "OK", c.do("SET", "later", "v").String())
+	// values modified in place are copied rather than changed under it
+	require.Equal(t, 3, c.do("RPUSH", "l", "c").Integer())
+	require.Equal(t, 1, c.do("ZADD", "z", "5", "added").Integer())
+	require.Equal(t, -69999, c.do("INCR", "n").Integer())
+	require.Eventually(t, func() bool {
+		return c.info("persistence", "rdb_bgsave_in_progress") == "0"
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, "ok", c.info("persistence", "rdb_last_bgsave_status"))
+	require.Equal(t, "4", c.info("persistence", "rdb_changes_since_last_save"))
+
+	// a new server loads the snapshot at startup
+	c2 := newRespClient(t, startTestServerWithConfig(t, conf))
+	require.Equal(t, "hello", c2.do("GET", "s").String())
+	require.Equal(t, "-70000", c2.do("GET", "n").String())
+	require.Equal(t, []string{"a", "b"}, stringValues(c2.do("LRANGE", "l", "0", "-1")))
+	require.Equal(t, "-2", c2.do("ZSCORE", "z", "n").String())
+	require.True(t, c2.do("ZSCORE", "z", "added").IsNull())
+	require.InDelta(t, 100, c2.do("TTL", "tmp").Integer(), 2)
+	require.Equal(t, "v", c2.do("GET", "after").String())
+	require.True(t, c2.do("GET", "later").IsNull())
+
+	// save rules trigger a background save
+	require.Equal(t, "OK", c2.do("CONFIG", "SET", "save", "1 2").String())
+	c2.do("SET", "a", "1")
+	c2.do("SET", "b", "2")
+	require.Eventually(t, func() bool {
+		return c2.info("persistence", "rdb_saves") == "1"
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestLoadRedisRDB(t *testing.T) {
+	// a version 11 file with the encodings of Redis 7.2: a quicklist of
+	// listpacks, a listpack sorted set and an LZF compressed string
+	dump, err := hex.DecodeString("524544495330303131fa0972656469732d76657205372e322e34fe00fb030012046c69737401021010" +
+		"0000000300816102dfff02c3e802ff11047a73657414140000000400816d0283312e3504816e020201ff0003626967c30628016162e0" +
+		"1d01ff4371618a864d08af")
+	require.NoError(t, err)
+	conf := DefaultConfig()
+	conf.Dir = t.TempDir()
+	conf.Save = nil
+	require.NoError(t, os.WriteFile(filepath.Join(conf.Dir, conf.DBFilename), dump, 0o644))
+	c := newRespClient(t, startTestServerWithConfig(t, conf))
+	require.Equal(t, []string{"a", "-1", "1000"}, stringValues(c.do("LRANGE", "list", "0", "-1")))
+	require.Equal(t, "1.5", c.do("ZSCORE", "zset", "m").String())
+	require.Equal(t, strings.Repeat("ab", 20), c.do("GET", "big").String())
+
+	// types the server does not have are refused rather than dropped
+	var buf bytes.Buffer
+	w, err := rdb.NewWriter(&buf, rdb.MaxVersion)
+	require.NoError(t, err)
+	require.NoError(t, w.Write(&rdb.Entry{Key: "h", Value: rdb.Hash{{Field: "f", Value: "v"}}}))
+	require.NoError(t, w.Close())
+	_, err = repo.NewDB().LoadRDB(&buf)
+	require.ErrorContains(t, err, "hash values are not supported")
+}
+
+func TestAOF(t *testing.T) {
+	conf := DefaultConfig()
+	conf.Dir = t.TempDir()
+	conf.Save = nil
+	conf.AppendOnly = true
+	conf.AppendFsync = "always"
+	c := newRespClient(t, startTestServerWithConfig(t, conf))
+	for _, cmd := range [][]string{
+		{"SET", "s", "hello"}, {"RPUSH", "l", "a"}, {"RPUSH", "l", "b"}, {"ZADD", "z", "1.5", "m"},
+		{"SET", "tmp", "v", "EX", "100"}, {"SET", "n", "1"}, {"INCR", "n"}, {"EXPIRE", "n", "-1"},
+	} {
+		require.Nil(t, c.do(cmd...).Error())
+	}
+	require.Equal(t, "1", c.info("persistence", "aof_enabled"))
+	// commands that changed nothing are not logged, relative times are
+	// logged as absolute ones
+	c.do("DEL", "missing")
+	c.do("GET", "s")
+	data, err := os.ReadFile(filepath.Join(conf.Dir, "appendonlydir", "appendonly.aof.1.incr.aof"))
+	require.NoError(t, err)
+	require.Contains(t, string(data), "$9\r\nPEXPIREAT\r\n$3\r\ntmp\r\n")
+	require.Contains(t, string(data), "*2\r\n$3\r\nDEL\r\n$1\r\nn\r\n")
+	require.NotContains(t, string(data), "EX\r\n")
+	require.NotContains(t, string(data), "missing")
+
+	// a new server replays the AOF
+	c2 := newRespClient(t, startTestServerWithConfig(t, conf))
+	require.Equal(t, "hello", c2.do("GET", "s").String())
+	require.Equal(t, []string{"a", "b"}, stringValues(c2.do("LRANGE", "l", "0", "-1")))
+	require.Equal(t, "1.5", c2.do("ZSCORE", "z", "m").String())
+	require.InDelta(t, 100, c2.do("TTL", "tmp").Integer(), 2)
+	require.Equal(t, 0, c2.do("EXISTS", "n").Integer())
+
+	// a single file AOF is moved to the AOF directory, a command cut
+	// short at its end is dropped
+	legacy := conf
+	legacy.Dir = t.TempDir()
+	legacy.AOFLoadTruncated = false
+	aof := filepath.Join(legacy.Dir, "appendonly.aof")
+	whole := "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n"
+	require.NoError(t, os.WriteFile(aof, []byte(whole+"*3\r\n$3\r\nSET\r\n$1\r\nx"), 0o644))
+	var out bytes.Buffer
+	require.Error(t, CheckAOF(aof, false, &out))
+	require.Contains(t, out.String(), "ok_up_to=27, ok_up_to_command=1, diff=18")
+	require.ErrorContains(t, NewServer(legacy).loadDataFromDisk(), "unexpected end of file")
+
+	legacy.AOFLoadTruncated = true
+	c3 := newRespClient(t, startTestServerWithConfig(t, legacy))
+	require.Equal(t, "v", c3.do("GET", "k").String())
+	data, err = os.ReadFile(filepath.Join(legacy.Dir, "appendonlydir", "appendonly.aof"))
+	require.NoError(t, err)
+	require.Equal(t, whole, string(data))
+	manifest := filepath.Join(legacy.Dir, "appendonlydir", "appendonly.aof.manifest")
+	data, err = os.ReadFile(manifest)
+	require.NoError(t, err)
+	require.Equal(t, "file appendonly.aof seq 1 type b\nfile appendonly.aof.1.incr.aof seq 1 type i\n", string(data))
+	require.NoError(t, CheckAOF(manifest, false, &out))
+
+	// garbage is reported, the repair mode drops it
+	require.NoError(t, os.WriteFile(aof, []byte(whole+"garbage\r\n"+whole), 0o644))
+	require.ErrorContains(t, CheckAOF(aof, false, &out), "not valid")
+	require.Contains(t, out.String(), "expected '*'")
+	require.NoError(t, CheckAOF(aof, true, &out))
+	require.NoError(t, CheckAOF(aof, false, &out))
+
+	// turning the AOF on at runtime writes the data already there
+	conf.AppendOnly = false
+	conf.Dir = t.TempDir()
+	c4 := newRespClient(t, startTestServerWithConfig(t, conf))
+	require.Equal(t, "OK", c4.do("SET", "before", "1").String())
+	require.Equal(t, "OK", c4.do("CONFIG", "SET", "appendonly", "yes").String())
+	require.Equal(t, "OK", c4.do("SET", "after", "2").String())
+	data, err = os.ReadFile(filepath.Join(conf.Dir, "appendonlydir", "appendonly.aof.1.incr.aof"))
+	require.NoError(t, err)
+	require.Equal(t, "*3\r\n$3\r\nSET\r\n$5\r\nafter\r\n$1\r\n2\r\n", string(data))
+	conf.AppendOnly = true
+	c5 := newRespClient(t, startTestServerWithConfig(t, conf))
+	require.Equal(t, "1", c5.do("GET", "before").String())
+	require.Equal(t, "2", c5.do("GET", "after").String())
+}
+
+func TestAOFRewrite(t *testing.T) {
+	conf := DefaultConfig()
+	conf.Dir = t.TempDir()
+	conf.Save = nil
+	conf.AppendOnly = true
+	conf.AppendFsync = "always"
+	conf.AOFUseRDBPreamble = false
+	c := newRespClient(t, startTestServerWithConfig(t, conf))
+	for i := 0; i < 100; i++ {
+		require.Nil(t, c.do("INCR", "counter").Error())
+	}
+	require.Nil(t, c.do("ZADD", "z", "inf", "m").Error())
+	require.Equal(t, "Background append only file rewriting started", c.do("BGREWRITEAOF").String())
+	require.Nil(t, c.do("RPUSH", "during", "x").Error())
+	require.Eventually(t, func() bool {
+		return c.info("persistence", "aof_rewrite_in_progress") == "0"
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, "ok", c.info("persistence", "aof_last_bgrewrite_status"))
+	// the first one wrote the AOF at startup
+	require.Equal(t, "2", c.info("persistence", "aof_rewrites"))
+
+	// the new base holds the dataset as of the rewrite, the writes since
+	// are in the new incremental file and the old files are gone
+	dir := filepath.Join(conf.Dir, "appendonlydir")
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	require.ElementsMatch(t, []string{"appendonly.aof.2.base.aof", "appendonly.aof.2.incr.aof", "appendonly.aof.manifest"}, names)
+	data, err := os.ReadFile(filepath.Join(dir, "appendonly.aof.2.base.aof"))
+	require.NoError(t, err)
+	require.Contains(t, string(data), "*3\r\n$3\r\nSET\r\n$7\r\ncounter\r\n$3\r\n100\r\n")
+	require.NoError(t, CheckAOF(filepath.Join(dir, "appendonly.aof.manifest"), false, io.Discard))
+
+	c2 := newRespClient(t, startTestServerWithConfig(t, conf))
+	require.Equal(t, "100", c2.do("GET", "counter").String())
+	require.Equal(t, "inf", c2.do("ZSCORE", "z", "m").String())
+	require.Equal(t, []string{"x"}, stringValues(c2.do("LRANGE", "during", "0", "-1")))
+
+	// the AOF is rewritten once it doubled in size
+	require.Equal(t, "OK", c2.do("CONFIG", "SET", "auto-aof-rewrite-min-size", "1", "aof-use-rdb-preamble", "yes").String())
+	for i := 0; i < 100; i++ {
+		require.Nil(t, c2.do("INCR", "counter").Error())
+	}
+	require.Eventually(t, func() bool {
+		return c2.info("persistence", "aof_rewrites") != "0" && c2.info("persistence", "aof_rewrite_in_progress") == "0"
+	}, 5*time.Second, 10*time.Millisecond)
+	bases, err := filepath.Glob(filepath.Join(dir, "*.base.rdb"))
+	require.NoError(t, err)
+	require.Len(t, bases, 1)
+	c3 := newRespClient(t, startTestServerWithConfig(t, conf))
+	require.Equal(t, "200", c3.do("GET", "counter").String())
+}
+
+func stringValues(val resp.Value) []string {
+	var vals []string
+	for _, v := range val.Array() {
+		vals = append(vals, v.String())
+	}
+	return vals
+}
+
+// linkProxy forwards connections to addr, cut breaks the ones open so far
+// like a network failure would.
+type linkProxy struct {
+	ln    net.Listener
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newLinkProxy(t *testing.T, addr string) *linkProxy {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	p := &linkProxy{ln: ln}
+	t.Cleanup(func() {
+		ln.Close()
+		p.cut()
+	})
+	go func() {
+		for {
+			client, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			server, err := net.Dial("tcp", addr)
+			if err != nil {
+				client.Close()
+				continue
+			}
+			p.mu.Lock()
+			p.conns = append(p.conns, client, server)
+			p.mu.Unlock()
+			go io.Copy(server, client)
+			go io.Copy(client, server)
+		}
+	}()
+	return p
+}
+
+func (p *linkProxy) cut() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.conns {
+		c.Close()
+	}
+	p.conns = nil
+}
+
+func TestReplication(t *testing.T) {
+	masterConn := startTestServer(t)
+	master := newRespClient(t, masterConn)
+	replicaConn := startTestServer(t)
+	replica := newRespClient(t, replicaConn)
+	require.Equal(t, "OK", master.do("SET", "a", "1").String())
+	master.do("RPUSH", "l", "x")
+
+	proxy := newLinkProxy(t, masterConn.RemoteAddr().String())
+	host, port, _ := net.SplitHostPort(proxy.ln.Addr().String())
+	require.Equal(t, "OK", replica.do("REPLICAOF", host, port).String())
+	require.Equal(t, "OK Already connected to specified master", replica.do("REPLICAOF", host, port).String())
+	linkUp := func() bool { return replica.info("replication", "master_link_status") == "up" }
+	require.Eventually(t, linkUp, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, "1", replica.do("GET", "a").String())
+	require.Equal(t, "1", master.info("stats", "sync_full"))
+
+	// writes are streamed, the replica refuses its own
+	master.do("ZADD", "z", "1.5", "m")
+	master.do("SET", "t", "v", "EX", "100")
+	require.Eventually(t, func() bool { return replica.do("GET", "t").String() == "v" }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, "1.5", replica.do("ZSCORE", "z", "m").String())
+	require.Contains(t, replica.do("SET", "x", "1").Error().Error(), "READONLY")
+	require.Equal(t, "slave", replica.info("replication", "role"))
+
+	// after a broken link the replica continues from the backlog
+	proxy.cut()
+	master.do("SET", "c", "3")
+	require.Eventually(t, func() bool { return replica.do("GET", "c").String() == "3" }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, "1", master.info("stats", "sync_full"))
+	require.Equal(t, "1", master.info("stats", "sync_partial_ok"))
+	require.Eventually(t, func() bool {
+		return replica.info("replication", "slave_repl_offset") == master.info("replication", "master_repl_offset")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the promoted replica takes writes, and the old master follows it
+	// from where it was
+	require.Equal(t, "OK", replica.do("REPLICAOF", "NO", "ONE").String())
+	require.Equal(t, "OK", replica.do("SET", "x", "1").String())
+	require.Equal(t, master.info("replication", "master_replid"), replica.info("replication", "master_replid2"))
+	host, port, _ = net.SplitHostPort(replicaConn.RemoteAddr().String())
+	require.Equal(t, "OK", master.do("REPLICAOF", host, port).String())
+	require.Eventually(t, func() bool { return master.do("GET", "x").String() == "1" }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, "1", replica.info("stats", "sync_partial_ok"))
+	require.Equal(t, "0", replica.info("stats", "sync_full"))
+}
+
+func TestReplicaExpire(t *testing.T) {
+	master := newRespClient(t, startTestServer(t))
+	conf := DefaultConfig()
+	conf.ReplicaReadOnly = false
+	replica := newRespClient(t, startTestServerWithConfig(t, conf))
+	host, port, _ := net.SplitHostPort(master.conn.RemoteAddr().String())
+	require.Equal(t, "OK", replica.do("REPLICAOF", host, port).String())
+	require.Eventually(t, func() bool { return replica.info("replication", "master_link_status") == "up" }, 5*time.Second, 10*time.Millisecond)
+
+	// the keys the master expires are deleted on the replica by its DEL
+	require.Equal(t, "OK", master.do("SET", "a", "v", "PX", "200").String())
+	require.Eventually(t, func() bool { return replica.do("DBSIZE").Integer() == 1 }, 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool { return replica.do("DBSIZE").Integer() == 0 }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, "0", replica.info("stats", "expired_keys"))
+
+	// a replica hides its expired keys without deleting them
+	require.Equal(t, "OK", replica.do("SET", "local", "v", "PX", "10").String())
+	time.Sleep(300 * time.Millisecond)
+	require.True(t, replica.do("GET", "local").IsNull())
+	require.Equal(t, 1, replica.do("DBSIZE").Integer())
+	require.Equal(t, "0", replica.info("stats", "expired_keys"))
+}
+
+func TestWait(t *testing.T) {
+	newAOFServer := func() *respClient {
+		conf := DefaultConfig()
+		conf.Dir = t.TempDir()
+		conf.Save = nil
+		conf.AppendOnly = true
+		conf.AppendFsync = "everysec"
+		return newRespClient(t, startTestServerWithConfig(t, conf))
+	}
+	master, replica := newAOFServer(), newAOFServer()
+	host, port, _ := net.SplitHostPort(master.conn.RemoteAddr().String())
+	require.Equal(t, "OK", replica.do("REPLICAOF", host, port).String())
+	require.Eventually(t, func() bool { return replica.info("replication", "master_link_status") == "up" }, 5*time.Second, 10*time.Millisecond)
+
+	require.Equal(t, "OK", master.do("SET", "a", "1").String())
+	require.Equal(t, 1, master.do("WAIT", "1", "0").Integer())
+	require.Equal(t, []string{"1", "1"}, stringValues(master.do("WAITAOF", "1", "1", "0")))
+
+	// without enough replicas WAIT replies at the timeout, and the
+	// commands pipelined after it run once it did
+	start := time.Now()
+	_, err := master.conn.Write([]byte("WAIT 2 100\r\nPING\r\n"))
+	require.NoError(t, err)
+	val, _, err := master.r.ReadValue()
+	require.NoError(t, err)
+	require.Equal(t, 1, val.Integer())
+	require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	val, _, err = master.r.ReadValue()
+	require.NoError(t, err)
+	require.Equal(t, "PONG", val.String())
+
+	require.Equal(t, "ERR WAIT cannot be used with replica instances. Please also note that since Redis 4.0 if a replica is configured to be writable (which is not the default) writes to replicas are just local and are not propagated.",
+		replica.do("WAIT", "1", "0").Error().Error())
+	require.Equal(t, "ERR WAITAOF cannot be used with replica instances. Please also note that writes to replicas are just local and are not propagated.",
+		replica.do("WAITAOF", "0", "1", "0").Error().Error())
+	require.Equal(t, "ERR timeout is negative", master.do("WAIT", "1", "-1").Error().Error())
+	plain := newRespClient(t, startTestServer(t))
+	require.Equal(t, "ERR WAITAOF cannot be used when numlocal is set but appendonly is disabled.",
+		plain.do("WAITAOF", "1", "0", "0").Error().Error())
+	require.Equal(t, []string{"0", "0"}, stringValues(plain.do("WAITAOF", "0", "0", "0")))
+}
+
+func TestClusterDisabled(t *testing.T) {
+	conn := startTestServer(t)
+	roundTrip(t, conn, "-ERR This instance has cluster support disabled\r\n", []string{"CLUSTER", "INFO"})
+	roundTrip(t, conn, "-ERR This instance has cluster support disabled\r\n", []string{"ASKING"})
+	c := newRespClient(t, conn)
+	require.Equal(t, "0", c.info("cluster", "cluster_enabled"))
+}
+
+func TestCluster(t *testing.T) {
+	var nodes []*respClient
+	var ids, addrs []string
+	for i := 0; i < 3; i++ {
+		conf := DefaultConfig()
+		conf.Dir = t.TempDir()
+		conf.Save = nil
+		conf.ClusterEnabled = true
+		conf.ClusterNodeTimeout = 500 * time.Millisecond
+		c := newRespClient(t, startTestServerWithConfig(t, conf))
+		nodes = append(nodes, c)
+		ids = append(ids, c.do("CLUSTER", "MYID").String())
+		addrs = append(addrs, c.conn.RemoteAddr().String())
+	}
+	require.Contains(t, nodes[0].do("SET", "foo", "1").Error().Error(), "CLUSTERDOWN")
+	require.Equal(t, "ERR REPLICAOF not allowed in cluster mode.", nodes[0].do("REPLICAOF", "127.0.0.1", "6379").Error().Error())
+	require.Equal(t, 12182, nodes[0].do("CLUSTER", "KEYSLOT", "foo").Integer())
+	require.Equal(t, 3443, nodes[0].do("CLUSTER", "KEYSLOT", "{user1000}.following").Integer())
+	require.Equal(t, 3443, nodes[0].do("CLUSTER", "KEYSLOT", "{user1000}.followers").Integer())
+	hello := stringValues(nodes[0].do("HELLO"))
+	require.Equal(t, []string{"mode", "cluster"}, hello[8:10])
+
+	// the first node meets the others, which meet each other through its
+	// gossip
+	for _, c := range nodes[1:] {
+		// myself has no IP until it talks to another node
+		var myself []string
+		for _, line := range strings.Split(c.do("CLUSTER", "NODES").String(), "\n") {
+			if strings.Contains(line, "myself") {
+				myself = strings.Fields(line)
+			}
+		}
+		addr, busPort, _ := strings.Cut(myself[1], "@")
+		_, port, _ := net.SplitHostPort(addr)
+		require.Equal(t, "OK", nodes[0].do("CLUSTER", "MEET", "127.0.0.1", port, busPort).String())
+	}
+	require.Eventually(t, func() bool {
+		for _, c := range nodes {
+			out := c.do("CLUSTER", "NODES").String()
+			if strings.Count(out, "connected") != 3 || strings.Contains(out, "handshake") || strings.Contains(out, "disconnected") {
+				return false
+			}
+		}
+		return true
+	}, 10*time.Second, 20*time.Millisecond)
+
+	require.Equal(t, "OK", nodes[0].do("CLUSTER", "ADDSLOTSRANGE", "0", "5460").String())
+	require.Equal(t, "OK", nodes[1].do("CLUSTER", "ADDSLOTSRANGE", "5461", "10922").String())
+	require.Equal(t, "OK", nodes[2].do("CLUSTER", "ADDSLOTSRANGE", "10923", "16383").String())
+	require.Contains(t, nodes[0].do("CLUSTER", "ADDSLOTS", "1").Error().Error(), "already busy")
+	require.Eventually(t, func() bool {
+		for _, c := range nodes {
+			if c.info("cluster", "cluster_enabled") != "1" || !strings.Contains(c.do("CLUSTER", "INFO").String(), "cluster_state:ok") {
+				return false
+			}
+		}
+		return true
+	}, 10*time.Second, 20*time.Millisecond)
+	slots := nodes[0].do("CLUSTER", "SLOTS").Array()
+	require.Len(t, slots, 3)
+	require.Equal(t, 10923, slots[2].Array()[0].Integer())
+	require.Equal(t, ids[2], slots[2].Array()[2].Array()[2].String())
+	require.Len(t, nodes[1].do("CLUSTER", "SHARDS").Array(), 3)
+
+	// keys are served by the node owning their slot
+	require.Equal(t, "MOVED 12182 "+addrs[2], nodes[0].do("SET", "foo", "1").Error().Error())
+	require.Equal(t, "OK", nodes[2].do("SET", "foo", "1").String())
+	require.Equal(t, "OK", nodes[0].do("SET", "{user1000}.a", "1").String())
+	require.Equal(t, 1, nodes[0].do("DEL", "{user1000}.a", "{user1000}.b").Integer())
+	require.Contains(t, nodes[2].do("DEL", "foo", "bar").Error().Error(), "CROSSSLOT")
+	require.Equal(t, 1, nodes[2].do("CLUSTER", "COUNTKEYSINSLOT", "12182").Integer())
+	require.Equal(t, []string{"foo"}, stringValues(nodes[2].do("CLUSTER", "GETKEYSINSLOT", "12182", "10")))
+
+	// while the slot moves, the keys not there anymore are asked to the
+	// new owner
+	require.Equal(t, "OK", nodes[1].do("CLUSTER", "SETSLOT", "12182", "IMPORTING", ids[2]).String())
+	require.Equal(t, "OK", nodes[2].do("CLUSTER", "SETSLOT", "12182", "MIGRATING", ids[1]).String())
+	require.Equal(t, "1", nodes[2].do("GET", "foo").String())
+	require.Equal(t, "ASK 12182 "+addrs[1], nodes[2].do("GET", "{foo}x").Error().Error())
+	require.Equal(t, "MOVED 12182 "+addrs[2], nodes[1].do("SET", "{foo}x", "2").Error().Error())
+	require.Equal(t, "OK", nodes[1].do("ASKING").String())
+	require.Equal(t, "OK", nodes[1].do("SET", "{foo}x", "2").String())
+	require.Contains(t, nodes[2].do("CLUSTER", "SETSLOT", "12182", "NODE", ids[1]).Error().Error(), "still hold keys")
+	nodes[2].do("DEL", "foo")
+	require.Equal(t, "OK", nodes[1].do("CLUSTER", "SETSLOT", "12182", "NODE", ids[1]).String())
+	require.Equal(t, "OK", nodes[2].do("CLUSTER", "SETSLOT", "12182", "NODE", ids[1]).String())
+	require.Eventually(t, func() bool {
+		err := nodes[0].do("GET", "{foo}x").Error()
+		return err != nil && err.Error() == "MOVED 12182 "+addrs[1]
+	}, 10*time.Second, 20*time.Millisecond)
+	require.Equal(t, "2", nodes[1].do("GET", "{foo}x").String())
+
+	// a node down is agreed to fail, and the cluster with it
+	_, err := nodes[2].conn.Write([]byte("SHUTDOWN NOSAVE\r\n"))
+	require.NoError(t, err)
+	_, err = io.ReadAll(nodes[2].conn)
+	require.NoError(t, err)
+	for _, c := range nodes[:2] {
+		require.Eventually(t, func() bool {
+			return strings.Contains(c.do("CLUSTER", "INFO").String(), "cluster_state:fail")
+		}, 10*time.Second, 20*time.Millisecond)
+		require.Contains(t, c.do("CLUSTER", "NODES").String(), "master,fail")
+	}
+	require.Contains(t, nodes[0].do("GET", "a").Error().Error(), "CLUSTERDOWN")
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"go-redis/command"
+	"log/slog"
+	"time"
+)
+
+// ShutdownTimeout bounds how long a shutdown waits for queued replies to be
+// written before connections are closed anyway.
+const ShutdownTimeout = 10 * time.Second
+
+// shutdownRequest is sent by Shutdown, which waits on errCh, or set by the
+// SHUTDOWN command, which has no ctx.
+type shutdownRequest struct {
+	opts  command.ShutdownOptions
+	ctx   context.Context
+	errCh chan error
+}
+
+// Shutdown gracefully stops the server: the commands already received are
+// executed, data is persisted, queued replies are written and every
+// connection is closed. If ctx expires first, the remaining connections are
+// closed without waiting for their replies.
+func (s *Server) Shutdown(ctx context.Context) error {
+	req := shutdownRequest{ctx: ctx, errCh: make(chan error, 1)}
+	select {
+	case s.shutdownCh <- req:
+	case <-s.doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-req.errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RequestShutdown is called by the SHUTDOWN command. The server shuts down
+// once the message holding the command has been handled.
+func (s *Server) RequestShutdown(opts command.ShutdownOptions) error {
+	if opts.Abort {
+		return fmt.Errorf("No shutdown in progress.")
+	}
+	s.pendingShutdown = &shutdownRequest{opts: opts}
+	return nil
+}
+
+// shutdown runs in the loop. It only returns an error when persisting the
+// data failed, then the server keeps serving.
+func (s *Server) shutdown(req *shutdownRequest) error {
+	slog.Info("User requested shutdown...")
+	ctx := req.ctx
+	if ctx == nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+	}
+	s.drain()
+	// a SHUTDOWN among the drained commands is this very shutdown
+	s.pendingShutdown = nil
+	if err := s.saveBeforeShutdown(req.opts); err != nil {
+		if !req.opts.Force {
+			slog.Error("Errors trying to shut down the server. Check the logs for more information.", "err", err)
+			return fmt.Errorf("Errors trying to SHUTDOWN. Check logs.")
+		}
+		slog.Warn("Error saving before shutdown, exiting anyway", "err", err)
+	}
+	s.waitRewrite()
+	if err := s.stopAppendOnly(); err != nil {
+		slog.Warn("Error flushing the append only file before exiting", "err", err)
+	}
+	s.closeListeners()
+	s.clusterStop()
+	if s.repl.link != nil {
+		s.repl.link.cancel()
+	}
+	for peer := range s.peers {
+		peer.closeAfterReply()
+	}
+	for peer := range s.peers {
+		select {
+		case <-peer.closeCh:
+		case <-ctx.Done():
+			peer.Close()
+		}
+	}
+	slog.Info("Redis is now ready to exit, bye bye...")
+	return nil
+}
+
+// drain executes the messages clients already handed to the loop.
+func (s *Server) drain() {
+	for {
+		select {
+		case message := <-s.msgCh:
+			if err := s.HandleRawMsg(message); err != nil {
+				slog.Error("Error handling raw message", "err", err)
+			}
+		case peer := <-s.peerCh:
+			s.addPeer(peer)
+		case peer := <-s.delPeerCh:
+			s.removePeer(peer)
+		default:
+			return
+		}
+	}
+}
+
+// saveBeforeShutdown saves a final snapshot when save rules are set or
+// SAVE was given, unless NOSAVE was. A background save running is waited
+// for first.
+func (s *Server) saveBeforeShutdown(opts command.ShutdownOptions) error {
+	s.waitBgsave()
+	if opts.NoSave || (!opts.Save && len(s.config.Save) == 0) {
+		return nil
+	}
+	slog.Info("Saving the final RDB snapshot before exiting.")
+	return s.Save()
+}
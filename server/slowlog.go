@@ -0,0 +1,85 @@
+package server
+
+import (
+	"fmt"
+	"go-redis/command"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+const (
+	// slowlogMaxArgc and slowlogMaxArgLen shorten the command lines kept in
+	// the slow log, like Redis does.
+	slowlogMaxArgc   = 32
+	slowlogMaxArgLen = 128
+)
+
+// slowlog keeps the most recent slow commands, newest first. It is only
+// used from the server loop.
+type slowlog struct {
+	entries []command.SlowlogEntry
+	nextID  int64
+}
+
+// slowlogRecord logs the command args when it ran for at least the configured
+// threshold.
+func (s *Server) slowlogRecord(conn *Conn, args []resp.Value, start time.Time, d time.Duration) {
+	threshold := s.config.SlowlogLogSlowerThan
+	if threshold < 0 || d < threshold || s.config.SlowlogMaxLen == 0 {
+		return
+	}
+	entry := command.SlowlogEntry{
+		ID:       s.slowlog.nextID,
+		Time:     start,
+		Duration: d,
+		Args:     slowlogArgs(args),
+		Addr:     conn.addr,
+		Name:     conn.name,
+	}
+	s.slowlog.nextID++
+	s.slowlog.entries = append([]command.SlowlogEntry{entry}, s.slowlog.entries...)
+	s.slowlog.trim(s.config.SlowlogMaxLen)
+}
+
+func (l *slowlog) trim(maxLen int) {
+	if len(l.entries) > maxLen {
+		l.entries = l.entries[:maxLen]
+	}
+}
+
+func slowlogArgs(vals []resp.Value) []string {
+	argc := len(vals)
+	if argc > slowlogMaxArgc {
+		argc = slowlogMaxArgc
+	}
+	args := make([]string, 0, argc)
+	for i := 0; i < argc; i++ {
+		if i == slowlogMaxArgc-1 && len(vals) > slowlogMaxArgc {
+			args = append(args, fmt.Sprintf("... (%d more arguments)", len(vals)-slowlogMaxArgc+1))
+			break
+		}
+		arg := vals[i].String()
+		if len(arg) > slowlogMaxArgLen {
+			arg = fmt.Sprintf("%s... (%d more bytes)", arg[:slowlogMaxArgLen], len(arg)-slowlogMaxArgLen)
+		}
+		args = append(args, arg)
+	}
+	return args
+}
+
+func (s *Server) SlowlogGet(count int) []command.SlowlogEntry {
+	entries := s.slowlog.entries
+	if count >= 0 && count < len(entries) {
+		entries = entries[:count]
+	}
+	return append([]command.SlowlogEntry(nil), entries...)
+}
+
+func (s *Server) SlowlogLen() int {
+	return len(s.slowlog.entries)
+}
+
+func (s *Server) SlowlogReset() {
+	s.slowlog.entries = nil
+}
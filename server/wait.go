@@ -0,0 +1,140 @@
+package server
+
+import (
+	"fmt"
+	"go-redis/command"
+	"go-redis/pkg/utils"
+	"log/slog"
+	"time"
+)
+
+// waitRequest blocks a client until its last write is acknowledged by
+// numReplicas replicas and, for WAITAOF, fsynced to the local AOF by
+// numLocal. A zero deadline waits forever.
+type waitRequest struct {
+	aof         bool
+	offset      int64
+	numLocal    int
+	numReplicas int
+	deadline    time.Time
+}
+
+// Wait returns how many replicas acknowledged the last write of client. It
+// blocks the client instead while fewer than numReplicas did, the reply is
+// then sent once they do or timeout expires.
+func (s *Server) Wait(client command.Client, numReplicas int, timeout time.Duration) (int, bool, error) {
+	if s.config.MasterHost != "" {
+		return 0, false, fmt.Errorf("WAIT cannot be used with replica instances. Please also note that since Redis 4.0 if a replica is configured to be writable (which is not the default) writes to replicas are just local and are not propagated.")
+	}
+	w := &waitRequest{offset: client.(*Conn).woff, numReplicas: numReplicas}
+	_, replicas := s.waitAcks(w)
+	if replicas >= numReplicas {
+		return replicas, false, nil
+	}
+	s.blockWait(client.(*Conn), w, timeout)
+	return 0, true, nil
+}
+
+// WaitAOF returns whether the last write of client is fsynced to the
+// local AOF and by how many replicas. It blocks the client instead while
+// fewer than numLocal and numReplicas did.
+func (s *Server) WaitAOF(client command.Client, numLocal, numReplicas int, timeout time.Duration) (int, int, bool, error) {
+	if s.config.MasterHost != "" {
+		return 0, 0, false, fmt.Errorf("WAITAOF cannot be used with replica instances. Please also note that writes to replicas are just local and are not propagated.")
+	}
+	if numLocal > 0 && s.aof.file == nil {
+		return 0, 0, false, fmt.Errorf("WAITAOF cannot be used when numlocal is set but appendonly is disabled.")
+	}
+	w := &waitRequest{aof: true, offset: client.(*Conn).woff, numLocal: numLocal, numReplicas: numReplicas}
+	local, replicas := s.waitAcks(w)
+	if local >= numLocal && replicas >= numReplicas {
+		return local, replicas, false, nil
+	}
+	s.blockWait(client.(*Conn), w, timeout)
+	return 0, 0, true, nil
+}
+
+func (s *Server) blockWait(c *Conn, w *waitRequest, timeout time.Duration) {
+	if timeout > 0 {
+		w.deadline = time.Now().Add(timeout)
+	}
+	c.wait = w
+	s.repl.waiting[c] = true
+	if w.numReplicas > 0 {
+		s.repl.getAck = true
+	}
+}
+
+// waitAcks counts the acknowledgements of the offset of w: whether the
+// local AOF has it on disk, and how many replicas processed it, or for
+// WAITAOF have it on disk.
+func (s *Server) waitAcks(w *waitRequest) (local, replicas int) {
+	if w.aof && s.aof.file != nil && s.aof.fsyncedOffset.Load() >= w.offset {
+		local = 1
+	}
+	for c := range s.repl.replicas {
+		r := c.replica
+		if r.state != replicaOnline {
+			continue
+		}
+		if (!w.aof && r.ackOffset >= w.offset) || (w.aof && r.aofOffset >= w.offset) {
+			replicas++
+		}
+	}
+	return local, replicas
+}
+
+// waitingLocalAOF reports whether a client waits for the local AOF.
+func (s *Server) waitingLocalAOF() bool {
+	for c := range s.repl.waiting {
+		if c.wait.numLocal > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// processWaiting asks the replicas for their offset on behalf of the
+// clients that just blocked, and unblocks the clients that got their
+// acknowledgements or waited long enough.
+func (s *Server) processWaiting(now time.Time) {
+	if len(s.repl.waiting) == 0 {
+		return
+	}
+	if s.repl.getAck {
+		s.repl.getAck = false
+		s.feedReplicationStream(utils.AppendCommand(nil, "REPLCONF", "GETACK", "*"))
+		s.flushReplicas()
+	}
+	for c := range s.repl.waiting {
+		w := c.wait
+		local, replicas := s.waitAcks(w)
+		done := local >= w.numLocal && replicas >= w.numReplicas
+		if !done && (w.deadline.IsZero() || now.Before(w.deadline)) {
+			continue
+		}
+		if w.aof {
+			c.reply.WriteArray(2)
+			c.reply.WriteInteger(int64(local))
+			c.reply.WriteInteger(int64(replicas))
+		} else {
+			c.reply.WriteInteger(int64(replicas))
+		}
+		s.unblock(c)
+	}
+}
+
+// unblock runs the commands the client sent while it was blocked, until
+// one blocks it again.
+func (s *Server) unblock(c *Conn) {
+	c.wait = nil
+	delete(s.repl.waiting, c)
+	for len(c.deferred) > 0 && c.wait == nil {
+		message := c.deferred[0]
+		c.deferred = c.deferred[1:]
+		if err := s.HandleRawMsg(message); err != nil {
+			slog.Error("Error handling raw message", "err", err)
+		}
+	}
+	c.flush()
+}